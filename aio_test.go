@@ -1,10 +1,26 @@
 package aio
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func assertEquals(actual, expected interface{}) {
@@ -13,6 +29,28 @@ func assertEquals(actual, expected interface{}) {
 	}
 }
 
+// Shell script line answering the -version check that installed runs before using a program.
+const fakeVersion = "[ \"$1\" = \"-version\" ] && exit 0\n"
+
+// Script of a fake ffprobe that reports a mono 8 kHz MP3 stream.
+const fakeMonoProbe = fakeVersion + "echo \"stream|index=0|codec_name=mp3|codec_type=audio|sample_rate=8000|channels=1\"\n"
+
+// Writes the shell scripts, given without their "#!/bin/sh" line, as programs named by the keys
+// into the directory, and puts the directory at the start of the PATH. Returns a function that
+// restores the PATH. Fake programs only work on Unix.
+func fakePrograms(dir string, scripts map[string]string) (restore func()) {
+	for program, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, program), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			panic(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+	}
+}
+
 func TestSamplesInt16(t *testing.T) {
 	audio, err := NewAudio("test/beach.mp3", &Options{Format: "u16"})
 	if err != nil {
@@ -28,7 +66,7 @@ func TestSamplesInt16(t *testing.T) {
 		assertEquals(len(samples), len(bytes)/2)
 
 		index := 0
-		endian := endianness()
+		endian := NativeEndianness()
 		for i := 0; i < len(bytes); i += 2 {
 			var sample uint16
 			if endian == "le" {
@@ -61,7 +99,7 @@ func TestSamplesFloat64(t *testing.T) {
 		assertEquals(len(samples), len(bytes)/8)
 
 		index := 0
-		endian := endianness()
+		endian := NativeEndianness()
 		for i := 0; i < len(bytes); i += 8 {
 			var bits uint64
 			if endian == "le" {
@@ -343,3 +381,6908 @@ func TestMicrophone(t *testing.T) {
 
 	fmt.Println("Microphone Reading test passed")
 }
+
+func TestAudioWriterLowLatency(t *testing.T) {
+	if _, err := NewAudioWriter("test/lowlatency.pcm", &Options{LowLatency: true, MaxDelay: -time.Second}); err == nil {
+		panic("expected error for negative MaxDelay")
+	}
+
+	// Buffers of 100 ms of a stereo sine are written to raw PCM, which has no header, so the
+	// output only grows once the samples of a buffer have been written out.
+	generator, err := NewGenerator(Sine(440, 0.5), 10, &Options{SampleRate: 44100, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	generator.SetBuffer(make([]byte, 4*4410))
+
+	filename := "test/lowlatency.pcm"
+	size := func() int64 {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+	// Returns the time from writing a buffer until it reaches the output, or 0 if it does not
+	// reach the output within the timeout.
+	firstByte := func(writer *AudioWriter, timeout time.Duration) time.Duration {
+		before := size()
+		generator.Read()
+		start := time.Now()
+		if err := writer.Write(generator.Buffer()); err != nil {
+			panic(err)
+		}
+		for time.Since(start) < timeout {
+			if size() > before {
+				return time.Since(start)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return 0
+	}
+
+	// Without LowLatency, ffmpeg buffers the output until more audio has been written.
+	writer, err := NewAudioWriter(filename, &Options{SampleRate: 44100, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(filename)
+	assertEquals(firstByte(writer, time.Second), time.Duration(0))
+	writer.Close()
+	os.Remove(filename)
+
+	// With LowLatency, every buffer reaches the output right away once ffmpeg has started.
+	writer, err = NewAudioWriter(filename, &Options{
+		SampleRate: 44100,
+		Channels:   2,
+		LowLatency: true,
+		MaxDelay:   50 * time.Millisecond,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer writer.Close()
+	assertEquals(writer.MaxDelay(), 50*time.Millisecond)
+	if firstByte(writer, 5*time.Second) == 0 {
+		panic("first buffer was not written to the output within 5 seconds")
+	}
+	for i := 0; i < 5; i++ {
+		elapsed := firstByte(writer, time.Second)
+		if elapsed == 0 || elapsed > writer.MaxDelay()+200*time.Millisecond {
+			panic(fmt.Sprintf("expected the buffer to reach the output within %v, took %v", writer.MaxDelay(), elapsed))
+		}
+		fmt.Printf("Time to first byte: %v\n", elapsed)
+	}
+
+	fmt.Println("Audio Writer Low Latency test passed")
+}
+
+func TestChapterValidation(t *testing.T) {
+	valid := []Chapter{
+		{Title: "One", Start: 0, End: 0.5},
+		{Title: "Two", Start: 0.5, End: 1},
+	}
+	overlapping := []Chapter{
+		{Title: "One", Start: 0, End: 0.6},
+		{Title: "Two", Start: 0.5, End: 1},
+	}
+	unsorted := []Chapter{
+		{Title: "Two", Start: 0.5, End: 1},
+		{Title: "One", Start: 0, End: 0.5},
+	}
+
+	assertEquals(checkChapters(valid), nil)
+	if checkChapters(overlapping) == nil {
+		panic("overlapping chapters should be rejected")
+	}
+	if checkChapters(unsorted) == nil {
+		panic("unsorted chapters should be rejected")
+	}
+	if checkChapterContainer("output.wav") == nil {
+		panic("wav files should not support chapters")
+	}
+	assertEquals(checkChapterContainer("output.m4b"), nil)
+
+	fmt.Println("Chapter Validation test passed")
+}
+
+func TestAudioWriterChapters(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+
+	options := Options{
+		SampleRate: audio.SampleRate(),
+		Channels:   audio.Channels(),
+		Format:     audio.Format(),
+		Chapters: []Chapter{
+			{Title: "Waves", Start: 0, End: 0.5},
+			{Title: "Seagulls", Start: 0.5, End: 1},
+		},
+	}
+
+	filename := "test/chapters.mka"
+	writer, err2 := NewAudioWriter(filename, &options)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	defer os.Remove(filename)
+
+	for audio.Read() {
+		writer.Write(audio.Buffer())
+	}
+	writer.Close()
+
+	output, err := exec.Command(
+		"ffprobe",
+		"-show_chapters",
+		"-print_format", "compact",
+		"-loglevel", "quiet",
+		filename,
+	).Output()
+	if err != nil {
+		panic(err)
+	}
+
+	chapters := strings.Split(strings.TrimSpace(string(output)), "\n")
+	assertEquals(len(chapters), 2)
+	assertEquals(strings.Contains(chapters[0], "tag:title=Waves"), true)
+	assertEquals(strings.Contains(chapters[1], "tag:title=Seagulls"), true)
+
+	fmt.Println("Audio Writer Chapters test passed")
+}
+
+func TestAudioWriterID3(t *testing.T) {
+	for _, version := range []int{3, 4} {
+		audio, err1 := NewAudio("test/beach.mp3", nil)
+		if err1 != nil {
+			panic(err1)
+		}
+
+		options := Options{
+			SampleRate: audio.SampleRate(),
+			Channels:   audio.Channels(),
+			Format:     audio.Format(),
+			ID3Version: version,
+			WriteID3v1: true,
+		}
+
+		filename := fmt.Sprintf("test/id3v2%d.mp3", version)
+		writer, err2 := NewAudioWriter(filename, &options)
+		if err2 != nil {
+			panic(err2)
+		}
+
+		for audio.Read() {
+			writer.Write(audio.Buffer())
+		}
+		writer.Close()
+
+		data, err := os.ReadFile(filename)
+		os.Remove(filename)
+		if err != nil {
+			panic(err)
+		}
+
+		// ID3v2 header: "ID3" followed by the major version byte.
+		assertEquals(string(data[:3]), "ID3")
+		assertEquals(data[3], byte(version))
+		// ID3v1 tag: last 128 bytes of the file starting with "TAG".
+		assertEquals(string(data[len(data)-128:len(data)-125]), "TAG")
+	}
+
+	fmt.Println("Audio Writer ID3 test passed")
+}
+
+func TestAudioWriterCopyMetadata(t *testing.T) {
+	// Create a short video with metadata to use as the stream file.
+	streamfile := "test/metadata.mkv"
+	err := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-loglevel", "quiet",
+		"-f", "lavfi",
+		"-i", "testsrc=duration=1:size=64x64:rate=10",
+		"-metadata", "title=Beach",
+		"-metadata", "comment=Original",
+		"-metadata:s:v", "language=eng",
+		streamfile,
+	).Run()
+	if err != nil {
+		panic(err)
+	}
+
+	defer os.Remove(streamfile)
+
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+
+	options := Options{
+		SampleRate:             audio.SampleRate(),
+		Channels:               audio.Channels(),
+		Format:                 audio.Format(),
+		StreamFile:             streamfile,
+		CopyStreamFileMetadata: true,
+		Metadata:               map[string]string{"comment": "Remuxed"},
+	}
+
+	filename := "test/metadata_output.mkv"
+	writer, err2 := NewAudioWriter(filename, &options)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	defer os.Remove(filename)
+
+	for audio.Read() {
+		writer.Write(audio.Buffer())
+	}
+	writer.Close()
+
+	output, err := exec.Command(
+		"ffprobe",
+		"-show_format",
+		"-show_streams",
+		"-print_format", "compact",
+		"-loglevel", "quiet",
+		filename,
+	).Output()
+	if err != nil {
+		panic(err)
+	}
+
+	tags := strings.ToLower(string(output))
+	assertEquals(strings.Contains(tags, "tag:title=beach"), true)
+	assertEquals(strings.Contains(tags, "tag:comment=remuxed"), true)
+	assertEquals(strings.Contains(tags, "tag:language=eng"), true)
+
+	fmt.Println("Audio Writer Copy Metadata test passed")
+}
+
+func TestTeeTarget(t *testing.T) {
+	target := teeTarget([]OutputSpec{
+		{Target: "archive.mp3"},
+		{Target: "udp://127.0.0.1:1234", Container: "mpegts", IgnoreFailure: true},
+		{Target: "a|b.mp3"},
+	})
+
+	assertEquals(target, `[onfail=abort]archive.mp3|[f=mpegts:onfail=ignore]udp://127.0.0.1:1234|[onfail=abort]a\|b.mp3`)
+
+	fmt.Println("Tee Target test passed")
+}
+
+func TestAudioWriterOutputs(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+
+	options := Options{
+		SampleRate: audio.SampleRate(),
+		Channels:   audio.Channels(),
+		Format:     audio.Format(),
+		Codec:      "libmp3lame",
+		Outputs: []OutputSpec{
+			{Target: "test/tee.mp3"},
+			{Target: "test/missing/tee.mp3", Container: "mp3", IgnoreFailure: true},
+		},
+	}
+
+	writer, err2 := NewAudioWriter("test/archive.mp3", &options)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	defer os.Remove("test/archive.mp3")
+	defer os.Remove("test/tee.mp3")
+
+	for audio.Read() {
+		writer.Write(audio.Buffer())
+	}
+	writer.Close()
+
+	assertEquals(exists("test/archive.mp3"), true)
+	assertEquals(exists("test/tee.mp3"), true)
+
+	failed := writer.FailedOutputs()
+	assertEquals(len(failed), 1)
+	assertEquals(failed[0], "test/missing/tee.mp3")
+
+	fmt.Println("Audio Writer Outputs test passed")
+}
+
+func TestAudioWriterRealTime(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+
+	options := Options{
+		SampleRate: audio.SampleRate(),
+		Channels:   audio.Channels(),
+		Format:     audio.Format(),
+		RealTime:   true,
+	}
+
+	filename := "test/realtime.mp3"
+	writer, err2 := NewAudioWriter(filename, &options)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	defer os.Remove(filename)
+
+	start := time.Now()
+	for audio.Read() {
+		writer.Write(audio.Buffer())
+	}
+	writer.Close()
+
+	// The file is about one second long, so writing it should take at least
+	// that long minus the audio that fits into the pipe buffer.
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		panic(fmt.Sprintf("writing took %v, expected real time pacing", elapsed))
+	}
+
+	fmt.Println("Audio Writer Real Time test passed")
+}
+
+func TestPlayerPause(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+	player, err2 := NewPlayer(
+		audio.Channels(),
+		audio.SampleRate(),
+		audio.Format(),
+	)
+	if err2 != nil {
+		panic(err2)
+	}
+	defer player.Close()
+
+	audio.Read()
+
+	player.Pause()
+	assertEquals(player.Paused(), true)
+
+	done := make(chan error)
+	go func() {
+		done <- player.Play(audio.Buffer())
+	}()
+
+	// Play blocks while the player is paused.
+	select {
+	case <-done:
+		panic("Play returned while paused")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	player.Resume()
+	assertEquals(player.Paused(), false)
+
+	if err := <-done; err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Pause test passed")
+}
+
+func TestGainSaturation(t *testing.T) {
+	samples := []int16{16384, -16384, 30000, -30000, 0}
+	buffer := applyGain(samplesToBytes(samples), createFormat("s16"), 2)
+	result := bytesToSamples(buffer, len(samples), createFormat("s16")).([]int16)
+
+	assertEquals(result[0], int16(32767))
+	assertEquals(result[1], int16(-32768))
+	assertEquals(result[2], int16(32767))
+	assertEquals(result[3], int16(-32768))
+	assertEquals(result[4], int16(0))
+
+	unsigned := []uint8{128, 255, 0, 192}
+	buffer = applyGain(samplesToBytes(unsigned), "u8", 2)
+
+	assertEquals(buffer[0], byte(128))
+	assertEquals(buffer[1], byte(255))
+	assertEquals(buffer[2], byte(0))
+	assertEquals(buffer[3], byte(255))
+
+	fmt.Println("Gain Saturation test passed")
+}
+
+func TestPlayerWait(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+	player, err2 := NewPlayer(audio.Channels(), audio.SampleRate(), audio.Format(), nil)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	start := time.Now()
+	for audio.Read() {
+		player.Play(audio.Buffer())
+	}
+
+	if err := player.Wait(); err != nil {
+		panic(err)
+	}
+
+	// Wait only returns once the entire file has been played.
+	if elapsed := time.Since(start); elapsed.Seconds() < audio.Duration() {
+		panic(fmt.Sprintf("Wait returned after %v", elapsed))
+	}
+
+	fmt.Println("Player Wait test passed")
+}
+
+func TestPlayerPlayAsync(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+	player, err2 := NewPlayer(
+		audio.Channels(),
+		audio.SampleRate(),
+		audio.Format(),
+		&Options{QueueSize: audio.SampleRate() * 2},
+	)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	assertEquals(player.QueueSize(), audio.SampleRate()*2)
+
+	// The whole file fits into the queue, so queueing it should not block.
+	start := time.Now()
+	for audio.Read() {
+		if err := player.PlayAsync(audio.Buffer()); err != nil {
+			panic(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed.Seconds() > audio.Duration()/2 {
+		panic(fmt.Sprintf("PlayAsync blocked for %v", elapsed))
+	}
+
+	if err := player.Wait(); err != nil {
+		panic(err)
+	}
+	assertEquals(player.Error(), nil)
+
+	fmt.Println("Player PlayAsync test passed")
+}
+
+func TestSpeakerParsing(t *testing.T) {
+	sinks := parseSinks(
+		`Auto-detected sinks for pulse:
+* alsa_output.pci-0000_00_1f.3.analog-stereo [Built-in Audio Analog Stereo]
+  alsa_output.usb-Generic_USB_Audio-00.analog-stereo [USB Audio Analog Stereo]`,
+	)
+
+	assertEquals(len(sinks), 2)
+	assertEquals(sinks[0], "alsa_output.pci-0000_00_1f.3.analog-stereo")
+	assertEquals(sinks[1], "alsa_output.usb-Generic_USB_Audio-00.analog-stereo")
+
+	devices := parseAudioToolboxDevices(
+		`[AudioToolbox @ 0x7f9b5c704a40] CoreAudio devices:
+[AudioToolbox @ 0x7f9b5c704a40] [0]         MacBook Pro Speakers, BuiltInSpeakerDevice
+[AudioToolbox @ 0x7f9b5c704a40] [1]                 LG HDR 4K, 1E6D-5B77`,
+	)
+
+	assertEquals(len(devices), 2)
+	assertEquals(devices[0], "MacBook Pro Speakers")
+	assertEquals(devices[1], "LG HDR 4K")
+
+	fmt.Println("Speaker Parsing test passed")
+}
+
+func TestPlayerPosition(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+	player, err2 := NewPlayer(audio.Channels(), audio.SampleRate(), audio.Format(), &Options{Lead: 100 * time.Millisecond})
+	if err2 != nil {
+		panic(err2)
+	}
+	defer player.Close()
+
+	assertEquals(player.Position(), 0.0)
+
+	written := 0
+	for audio.Read() {
+		player.Play(audio.Buffer())
+		written += len(audio.Buffer())
+	}
+
+	assertEquals(player.BytesWritten(), written)
+
+	// Play returns once the last bytes are written, which is at most the lead ahead of playback.
+	position := player.Position()
+	if position > audio.Duration() || position < audio.Duration()-0.2 {
+		panic(fmt.Sprintf("invalid playback position: %f", position))
+	}
+
+	fmt.Println("Player Position test passed")
+}
+
+func TestPlayerErrors(t *testing.T) {
+	player, err := NewPlayer(2, 44100, "s16", &Options{Device: "aio-missing-device"})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	// The device cannot be opened, so playback fails with the error from ffmpeg.
+	err = player.Play(make([]int16, 44100*2))
+	if err == nil {
+		panic("playing to a missing device should fail")
+	}
+	assertEquals(strings.HasPrefix(err.Error(), "audio playback failed: "), true)
+
+	fmt.Println("Player Errors test passed")
+}
+
+func TestPlayFile(t *testing.T) {
+	if err := PlayFile("test/beach.mp3", &Options{SampleRate: 22050, Channels: 1}); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := PlayFileContext(ctx, "test/beach.mp3", nil)
+	assertEquals(err, context.DeadlineExceeded)
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		panic(fmt.Sprintf("cancelling playback took %v", elapsed))
+	}
+
+	fmt.Println("Play File test passed")
+}
+
+func TestSampleConversion(t *testing.T) {
+	s16 := createFormat("s16")
+
+	floats := []float64{0, 0.5, -0.5, 1, -1, 2}
+	buffer := convertBuffer(samplesToBytes(floats), sampleFormat(floats), s16)
+	result := bytesToSamples(buffer, len(floats), s16).([]int16)
+
+	assertEquals(result[0], int16(0))
+	assertEquals(result[1], int16(16384))
+	assertEquals(result[2], int16(-16384))
+	assertEquals(result[3], int16(32767))
+	assertEquals(result[4], int16(-32768))
+	assertEquals(result[5], int16(32767))
+
+	ints := []int32{0, 1 << 30, -1 << 30, math.MaxInt32, math.MinInt32}
+	buffer = convertBuffer(samplesToBytes(ints), sampleFormat(ints), s16)
+	result = bytesToSamples(buffer, len(ints), s16).([]int16)
+
+	assertEquals(result[0], int16(0))
+	assertEquals(result[1], int16(16384))
+	assertEquals(result[2], int16(-16384))
+	assertEquals(result[3], int16(32767))
+	assertEquals(result[4], int16(-32768))
+
+	// Byte slices are raw audio data and are never converted.
+	assertEquals(sampleFormat([]byte{}), "")
+
+	fmt.Println("Sample Conversion test passed")
+}
+
+func TestPlayerWriter(t *testing.T) {
+	audio, err1 := NewAudio("test/beach.mp3", nil)
+	if err1 != nil {
+		panic(err1)
+	}
+	player, err2 := NewPlayer(audio.Channels(), audio.SampleRate(), audio.Format(), nil)
+	if err2 != nil {
+		panic(err2)
+	}
+
+	writer := player.Writer()
+
+	audio.Read()
+	buffer := audio.Buffer()
+
+	// Split the buffer in the middle of a frame.
+	if _, err := writer.Write(buffer[:101]); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 100)
+	if _, err := writer.Write(buffer[101:]); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), len(buffer))
+
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Writer test passed")
+}
+
+func TestPlayerLoop(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "f32", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	// 100 ms sine wave at 440 Hz.
+	samples := make([]float32, 4410)
+	for i := range samples {
+		samples[i] = float32(0.2 * math.Sin(2*math.Pi*440*float64(i)/44100))
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- player.PlayLoop(samples, stop)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	if err := player.Play(samples); err == nil {
+		panic("Play should fail while looping")
+	}
+
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			panic(err)
+		}
+	case <-time.After(300 * time.Millisecond):
+		panic("loop did not stop")
+	}
+
+	// The clip has been played several times.
+	if player.BytesWritten() < 4*len(samples)*4 {
+		panic("loop did not repeat the samples")
+	}
+
+	fmt.Println("Player Loop test passed")
+}
+
+func TestPlayerUnderrun(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	underruns := make(chan struct{}, 1)
+	player.OnUnderrun(func() {
+		underruns <- struct{}{}
+	})
+
+	silence := make([]int16, 4410) // 100 ms.
+
+	player.Play(silence)
+	if player.BufferedDuration() <= 0 {
+		panic("expected buffered audio after Play")
+	}
+
+	// Starve the player, then continue playing.
+	time.Sleep(300 * time.Millisecond)
+	assertEquals(player.BufferedDuration(), time.Duration(0))
+	player.Play(silence)
+
+	select {
+	case <-underruns:
+	case <-time.After(time.Second):
+		panic("underrun callback was not called")
+	}
+	assertEquals(player.Underruns(), 1)
+
+	// Running out of audio while paused is not an underrun.
+	player.Pause()
+	time.Sleep(300 * time.Millisecond)
+	player.Resume()
+	player.Play(silence)
+	assertEquals(player.Underruns(), 1)
+
+	fmt.Println("Player Underrun test passed")
+}
+
+func TestPlayerReconfigure(t *testing.T) {
+	player, err := NewPlayer(2, 44100, "s16", &Options{QueueSize: 1000})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	player.Play(make([]int16, 44100/5*2))
+
+	if err := player.Reconfigure(1, 16000, "f32"); err != nil {
+		panic(err)
+	}
+
+	assertEquals(player.Channels(), 1)
+	assertEquals(player.SampleRate(), 16000)
+	assertEquals(player.Format(), "f32")
+	assertEquals(player.QueueSize(), 1000)
+	assertEquals(player.BytesWritten(), 0)
+
+	// int16 samples are converted to the new f32 format.
+	if err := player.Play(make([]int16, 1600)); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 1600*4)
+
+	if player.Reconfigure(1, 16000, "f16") == nil {
+		panic("invalid format should be rejected")
+	}
+	if player.Reconfigure(0, 16000, "s16") == nil {
+		panic("invalid number of channels should be rejected")
+	}
+	if player.Reconfigure(1, -44100, "s16") == nil {
+		panic("invalid sample rate should be rejected")
+	}
+	// Rejected configurations leave the player unchanged.
+	assertEquals(player.Channels(), 1)
+	assertEquals(player.SampleRate(), 16000)
+	assertEquals(player.QueueSize(), 1000)
+
+	fmt.Println("Player Reconfigure test passed")
+}
+
+func TestPlayerBackend(t *testing.T) {
+	player, err := NewPlayer(2, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	if installed("ffplay") == nil {
+		assertEquals(player.Backend(), "ffplay")
+	} else {
+		assertEquals(player.Backend(), "ffmpeg")
+	}
+
+	if err := player.Play(make([]int16, 4410*2)); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Backend test passed")
+}
+
+func TestPlayerStop(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "s16", &Options{Lead: 100 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- player.Play(make([]int16, 44100*5)) // 5 seconds.
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	player.Stop()
+	player.Stop() // Stopping twice does nothing.
+
+	select {
+	case err := <-done:
+		if err == nil {
+			panic("stopped Play should return an error")
+		}
+	case <-time.After(time.Second):
+		panic("Play did not return after Stop")
+	}
+
+	assertEquals(player.BytesWritten(), 0)
+
+	// The player can be used again after stopping.
+	if err := player.Play(make([]int16, 4410)); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Stop test passed")
+}
+
+func TestPlayerChannelLayout(t *testing.T) {
+	if _, err := NewPlayer(2, 44100, "s16", &Options{ChannelLayout: "5.1"}); err == nil {
+		panic("expected error for mismatched channel layout")
+	}
+	if _, err := NewPlayer(6, 44100, "s16", &Options{ChannelLayout: "5.2"}); err == nil {
+		panic("expected error for unknown channel layout")
+	}
+
+	player, err := NewPlayer(6, 44100, "s16", &Options{ChannelLayout: "5.1", Downmix: true})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	assertEquals(player.ChannelLayout(), "5.1")
+	assertEquals(player.Downmix(), true)
+	assertEquals(strings.Join(player.input(), " "), "-f s16le -ac 6 -ch_layout 5.1 -ar 44100 -i -")
+	assertEquals(strings.Join(player.filters(), ","), "aformat=channel_layouts=stereo")
+
+	if err := player.Reconfigure(2, 44100, "s16"); err != nil {
+		panic(err)
+	}
+	assertEquals(player.ChannelLayout(), "")
+	assertEquals(len(player.filters()), 0)
+
+	fmt.Println("Player Channel Layout test passed")
+}
+
+func TestPlayer24Bit(t *testing.T) {
+	player, err := NewPlayer(2, 44100, "s24", &Options{Endianness: "le", QueueSize: 100})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	assertEquals(player.Format(), "s24")
+	assertEquals(player.BitsPerSample(), 24)
+	assertEquals(player.BytesPerFrame(), 6)
+	assertEquals(player.QueueSize(), 100)
+	assertEquals(strings.Join(player.input(), " "), "-f s32le -ac 2 -ar 44100 -i -")
+
+	// 24-bit values are moved to the top of 32-bit samples, whether given as []int32 or packed bytes.
+	expected := []byte{0, 1, 0, 0, 0, 255, 255, 255, 0, 255, 255, 127, 0, 0, 0, 128}
+	buffer, err := player.prepare([]int32{1, -1, 8388607, -8388608})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(buffer, expected), true)
+	buffer, err = player.prepare([]byte{1, 0, 0, 255, 255, 255, 255, 255, 127, 0, 0, 128})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(buffer, expected), true)
+
+	if _, err := player.prepare([]int32{1 << 23}); err == nil {
+		panic("expected error for sample outside of the 24-bit range")
+	}
+	if err := player.Play(make([]int32, 4410*2)); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 4410*2*4)
+
+	unsigned, err := NewPlayer(1, 44100, "u24be", &Options{ClampSamples: true})
+	if err != nil {
+		panic(err)
+	}
+	defer unsigned.Close()
+
+	assertEquals(unsigned.Format(), "u24")
+	assertEquals(strings.Join(unsigned.input(), " "), "-f s32be -ac 1 -ar 44100 -i -")
+	buffer, err = unsigned.prepare([]uint32{1 << 23, 1 << 25})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(buffer, []byte{0, 0, 0, 0, 127, 255, 255, 0}), true)
+
+	if err := player.Reconfigure(2, 48000, "s16"); err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Join(player.input(), " "), "-f s16le -ac 2 -ar 48000 -i -")
+	assertEquals(player.QueueSize(), 100)
+
+	fmt.Println("Player 24 Bit test passed")
+}
+
+func TestPlayerFilter(t *testing.T) {
+	player, err := NewPlayer(6, 44100, "s16", &Options{Downmix: true, Filter: "bass=g=6"})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	assertEquals(player.Filter(), "bass=g=6")
+	assertEquals(strings.Join(player.filters(), ","), "aformat=channel_layouts=stereo,bass=g=6")
+
+	if err := player.Play(make([]int16, 4410*6)); err != nil {
+		panic(err)
+	}
+
+	if err := player.SetFilter("equalizer=f=100:t=q:w=1:g=6"); err != nil {
+		panic(err)
+	}
+	assertEquals(player.Filter(), "equalizer=f=100:t=q:w=1:g=6")
+
+	if err := player.Play(make([]int16, 4410*6)); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Filter test passed")
+}
+
+func TestPlayerProgress(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "s16", &Options{ProgressInterval: 50 * time.Millisecond, Lead: 100 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	var mutex sync.Mutex
+	progress := []int64{}
+	player.OnProgress(func(played int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		progress = append(progress, played)
+	})
+
+	if err := player.Play(make([]int16, 44100/2)); err != nil {
+		panic(err)
+	}
+	player.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(progress) < 3 {
+		panic(fmt.Sprintf("expected at least 3 progress calls, got %d", len(progress)))
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] < progress[i-1] {
+			panic("played samples decreased")
+		}
+	}
+	if progress[len(progress)-1] > 44100/2 {
+		panic("played samples exceed samples written")
+	}
+
+	fmt.Println("Player Progress test passed")
+}
+
+func TestPlayerDisplay(t *testing.T) {
+	if _, err := NewPlayer(2, 44100, "s16", &Options{Display: 3}); err == nil {
+		panic("expected error for invalid display mode")
+	}
+
+	if runtime.GOOS == "linux" {
+		display, wayland := os.Getenv("DISPLAY"), os.Getenv("WAYLAND_DISPLAY")
+		defer os.Setenv("DISPLAY", display)
+		defer os.Setenv("WAYLAND_DISPLAY", wayland)
+
+		os.Unsetenv("DISPLAY")
+		os.Unsetenv("WAYLAND_DISPLAY")
+		if _, err := NewPlayer(2, 44100, "s16", &Options{Display: 1}); err == nil {
+			panic("expected error without a display")
+		}
+
+		os.Setenv("DISPLAY", ":0")
+	}
+
+	if installed("ffplay") != nil {
+		return
+	}
+
+	player, err := NewPlayer(2, 44100, "s16", &Options{Display: 2, WindowTitle: "aio"})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	assertEquals(player.Display(), 2)
+
+	fmt.Println("Player Display test passed")
+}
+
+func TestPlayerPlayAudio(t *testing.T) {
+	audio, err := NewAudio("test/beach.mp3", &Options{Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+
+	mismatched, err := NewPlayer(audio.Channels()+1, audio.SampleRate(), "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer mismatched.Close()
+
+	if err := mismatched.PlayAudio(audio); err == nil {
+		panic("expected error for mismatched channels")
+	}
+
+	audio, err = NewAudio("test/beach.mp3", &Options{Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+
+	player, err := NewPlayer(audio.Channels(), audio.SampleRate(), "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	if err := player.PlayAudio(audio); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), false)
+
+	fmt.Println("Player PlayAudio test passed")
+}
+
+func TestPlayerBalance(t *testing.T) {
+	buffer := applyChannelGains(samplesToBytes([]int16{1000, 1000, -2000, -2000}), createFormat("s16"), []float64{0.5, 1})
+	result := bytesToSamples(buffer, 4, createFormat("s16")).([]int16)
+
+	assertEquals(result[0], int16(500))
+	assertEquals(result[1], int16(1000))
+	assertEquals(result[2], int16(-1000))
+	assertEquals(result[3], int16(-2000))
+
+	mono, err := NewPlayer(1, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer mono.Close()
+
+	if err := mono.SetBalance(0.5); err == nil {
+		panic("expected error for balance on mono player")
+	}
+
+	player, err := NewPlayer(2, 44100, "f32", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	if err := player.SetBalance(2); err == nil {
+		panic("expected error for balance out of range")
+	}
+	if err := player.SetBalance(-0.5); err != nil {
+		panic(err)
+	}
+	assertEquals(player.Balance(), -0.5)
+
+	if err := player.Play([]float32{1, 1, 1, 1}); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Balance test passed")
+}
+
+func TestPlayerLead(t *testing.T) {
+	if _, err := NewPlayer(1, 44100, "s16", &Options{Lead: -time.Second}); err == nil {
+		panic("expected error for negative lead")
+	}
+
+	player, err := NewPlayer(1, 44100, "s16", &Options{Lead: 500 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	assertEquals(player.Lead(), 500*time.Millisecond)
+
+	// Play returns once all but the last 500 ms have been played.
+	start := time.Now()
+	if err := player.Play(make([]int16, 44100)); err != nil {
+		panic(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 800*time.Millisecond {
+		panic(fmt.Sprintf("expected Play to take about 500 ms, took %v", elapsed))
+	}
+
+	// Without a lead, Play does not wait for the audio to be played.
+	unpaced, err := NewPlayer(1, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer unpaced.Close()
+
+	assertEquals(unpaced.Lead(), time.Duration(0))
+
+	start = time.Now()
+	if err := unpaced.Play(make([]int16, 44100)); err != nil {
+		panic(err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		panic(fmt.Sprintf("expected unpaced Play to return immediately, took %v", elapsed))
+	}
+
+	fmt.Println("Player Lead test passed")
+}
+
+func TestPlayerFade(t *testing.T) {
+	// Unsigned samples fade towards the center value.
+	buffer := applyFrameGains([]byte{255, 0, 255, 0}, "u8", 2, func(frame int) float64 {
+		return 1 - float64(frame)
+	})
+	assertEquals(buffer[0], byte(255))
+	assertEquals(buffer[1], byte(0))
+	assertEquals(buffer[2], byte(128))
+	assertEquals(buffer[3], byte(128))
+
+	player, err := NewPlayer(1, 44100, "s16", &Options{Lead: 100 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	player.FadeIn(50 * time.Millisecond)
+	if err := player.Play(make([]int16, 44100/5)); err != nil {
+		panic(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- player.Play(make([]int16, 44100*5)) // 5 seconds.
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	player.FadeOut(200 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		panic(fmt.Sprintf("expected FadeOut to take about 300 ms, took %v", elapsed))
+	}
+
+	if err := <-done; err == nil {
+		panic("expected Play to be stopped by FadeOut")
+	}
+
+	// The player can be used again after fading out.
+	if err := player.Play(make([]int16, 4410)); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Player Fade test passed")
+}
+
+func TestPlayerPlayChan(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	buffers := make(chan []byte)
+	go func() {
+		for i := 0; i < 5; i++ {
+			buffers <- make([]byte, 4410*2)
+		}
+		close(buffers)
+	}()
+	if err := player.PlayChan(buffers); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 5*4410*2)
+
+	samples := make(chan interface{})
+	go func() {
+		samples <- make([]float32, 4410)
+		samples <- "invalid"
+	}()
+	if err := player.PlaySamplesChan(samples); err == nil {
+		panic("expected error for invalid samples")
+	}
+
+	// Stop returns from PlayChan even if nothing is sent.
+	done := make(chan error)
+	go func() {
+		done <- player.PlayChan(make(chan []byte))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	player.Stop()
+	if err := <-done; err == nil {
+		panic("expected PlayChan to be stopped")
+	}
+
+	fmt.Println("Player PlayChan test passed")
+}
+
+func TestPlayerLatency(t *testing.T) {
+	if _, err := NewPlayer(2, 44100, "s16", &Options{Latency: -time.Second}); err == nil {
+		panic("expected error for negative latency")
+	}
+
+	player, err := NewPlayer(2, 44100, "s16", &Options{Latency: 20 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	if runtime.GOOS != "linux" {
+		assertEquals(player.Latency(), time.Duration(0))
+		return
+	}
+
+	assertEquals(player.Latency(), 20*time.Millisecond)
+
+	command, err := player.sinkCommand()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Contains(strings.Join(command, " "), "-buffer_duration 20"), true)
+
+	fmt.Println("Player Latency test passed")
+}
+
+func TestPlayerSyncOffset(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "u8", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+
+	player.SetSyncOffset(100 * time.Millisecond)
+	assertEquals(player.SyncOffset(), 100*time.Millisecond)
+
+	if err := player.Play(make([]uint8, 4410)); err != nil {
+		panic(err)
+	}
+	// 100 ms of silence are inserted once.
+	assertEquals(player.BytesWritten(), 8820)
+	if err := player.Play(make([]uint8, 4410)); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 13230)
+
+	// Decreasing the offset drops audio.
+	player.SetSyncOffset(50 * time.Millisecond)
+	if err := player.Play(make([]uint8, 4410)); err != nil {
+		panic(err)
+	}
+	assertEquals(player.BytesWritten(), 15435)
+
+	// Silence for unsigned formats is the center value.
+	player.SetSyncOffset(60 * time.Millisecond)
+	player.mutex.Lock()
+	silence := player.sync([]byte{})
+	player.mutex.Unlock()
+	assertEquals(len(silence), 441)
+	assertEquals(silence[0], byte(128))
+
+	fmt.Println("Player Sync Offset test passed")
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	for _, format := range ValidFormats() {
+		normalized, err := NormalizeFormat(format)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(normalized, createFormat(format))
+	}
+
+	normalized, err := NormalizeFormat("u24be")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(normalized, "u24be")
+
+	for _, format := range []string{"", "s16lele", "u8be", "alaw", "f16"} {
+		if _, err := NormalizeFormat(format); err == nil {
+			panic(fmt.Sprintf("expected error for format %q", format))
+		}
+	}
+
+	fmt.Println("Normalize Format test passed")
+}
+
+func TestUnknownMetadata(t *testing.T) {
+	// ffprobe output for a raw AAC stream, which has no duration or bitrate.
+	output := "stream|index=0|codec_name=aac|codec_long_name=AAC (Advanced Audio Coding)|profile=LC|codec_type=audio|" +
+		"codec_tag_string=[0][0][0][0]|codec_tag=0x0000|sample_fmt=fltp|sample_rate=44100|channels=2|channel_layout=stereo|" +
+		"bits_per_sample=0|id=N/A|r_frame_rate=0/0|avg_frame_rate=0/0|time_base=1/28224000|start_pts=0|start_time=0.000000|" +
+		"duration_ts=N/A|duration=N/A|bit_rate=N/A|max_bit_rate=N/A|bits_per_raw_sample=N/A|nb_frames=N/A\n"
+
+	data := parseFFprobe(output)
+	assertEquals(len(data), 1)
+
+	audio := &Audio{known: make(map[string]bool)}
+	audio.addAudioData(data[0])
+
+	assertEquals(audio.SampleRate(), 44100)
+	assertEquals(audio.Channels(), 2)
+	assertEquals(audio.Codec(), "aac")
+	assertEquals(audio.Known("sample_rate"), true)
+	assertEquals(audio.Known("channels"), true)
+	assertEquals(audio.Known("codec_name"), true)
+	assertEquals(audio.Known("duration"), false)
+	assertEquals(audio.Known("bit_rate"), false)
+	assertEquals(audio.Duration(), float64(0))
+	assertEquals(audio.Bitrate(), 0)
+
+	audio = &Audio{known: make(map[string]bool)}
+	audio.addAudioData(parseFFprobe("stream|index=0|codec_name=pcm_s16le|sample_rate=N/A|channels=1|duration=1.500000\n")[0])
+
+	assertEquals(audio.Known("sample_rate"), false)
+	assertEquals(audio.Known("duration"), true)
+	assertEquals(audio.Duration(), 1.5)
+
+	fmt.Println("Unknown Metadata test passed")
+}
+
+func TestLargeFFprobeOutput(t *testing.T) {
+	if installed("ffmpeg") != nil {
+		panic("ffmpeg is not installed")
+	}
+
+	filename := filepath.Join(os.TempDir(), "aio_large_probe.mka")
+	defer os.Remove(filename)
+
+	// Add enough stream tags to make the ffprobe output much larger than 2 KB.
+	args := []string{"-y", "-loglevel", "quiet", "-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo", "-t", "0.1"}
+	for i := 0; i < 64; i++ {
+		args = append(args, "-metadata:s:a:0", fmt.Sprintf("tag%d=%s", i, strings.Repeat("x", 64)))
+	}
+	args = append(args, filename)
+	if err := exec.Command("ffmpeg", args...).Run(); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+
+	assertEquals(audio.SampleRate(), 44100)
+	assertEquals(audio.Channels(), 2)
+	// Matroska may change the case of tag names.
+	found := false
+	for key, value := range audio.MetaData() {
+		if strings.EqualFold(key, "TAG:tag63") {
+			assertEquals(value, strings.Repeat("x", 64))
+			found = true
+		}
+	}
+	assertEquals(found, true)
+
+	fmt.Println("Large FFprobe Output test passed")
+}
+
+func TestFFprobeParsing(t *testing.T) {
+	tests := []struct {
+		line     string
+		key      string
+		expected string
+	}{
+		{`stream|index=0|codec_name=mp3|sample_rate=44100|TAG:title=Beach Waves`, "TAG:title", "Beach Waves"},
+		{`stream|index=0|codec_name=flac|TAG:ARTIST=Sigur Rós|TAG:ALBUM=夜の海`, "TAG:ARTIST", "Sigur Rós"},
+		{`stream|index=0|codec_name=flac|TAG:ARTIST=Sigur Rós|TAG:ALBUM=夜の海`, "TAG:ALBUM", "夜の海"},
+		{`stream|index=0|codec_name=opus|TAG:comment=key=value|sample_rate=48000`, "TAG:comment", "key=value"},
+		{`stream|index=0|codec_name=opus|TAG:cover=aGVsbG8=|sample_rate=48000`, "TAG:cover", "aGVsbG8="},
+		{`stream|index=0|codec_name=opus|TAG:cover=aGVsbG8=|sample_rate=48000`, "sample_rate", "48000"},
+		{`stream|index=0|codec_name=aac|TAG:title=|sample_rate=44100`, "TAG:title", ""},
+		{`stream|index=0|codec_name=aac|TAG:title=|sample_rate=44100`, "sample_rate", "44100"},
+		{`stream|index=0|TAG:title=Left \| Right|sample_rate=22050|channels=1`, "TAG:title", "Left | Right"},
+		{`stream|index=0|TAG:title=Left \| Right|sample_rate=22050|channels=1`, "sample_rate", "22050"},
+		{`stream|index=0|TAG:path=C:\\Music\\beach.mp3|channels=2`, "TAG:path", `C:\Music\beach.mp3`},
+		{`stream|index=0|TAG:lyrics=line one\nline two|channels=2`, "TAG:lyrics", "line one\nline two"},
+	}
+
+	for _, test := range tests {
+		data := parseFFprobe(test.line + "\n")
+		assertEquals(len(data), 1)
+		value, ok := data[0][test.key]
+		if !ok {
+			panic(fmt.Sprintf("key %s not found in %s", test.key, test.line))
+		}
+		assertEquals(value, test.expected)
+	}
+
+	fmt.Println("FFprobe Parsing test passed")
+}
+
+func TestMultipleStreamParsing(t *testing.T) {
+	output := "stream|index=0|codec_name=opus|codec_type=audio|sample_rate=48000|channels=2|TAG:language=eng\n" +
+		"side_data|side_data_type=Replay Gain|track_gain=-6.000000\n" +
+		"stream|index=1|codec_name=aac|codec_type=audio|sample_rate=22050|channels=1|TAG:language=ger\n"
+
+	data := parseFFprobe(output)
+	assertEquals(len(data), 2)
+	assertEquals(data[0]["sample_rate"], "48000")
+	assertEquals(data[0]["track_gain"], "-6.000000")
+	assertEquals(data[0]["TAG:language"], "eng")
+	assertEquals(data[1]["sample_rate"], "22050")
+	assertEquals(data[1]["codec_name"], "aac")
+	assertEquals(data[1]["TAG:language"], "ger")
+
+	if installed("ffmpeg") != nil {
+		panic("ffmpeg is not installed")
+	}
+
+	filename := filepath.Join(os.TempDir(), "aio_two_streams.mka")
+	defer os.Remove(filename)
+
+	err := exec.Command(
+		"ffmpeg", "-y", "-loglevel", "quiet",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000:duration=0.5",
+		"-f", "lavfi", "-i", "sine=frequency=880:sample_rate=22050:duration=0.5",
+		"-map", "0", "-map", "1", "-ac:a:1", "2",
+		filename,
+	).Run()
+	if err != nil {
+		panic(err)
+	}
+
+	streams, err := NewAudioStreams(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(streams), 2)
+	assertEquals(streams[0].SampleRate(), 48000)
+	assertEquals(streams[0].Channels(), 1)
+	assertEquals(streams[1].SampleRate(), 22050)
+	assertEquals(streams[1].Channels(), 2)
+
+	fmt.Println("Multiple Stream Parsing test passed")
+}
+
+func TestStreamTypes(t *testing.T) {
+	audio, err := NewAudio("test/beach.mp3", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+
+	assertEquals(audio.HasStreams(), false)
+	assertEquals(audio.MetaData()["codec_type"], "audio")
+
+	fmt.Println("Stream Types test passed")
+}
+
+func BenchmarkNewAudio(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := NewAudio("test/beach.mp3", nil); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestProcessCleanup(t *testing.T) {
+	HandleInterrupts(true)
+	HandleInterrupts(false)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		player, err := NewPlayer(1, 44100, "s16", nil)
+		if err != nil {
+			panic(err)
+		}
+		if err := player.Play(make([]int16, 441)); err != nil {
+			panic(err)
+		}
+		player.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if after := runtime.NumGoroutine(); after > before+2 {
+		panic(fmt.Sprintf("goroutines leaked: %d before, %d after", before, after))
+	}
+
+	processes.mutex.Lock()
+	assertEquals(len(processes.running), 0)
+	processes.mutex.Unlock()
+
+	fmt.Println("Process Cleanup test passed")
+}
+
+func TestInstalledCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The program counts how often it has been run.
+	counter := filepath.Join(dir, "count")
+	defer fakePrograms(dir, map[string]string{
+		"aiotestprogram": fmt.Sprintf("echo run >> %s\n", counter),
+	})()
+
+	for i := 0; i < 3; i++ {
+		if err := installed("aiotestprogram"); err != nil {
+			panic(err)
+		}
+	}
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Count(string(runs), "run"), 1)
+
+	// A removed program is no longer reported as installed.
+	os.Remove(filepath.Join(dir, "aiotestprogram"))
+	if err := installed("aiotestprogram"); err == nil {
+		panic("expected error for removed program")
+	}
+
+	fmt.Println("Installed Cache test passed")
+}
+
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (logger *testLogger) Printf(format string, v ...interface{}) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.lines = append(logger.lines, fmt.Sprintf(format, v...))
+}
+
+func (logger *testLogger) contains(text string) bool {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	for _, line := range logger.lines {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogging(t *testing.T) {
+	cmd := exec.Command("ffplay")
+
+	// Logging is silent and does not allocate by default.
+	allocs := testing.AllocsPerRun(100, func() {
+		logEvent(cmd, "started")
+	})
+	assertEquals(allocs, float64(0))
+	assertEquals(logOutput(cmd, "info", nil), nil)
+
+	logger := &testLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	output := logOutput(cmd, "info", nil)
+	output.Write([]byte("first line\nsecond "))
+	output.Write([]byte("line\r\n"))
+
+	player, err := NewPlayer(1, 44100, "s16", &Options{LogLevel: "info"})
+	if err != nil {
+		panic(err)
+	}
+	if err := player.Play(make([]int16, 441)); err != nil {
+		panic(err)
+	}
+	player.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	assertEquals(logger.contains("ffplay: first line"), true)
+	assertEquals(logger.contains("ffplay: second line"), true)
+	assertEquals(logger.contains("-loglevel info"), true)
+	assertEquals(logger.contains("started"), true)
+	assertEquals(logger.contains("closed"), true)
+
+	fmt.Println("Logging test passed")
+}
+
+func TestEndianness(t *testing.T) {
+	native := NativeEndianness()
+	assertEquals(native == "le" || native == "be", true)
+
+	for _, order := range []string{"le", "be"} {
+		format, err := orderFormat("s16", order)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(format, "s16"+order)
+
+		ints := []int16{0, 1, -2, 32767, -32768}
+		buffer := samplesToFormat(ints, format)
+		codec := newSampleCodec(format)
+		for i, sample := range ints {
+			assertEquals(int16(codec.bits(buffer[i*2:])), sample)
+		}
+		result := bytesToSamples(buffer, len(ints), format).([]int16)
+		for i := range ints {
+			assertEquals(result[i], ints[i])
+		}
+
+		if format, err = orderFormat("f32", order); err != nil {
+			panic(err)
+		}
+		floats := []float32{0, 0.5, -0.25, 1, -1}
+		buffer = samplesToFormat(floats, format)
+		codec = newSampleCodec(format)
+		for i, sample := range floats {
+			assertEquals(float32(codec.decode(buffer[i*4:])), sample)
+		}
+		floatResult := bytesToSamples(buffer, len(floats), format).([]float32)
+		for i := range floats {
+			assertEquals(floatResult[i], floats[i])
+		}
+	}
+
+	format, err := orderFormat("s16le", "be")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(format, "s16le")
+
+	if format, err = orderFormat("u8", "be"); err != nil {
+		panic(err)
+	}
+	assertEquals(format, "u8")
+
+	if format, err = orderFormat("f64", "native"); err != nil {
+		panic(err)
+	}
+	assertEquals(format, "f64"+native)
+
+	if _, err := orderFormat("s16", "middle"); err == nil {
+		panic("expected error for invalid endianness")
+	}
+
+	fmt.Println("Endianness test passed")
+}
+
+func TestConcurrentClose(t *testing.T) {
+	audio, err := NewAudio("test/beach.mp3", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for audio.Read() {
+			audio.Buffer()
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	audio.Close()
+	wait.Wait()
+	assertEquals(audio.Read(), false)
+
+	writer, err := NewAudioWriter("test/output.wav", &Options{SampleRate: 44100})
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("test/output.wav")
+
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for writer.Write(make([]int16, 4410)) == nil {
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	writer.Close()
+	wait.Wait()
+	if err := writer.Write(make([]int16, 2)); err == nil {
+		panic("expected error writing to a closed writer")
+	}
+
+	fmt.Println("Concurrent Close test passed")
+}
+
+func TestPlayerConcurrentClose(t *testing.T) {
+	player, err := NewPlayer(1, 44100, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for player.Play(make([]int16, 441)) == nil {
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	player.Close()
+	wait.Wait()
+
+	if err := player.Play(make([]int16, 441)); err == nil {
+		panic("expected error playing on a closed player")
+	}
+
+	processes.mutex.Lock()
+	assertEquals(len(processes.running), 0)
+	processes.mutex.Unlock()
+
+	fmt.Println("Player Concurrent Close test passed")
+}
+
+func TestFrameSizes(t *testing.T) {
+	audio := &Audio{samplerate: 44100, channels: 2, bps: 16}
+	assertEquals(audio.BytesPerFrame(), 4)
+	assertEquals(audio.SamplesPerFrame(), 2)
+	assertEquals(audio.BytesPerSecond(), 176400)
+	assertEquals(audio.SetBuffer(make([]byte, 6)) != nil, true)
+
+	mic := &Microphone{samplerate: 48000, channels: 1, bps: 24}
+	assertEquals(mic.BytesPerFrame(), 3)
+	assertEquals(mic.SamplesPerFrame(), 1)
+	assertEquals(mic.BytesPerSecond(), 144000)
+	assertEquals(mic.SetBuffer(make([]byte, 9)), nil)
+
+	writer := &AudioWriter{samplerate: 8000, channels: 6, format: "s24be"}
+	assertEquals(writer.BytesPerFrame(), 18)
+	assertEquals(writer.SamplesPerFrame(), 6)
+	assertEquals(writer.BytesPerSecond(), 144000)
+
+	player := &Player{samplerate: 22050, channels: 2, bps: 64}
+	assertEquals(player.BytesPerFrame(), 16)
+	assertEquals(player.SamplesPerFrame(), 2)
+	assertEquals(player.BytesPerSecond(), 352800)
+
+	fmt.Println("Frame Sizes test passed")
+}
+
+func TestConvertSamples(t *testing.T) {
+	values := []float64{-1, -0.5, -0.123, 0, 0.0001, 0.25, 0.999, 1}
+	// Largest error introduced by storing a value in each format.
+	steps := map[string]float64{
+		"u8": 1.0 / 128, "s8": 1.0 / 128, "u16": 1.0 / 32768, "s16": 1.0 / 32768,
+		"u32": 1.0 / 2147483648, "s32": 1.0 / 2147483648, "f32": 1e-7, "f64": 0,
+	}
+
+	for a, stepA := range steps {
+		for b, stepB := range steps {
+			first, err := ConvertSamples(values, a)
+			if err != nil {
+				panic(err)
+			}
+			second, err := ConvertSamples(first, b)
+			if err != nil {
+				panic(err)
+			}
+			result, err := ConvertSamples(second, "f64")
+			if err != nil {
+				panic(err)
+			}
+			for i, value := range result.([]float64) {
+				if math.Abs(value-values[i]) > stepA+stepB {
+					panic(fmt.Sprintf("%s -> %s: expected %v, got %v", a, b, values[i], value))
+				}
+			}
+		}
+	}
+
+	packed, err := ConvertSamples([]int16{-32768, 0, 16384}, "s24be")
+	if err != nil {
+		panic(err)
+	}
+	codec := newSampleCodec("s24be")
+	assertEquals(len(packed.([]byte)), 9)
+	assertEquals(codec.decode(packed.([]byte)[0:]), -1.0)
+	assertEquals(codec.decode(packed.([]byte)[3:]), 0.0)
+	assertEquals(codec.decode(packed.([]byte)[6:]), 0.5)
+
+	saturated, err := ConvertSamples([]float32{2, -2}, "s16")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(saturated.([]int16)[0], int16(32767))
+	assertEquals(saturated.([]int16)[1], int16(-32768))
+
+	unsigned, err := ConvertSamples([]int8{-128, 0, 127}, "u8")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(unsigned.([]uint8)[0], uint8(0))
+	assertEquals(unsigned.([]uint8)[1], uint8(128))
+	assertEquals(unsigned.([]uint8)[2], uint8(255))
+
+	dst := make([]int16, 0, 16)
+	reused, err := ConvertSamplesInto(dst, []float64{0.5, -0.5}, "s16")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(reused.([]int16)), 2)
+	assertEquals(&reused.([]int16)[0], &dst[:1][0])
+	assertEquals(dst[:2][0], int16(16384))
+
+	// Slices of the wrong type or capacity are not reused.
+	replaced, err := ConvertSamplesInto(make([]int32, 1), []float64{0.5, -0.5}, "s16")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(replaced.([]int16)), 2)
+
+	if _, err := ConvertSamples([]string{"a"}, "s16"); err == nil {
+		panic("expected error for invalid sample type")
+	}
+	if _, err := ConvertSamples([]int16{0}, "s12"); err == nil {
+		panic("expected error for invalid format")
+	}
+
+	fmt.Println("Convert Samples test passed")
+}
+
+func TestResample(t *testing.T) {
+	// One second of a 1 kHz tone on the left channel and silence on the right.
+	sine := func(rate int, frequency float64) []float64 {
+		samples := make([]float64, rate*2)
+		for i := 0; i < rate; i++ {
+			samples[i*2] = 0.5 * math.Sin(2*math.Pi*frequency*float64(i)/float64(rate))
+		}
+		return samples
+	}
+
+	resampled, err := Resample(sine(44100, 1000), 2, 44100, 48000)
+	if err != nil {
+		panic(err)
+	}
+	expected := sine(48000, 1000)
+	result := resampled.([]float64)
+	assertEquals(len(result), len(expected))
+	// The edges are skipped, since the audio around the buffer is treated as silence.
+	for i := 2000; i < len(result)-2000; i++ {
+		if math.Abs(result[i]-expected[i]) > 1e-3 {
+			panic(fmt.Sprintf("sample %d: expected %v, got %v", i, expected[i], result[i]))
+		}
+	}
+
+	// Frequencies above the new Nyquist frequency are filtered out when downsampling.
+	resampled, err = Resample(sine(48000, 6000), 2, 48000, 8000)
+	if err != nil {
+		panic(err)
+	}
+	result = resampled.([]float64)
+	assertEquals(len(result), 16000)
+	for i := 400; i < len(result)-400; i++ {
+		if math.Abs(result[i]) > 0.01 {
+			panic(fmt.Sprintf("sample %d was not filtered: %v", i, result[i]))
+		}
+	}
+
+	ints := []int16{100, -100, 200, -200}
+	resampled, err = Resample(ints, 2, 8000, 16000)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(resampled.([]int16)), 8)
+
+	// Identity conversions return the samples without copying.
+	same, err := Resample(ints, 2, 44100, 44100)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(&same.([]int16)[0], &ints[0])
+
+	if _, err := Resample([]int16{1, 2, 3}, 2, 8000, 16000); err == nil {
+		panic("expected error for incomplete frame")
+	}
+	if _, err := Resample(ints, 0, 8000, 16000); err == nil {
+		panic("expected error for invalid channels")
+	}
+	if _, err := Resample(ints, 2, 0, 16000); err == nil {
+		panic("expected error for invalid sample rate")
+	}
+
+	fmt.Println("Resample test passed")
+}
+
+func TestGainHelpers(t *testing.T) {
+	assertEquals(ToDBFS(1), 0.0)
+	assertEquals(math.IsInf(ToDBFS(0), -1), true)
+	assertEquals(math.Abs(ToDBFS(0.5)+6.0206) < 1e-4, true)
+	assertEquals(math.Abs(FromDBFS(ToDBFS(0.25))-0.25) < 1e-12, true)
+
+	values := []float64{0, 0.25, -0.5, 0.125}
+	steps := map[string]float64{
+		"u8": 1.0 / 128, "s8": 1.0 / 128, "u16": 1.0 / 32768, "s16": 1.0 / 32768,
+		"u32": 1.0 / 2147483648, "s32": 1.0 / 2147483648, "f32": 1e-7, "f64": 1e-12,
+	}
+	for format, step := range steps {
+		samples, err := ConvertSamples(values, format)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := ApplyGain(samples, ToDBFS(0.5)); err != nil {
+			panic(err)
+		}
+		result, _ := ConvertSamples(samples, "f64")
+		for i, value := range result.([]float64) {
+			if math.Abs(value-values[i]*0.5) > step {
+				panic(fmt.Sprintf("%s: expected %v, got %v", format, values[i]*0.5, value))
+			}
+		}
+
+		if err := NormalizePeak(samples, -1); err != nil {
+			panic(err)
+		}
+		peak, err := Peak(samples)
+		if err != nil {
+			panic(err)
+		}
+		if math.Abs(ToDBFS(peak)+1) > 0.01 {
+			panic(fmt.Sprintf("%s: expected peak of -1 dBFS, got %v", format, ToDBFS(peak)))
+		}
+
+		// Integer formats saturate instead of wrapping around.
+		if err := ApplyGain(samples, 20); err != nil {
+			panic(err)
+		}
+		result, _ = ConvertSamples(samples, "f64")
+		if format[0] != 'f' {
+			assertEquals(result.([]float64)[1] > 0.99, true)
+			assertEquals(result.([]float64)[2], -1.0)
+		}
+	}
+
+	// The middle of the range of unsigned formats is silence and is not changed by gains.
+	unsigned := []uint8{128, 192, 64}
+	if err := ApplyGain(unsigned, 6); err != nil {
+		panic(err)
+	}
+	assertEquals(unsigned[0], uint8(128))
+	assertEquals(unsigned[1] > 192, true)
+	assertEquals(unsigned[2] < 64, true)
+
+	silence := []int16{0, 0}
+	if err := NormalizePeak(silence, 0); err != nil {
+		panic(err)
+	}
+	assertEquals(silence[0], int16(0))
+
+	if err := ApplyGain([]string{}, 1); err == nil {
+		panic("expected error for invalid sample type")
+	}
+
+	fmt.Println("Gain Helpers test passed")
+}
+
+func TestGenerator(t *testing.T) {
+	generator, err := NewGenerator(Sine(440, 0.5), 1.5, &Options{Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	defer generator.Close()
+
+	assertEquals(generator.SampleRate(), 44100)
+	assertEquals(generator.Format(), "s16")
+
+	crossings := 0
+	sizes := []int{}
+	previous := int16(0)
+	for generator.Read() {
+		samples := generator.Samples().([]int16)
+		sizes = append(sizes, len(samples))
+		for _, sample := range samples {
+			if (previous < 0) != (sample < 0) {
+				crossings++
+			}
+			previous = sample
+		}
+	}
+	assertEquals(len(sizes), 2)
+	assertEquals(sizes[0], 44100)
+	assertEquals(sizes[1], 22050)
+	// A 440 Hz sine wave crosses zero twice per period.
+	if crossings < 1318 || crossings > 1322 {
+		panic(fmt.Sprintf("expected 1320 zero crossings, got %d", crossings))
+	}
+	assertEquals(generator.Read(), false)
+
+	generator, err = NewGenerator(func(t float64, channel int) float64 {
+		return float64(channel) / 2
+	}, 0, &Options{Channels: 2, Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	generator.SetBuffer(make([]byte, 80))
+	for i := 0; i < 3; i++ {
+		assertEquals(generator.Read(), true)
+		samples := generator.Samples().([]float32)
+		assertEquals(samples[8], float32(0))
+		assertEquals(samples[9], float32(0.5))
+	}
+	generator.Close()
+	assertEquals(generator.Read(), false)
+
+	for _, signal := range []Signal{WhiteNoise(0.5), PinkNoise(0.5), Silence()} {
+		generator, err := NewGenerator(signal, 0.1, &Options{Format: "f64"})
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(generator.Read(), true)
+		for _, sample := range generator.Samples().([]float64) {
+			assertEquals(math.Abs(sample) <= 0.5, true)
+		}
+	}
+
+	if _, err := NewGenerator(nil, 1, nil); err == nil {
+		panic("expected error for nil signal")
+	}
+	if _, err := NewGenerator(Silence(), -1, nil); err == nil {
+		panic("expected error for negative duration")
+	}
+
+	fmt.Println("Generator test passed")
+}
+
+// Writes a WAV file with the given format tag and raw sample data.
+func writeTestWAV(filename string, tag uint16, bps, channels, samplerate int, data []byte) {
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+len(data)))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], tag)
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(samplerate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(samplerate*channels*bps/8))
+	binary.LittleEndian.PutUint16(header[32:], uint16(channels*bps/8))
+	binary.LittleEndian.PutUint16(header[34:], uint16(bps))
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(len(data)))
+	if err := os.WriteFile(filename, append(header, data...), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func TestWAVReading(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// 1.5 seconds of a ramp on both channels.
+	samples := make([]int16, 8000*2*3/2)
+	for i := range samples {
+		samples[i] = int16(i * 4)
+	}
+	data := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(sample))
+	}
+	filename := filepath.Join(directory, "ramp.wav")
+	writeTestWAV(filename, 1, 16, 2, 8000, data)
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+
+	assertEquals(audio.SampleRate(), 8000)
+	assertEquals(audio.Channels(), 2)
+	assertEquals(audio.Duration(), 1.5)
+	assertEquals(audio.Codec(), "pcm_s16le")
+	assertEquals(audio.Bitrate(), 256000)
+	assertEquals(audio.Known("duration"), true)
+	assertEquals(audio.Total(), len(data))
+
+	sizes := []int{}
+	index := 0
+	for audio.Read() {
+		result := audio.Samples().([]int16)
+		sizes = append(sizes, len(result))
+		for _, sample := range result {
+			assertEquals(sample, samples[index])
+			index++
+		}
+	}
+	assertEquals(len(sizes), 2)
+	assertEquals(sizes[0], 16000)
+	assertEquals(sizes[1], 8000)
+
+	// Samples are converted to the requested format.
+	floats, err := NewAudio(filename, &Options{Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	defer floats.Close()
+	assertEquals(floats.Read(), true)
+	assertEquals(floats.Samples().([]float32)[1], float32(4)/32768)
+
+	// 24-bit samples and a chunk before the data chunk.
+	data = []byte{0, 0, 0x80, 0xFF, 0xFF, 0x7F, 0, 0, 0x40}
+	extra := []byte("LIST\x03\x00\x00\x00abc\x00")
+	writeTestWAV(filename, 1, 24, 1, 44100, data)
+	content, _ := os.ReadFile(filename)
+	content = append(append(append([]byte{}, content[:36]...), extra...), content[36:]...)
+	os.WriteFile(filename, content, 0644)
+
+	packed, err := NewAudio(filename, &Options{Format: "s32"})
+	if err != nil {
+		panic(err)
+	}
+	defer packed.Close()
+	assertEquals(packed.Codec(), "pcm_s24le")
+	assertEquals(packed.Read(), true)
+	ints := packed.Samples().([]int32)
+	assertEquals(len(ints), 3)
+	assertEquals(ints[0], int32(-2147483648))
+	assertEquals(ints[1], int32(2147483392))
+	assertEquals(ints[2], int32(1073741824))
+	assertEquals(packed.Read(), false)
+
+	// Compressed WAV files are not read natively.
+	writeTestWAV(filename, 0x0055, 16, 2, 44100, data)
+	wav, err := parseWAV(filename)
+	assertEquals(wav == nil && err == nil, true)
+
+	fmt.Println("WAV Reading test passed")
+}
+
+func TestWAVMatchesFFmpeg(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	data := make([]byte, 44100*2*2)
+	for i := 0; i < len(data); i += 2 {
+		binary.LittleEndian.PutUint16(data[i:], uint16(i*31))
+	}
+	filename := filepath.Join(directory, "noise.wav")
+	writeTestWAV(filename, 1, 16, 2, 44100, data)
+
+	for _, format := range []string{"s16", "s32", "f32"} {
+		native, err := NewAudio(filename, &Options{Format: format})
+		if err != nil {
+			panic(err)
+		}
+		defer native.Close()
+		decoded, err := NewAudio(filename, &Options{Format: format})
+		if err != nil {
+			panic(err)
+		}
+		defer decoded.Close()
+		// Reading without the WAV layout goes through ffmpeg.
+		decoded.wav = nil
+
+		for native.Read() {
+			assertEquals(decoded.Read(), true)
+			assertEquals(bytes.Equal(native.Buffer(), decoded.Buffer()), true)
+		}
+		assertEquals(decoded.Read(), false)
+	}
+
+	fmt.Println("WAV Matches FFmpeg test passed")
+}
+
+func TestProbeParsing(t *testing.T) {
+	output := "stream|index=0|codec_name=h264|codec_type=video|duration=10.000000|bit_rate=N/A\n" +
+		"stream|index=1|codec_name=opus|codec_type=audio|sample_rate=48000|channels=2|channel_layout=stereo|" +
+		"duration=9.980000|bit_rate=N/A|TAG:language=eng\n" +
+		"side_data|side_data_type=Replay Gain|track_gain=-6.000000\n" +
+		"format|filename=movie.mkv|nb_streams=2|format_name=matroska,webm|duration=10.000000|size=1048576|" +
+		"bit_rate=838860|TAG:title=Beach \\| Waves|TAG:ENCODER=Lavf59\n"
+
+	streams, format := parseSections(output)
+	probe := newProbeResult(streams, format)
+
+	assertEquals(len(probe.Streams), 2)
+	assertEquals(probe.Streams[0].Type, "video")
+	assertEquals(probe.Streams[0].Bitrate, 0)
+	audio := probe.AudioStreams()
+	assertEquals(len(audio), 1)
+	assertEquals(audio[0].Index, 1)
+	assertEquals(audio[0].Codec, "opus")
+	assertEquals(audio[0].SampleRate, 48000)
+	assertEquals(audio[0].Channels, 2)
+	assertEquals(audio[0].ChannelLayout, "stereo")
+	assertEquals(audio[0].Duration, 9.98)
+	assertEquals(audio[0].Tags["language"], "eng")
+	assertEquals(audio[0].MetaData["track_gain"], "-6.000000")
+	// The format record does not belong to the last stream.
+	_, ok := audio[0].MetaData["format_name"]
+	assertEquals(ok, false)
+
+	assertEquals(probe.Format.Name, "matroska,webm")
+	assertEquals(probe.Format.Duration, 10.0)
+	assertEquals(probe.Format.Size, int64(1048576))
+	assertEquals(probe.Format.Bitrate, 838860)
+	assertEquals(probe.Format.Tags["title"], "Beach | Waves")
+	assertEquals(probe.Format.Tags["ENCODER"], "Lavf59")
+
+	assertEquals(len(parseFFprobe(output)), 2)
+
+	fmt.Println("Probe Parsing test passed")
+}
+
+func TestProbeAudio(t *testing.T) {
+	probe, err := ProbeAudio("test/beach.mp3")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(probe.Format.Name, "mp3")
+	assertEquals(probe.Format.Duration > 0, true)
+
+	audio, err := NewAudio("test/beach.mp3", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+
+	stream := probe.AudioStreams()[0]
+	assertEquals(stream.SampleRate, audio.SampleRate())
+	assertEquals(stream.Channels, audio.Channels())
+	assertEquals(stream.Codec, audio.Codec())
+	assertEquals(stream.Duration, audio.Duration())
+
+	if _, err := ProbeAudio("test/missing.mp3"); err == nil {
+		panic("expected error for missing file")
+	}
+
+	fmt.Println("Probe Audio test passed")
+}
+
+func TestCapabilityParsing(t *testing.T) {
+	assertEquals(parseVersion("ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023 the FFmpeg developers\n"), "6.1.1-3ubuntu5")
+	assertEquals(parseVersion(""), "")
+
+	encoders := parseCodecs("Encoders:\n" +
+		" V..... = Video\n" +
+		" A..... = Audio\n" +
+		" ------\n" +
+		" V....D a64multi             Multicolor charset for Commodore 64 (codec a64_multi)\n" +
+		" A....D aac                  AAC (Advanced Audio Coding)\n" +
+		" A....D libopus              libopus Opus (codec opus)\n")
+	assertEquals(len(encoders), 3)
+	assertEquals(encoders["aac"], true)
+	assertEquals(encoders["libopus"], true)
+	assertEquals(encoders["="], false)
+
+	filters := parseFilters("Filters:\n" +
+		"  T.. = Timeline support\n" +
+		"  A = Audio input/output\n" +
+		"  | = Source or sink filter\n" +
+		" ... acompressor       A->A       Audio compressor.\n" +
+		" TSC loudnorm          A->A       EBU R128 loudness normalization\n" +
+		" ... amix              N->A       Audio mixing.\n")
+	assertEquals(len(filters), 3)
+	assertEquals(filters["loudnorm"], true)
+	assertEquals(filters["amix"], true)
+
+	muxers := parseMuxers("File formats:\n" +
+		" D. = Demuxing supported\n" +
+		" .E = Muxing supported\n" +
+		" --\n" +
+		"  E 3g2             3GP2 (3GPP2 file format)\n" +
+		"  E chromaprint     Chromaprint\n" +
+		"  E matroska,webm   Matroska\n")
+	assertEquals(len(muxers), 4)
+	assertEquals(muxers["chromaprint"], true)
+	assertEquals(muxers["webm"], true)
+	assertEquals(muxers["="], false)
+
+	names := filterNames("[in]volume=0.5, aecho@echo=0.8:0.9:'1000,1800':0.3[a];[a]loudnorm[out]")
+	assertEquals(len(names), 3)
+	assertEquals(names[0], "volume")
+	assertEquals(names[1], "aecho")
+	assertEquals(names[2], "loudnorm")
+
+	fmt.Println("Capability Parsing test passed")
+}
+
+func TestCapabilities(t *testing.T) {
+	version, err := FFmpegVersion()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(version != "", true)
+	assertEquals(HasEncoder("pcm_s16le"), true)
+	assertEquals(HasDecoder("pcm_s16le"), true)
+	assertEquals(HasFilter("volume"), true)
+	assertEquals(HasEncoder("not_an_encoder"), false)
+	assertEquals(HasFilter("not_a_filter"), false)
+
+	if _, err := NewAudioWriter("test/output.wav", &Options{Codec: "not_an_encoder"}); err == nil {
+		panic("expected error for missing encoder")
+	}
+
+	fmt.Println("Capabilities test passed")
+}
+
+func TestSampleFastPath(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	native := NativeEndianness()
+	swapped := map[string]string{"le": "be", "be": "le"}[native]
+
+	for _, kind := range []string{"u16", "s16", "u32", "s32", "f32", "f64"} {
+		size := newSampleCodec(kind + native).size
+		buffer := make([]byte, 1024*size)
+		random.Read(buffer)
+
+		fast := bytesToSamples(buffer, 1024, kind+native)
+		safe := decodeSamples(buffer, kind+native)
+		assertEquals(bytes.Equal(samplesToBytes(fast), samplesToBytes(safe)), true)
+
+		// Aligned buffers in the native byte order share memory with the samples.
+		assertEquals(&samplesToBytes(fast)[0], &buffer[0])
+
+		// Samples in the other byte order are decoded to the same values.
+		reversed := make([]byte, len(buffer))
+		for i := 0; i < len(buffer); i += size {
+			for j := 0; j < size; j++ {
+				reversed[i+j] = buffer[i+size-1-j]
+			}
+		}
+		other := bytesToSamples(reversed, 1024, kind+swapped)
+		assertEquals(bytes.Equal(samplesToBytes(other), samplesToBytes(fast)), true)
+		assertEquals(bytes.Equal(samplesToFormat(other, kind+swapped), reversed), true)
+
+		// Unaligned buffers are copied.
+		unaligned := make([]byte, len(buffer)+1)[1:]
+		copy(unaligned, buffer)
+		copied := bytesToSamples(unaligned, 1024, kind+native)
+		assertEquals(bytes.Equal(samplesToBytes(copied), buffer), true)
+
+		// Empty buffers have no samples.
+		assertEquals(len(samplesToBytes(bytesToSamples(nil, 0, kind+native))), 0)
+	}
+
+	// Changing aliased samples changes the buffer.
+	buffer := make([]byte, 4)
+	bytesToSamples(buffer, 2, "s16"+native).([]int16)[1] = -1
+	assertEquals(buffer[2], byte(255))
+	assertEquals(buffer[3], byte(255))
+
+	// Empty samples of a known type are converted to empty bytes, not to nil.
+	for _, empty := range []interface{}{
+		[]uint8{}, []int8{}, []uint16{}, []int16{}, []uint32{}, []int32{}, []float32{}, []float64{}, []int16(nil),
+	} {
+		converted := samplesToBytes(empty)
+		assertEquals(converted != nil, true)
+		assertEquals(len(converted), 0)
+	}
+	assertEquals(samplesToBytes([]string{}) == nil, true)
+
+	// Writing no samples writes nothing.
+	ring, err := NewRingBuffer(16, 1, 44100, "s16", RingBufferOptions{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Write([]int16{}), nil)
+	assertEquals(ring.Available(), 0)
+
+	fmt.Println("Sample Fast Path test passed")
+}
+
+func BenchmarkBytesToSamples(b *testing.B) {
+	native := NativeEndianness()
+	swapped := map[string]string{"le": "be", "be": "le"}[native]
+	for _, kind := range []string{"s8", "u16", "s16", "s24", "u32", "s32", "f32", "f64"} {
+		for _, order := range []string{native, swapped} {
+			format := kind + order
+			if kind == "s8" {
+				format = kind
+			}
+			size := newSampleCodec(format).size
+			// 100 ms of 32 channel audio at 96 kHz.
+			samples := 9600 * 32
+			buffer := make([]byte, samples*size)
+			b.Run(format, func(b *testing.B) {
+				b.SetBytes(int64(len(buffer)))
+				for i := 0; i < b.N; i++ {
+					bytesToSamples(buffer, samples, format)
+				}
+			})
+			if kind == "s8" {
+				break
+			}
+		}
+	}
+}
+
+func TestOptionValidation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake programs record every run, none of them should be started for invalid options.
+	runs := filepath.Join(dir, "runs")
+	scripts := map[string]string{}
+	for _, program := range []string{"ffmpeg", "ffprobe", "ffplay"} {
+		scripts[program] = fmt.Sprintf("echo %s >> %s\n", program, runs)
+	}
+	defer fakePrograms(dir, scripts)()
+	filename := filepath.Join(dir, "input.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	newAudio := func(options *Options) error {
+		_, err := NewAudio(filename, options)
+		return err
+	}
+	newAudioStreams := func(options *Options) error {
+		_, err := NewAudioStreams(filename, options)
+		return err
+	}
+	newMicrophone := func(options *Options) error {
+		_, err := NewMicrophone(0, options)
+		return err
+	}
+	newAudioWriter := func(options *Options) error {
+		_, err := NewAudioWriter(filepath.Join(dir, "output.wav"), options)
+		return err
+	}
+	newPlayer := func(options *Options) error {
+		_, err := NewPlayer(2, 44100, "s16", options)
+		return err
+	}
+
+	tests := []struct {
+		field       string
+		constructor func(*Options) error
+		options     *Options
+	}{
+		{"SampleRate", newAudio, &Options{SampleRate: -1}},
+		{"SampleRate", newAudioStreams, &Options{SampleRate: 768001}},
+		{"SampleRate", newMicrophone, &Options{SampleRate: -44100}},
+		{"SampleRate", newAudioWriter, &Options{SampleRate: 1000000}},
+		{"SampleRate", newPlayer, &Options{SampleRate: -1}},
+		{"Channels", newAudio, &Options{Channels: 65}},
+		{"Channels", newAudioStreams, &Options{Channels: -2}},
+		{"Channels", newMicrophone, &Options{Channels: 100}},
+		{"Channels", newAudioWriter, &Options{Channels: -1}},
+		{"Channels", newPlayer, &Options{Channels: 65}},
+		{"Stream", newAudio, &Options{Stream: -1}},
+		{"Bitrate", newAudioWriter, &Options{Bitrate: -128000}},
+		{"Volume", newPlayer, &Options{Volume: 101}},
+		{"Display", newPlayer, &Options{Display: 3}},
+		{"QueueSize", newPlayer, &Options{QueueSize: -1}},
+		{"Lead", newPlayer, &Options{Lead: -time.Second}},
+		{"Latency", newPlayer, &Options{Latency: -time.Millisecond}},
+		{"ProgressInterval", newPlayer, &Options{ProgressInterval: -time.Second}},
+		{"filename", func(options *Options) error {
+			_, err := NewAudio("", options)
+			return err
+		}, nil},
+		{"filename", func(options *Options) error {
+			_, err := NewAudioStreams("", options)
+			return err
+		}, nil},
+		{"filename", func(options *Options) error {
+			_, err := NewAudioWriter("", options)
+			return err
+		}, nil},
+		{"channels", func(options *Options) error {
+			_, err := NewPlayer(0, 44100, "s16", options)
+			return err
+		}, nil},
+		{"samplerate", func(options *Options) error {
+			_, err := NewPlayer(2, 800000, "s16", options)
+			return err
+		}, nil},
+		{"options", func(options *Options) error {
+			_, err := NewPlayer(2, 44100, "s16", options, options)
+			return err
+		}, &Options{}},
+	}
+
+	for _, test := range tests {
+		err := test.constructor(test.options)
+		var optionErr *OptionError
+		if !errors.As(err, &optionErr) {
+			panic(fmt.Sprintf("expected option error for %s, got %v", test.field, err))
+		}
+		assertEquals(optionErr.Field, test.field)
+	}
+
+	if _, err := os.Stat(runs); !os.IsNotExist(err) {
+		output, _ := os.ReadFile(runs)
+		panic(fmt.Sprintf("processes were started for invalid options: %q", output))
+	}
+
+	fmt.Println("Option Validation test passed")
+}
+
+func TestStreamHandler(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Half a second of a ramp on both channels.
+	samples := make([]int16, 8000)
+	for i := range samples {
+		samples[i] = int16(i * 4)
+	}
+	data := samplesToBytes(samples)
+	filename := filepath.Join(directory, "ramp.wav")
+	writeTestWAV(filename, wavPCM, 16, 2, 8000, data)
+
+	server := httptest.NewServer(StreamHandler(filename, &Options{Format: "s16"}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		panic(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(response.StatusCode, http.StatusOK)
+	assertEquals(response.Header.Get("Content-Type"), "audio/wav")
+
+	// The streamed file can be read back, even though its size is not known in advance.
+	streamed := filepath.Join(directory, "streamed.wav")
+	if err := os.WriteFile(streamed, body, 0644); err != nil {
+		panic(err)
+	}
+	wav, err := parseWAV(streamed)
+	if err != nil || wav == nil {
+		panic("streamed audio is not a WAV file")
+	}
+	assertEquals(wav.format, "s16le")
+	assertEquals(wav.samplerate, 8000)
+	assertEquals(wav.channels, 2)
+	assertEquals(bytes.Equal(body[44:], data), true)
+
+	// Floating point samples use the WAV float format tag.
+	float := httptest.NewServer(StreamHandler(filename, &Options{Format: "f32"}))
+	defer float.Close()
+	response, err = http.Get(float.URL)
+	if err != nil {
+		panic(err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	assertEquals(binary.LittleEndian.Uint16(body[20:22]), uint16(wavFloat))
+	assertEquals(len(body), 44+len(data)*2)
+
+	// Big endian samples cannot be stored in a WAV file, and missing files are not found.
+	for handler, status := range map[http.Handler]int{
+		StreamHandler(filename, &Options{Format: "s16be"}):          http.StatusInternalServerError,
+		StreamHandler(filename, &Options{SampleRate: -1}):           http.StatusInternalServerError,
+		StreamHandler(filepath.Join(directory, "missing.wav"), nil): http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assertEquals(recorder.Code, status)
+	}
+
+	fmt.Println("Stream Handler test passed")
+}
+
+func TestStreamHandlerDisconnect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg records its arguments and produces output until it is killed.
+	arguments := filepath.Join(dir, "arguments")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fmt.Sprintf(`case "$2" in
+-version) echo "ffmpeg version 6.1.1"; exit 0;;
+-encoders) printf ' ------\n A....D libmp3lame   MP3\n'; exit 0;;
+-decoders|-filters|-muxers) exit 0;;
+esac
+[ "$1" = "-version" ] && exit 0
+echo "$@" > %s
+exec yes
+`, arguments),
+	})()
+
+	filename := filepath.Join(dir, "input.flac")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	server := httptest.NewServer(StreamHandler(filename, &Options{Codec: "libmp3lame", Bitrate: 128000}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		panic(err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(response.Header.Get("Content-Type"), "audio/mpeg")
+	if _, err := io.ReadFull(response.Body, make([]byte, 1<<20)); err != nil {
+		panic(err)
+	}
+
+	args, err := os.ReadFile(arguments)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Contains(string(args), "-acodec libmp3lame -ab 128000 -f mp3 -"), true)
+
+	// Disconnecting stops the ffmpeg process.
+	cancel()
+	response.Body.Close()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		processes.mutex.Lock()
+		running := len(processes.running)
+		processes.mutex.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			panic("ffmpeg was not stopped after the client disconnected")
+		}
+	}
+
+	fmt.Println("Stream Handler Disconnect test passed")
+}
+
+func TestStdinInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffprobe reports a mono stream and records the bytes it was given. The fake ffmpeg
+	// "decodes" its input by copying it to stdout.
+	probed := filepath.Join(dir, "probed")
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fmt.Sprintf(fakeVersion+`cat > %s
+echo "stream|index=0|codec_name=pcm_s16le|codec_type=audio|sample_rate=8000|channels=1"
+echo "format|format_name=s16le|duration=N/A"
+`, probed),
+		"ffmpeg": fakeVersion + "exec cat\n",
+	})()
+
+	// The fixture is larger than the probed prefix, so both parts have to reach ffmpeg.
+	fixture := make([]byte, stdinProbeSize+1000000)
+	rand.New(rand.NewSource(1)).Read(fixture)
+	input := filepath.Join(dir, "input.raw")
+	if err := os.WriteFile(input, fixture, 0644); err != nil {
+		panic(err)
+	}
+	file, err := os.Open(input)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	// The test binary is run again with the fixture piped to its stdin.
+	output := filepath.Join(dir, "output.raw")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestStdinHelperProcess$")
+	cmd.Stdin = file
+	cmd.Env = append(
+		os.Environ(),
+		"AIO_STDIN_OUTPUT="+output,
+	)
+	if result, err := cmd.CombinedOutput(); err != nil {
+		panic(fmt.Sprintf("helper process failed: %v\n%s", err, result))
+	}
+
+	decoded, err := os.ReadFile(output)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(decoded, fixture), true)
+
+	prefix, err := os.ReadFile(probed)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(prefix, fixture[:stdinProbeSize]), true)
+
+	fmt.Println("Stdin Input test passed")
+}
+
+// Reads audio from stdin and writes the samples to the file given by AIO_STDIN_OUTPUT.
+// Only runs as the helper process started by TestStdinInput.
+func TestStdinHelperProcess(t *testing.T) {
+	output := os.Getenv("AIO_STDIN_OUTPUT")
+	if output == "" {
+		return
+	}
+
+	audio, err := NewAudio("-", nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.SampleRate(), 8000)
+	assertEquals(audio.Channels(), 1)
+
+	// Stdin can only be read once.
+	if _, err := NewAudio("pipe:0", nil); err == nil {
+		panic("expected error when reading stdin twice")
+	}
+
+	var decoded []byte
+	for audio.Read() {
+		decoded = append(decoded, audio.Buffer()...)
+	}
+	if err := os.WriteFile(output, decoded, 0644); err != nil {
+		panic(err)
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	// Stereo clips of constant values, so the faded samples follow the curve.
+	a := []float64{0.5, -0.5, 0.5, -0.5, 0.5, -0.5, 0.5, -0.5}
+	b := []float64{0.25, 0.25, 0.25, 0.25, 0.25, 0.25}
+
+	result, err := Crossfade(a, b, 2, 2, "linear")
+	if err != nil {
+		panic(err)
+	}
+	faded := result.([]float64)
+	assertEquals(len(faded), len(a)+len(b)-2*2)
+	expected := []float64{
+		0.5, -0.5, 0.5, -0.5,
+		0.5*0.75 + 0.25*0.25, -0.5*0.75 + 0.25*0.25,
+		0.5*0.25 + 0.25*0.75, -0.5*0.25 + 0.25*0.75,
+		0.25, 0.25,
+	}
+	for i, value := range expected {
+		if math.Abs(faded[i]-value) > 1e-12 {
+			panic(fmt.Sprintf("expected %v at %d, got %v", value, i, faded[i]))
+		}
+	}
+
+	// Equal power gains keep the power of both clips constant.
+	result, err = Crossfade([]float64{1, 1, 1, 1}, []float64{0, 0, 0, 0}, 4, 1, "equal-power")
+	if err != nil {
+		panic(err)
+	}
+	out := result.([]float64)
+	result, _ = Crossfade([]float64{0, 0, 0, 0}, []float64{1, 1, 1, 1}, 4, 1, "equal-power")
+	in := result.([]float64)
+	for i := range out {
+		assertEquals(math.Abs(out[i]*out[i]+in[i]*in[i]-1) < 1e-12, true)
+	}
+
+	// Integer samples saturate instead of wrapping around.
+	loud := []int16{30000, 30000, 30000, 30000}
+	result, err = Crossfade(loud, []int16{30000, 30000, 30000, 30000}, 4, 1, "equal-power")
+	if err != nil {
+		panic(err)
+	}
+	for _, value := range result.([]int16) {
+		assertEquals(value >= 30000, true)
+	}
+	for _, format := range []string{"u8", "s8", "u16", "s16", "u32", "s32", "f32", "f64"} {
+		first, _ := ConvertSamples(a, format)
+		second, _ := ConvertSamples(b, format)
+		result, err := Crossfade(first, second, 3, 2, "linear")
+		if err != nil {
+			panic(err)
+		}
+		converted, _ := ConvertSamples(result, "f64")
+		assertEquals(len(converted.([]float64)), len(a)+len(b)-3*2)
+	}
+
+	// An overlap of 0 concatenates the clips.
+	result, err = Crossfade([]int16{1, 2}, []int16{3, 4}, 0, 1, "linear")
+	if err != nil {
+		panic(err)
+	}
+	joined := result.([]int16)
+	assertEquals(len(joined), 4)
+	for i, value := range []int16{1, 2, 3, 4} {
+		assertEquals(joined[i], value)
+	}
+
+	if _, err := Crossfade(a, b, 4, 2, "linear"); err == nil {
+		panic("expected error for overlap longer than a clip")
+	}
+	if _, err := Crossfade(a, []float32{0, 0}, 1, 2, "linear"); err == nil {
+		panic("expected error for mismatched sample types")
+	}
+	if _, err := Crossfade(a, b, 1, 2, "cubic"); err == nil {
+		panic("expected error for invalid curve")
+	}
+	if _, err := Crossfade(a, b[:3], 1, 2, "linear"); err == nil {
+		panic("expected error for samples that are not a multiple of the channels")
+	}
+
+	fmt.Println("Crossfade test passed")
+}
+
+// Returns stereo samples of a 1 kHz sine at 48 kHz with the given peak level in dBFS for
+// each of the given durations in seconds, following the EBU Tech 3341 test signals.
+func testTone(levels []float64, seconds []float64) []float32 {
+	var samples []float32
+	for i, level := range levels {
+		amplitude := FromDBFS(level)
+		for frame := 0; frame < int(seconds[i]*48000); frame++ {
+			value := float32(amplitude * math.Sin(2*math.Pi*1000*float64(frame)/48000))
+			samples = append(samples, value, value)
+		}
+	}
+	return samples
+}
+
+func TestMeter(t *testing.T) {
+	tests := []struct {
+		levels     []float64
+		seconds    []float64
+		integrated float64
+	}{
+		{[]float64{-23}, []float64{20}, -23},
+		{[]float64{-33}, []float64{20}, -33},
+		{[]float64{-36, -23, -36}, []float64{10, 60, 10}, -23},
+		{[]float64{-72, -36, -23, -36, -72}, []float64{10, 10, 60, 10, 10}, -23},
+	}
+	for _, test := range tests {
+		meter, err := NewMeter(2, 48000, "f32", nil)
+		if err != nil {
+			panic(err)
+		}
+		samples := testTone(test.levels, test.seconds)
+		// Buffers of any size are split into blocks by the meter.
+		for len(samples) > 0 {
+			size := 2 * 1234
+			if size > len(samples) {
+				size = len(samples)
+			}
+			if err := meter.Process(samples[:size]); err != nil {
+				panic(err)
+			}
+			samples = samples[size:]
+		}
+		if math.Abs(meter.Integrated()-test.integrated) > 0.1 {
+			panic(fmt.Sprintf("expected %v LUFS, got %v", test.integrated, meter.Integrated()))
+		}
+	}
+
+	// A steady tone has the same momentary and short-term loudness.
+	meter, err := NewMeter(2, 48000, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(math.IsInf(meter.Momentary(), -1), true)
+	assertEquals(math.IsInf(meter.Integrated(), -1), true)
+	tone, _ := ConvertSamples(testTone([]float64{-20}, []float64{4}), "s16")
+	if err := meter.Process(samplesToBytes(tone)); err != nil {
+		panic(err)
+	}
+	assertEquals(math.Abs(meter.Momentary()+20) < 0.1, true)
+	assertEquals(math.Abs(meter.ShortTerm()+20) < 0.1, true)
+	assertEquals(math.Abs(ToDBFS(meter.Peak())+20) < 0.01, true)
+
+	if err := meter.Process(make([]byte, 3)); err == nil {
+		panic("expected error for partial frame")
+	}
+	if _, err := NewMeter(0, 48000, "s16", nil); err == nil {
+		panic("expected error for invalid channels")
+	}
+
+	fmt.Println("Meter test passed")
+}
+
+func TestMeterMatchesFFmpeg(t *testing.T) {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-nostats",
+		"-i", "test/beach.mp3",
+		"-filter_complex", "ebur128",
+		"-f", "null", "-",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		panic(err)
+	}
+	// The summary at the end of the output contains the integrated loudness, e.g. "I: -23.0 LUFS".
+	summary := string(output[strings.LastIndex(string(output), "Summary:"):])
+	fields := strings.Fields(summary[strings.Index(summary, "I:"):])
+	expected := parse(fields[1])
+
+	audio, err := NewAudio("test/beach.mp3", &Options{Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	meter, err := NewMeter(audio.Channels(), audio.SampleRate(), "f32", nil)
+	if err != nil {
+		panic(err)
+	}
+	for audio.Read() {
+		if err := meter.Process(audio.Buffer()); err != nil {
+			panic(err)
+		}
+	}
+
+	if math.Abs(meter.Integrated()-expected) > 0.5 {
+		panic(fmt.Sprintf("expected %v LUFS, got %v", expected, meter.Integrated()))
+	}
+
+	fmt.Println("Meter Matches FFmpeg test passed")
+}
+
+func TestReadFrame(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// 1.25 seconds of mono audio at 8000 Hz, read in buffers of half a second.
+	samples := make([]int16, 10000)
+	for i := range samples {
+		samples[i] = int16(i)
+	}
+	filename := filepath.Join(directory, "ramp.wav")
+	writeTestWAV(filename, wavPCM, 16, 1, 8000, samplesToBytes(samples))
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	audio.SetBuffer(make([]byte, 8000))
+
+	first, err := audio.ReadFrame()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(first.Samples, 4000)
+	assertEquals(first.PTS, time.Duration(0))
+
+	// Read and ReadFrame share the position, and frames are not overwritten by Read.
+	assertEquals(audio.Read(), true)
+	assertEquals(audio.Samples().([]int16)[0], int16(4000))
+	assertEquals(first.Data[0], byte(0))
+
+	last, err := audio.ReadFrame()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(last.Samples, 2000)
+	assertEquals(len(last.Data), 4000)
+	assertEquals(last.PTS, time.Second)
+	assertEquals(bytes.Equal(last.Data, samplesToBytes(samples[8000:])), true)
+
+	if _, err := audio.ReadFrame(); err != io.EOF {
+		panic(fmt.Sprintf("expected io.EOF, got %v", err))
+	}
+	assertEquals(audio.Read(), false)
+
+	// Closed audio also reports io.EOF.
+	closed, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	closed.Close()
+	if _, err := closed.ReadFrame(); err != io.EOF {
+		panic(fmt.Sprintf("expected io.EOF, got %v", err))
+	}
+
+	fmt.Println("Read Frame test passed")
+}
+
+func TestReadFrameError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg outputs half a second of audio and then fails.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg":  fakeVersion + "head -c 8000 /dev/zero\nexit 1\n",
+	})()
+
+	filename := filepath.Join(dir, "broken.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	frame, err := audio.ReadFrame()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(frame.Samples, 4000)
+
+	// The failure of ffmpeg is returned after the last frame instead of io.EOF.
+	if _, err := audio.ReadFrame(); err == nil || err == io.EOF {
+		panic(fmt.Sprintf("expected ffmpeg error, got %v", err))
+	}
+
+	fmt.Println("Read Frame Error test passed")
+}
+
+// Sink collecting the written samples in memory.
+type testSink struct {
+	samplerate int
+	channels   int
+	format     string
+	written    []byte
+	closed     bool
+}
+
+func (sink *testSink) SampleRate() int    { return sink.samplerate }
+func (sink *testSink) Channels() int      { return sink.channels }
+func (sink *testSink) BitsPerSample() int { return newSampleCodec(sink.format).size * 8 }
+func (sink *testSink) BytesPerFrame() int { return sink.BitsPerSample() / 8 * sink.channels }
+func (sink *testSink) Format() string     { return sink.format }
+func (sink *testSink) Close()             { sink.closed = true }
+
+func (sink *testSink) Write(samples interface{}) error {
+	sink.written = append(sink.written, samplesToBytes(samples)...)
+	return nil
+}
+
+func TestPipe(t *testing.T) {
+	generator, err := NewGenerator(Sine(440, 0.5), 1.5, &Options{SampleRate: 8000, Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	sink := &testSink{samplerate: 8000, channels: 1, format: "f32"}
+	if err := Pipe(generator, sink); err != nil {
+		panic(err)
+	}
+
+	// The samples are converted to the format of the sink, and only the source is closed.
+	written := bytesToSamples(sink.written, len(sink.written)/4, createFormat("f32")).([]float32)
+	assertEquals(len(written), 12000)
+	for i, value := range written {
+		expected := 0.5 * math.Sin(2*math.Pi*440*float64(i)/8000)
+		if math.Abs(float64(value)-expected) > 1.0/32768 {
+			panic(fmt.Sprintf("expected %v at %d, got %v", expected, i, value))
+		}
+	}
+	assertEquals(generator.Read(), false)
+	assertEquals(sink.closed, false)
+
+	// Sources are read until the end, even when they are read through the interface.
+	var source Source
+	source, err = NewGenerator(Silence(), 0.25, &Options{SampleRate: 8000, Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	frames := 0
+	for {
+		frame, err := source.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(frame.PTS, time.Duration(frames)*time.Second/8000)
+		frames += frame.Samples
+	}
+	assertEquals(frames, 2000)
+
+	mismatched, err := NewGenerator(Silence(), 1, &Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	if err := Pipe(mismatched, sink); err == nil {
+		panic("expected error for mismatched channels")
+	}
+
+	fmt.Println("Pipe test passed")
+}
+
+func TestDefaultOptions(t *testing.T) {
+	defer SetDefaultOptions(Options{})
+
+	metadata := map[string]string{"artist": "aio"}
+	SetDefaultOptions(Options{Format: "f32", SampleRate: 48000, Channels: 1, Metadata: metadata})
+
+	// The defaults are copied, so changing the given options has no effect.
+	metadata["artist"] = "changed"
+	assertEquals(DefaultOptions().Metadata["artist"], "aio")
+	returned := DefaultOptions()
+	returned.Metadata["artist"] = "changed"
+	returned.Format = "s16"
+	assertEquals(DefaultOptions().Metadata["artist"], "aio")
+	assertEquals(DefaultOptions().Format, "f32")
+
+	generator, err := NewGenerator(Silence(), 1, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(generator.Format(), "f32")
+	assertEquals(generator.SampleRate(), 48000)
+	assertEquals(generator.Channels(), 1)
+
+	// Fields set in the options of a constructor win over the defaults, and are not changed.
+	options := &Options{Channels: 2}
+	generator, err = NewGenerator(Silence(), 1, options)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(generator.Format(), "f32")
+	assertEquals(generator.Channels(), 2)
+	assertEquals(options.Format, "")
+	assertEquals(options.SampleRate, 0)
+
+	merged := withDefaults(&Options{Format: "s16", Metadata: map[string]string{"title": "test"}})
+	assertEquals(merged.Format, "s16")
+	assertEquals(merged.SampleRate, 48000)
+	assertEquals(merged.Metadata["title"], "test")
+	assertEquals(merged.Metadata["artist"], "")
+
+	// Defaults can be read and set from many goroutines.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					SetDefaultOptions(Options{SampleRate: 8000 * (j%4 + 1)})
+				} else {
+					withDefaults(nil)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	fmt.Println("Default Options test passed")
+}
+
+func TestNice(t *testing.T) {
+	for _, nice := range []int{-21, 20} {
+		_, err := NewAudio("test.mp3", &Options{Nice: nice})
+		optionError, ok := err.(*OptionError)
+		if !ok {
+			panic(fmt.Sprintf("expected *OptionError for Nice %d, got %v", nice, err))
+		}
+		assertEquals(optionError.Field, "Nice")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg waits for its priority to be set and stores its niceness.
+	niceness := filepath.Join(dir, "nice")
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg": fakeVersion + "sleep 0.5\nnice > " + niceness +
+			"\nhead -c 8000 /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "quiet.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewAudio(filename, &Options{Nice: 10})
+	if err != nil {
+		panic(err)
+	}
+	for audio.Read() {
+	}
+	audio.Close()
+
+	data, err := os.ReadFile(niceness)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.TrimSpace(string(data)), "10")
+
+	fmt.Println("Nice test passed")
+}
+
+func TestProtocolFilenames(t *testing.T) {
+	data, err := os.ReadFile("test/beach.mp3")
+	if err != nil {
+		panic(err)
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Windows does not allow colons in filenames.
+	names := []string{"100% volume.mp3", "beach%20mix.mp3"}
+	if runtime.GOOS != "windows" {
+		names = append(names, "recording 2024-03-01 10:30.mp3", "concat:beach.mp3", "pipe:1.mp3")
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			panic(err)
+		}
+
+		probe, err := ProbeAudio(filename)
+		if err != nil {
+			panic(fmt.Sprintf("could not probe %q: %v", name, err))
+		}
+		assertEquals(len(probe.Streams), 1)
+
+		audio, err := NewAudio(filename, nil)
+		if err != nil {
+			panic(fmt.Sprintf("could not open %q: %v", name, err))
+		}
+		assertEquals(audio.SampleRate(), 48000)
+		assertEquals(audio.Duration(), 1.032)
+		samples := 0
+		for audio.Read() {
+			samples += len(audio.Buffer())
+		}
+		if samples == 0 {
+			panic(fmt.Sprintf("no audio decoded from %q", name))
+		}
+		audio.Close()
+	}
+
+	fmt.Println("Protocol Filenames test passed")
+}
+
+// Sink failing after a number of writes.
+type failingSink struct {
+	testSink
+	writes int
+}
+
+func (sink *failingSink) Write(samples interface{}) error {
+	if sink.writes == 0 {
+		return fmt.Errorf("sink failed")
+	}
+	sink.writes--
+	return sink.testSink.Write(samples)
+}
+
+// Sink taking a while for every write.
+type slowSink struct {
+	testSink
+}
+
+func (sink *slowSink) Write(samples interface{}) error {
+	time.Sleep(20 * time.Millisecond)
+	return sink.testSink.Write(samples)
+}
+
+func TestFanout(t *testing.T) {
+	options := &Options{SampleRate: 8000, Channels: 1}
+	generator, err := NewGenerator(Sine(440, 0.5), 1.5, options)
+	if err != nil {
+		panic(err)
+	}
+	float := &testSink{samplerate: 8000, channels: 1, format: "f32"}
+	integer := &testSink{samplerate: 8000, channels: 1, format: "s16"}
+	stop, errs := Fanout(generator, float, integer)
+	defer stop()
+	for err := range errs {
+		panic(err)
+	}
+
+	// Every sink gets all samples in its own format, and all sinks are closed.
+	assertEquals(len(float.written), 12000*4)
+	assertEquals(len(integer.written), 12000*2)
+	assertEquals(float.closed, true)
+	assertEquals(integer.closed, true)
+	floats := bytesToSamples(float.written, 12000, createFormat("f32")).([]float32)
+	integers := bytesToSamples(integer.written, 12000, createFormat("s16")).([]int16)
+	for i := range floats {
+		if math.Abs(float64(floats[i])-float64(integers[i])/32768) > 1.0/32768 {
+			panic(fmt.Sprintf("sinks differ at %d: %v and %v", i, floats[i], integers[i]))
+		}
+	}
+
+	// A failing sink stops all others.
+	generator, err = NewGenerator(Silence(), 0, options)
+	if err != nil {
+		panic(err)
+	}
+	failing := &failingSink{testSink: testSink{samplerate: 8000, channels: 1, format: "s16"}, writes: 2}
+	other := &testSink{samplerate: 8000, channels: 1, format: "s16"}
+	_, errs = Fanout(generator, other, failing)
+	count := 0
+	for err := range errs {
+		if !strings.Contains(err.Error(), "sink 1") {
+			panic(fmt.Sprintf("unexpected error: %v", err))
+		}
+		count++
+	}
+	assertEquals(count, 1)
+	assertEquals(other.closed, true)
+	assertEquals(failing.closed, true)
+	assertEquals(generator.Read(), false)
+
+	// Slow sinks wrapped with Dropping lose audio instead of holding up the others.
+	generator, err = NewGenerator(Silence(), 2, options)
+	if err != nil {
+		panic(err)
+	}
+	generator.SetBuffer(make([]byte, 160))
+	slow := &slowSink{testSink{samplerate: 8000, channels: 1, format: "s16"}}
+	fast := &testSink{samplerate: 8000, channels: 1, format: "s16"}
+	_, errs = Fanout(generator, Dropping(slow, 2), fast)
+	for err := range errs {
+		panic(err)
+	}
+	assertEquals(len(fast.written), 32000)
+	if len(slow.written) >= 32000 || len(slow.written) == 0 {
+		panic(fmt.Sprintf("expected the slow sink to drop audio, got %d bytes", len(slow.written)))
+	}
+	assertEquals(slow.closed, true)
+
+	// Stop ends an endless source.
+	generator, err = NewGenerator(Silence(), 0, options)
+	if err != nil {
+		panic(err)
+	}
+	stop, errs = Fanout(generator, &slowSink{testSink{samplerate: 8000, channels: 1, format: "s16"}})
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	stop()
+	for err := range errs {
+		panic(err)
+	}
+
+	_, errs = Fanout(generator, &testSink{samplerate: 8000, channels: 2, format: "s16"})
+	if err := <-errs; err == nil {
+		panic("expected error for mismatched channels")
+	}
+
+	fmt.Println("Fanout test passed")
+}
+
+func TestAnalyzer(t *testing.T) {
+	generator, err := NewGenerator(Sine(1000, 0.5), 1, &Options{SampleRate: 8000, Channels: 2, Format: "s16"})
+	if err != nil {
+		panic(err)
+	}
+	analyzer, err := NewAnalyzer(2, 8000, "s16", AnalyzerOptions{WindowSize: 256, PerChannel: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(analyzer.HopSize(), 128)
+	assertEquals(analyzer.Bins(), 129)
+	assertEquals(analyzer.Frequency(32), 1000.0)
+
+	// Buffers of 100 frames do not line up with the hop size.
+	generator.SetBuffer(make([]byte, 400))
+	var spectra []Spectrum
+	for generator.Read() {
+		result, err := analyzer.Process(generator.Buffer())
+		if err != nil {
+			panic(err)
+		}
+		spectra = append(spectra, result...)
+	}
+
+	// Windows start every 128 samples, as long as all 256 samples are available.
+	assertEquals(len(spectra), 2*((8000-256)/128+1))
+	for i, spectrum := range spectra {
+		assertEquals(spectrum.Channel, i%2)
+		assertEquals(spectrum.PTS, time.Duration(i/2*128)*time.Second/8000)
+		peak := 0
+		for bin, magnitude := range spectrum.Magnitudes {
+			if magnitude > spectrum.Magnitudes[peak] {
+				peak = bin
+			}
+		}
+		assertEquals(peak, 32)
+		if math.Abs(spectrum.Magnitudes[peak]-0.5) > 0.001 {
+			panic(fmt.Sprintf("expected a magnitude of 0.5, got %v", spectrum.Magnitudes[peak]))
+		}
+	}
+
+	// Channels are mixed by default, and hops may skip samples.
+	generator, err = NewGenerator(Sine(1000, 0.5), 1, &Options{SampleRate: 8000, Channels: 2, Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	settings := AnalyzerOptions{WindowSize: 256, HopSize: 1000, WindowFunction: "blackman", Decibels: true}
+	analyzer, err = NewAnalyzer(2, 8000, "f32", settings, nil)
+	if err != nil {
+		panic(err)
+	}
+	generator.Read()
+	spectra, err = analyzer.Process(generator.Samples())
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(spectra), 8)
+	assertEquals(spectra[1].Channel, -1)
+	assertEquals(spectra[1].PTS, 125*time.Millisecond)
+	if math.Abs(spectra[1].Magnitudes[32]-20*math.Log10(0.5)) > 0.01 {
+		panic(fmt.Sprintf("expected %v dB, got %v", 20*math.Log10(0.5), spectra[1].Magnitudes[32]))
+	}
+
+	for _, settings := range []AnalyzerOptions{{WindowSize: 100}, {WindowSize: 1}, {HopSize: -1}, {WindowFunction: "triangle"}} {
+		if _, err := NewAnalyzer(1, 8000, "s16", settings, nil); err == nil {
+			panic(fmt.Sprintf("expected error for %+v", settings))
+		}
+	}
+	if _, err := analyzer.Process(make([]byte, 3)); err == nil {
+		panic("expected error for a partial frame")
+	}
+
+	fmt.Println("Analyzer test passed")
+}
+
+func TestCloseAll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg decodes endless audio, or consumes all written audio. Capability queries fail.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *\"-i - \"*) exec cat > /dev/null ;; esac\n[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"exec cat /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "endless.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	before := OpenProcesses()
+
+	// Open many readers and writers at once, and close half of them right away. The others are
+	// kept reachable, so that their processes are not stopped when they are garbage collected.
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	open := []interface{}{}
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				audio, err := NewAudio(filename, nil)
+				if err != nil {
+					panic(err)
+				}
+				if !audio.Read() {
+					panic("expected audio")
+				}
+				if i%4 == 0 {
+					audio.Close()
+				} else {
+					mutex.Lock()
+					open = append(open, audio)
+					mutex.Unlock()
+				}
+			} else {
+				writer, err := NewAudioWriter(filepath.Join(dir, fmt.Sprintf("output%d.wav", i)), nil)
+				if err != nil {
+					panic(err)
+				}
+				if err := writer.Write(make([]int16, 100)); err != nil {
+					panic(err)
+				}
+				if i%4 == 1 {
+					writer.Close()
+				} else {
+					mutex.Lock()
+					open = append(open, writer)
+					mutex.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assertEquals(OpenProcesses(), before+20)
+
+	CloseAll()
+	assertEquals(OpenProcesses(), before)
+	CloseAll()
+	runtime.KeepAlive(open)
+
+	// Objects closed by CloseAll stay closed.
+	for _, object := range open {
+		switch object := object.(type) {
+		case *Audio:
+			assertEquals(object.Read(), false)
+		case *AudioWriter:
+			assertEquals(object.Write(make([]int16, 100)).Error(), "audio writer is closed")
+		}
+	}
+
+	fmt.Println("Close All test passed")
+}
+
+func TestBannerParsing(t *testing.T) {
+	mp3 := `[mp3 @ 0x55d0c6a1e2c0] Estimating duration from bitrate, this may be inaccurate
+Input #0, mp3, from 'test/beach.mp3':
+  Metadata:
+    encoder         : Lavf58.29.100
+  Duration: 00:00:01.03, start: 0.025057, bitrate: 129 kb/s
+  Stream #0:0: Audio: mp3 (mp3float), 48000 Hz, stereo, fltp, 128 kb/s
+At least one output file must be specified
+`
+	result, ok := parseBanner(mp3)
+	assertEquals(ok, true)
+	assertEquals(result.Fallback, true)
+	assertEquals(result.Format.Name, "mp3")
+	assertEquals(result.Format.Duration, 1.03)
+	assertEquals(result.Format.Bitrate, 129000)
+	assertEquals(result.Format.Size, int64(0))
+	assertEquals(result.Format.Tags["encoder"], "Lavf58.29.100")
+	assertEquals(len(result.Streams), 1)
+	stream := result.Streams[0]
+	assertEquals(stream.Type, "audio")
+	assertEquals(stream.Codec, "mp3")
+	assertEquals(stream.SampleRate, 48000)
+	assertEquals(stream.Channels, 2)
+	assertEquals(stream.ChannelLayout, "stereo")
+	assertEquals(stream.Bitrate, 128000)
+	assertEquals(stream.Duration, 1.03)
+	assertEquals(stream.MetaData["sample_fmt"], "fltp")
+
+	flac := `Input #0, flac, from 'album/01 - Intro.flac':
+  Metadata:
+    ARTIST          : Someone
+    TITLE           : Intro: Part 1
+  Duration: 00:03:25.12, start: 0.000000, bitrate: 912 kb/s
+  Stream #0:0: Audio: flac, 44100 Hz, stereo, s16
+  Stream #0:1: Video: mjpeg (Baseline), yuvj420p(pc, bt470bg/unknown/unknown), 500x500 [SAR 1:1 DAR 1:1], 90k tbr, 90k tbn (attached pic)
+    Metadata:
+      comment         : Cover (front)
+At least one output file must be specified
+`
+	result, ok = parseBanner(flac)
+	assertEquals(ok, true)
+	assertEquals(result.Format.Name, "flac")
+	assertEquals(result.Format.Duration, 205.12)
+	assertEquals(result.Format.Tags["TITLE"], "Intro: Part 1")
+	assertEquals(len(result.Streams), 2)
+	assertEquals(len(result.AudioStreams()), 1)
+	assertEquals(result.Streams[0].Codec, "flac")
+	assertEquals(result.Streams[0].SampleRate, 44100)
+	assertEquals(result.Streams[0].Bitrate, 0)
+	assertEquals(result.Streams[0].MetaData["sample_fmt"], "s16")
+	assertEquals(result.Streams[1].Type, "video")
+	assertEquals(result.Streams[1].Codec, "mjpeg")
+	assertEquals(result.Streams[1].Index, 1)
+	assertEquals(result.Streams[1].Tags["comment"], "Cover (front)")
+
+	mkv := `Input #0, matroska,webm, from 'movie.mkv':
+  Metadata:
+    ENCODER         : Lavf60.3.100
+  Duration: 00:00:10.00, start: 0.000000, bitrate: 1234 kb/s
+  Chapters:
+    Chapter #0:0: start 0.000000, end 5.000000
+      Metadata:
+        title           : Opening
+  Stream #0:0: Video: h264 (High), yuv420p(progressive), 1920x1080 [SAR 1:1 DAR 16:9], 25 fps, 25 tbr, 1k tbn (default)
+    Metadata:
+      DURATION        : 00:00:10.000000000
+  Stream #0:1(eng): Audio: aac (LC), 48000 Hz, 5.1(side), fltp (default)
+    Metadata:
+      title           : Surround
+  Stream #0:2[0x3](jpn): Audio: opus, 48000 Hz, 3 channels, fltp
+  Stream #0:3(eng): Subtitle: subrip
+Input #1, wav, from 'other.wav':
+  Duration: 00:00:01.00, bitrate: 1411 kb/s
+  Stream #1:0: Audio: pcm_s16le ([1][0][0][0] / 0x0001), 44100 Hz, 2 channels, s16, 1411 kb/s
+`
+	result, ok = parseBanner(mkv)
+	assertEquals(ok, true)
+	assertEquals(result.Format.Name, "matroska,webm")
+	assertEquals(result.Format.Duration, 10.0)
+	assertEquals(len(result.Streams), 4)
+	audio := result.AudioStreams()
+	assertEquals(len(audio), 2)
+	assertEquals(audio[0].Index, 1)
+	assertEquals(audio[0].Codec, "aac")
+	assertEquals(audio[0].Channels, 6)
+	assertEquals(audio[0].ChannelLayout, "5.1(side)")
+	assertEquals(audio[0].Tags["language"], "eng")
+	assertEquals(audio[0].Tags["title"], "Surround")
+	assertEquals(audio[0].Duration, 10.0)
+	assertEquals(audio[1].Index, 2)
+	assertEquals(audio[1].Codec, "opus")
+	assertEquals(audio[1].Channels, 3)
+	assertEquals(audio[1].ChannelLayout, "")
+	assertEquals(audio[1].Tags["language"], "jpn")
+	assertEquals(result.Streams[0].Tags["DURATION"], "00:00:10.000000000")
+	assertEquals(result.Streams[3].Type, "subtitle")
+
+	_, ok = parseBanner("missing.mp3: No such file or directory\n")
+	assertEquals(ok, false)
+
+	// The microphone shares the stream parser.
+	mic := &Microphone{}
+	mic.parseMicrophoneData("Input #0, pulse, from 'default':\n  Duration: N/A, start: 1.0, bitrate: 1536 kb/s\n" +
+		"  Stream #0:0: Audio: pcm_s16le, 48000 Hz, mono, s16, 768 kb/s\n")
+	assertEquals(mic.samplerate, 48000)
+	assertEquals(mic.channels, 1)
+
+	fmt.Println("Banner Parsing test passed")
+}
+
+func TestProbeFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only a fake ffmpeg is in the PATH, which prints the banner or decodes silence.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"if [ \"$1\" = \"-hide_banner\" ]; then\n" +
+			"  echo \"Input #0, mp3, from '$4':\" >&2\n" +
+			"  echo \"  Duration: 00:00:00.50, start: 0.000000, bitrate: 64 kb/s\" >&2\n" +
+			"  echo \"  Stream #0:0: Audio: mp3, 8000 Hz, mono, fltp, 64 kb/s\" >&2\n" +
+			"  echo \"At least one output file must be specified\" >&2\n" +
+			"  exit 1\nfi\nexec /bin/dd if=/dev/zero bs=8000 count=1 2>/dev/null\n",
+	})()
+
+	filename := filepath.Join(dir, "quiet.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	os.Setenv("PATH", dir)
+
+	probe, err := ProbeAudio(filename)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(probe.Fallback, true)
+	assertEquals(probe.Format.Duration, 0.5)
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.SampleRate(), 8000)
+	assertEquals(audio.Channels(), 1)
+	assertEquals(audio.Codec(), "mp3")
+	assertEquals(audio.Duration(), 0.5)
+	samples := 0
+	for audio.Read() {
+		samples += len(audio.Buffer()) / 2
+	}
+	assertEquals(samples, 4000)
+
+	fmt.Println("Probe Fallback test passed")
+}
+
+func TestFilterGraph(t *testing.T) {
+	if _, err := NewFilterGraph(1, 8000, "s16", "", nil); err == nil {
+		panic("expected error for an empty filter graph")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg passes the audio through unchanged, or fails for the "fail" filter.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *\"-af fail \"*) echo \"No such filter: 'fail'\" >&2; exit 1 ;;\n" +
+			"*\"-af \"*) exec cat ;; esac\nexit 1\n",
+	})()
+
+	graph, err := NewFilterGraph(2, 8000, "s16", "anull", nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(graph.Channels(), 2)
+	assertEquals(graph.SampleRate(), 8000)
+	assertEquals(graph.Format(), "s16")
+	graph.SetBuffer(make([]byte, 4000))
+
+	// Reading in the background while writing, more audio than fits in the pipes.
+	read := make(chan []int16)
+	go func() {
+		samples := []int16{}
+		for graph.Read() {
+			samples = append(samples, graph.Samples().([]int16)...)
+		}
+		read <- samples
+	}()
+
+	written := 0
+	for i := 0; i < 100; i++ {
+		samples := make([]int16, 1000)
+		for j := range samples {
+			samples[j] = int16(written + j)
+		}
+		if err := graph.Write(samples); err != nil {
+			panic(err)
+		}
+		written += len(samples)
+	}
+	graph.Close()
+
+	// All audio comes out once the graph is closed, including a shorter last buffer.
+	samples := <-read
+	assertEquals(len(samples), written)
+	for i, sample := range samples {
+		assertEquals(sample, int16(i))
+	}
+	assertEquals(graph.Latency(), time.Duration(0))
+	assertEquals(graph.Error(), nil)
+	if err := graph.Write(make([]int16, 2)); err == nil {
+		panic("expected error for a closed filter graph")
+	}
+
+	// Process returns the audio filtered so far.
+	graph, err = NewFilterGraph(1, 8000, "s16", "anull", &Options{Format: "s16"})
+	if err != nil {
+		panic(err)
+	}
+	processed := 0
+	for i := 0; i < 20 && processed < 8000; i++ {
+		output, err := graph.Process(make([]int16, 800))
+		if err != nil {
+			panic(err)
+		}
+		processed += len(output.([]int16))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if processed == 0 {
+		panic("expected filtered audio")
+	}
+	if latency := graph.Latency(); latency < 0 || latency > 2*time.Second {
+		panic(fmt.Sprintf("unexpected latency: %v", latency))
+	}
+	graph.Close()
+
+	// Once the unread output is full, Write waits until it has been read.
+	graph, err = NewFilterGraph(1, 8000, "s16", "anull", nil)
+	if err != nil {
+		panic(err)
+	}
+	large := make([]int16, 2*maxFilterOutput)
+	for i := range large {
+		large[i] = int16(i)
+	}
+	done := make(chan error)
+	go func() {
+		for i := 0; i < len(large); i += 8192 {
+			if err := graph.Write(large[i : i+8192]); err != nil {
+				done <- err
+				return
+			}
+		}
+		graph.Close()
+		done <- nil
+	}()
+	select {
+	case <-done:
+		panic("Write did not wait for the output to be read")
+	case <-time.After(200 * time.Millisecond):
+	}
+	graph.mutex.Lock()
+	if pending := len(graph.output); pending > maxFilterOutput+32*1024 {
+		panic(fmt.Sprintf("expected at most %d bytes of unread output, got %d", maxFilterOutput, pending))
+	}
+	graph.mutex.Unlock()
+	graph.SetBuffer(make([]byte, 64*1024))
+	filtered := []int16{}
+	for graph.Read() {
+		filtered = append(filtered, graph.Samples().([]int16)...)
+	}
+	if err := <-done; err != nil {
+		panic(err)
+	}
+	assertEquals(len(filtered), len(large))
+	for i := range large {
+		assertEquals(filtered[i], large[i])
+	}
+
+	// Write returns the error of ffmpeg once its input is closed.
+	graph, err = NewFilterGraph(1, 8000, "s16", "fail", nil)
+	if err != nil {
+		panic(err)
+	}
+	err = nil
+	for i := 0; i < 100 && err == nil; i++ {
+		err = graph.Write(make([]int16, 8000))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err == nil || !strings.Contains(err.Error(), "No such filter") {
+		panic(fmt.Sprintf("expected filter error from Write, got %v", err))
+	}
+	graph.Close()
+	if err := graph.Error(); err == nil || !strings.Contains(err.Error(), "No such filter") {
+		panic(fmt.Sprintf("expected filter error, got %v", err))
+	}
+
+	fmt.Println("Filter Graph test passed")
+}
+
+func TestTranscoder(t *testing.T) {
+	pcm := TranscodeSpec{Format: "s16", SampleRate: 8000, Channels: 1}
+	if _, err := NewTranscoder(pcm, TranscodeSpec{Codec: "libopus"}, nil); err == nil {
+		panic("expected error for an output without container")
+	}
+	if _, err := NewTranscoder(TranscodeSpec{Format: "s16"}, pcm, nil); err == nil {
+		panic("expected error for raw input without sample rate")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg copies its input to its output, or fails for the "broken" codec.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *\"-acodec broken \"*) echo \"Unknown encoder 'broken'\" >&2; exit 1 ;;\n" +
+			"*\"-i - \"*) exec cat ;; esac\nexit 1\n",
+	})()
+
+	transcoder, err := NewTranscoder(pcm, TranscodeSpec{Container: "ogg", Codec: "libopus"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(transcoder.Input().Format, createFormat("s16"))
+
+	// Writing far more than fits in the pipes without reading does not block.
+	input := make([]byte, maxTranscodeOutput/2)
+	for i := range input {
+		input[i] = byte(i * 7)
+	}
+	for i := 0; i < len(input); i += 1000 {
+		end := i + 1000
+		if end > len(input) {
+			end = len(input)
+		}
+		if _, err := transcoder.Write(input[i:end]); err != nil {
+			panic(err)
+		}
+	}
+	if err := transcoder.CloseWrite(); err != nil {
+		panic(err)
+	}
+	output, err := io.ReadAll(transcoder)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(output, input), true)
+	if _, err := transcoder.Write([]byte{0, 0}); err == nil {
+		panic("expected error after CloseWrite")
+	}
+	transcoder.Close()
+
+	// Once the unread output is full, Write waits until it has been read.
+	transcoder, err = NewTranscoder(pcm, TranscodeSpec{Container: "ogg", Codec: "libopus"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	large := make([]byte, 4*maxTranscodeOutput)
+	for i := range large {
+		large[i] = byte(i * 13)
+	}
+	written := make(chan error)
+	go func() {
+		for i := 0; i < len(large); i += 64 * 1024 {
+			if _, err := transcoder.Write(large[i : i+64*1024]); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- transcoder.CloseWrite()
+	}()
+	select {
+	case <-written:
+		panic("Write did not wait for the output to be read")
+	case <-time.After(200 * time.Millisecond):
+	}
+	transcoder.state.mutex.Lock()
+	if pending := len(transcoder.state.pending); pending > maxTranscodeOutput+32*1024 {
+		panic(fmt.Sprintf("expected at most %d bytes of unread output, got %d", maxTranscodeOutput, pending))
+	}
+	transcoder.state.mutex.Unlock()
+	output, err = io.ReadAll(transcoder)
+	if err != nil {
+		panic(err)
+	}
+	if err := <-written; err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(output, large), true)
+	transcoder.Close()
+
+	// Encoder errors are returned from Read and Write.
+	transcoder, err = NewTranscoder(pcm, TranscodeSpec{Container: "ogg", Codec: "broken"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := io.ReadAll(transcoder); err == nil || !strings.Contains(err.Error(), "Unknown encoder") {
+		panic(fmt.Sprintf("expected encoder error, got %v", err))
+	}
+	if _, err := transcoder.Write([]byte{0, 0}); err == nil || !strings.Contains(err.Error(), "Unknown encoder") {
+		panic(fmt.Sprintf("expected encoder error, got %v", err))
+	}
+	transcoder.Close()
+
+	// Close stops a Read waiting for output.
+	transcoder, err = NewTranscoder(pcm, TranscodeSpec{Format: "f32"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		transcoder.Close()
+	}()
+	if _, err := transcoder.Read(make([]byte, 10)); err == nil {
+		panic("expected error for a closed transcoder")
+	}
+	// Closing again waits for the process to exit.
+	transcoder.Close()
+	assertEquals(transcoder.state.handle.closed(), true)
+
+	fmt.Println("Transcoder test passed")
+}
+
+func TestWriterSampleTypes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg copies the written audio to the output file.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"for last; do :; done\nexec cat > \"$last\"\n",
+	})()
+
+	typed := []string{"s8", "u16", "s16", "u32", "s32", "f32", "f64"}
+	for _, format := range formats {
+		writer, err := NewAudioWriter(filepath.Join(dir, "output.raw"), &Options{Format: format, Channels: 1})
+		if err != nil {
+			panic(err)
+		}
+		for _, kind := range typed {
+			samples := makeSamples(kind, 2)
+			err := writer.Write(samples)
+			// 24-bit samples are held in 32-bit slices.
+			if kind == format || kind == "s32" && format == "s24" || kind == "u32" && format == "u24" {
+				if err != nil {
+					panic(err)
+				}
+				continue
+			}
+			if err == nil {
+				panic(fmt.Sprintf("expected error writing %T to a %s writer", samples, format))
+			}
+			expected := fmt.Sprintf("writer format %s expects %T, got %T", writer.format, makeSamples(format, 0), samples)
+			assertEquals(err.Error(), expected)
+		}
+		// Byte slices are written as they are.
+		if err := writer.Write(make([]byte, writer.BytesPerFrame())); err != nil {
+			panic(err)
+		}
+		writer.Close()
+	}
+
+	// Samples are converted with Options.ConvertSamples.
+	filename := filepath.Join(dir, "converted.raw")
+	writer, err := NewAudioWriter(filename, &Options{Format: "s16le", Channels: 1, ConvertSamples: true})
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write([]float64{0.5, -0.5}); err != nil {
+		panic(err)
+	}
+	writer.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(data), 4)
+	assertEquals(int16(binary.LittleEndian.Uint16(data)), int16(16384))
+	assertEquals(int16(binary.LittleEndian.Uint16(data[2:])), int16(-16384))
+
+	fmt.Println("Writer Sample Types test passed")
+}
+
+func TestAudioBufferClips(t *testing.T) {
+	samples := make([]int16, 2*8000)
+	for i := range samples {
+		samples[i] = int16(i / 2)
+	}
+	buffer, err := NewAudioBuffer(samples, 2, 8000, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	// The buffer holds a copy of the samples.
+	samples[0] = 100
+
+	assertEquals(buffer.Frames(), 8000)
+	assertEquals(buffer.Duration(), time.Second)
+	assertEquals(buffer.Format(), "s16")
+	assertEquals(buffer.Samples().([]int16)[0], int16(0))
+
+	// Slices are rounded down to whole frames.
+	slice, err := buffer.Slice(250*time.Millisecond, 500*time.Millisecond+time.Microsecond)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(slice.Frames(), 2000)
+	assertEquals(slice.Samples().([]int16)[0], int16(2000))
+	assertEquals(slice.Samples().([]int16)[1], int16(2000))
+
+	end, err := buffer.Slice(900*time.Millisecond, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(end.Frames(), 800)
+	if _, err := buffer.Slice(2*time.Second, 3*time.Second); err == nil {
+		panic("expected error slicing past the end")
+	}
+	if _, err := buffer.Slice(time.Second, 0); err == nil {
+		panic("expected error slicing backwards")
+	}
+
+	// Appended audio is converted to the format of the first buffer.
+	other, err := NewAudioBuffer([]float32{0.5, -0.5}, 2, 8000, "f32", nil)
+	if err != nil {
+		panic(err)
+	}
+	joined, err := end.Append(other)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(joined.Frames(), 801)
+	assertEquals(joined.Format(), "s16")
+	values := joined.Samples().([]int16)
+	assertEquals(values[0], int16(7200))
+	assertEquals(values[1600], int16(16384))
+	assertEquals(values[1601], int16(-16384))
+	// Appending does not change either buffer.
+	assertEquals(end.Frames(), 800)
+	assertEquals(buffer.Frames(), 8000)
+
+	mono, err := NewAudioBuffer([]byte{0, 0}, 1, 8000, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := buffer.Append(mono); err == nil {
+		panic("expected error appending audio with other channels")
+	}
+
+	if _, err := NewAudioBuffer([]float64{0, 0}, 2, 8000, "s16", nil); err == nil {
+		panic("expected error for samples of the wrong type")
+	}
+	converted, err := NewAudioBuffer([]float64{0.5, 0.5}, 2, 8000, "s16", &Options{ConvertSamples: true})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(converted.Samples().([]int16)[0], int16(16384))
+	if _, err := NewAudioBuffer([]int16{0, 0, 0}, 2, 8000, "s16", nil); err == nil {
+		panic("expected error for a partial frame")
+	}
+
+	fmt.Println("Audio Buffer test passed")
+}
+
+func TestReverse(t *testing.T) {
+	decode := func(options *Options) (*Audio, []byte) {
+		audio, err := NewAudio("test/beach.mp3", options)
+		if err != nil {
+			panic(err)
+		}
+		var data []byte
+		for audio.Read() {
+			data = append(data, audio.Buffer()...)
+			assertEquals(audio.Position(), float64(len(data)/audio.BytesPerFrame())/float64(audio.SampleRate()))
+		}
+		return audio, data
+	}
+
+	forward, data := decode(&Options{Format: "s16"})
+	reversed, backward := decode(&Options{Format: "s16", Reverse: true})
+	assertEquals(reversed.Reverse(), true)
+	assertEquals(reversed.Duration(), forward.Duration())
+	assertEquals(reversed.Total(), forward.Total())
+	assertEquals(len(backward), len(data))
+
+	// Reversing the frames of the reversed audio again gives the forward audio.
+	frame := forward.BytesPerFrame()
+	restored := make([]byte, len(backward))
+	for i := 0; i < len(backward); i += frame {
+		copy(restored[len(restored)-i-frame:], backward[i:i+frame])
+	}
+	assertEquals(sha256.Sum256(restored), sha256.Sum256(data))
+
+	fmt.Println("Reverse test passed")
+}
+
+// Reads a token up to one of the terminating characters the way ffmpeg's av_get_token does,
+// returning the unescaped token and the rest of the string.
+func getToken(value, term string) (string, string) {
+	const whitespace = " \n\t\r"
+	value = strings.TrimLeft(value, whitespace)
+	token := []byte{}
+	end := 0 // Length of the token that must not be trimmed.
+	i := 0
+	for i < len(value) && !strings.ContainsRune(term, rune(value[i])) {
+		c := value[i]
+		i++
+		switch {
+		case c == '\\' && i < len(value):
+			token = append(token, value[i])
+			i++
+			end = len(token)
+		case c == '\'':
+			for i < len(value) && value[i] != '\'' {
+				token = append(token, value[i])
+				i++
+			}
+			if i < len(value) {
+				i++
+				end = len(token)
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+	for len(token) > end && strings.ContainsRune(whitespace, rune(token[len(token)-1])) {
+		token = token[:len(token)-1]
+	}
+	return string(token), value[i:]
+}
+
+func TestFilterBuilder(t *testing.T) {
+	filter, err := Filters().Volume(0.8).HighPass(80).ATempo(1.25).Loudnorm(-16).Build()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(filter, "volume=volume=0.8,highpass=f=80,atempo=tempo=1.25,loudnorm=I=-16")
+
+	filter, err = Filters().LowPass(1e6 - 1).Raw("aecho=0.8:0.9:1000:0.3").Build()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(filter, "lowpass=f=999999,aecho=0.8:0.9:1000:0.3")
+
+	empty, err := Filters().Build()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(empty, "")
+
+	// The first invalid parameter is returned.
+	invalid := []*FilterChain{
+		Filters().Volume(-1),
+		Filters().Volume(math.NaN()),
+		Filters().HighPass(0),
+		Filters().LowPass(1e6),
+		Filters().ATempo(0.25),
+		Filters().ATempo(101),
+		Filters().Loudnorm(-4),
+		Filters().Loudnorm(-71),
+		Filters().Raw(" "),
+	}
+	for _, chain := range invalid {
+		if _, err := chain.Volume(1).Build(); err == nil {
+			panic("expected error for an invalid filter parameter")
+		}
+	}
+	_, err = Filters().Volume(1).ATempo(0).HighPass(-1).Build()
+	assertEquals(err.Error(), "invalid tempo: 0, must be between 0.5 and 100")
+
+	// Escaped values survive both levels of ffmpeg's parsing unchanged.
+	assertEquals(Escape("10:30, live"), `10\\:30\,\\\ live`)
+	random := rand.New(rand.NewSource(1))
+	alphabet := []byte(" \t\n\\':,;[]=ab1")
+	for i := 0; i < 10000; i++ {
+		value := make([]byte, random.Intn(12))
+		for j := range value {
+			value[j] = alphabet[random.Intn(len(alphabet))]
+		}
+
+		graph := "drawtext=text=" + Escape(string(value)) + ",volume=1"
+		filter, rest := getToken(graph, "[],;")
+		assertEquals(rest, ",volume=1")
+		arguments := strings.TrimPrefix(filter, "drawtext=")
+		option, rest := getToken(strings.TrimPrefix(arguments, "text="), ":")
+		if option != string(value) || rest != "" {
+			panic(fmt.Sprintf("escaping %q gave %q", value, graph))
+		}
+	}
+
+	fmt.Println("Filter Builder test passed")
+}
+
+func TestPresets(t *testing.T) {
+	voice, err := PresetVoice()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(voice.SampleRate, 16000)
+	assertEquals(voice.Channels, 1)
+	assertEquals(voice.Codec, "libopus")
+	assertEquals(voice.Bitrate, 24000)
+
+	podcast, err := PresetPodcast("episode.M4A")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(podcast.Codec, "aac")
+	assertEquals(podcast.Filter, "loudnorm=I=-16")
+
+	mp3, err := PresetMusicHigh("song.mp3")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(mp3.Codec, "libmp3lame")
+	assertEquals(mp3.Quality, "0")
+	assertEquals(mp3.Bitrate, 0)
+	aac, err := PresetMusicHigh("song.m4a")
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(aac.Codec, "aac")
+	assertEquals(aac.Bitrate, 256000)
+
+	archival, err := PresetArchival()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(archival.Codec, "flac")
+	assertEquals(archival.CompressionLevel, 8)
+
+	if _, err := PresetPodcast("episode.ogg"); err == nil {
+		panic("expected error for an unsupported extension")
+	}
+	if _, err := PresetMusicHigh("song"); err == nil {
+		panic("expected error for a missing extension")
+	}
+	if _, err := NewAudioWriter("test/output.flac", &Options{CompressionLevel: -1}); err == nil {
+		panic("expected error for a negative compression level")
+	}
+
+	fmt.Println("Presets test passed")
+}
+
+func TestPresetEncoding(t *testing.T) {
+	presets := map[string]func() (*Options, error){
+		"test/voice.ogg":     PresetVoice,
+		"test/podcast.mp3":   func() (*Options, error) { return PresetPodcast("test/podcast.mp3") },
+		"test/podcast.m4a":   func() (*Options, error) { return PresetPodcast("test/podcast.m4a") },
+		"test/music.mp3":     func() (*Options, error) { return PresetMusicHigh("test/music.mp3") },
+		"test/music.m4a":     func() (*Options, error) { return PresetMusicHigh("test/music.m4a") },
+		"test/archival.flac": PresetArchival,
+	}
+	for filename, preset := range presets {
+		options, err := preset()
+		if err != nil {
+			panic(err)
+		}
+
+		// Encode a second of generated audio with the preset.
+		generator, err := NewGenerator(Sine(440, 0.5), 1, &Options{
+			SampleRate: options.SampleRate,
+			Channels:   options.Channels,
+			Format:     options.Format,
+		})
+		if err != nil {
+			panic(err)
+		}
+		writer, err := NewAudioWriter(filename, options)
+		if err != nil {
+			panic(err)
+		}
+		if err := writer.WriteFrom(generator); err != nil {
+			panic(err)
+		}
+		writer.Close()
+
+		audio, err := NewAudio(filename, nil)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(audio.SampleRate(), options.SampleRate)
+		assertEquals(audio.Channels(), options.Channels)
+		assertEquals(math.Abs(audio.Duration()-1) < 0.1, true)
+		audio.Close()
+		os.Remove(filename)
+	}
+
+	fmt.Println("Preset Encoding test passed")
+}
+
+func TestWatchDevices(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg lists the sources in the file, or fails if the file is missing.
+	sources := filepath.Join(dir, "sources")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"cat \"" + sources + "\" 2>/dev/null || { echo 'Cannot list sources: Connection refused' >&2; exit 1; }\n",
+	})()
+
+	list := func(names ...string) {
+		output := "Auto-detected sources for pulse:\n"
+		for _, name := range names {
+			output += "  " + name + " [" + name + " description]\n"
+		}
+		if err := os.WriteFile(sources+".tmp", []byte(output), 0644); err != nil {
+			panic(err)
+		}
+		if err := os.Rename(sources+".tmp", sources); err != nil {
+			panic(err)
+		}
+	}
+
+	list("builtin", "headset")
+	names, err := ListMicrophones()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Join(names, ","), "builtin,headset")
+
+	if _, err := WatchDevices(context.Background(), 0); err == nil {
+		panic("expected error for a non-positive interval")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := WatchDevices(ctx, 10*time.Millisecond)
+	if err != nil {
+		panic(err)
+	}
+	next := func() DeviceEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			panic("timed out waiting for a device event")
+		}
+	}
+
+	// The current devices are reported first.
+	assertEquals(next(), DeviceEvent{Type: "added", Name: "builtin", Index: 0})
+	assertEquals(next(), DeviceEvent{Type: "added", Name: "headset", Index: 1})
+
+	list("builtin", "usb")
+	assertEquals(next(), DeviceEvent{Type: "removed", Name: "headset", Index: 1})
+	assertEquals(next(), DeviceEvent{Type: "added", Name: "usb", Index: 1})
+
+	// Errors are reported without stopping the watcher.
+	os.Remove(sources)
+	event := next()
+	assertEquals(event.Type, "error")
+	assertEquals(strings.Contains(event.Err.Error(), "Connection refused"), true)
+	for event.Type == "error" {
+		list("usb")
+		event = next()
+	}
+	assertEquals(event, DeviceEvent{Type: "removed", Name: "builtin", Index: 0})
+
+	cancel()
+	for range events {
+	}
+
+	fmt.Println("Watch Devices test passed")
+}
+
+func TestCodecInference(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg records the arguments of the writer and consumes the written audio.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *\"-i - \"*) echo \"$*\" > \"" + args + "\"; exec cat > /dev/null ;; esac\nexit 1\n",
+	})()
+
+	tests := []struct {
+		filename string
+		options  Options
+		codec    string
+		rate     int
+	}{
+		{"out.opus", Options{}, "libopus", 48000},
+		{"out.OPUS", Options{SampleRate: 16000}, "libopus", 16000},
+		{"out.m4a", Options{}, "aac", 44100},
+		{"out.ogg", Options{}, "libvorbis", 44100},
+		{"out.flac", Options{}, "flac", 44100},
+		{"out.wav", Options{Format: "f32be"}, "pcm_f32le", 44100},
+		{"out.wav", Options{Format: "u8"}, "pcm_u8", 44100},
+		{"out.mp3", Options{}, "", 44100},
+		// Explicit options override the inferred codec.
+		{"out.opus", Options{Codec: "libvorbis"}, "libvorbis", 44100},
+	}
+	for _, test := range tests {
+		options := test.options
+		writer, err := NewAudioWriter(filepath.Join(dir, test.filename), &options)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(writer.Codec(), test.codec)
+		assertEquals(writer.EncodedSampleRate(), test.rate)
+
+		if err := writer.Write(make([]byte, writer.BytesPerFrame())); err != nil {
+			panic(err)
+		}
+		writer.Close()
+
+		data, err := os.ReadFile(args)
+		if err != nil {
+			panic(err)
+		}
+		command := string(data)
+		assertEquals(strings.Contains(command, "-acodec "+test.codec+" "), test.codec != "")
+		resampled := fmt.Sprintf("-ar %d %s", test.rate, filepath.Join(dir, test.filename))
+		assertEquals(strings.Contains(command, resampled), test.rate != writer.SampleRate())
+	}
+
+	fmt.Println("Codec Inference test passed")
+}
+
+func TestStartTime(t *testing.T) {
+	audio := &Audio{known: make(map[string]bool)}
+	audio.addAudioData(parseFFprobe("stream|index=1|codec_name=aac|sample_rate=48000|channels=2|start_time=0.021333\n")[0])
+	assertEquals(audio.Known("start_time"), true)
+	assertEquals(audio.StartTime(), 0.021333)
+
+	audio = &Audio{known: make(map[string]bool)}
+	audio.addAudioData(parseFFprobe("stream|index=1|codec_name=aac|sample_rate=48000|channels=2|start_time=N/A\n")[0])
+	assertEquals(audio.Known("start_time"), false)
+	assertEquals(audio.StartTime(), float64(0))
+
+	// Without ffprobe, the start time of the file is used for every audio stream.
+	banner := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'clip.mp4':
+  Duration: 00:00:02.00, start: -0.021333, bitrate: 2000 kb/s
+  Stream #0:0[0x1](und): Video: h264 (High), yuv420p, 1280x720, 1800 kb/s, 30 fps
+  Stream #0:1[0x2](und): Audio: aac (LC), 48000 Hz, stereo, fltp, 128 kb/s (default)
+`
+	result, ok := parseBanner(banner)
+	assertEquals(ok, true)
+	assertEquals(result.Streams[0].MetaData["start_time"], "")
+	assertEquals(result.Streams[1].MetaData["start_time"], "-0.021333")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg records the arguments of the reader and decodes one frame of silence.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeVersion +
+			"echo \"stream|index=0|codec_name=aac|codec_type=audio|sample_rate=8000|channels=1|start_time=0.500000\"\n",
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$*\" > \"" + args + "\"\nexec head -c 2 /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "late.m4a")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	for _, align := range []bool{false, true} {
+		audio, err := NewAudio(filename, &Options{Format: "s16", AlignStart: align})
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(audio.StartTime(), 0.5)
+		assertEquals(audio.AlignStart(), align)
+		for audio.Read() {
+		}
+		audio.Close()
+
+		data, err := os.ReadFile(args)
+		if err != nil {
+			panic(err)
+		}
+		command := string(data)
+		assertEquals(strings.HasPrefix(command, "-copyts "), align)
+		assertEquals(strings.Contains(command, "-af aresample=async=1:first_pts=0 "), align)
+	}
+
+	fmt.Println("Start Time test passed")
+}
+
+func TestPack24(t *testing.T) {
+	samples := []int32{0, 1, -1, 8388607, -8388608, 0x123456}
+	packed, ok, err := pack24(samples, "s24le", false)
+	assertEquals(ok, true)
+	assertEquals(err, nil)
+	assertEquals(hex.EncodeToString(packed), "000000"+"010000"+"ffffff"+"ffff7f"+"000080"+"563412")
+
+	packed, _, _ = pack24(samples, "s24be", false)
+	assertEquals(hex.EncodeToString(packed), "000000"+"000001"+"ffffff"+"7fffff"+"800000"+"123456")
+
+	packed, _, _ = pack24([]uint32{0, 16777215, 0x800000}, "u24le", false)
+	assertEquals(hex.EncodeToString(packed), "000000"+"ffffff"+"000080")
+
+	// Values outside of the 24-bit range are rejected, or saturate if clamped.
+	_, ok, err = pack24([]int32{0, 8388608}, "s24le", false)
+	assertEquals(ok, true)
+	assertEquals(err != nil, true)
+	packed, _, err = pack24([]int32{8388608, -8388609, math.MinInt32}, "s24le", true)
+	assertEquals(err, nil)
+	assertEquals(hex.EncodeToString(packed), "ffff7f"+"000080"+"000080")
+	_, _, err = pack24([]uint32{1 << 24}, "u24le", false)
+	assertEquals(err != nil, true)
+
+	// Other types and formats are left to the usual conversion.
+	_, ok, _ = pack24(samples, "s32le", false)
+	assertEquals(ok, false)
+	_, ok, _ = pack24(samples, "u24le", false)
+	assertEquals(ok, false)
+	_, ok, _ = pack24([]int16{1}, "s24le", false)
+	assertEquals(ok, false)
+
+	buffer, err := NewAudioBuffer(samples, 2, 48000, "s24", &Options{Endianness: "be"})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(buffer.Frames(), 3)
+	assertEquals(hex.EncodeToString(buffer.Buffer()[3:6]), "000001")
+	_, err = NewAudioBuffer([]int32{1 << 23, 0}, 1, 48000, "s24", nil)
+	assertEquals(err != nil, true)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg stores the written audio.
+	written := filepath.Join(dir, "written")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"exec cat > \"" + written + "\"\n",
+	})()
+
+	writer, err := NewAudioWriter(filepath.Join(dir, "output.wav"), &Options{Format: "s24le", Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write(samples); err != nil {
+		panic(err)
+	}
+	assertEquals(writer.Write([]int32{-8388609}) != nil, true)
+	assertEquals(writer.Write([]int16{1}) != nil, true)
+	writer.Close()
+
+	data, err := os.ReadFile(written)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(hex.EncodeToString(data), "000000"+"010000"+"ffffff"+"ffff7f"+"000080"+"563412")
+
+	fmt.Println("Pack 24 test passed")
+}
+
+func TestRoundTrip24(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A full scale sweep over all 24-bit values, including the extremes.
+	samples := make([]int32, 48000*2)
+	random := rand.New(rand.NewSource(24))
+	for i := range samples {
+		samples[i] = random.Int31n(1<<24) - 1<<23
+	}
+	samples[0], samples[1] = -1<<23, 1<<23-1
+	expected, _, err := pack24(samples, "s24le", false)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, name := range []string{"round.wav", "round.flac"} {
+		filename := filepath.Join(dir, name)
+		writer, err := NewAudioWriter(filename, &Options{SampleRate: 48000, Channels: 2, Format: "s24le"})
+		if err != nil {
+			panic(err)
+		}
+		if err := writer.Write(samples); err != nil {
+			panic(err)
+		}
+		writer.Close()
+
+		audio, err := NewAudio(filename, &Options{Format: "s24le"})
+		if err != nil {
+			panic(err)
+		}
+		buffer, err := audio.ReadAllBuffer()
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(sha256.Sum256(buffer.Buffer()), sha256.Sum256(expected))
+	}
+
+	fmt.Println("Round Trip 24 test passed")
+}
+
+func TestMicrophoneDrift(t *testing.T) {
+	// A 48 kHz device that captured 47997 frames in one second of wall clock time.
+	started := time.Now()
+	mic := &Microphone{samplerate: 48000, channels: 2, bps: 16, started: started}
+	assertEquals(mic.Drift(), Drift{})
+
+	mic.frames = 47997
+	mic.last = started.Add(time.Second)
+	drift := mic.Drift()
+	assertEquals(drift.Frames, int64(47997))
+	assertEquals(drift.Elapsed, time.Second)
+	assertEquals(drift.Offset, -62500*time.Nanosecond)
+	assertEquals(drift.SampleRate, 47997.0)
+	assertEquals(drift.Inserted, int64(0))
+
+	// With compensation, ffmpeg inserts the frames the device falls behind the wall clock, and
+	// drops the frames it runs ahead.
+	mic.drift = true
+	drift = mic.Drift()
+	assertEquals(drift.Inserted, int64(3))
+	assertEquals(drift.Dropped, int64(0))
+	mic.frames = 48005
+	drift = mic.Drift()
+	assertEquals(drift.Inserted, int64(0))
+	assertEquals(drift.Dropped, int64(5))
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg describes a stereo 48 kHz device, and records one second of silence.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *-loglevel*) echo \"$*\" > \"" + args + "\"; exec head -c 192000 /dev/zero ;; esac\n" +
+			"echo \"  Stream #0:0: Audio: pcm_s16le, 48000 Hz, stereo, s16, 1536 kb/s\" >&2\nexit 1\n",
+	})()
+
+	for _, compensate := range []bool{false, true} {
+		mic, err := NewMicrophone(0, &Options{DriftCompensation: compensate})
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(mic.DriftCompensation(), compensate)
+		if !mic.Read() {
+			panic("expected audio")
+		}
+		assertEquals(mic.Drift().Frames, int64(48000))
+		mic.Close()
+
+		data, err := os.ReadFile(args)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(strings.Contains(string(data), "-af "+driftFilter+" "), compensate)
+	}
+
+	fmt.Println("Microphone Drift test passed")
+}
+
+func TestDecodeErrors(t *testing.T) {
+	counter := &errorCounter{}
+	output := "[mp3 @ 0x55d0c6a1e2c0] [warning] Estimating duration from bitrate, this may be inaccurate\n" +
+		"[mp3float @ 0x5581c6e3c2c0] [error] Header missing\r" +
+		"[error] Error while decoding stream #0:0: Invalid data found when processing input\n" +
+		"[aac @ 0x5581c6e3c2c0] [err"
+	counter.Write([]byte(output))
+	assertEquals(counter.Count(), 1)
+	counter.Write([]byte("or] Reserved bit set.\n"))
+	assertEquals(counter.Count(), 2)
+	assertEquals((*errorCounter)(nil).Count(), 0)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg records its arguments, reports two damaged frames and decodes some silence.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$*\" > \"" + args + "\"\n" +
+			"echo \"[mp3float @ 0x5581c6e3c2c0] [error] Header missing\" >&2\n" +
+			"echo \"[mp3float @ 0x5581c6e3c2c0] [error] invalid block type\" >&2\n" +
+			"exec head -c 1000 /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "damaged.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	for _, ignore := range []bool{false, true} {
+		audio, err := NewAudio(filename, &Options{Format: "s16", IgnoreErrors: ignore})
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(audio.IgnoreErrors(), ignore)
+		buffer, err := audio.ReadAllBuffer()
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(len(buffer.Buffer()), 1000)
+
+		data, err := os.ReadFile(args)
+		if err != nil {
+			panic(err)
+		}
+		command := string(data)
+		assertEquals(strings.HasPrefix(command, "-err_detect ignore_err -fflags +discardcorrupt -i "), ignore)
+		assertEquals(strings.Contains(command, "-loglevel repeat+level+warning "), ignore)
+		if ignore {
+			assertEquals(audio.DecodeErrors(), 2)
+		} else {
+			assertEquals(audio.DecodeErrors(), 0)
+		}
+	}
+
+	fmt.Println("Decode Errors test passed")
+}
+
+func TestTrimSilence(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Quiet noise around two sounds separated by a pause, with a click at the start and end.
+	samples := []int16{}
+	add := func(frames int, amplitude int16) {
+		for i := 0; i < frames; i++ {
+			if i%2 == 0 {
+				samples = append(samples, amplitude)
+			} else {
+				samples = append(samples, -amplitude)
+			}
+		}
+	}
+	add(2000, 10)
+	add(8, 20000)
+	add(2000, 10)
+	add(2000, 10000)
+	add(800, 10)
+	add(2000, 10000)
+	add(1000, 10)
+	add(8, 20000)
+	add(1000, 10)
+
+	data := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(sample))
+	}
+	filename := filepath.Join(directory, "memo.wav")
+	writeTestWAV(filename, 1, 16, 1, 8000, data)
+
+	tests := []struct {
+		duration   time.Duration
+		start, end int
+	}{
+		{0, 2000, 1000},
+		{10 * time.Millisecond, 4008, 2008},
+	}
+	for _, test := range tests {
+		audio, err := NewAudio(filename, &Options{Format: "s16le", TrimSilence: true, SilenceDuration: test.duration})
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(audio.TrimSilence(), true)
+		assertEquals(audio.Duration(), float64(len(samples))/8000)
+		buffer, err := audio.ReadAllBuffer()
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(audio.TrimmedStart(), float64(test.start)/8000)
+		assertEquals(audio.TrimmedEnd(), float64(test.end)/8000)
+		assertEquals(bytes.Equal(buffer.Buffer(), data[test.start*2:len(data)-test.end*2]), true)
+	}
+
+	// Audio that is silent throughout is trimmed completely.
+	writeTestWAV(filename, 1, 16, 1, 8000, make([]byte, 1000))
+	audio, err := NewAudio(filename, &Options{TrimSilence: true, SilenceThreshold: -20})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), false)
+	assertEquals(audio.TrimmedStart(), 500.0/8000)
+	assertEquals(audio.TrimmedEnd(), 0.0)
+
+	_, err = NewAudio(filename, &Options{TrimSilence: true, SilenceThreshold: 6})
+	assertEquals(err != nil, true)
+
+	fmt.Println("Trim Silence test passed")
+}
+
+func TestChunker(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// A counting pattern on two channels, read in buffers of 1000 frames.
+	const frames = 2537
+	data := make([]byte, frames*4)
+	for i := 0; i < frames; i++ {
+		binary.LittleEndian.PutUint16(data[i*4:], uint16(i))
+		binary.LittleEndian.PutUint16(data[i*4+2:], uint16(-i))
+	}
+	filename := filepath.Join(directory, "count.wav")
+	writeTestWAV(filename, 1, 16, 2, 1000, data)
+
+	tests := []struct {
+		size, hop int
+		final     string
+	}{
+		{25, 10, "pad"},
+		{25, 10, "truncate"},
+		{25, 10, "drop"},
+		{10, 25, "pad"},
+		{7, 7, "truncate"},
+		{1000, 1000, "pad"},
+		{4000, 100, "truncate"},
+	}
+	for _, test := range tests {
+		audio, err := NewAudio(filename, &Options{Format: "s16"})
+		if err != nil {
+			panic(err)
+		}
+		chunker, err := NewChunker(audio, ChunkerOptions{WindowSize: test.size, HopSize: test.hop, FinalWindow: test.final})
+		if err != nil {
+			panic(err)
+		}
+
+		// The expected windows start every hop samples, and the last one is only read if it
+		// holds samples that are not part of an earlier window.
+		covered := 0
+		windows := 0
+		for start := 0; start < frames; start += test.hop {
+			end := start + test.size
+			if end > frames && (frames <= covered || test.final == "drop") {
+				break
+			}
+			if !chunker.Read() {
+				panic(fmt.Sprintf("expected window at %d for %v", start, test))
+			}
+			windows++
+			assertEquals(chunker.PTS(), time.Duration(start)*time.Millisecond)
+			samples := chunker.Samples().([]int16)
+			length := test.size
+			if end > frames && test.final == "truncate" {
+				length = frames - start
+			}
+			assertEquals(len(samples), length*2)
+			for i := 0; i < length; i++ {
+				expected := start + i
+				if expected >= frames {
+					expected = 0 // Padded with silence.
+				}
+				assertEquals(samples[i*2], int16(expected))
+				assertEquals(samples[i*2+1], int16(-expected))
+			}
+			covered = end
+			if end > frames {
+				break
+			}
+		}
+		assertEquals(chunker.Read(), false)
+		assertEquals(len(chunker.Buffer()), 0)
+		_, err = chunker.ReadFrame()
+		assertEquals(err, io.EOF)
+		assertEquals(windows > 0, true)
+		chunker.Close()
+	}
+
+	// Windows given as durations are rounded to the nearest sample, and read as owned frames.
+	audio, err := NewAudio(filename, &Options{Format: "s16"})
+	if err != nil {
+		panic(err)
+	}
+	chunker, err := NewChunker(audio, ChunkerOptions{WindowDuration: 25 * time.Millisecond, HopDuration: 10 * time.Millisecond})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(chunker.WindowSize(), 25)
+	assertEquals(chunker.HopSize(), 10)
+	assertEquals(chunker.FinalWindow(), "pad")
+	first, err := chunker.ReadFrame()
+	if err != nil {
+		panic(err)
+	}
+	second, err := chunker.ReadFrame()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(first.Samples, 25)
+	assertEquals(second.PTS, 10*time.Millisecond)
+	assertEquals(binary.LittleEndian.Uint16(first.Data[40:]), uint16(10))
+	assertEquals(bytes.Equal(first.Data[40:], second.Data[:60]), true)
+	chunker.Close()
+
+	// Unsigned formats are padded with their own silence.
+	buffer := make([]byte, 4)
+	fillSilence(buffer, "u16le")
+	assertEquals(hex.EncodeToString(buffer), "00800080")
+
+	_, err = NewChunker(audio, ChunkerOptions{WindowSize: 10, WindowDuration: time.Second})
+	assertEquals(err != nil, true)
+	_, err = NewChunker(audio, ChunkerOptions{FinalWindow: "zero"})
+	assertEquals(err != nil, true)
+
+	fmt.Println("Chunker test passed")
+}
+
+func TestRingBuffer(t *testing.T) {
+	ring, err := NewRingBuffer(4, 1, 8000, "s16", RingBufferOptions{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Size(), 4)
+	assertEquals(ring.Free(), 4)
+	if err := ring.Write([]int16{1, 2, 3}); err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Available(), 3)
+
+	// Writes that do not fit are rejected as a whole.
+	assertEquals(ring.Write([]int16{4, 5}) != nil, true)
+	assertEquals(ring.Dropped(), 2)
+	assertEquals(ring.Write([]float32{4}) != nil, true)
+	assertEquals(ring.Write([]byte{1}) != nil, true)
+
+	buffer := make([]int16, 2)
+	n, err := ring.Read(samplesToBytes(buffer))
+	assertEquals(n, 4)
+	assertEquals(err, nil)
+	assertEquals(buffer[0], int16(1))
+	assertEquals(buffer[1], int16(2))
+
+	// Audio wraps around the end of the buffer.
+	if err := ring.Write([]int16{4, 5, 6}); err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Free(), 0)
+	buffer = make([]int16, 8)
+	n, _ = ring.Read(samplesToBytes(buffer))
+	assertEquals(n, 8)
+	for i, sample := range buffer[:4] {
+		assertEquals(sample, int16(i+3))
+	}
+
+	// With overwrite, the oldest audio is replaced by the newest.
+	ring, err = NewRingBuffer(4, 1, 8000, "s16", RingBufferOptions{Overwrite: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Overwrite(), true)
+	ring.Write([]int16{1, 2, 3})
+	ring.Write([]int16{4, 5})
+	ring.Write([]int16{6, 7, 8, 9, 10, 11})
+	assertEquals(ring.Dropped(), 7)
+	n, _ = ring.Read(samplesToBytes(buffer))
+	assertEquals(n, 8)
+	for i, sample := range buffer[:4] {
+		assertEquals(sample, int16(i+8))
+	}
+
+	// Closing unblocks readers once the rest of the audio has been read.
+	ring.Write([]int16{12})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ring.Close()
+	}()
+	n, err = ring.Read(samplesToBytes(buffer))
+	assertEquals(n, 2)
+	assertEquals(err, nil)
+	_, err = ring.Read(samplesToBytes(buffer))
+	assertEquals(err, io.EOF)
+	assertEquals(ring.Write([]int16{13}) != nil, true)
+
+	// Byte slices are written and read without allocating.
+	ring, err = NewRingBuffer(4, 1, 8000, "s16", RingBufferOptions{Overwrite: true}, nil)
+	if err != nil {
+		panic(err)
+	}
+	var samples interface{} = samplesToBytes([]int16{1, 2, 3})
+	output := samplesToBytes(buffer)
+	allocs := testing.AllocsPerRun(100, func() {
+		ring.Write(samples)
+		ring.Read(output)
+	})
+	assertEquals(allocs, float64(0))
+
+	// A Source can be piped into the buffer.
+	generator, err := NewGenerator(Sine(440, 0.5), 0.5, &Options{SampleRate: 8000, Channels: 1, Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	ring, err = NewRingBuffer(8000, 1, 8000, "f32", RingBufferOptions{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := Pipe(generator, ring); err != nil {
+		panic(err)
+	}
+	assertEquals(ring.Available(), 4000)
+
+	fmt.Println("Ring Buffer test passed")
+}
+
+func TestRingBufferStress(t *testing.T) {
+	// Two hours of 8 kHz audio, where every sample holds its index, exchanged between a writer
+	// and a reader with irregular buffer sizes.
+	const total = 2 * 60 * 60 * 8000
+	ring, err := NewRingBuffer(4096, 1, 8000, "s32", RingBufferOptions{}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	done := make(chan int)
+	go func() {
+		buffer := make([]int32, 3001)
+		next := 0
+		for {
+			n, err := ring.Read(samplesToBytes(buffer[:1+next%len(buffer)]))
+			if err == io.EOF {
+				done <- next
+				return
+			}
+			if err != nil {
+				panic(err)
+			}
+			for _, sample := range buffer[:n/4] {
+				if sample != int32(next) {
+					panic(fmt.Sprintf("expected sample %d, got %d", next, sample))
+				}
+				next++
+			}
+		}
+	}()
+
+	chunk := make([]int32, 2048)
+	for written := 0; written < total; {
+		size := 1 + written%len(chunk)
+		if size > total-written {
+			size = total - written
+		}
+		for ring.Free() < size {
+			runtime.Gosched()
+		}
+		for i := range chunk[:size] {
+			chunk[i] = int32(written + i)
+		}
+		if err := ring.Write(chunk[:size]); err != nil {
+			panic(err)
+		}
+		written += size
+	}
+	ring.Close()
+
+	assertEquals(<-done, total)
+	assertEquals(ring.Dropped(), 0)
+
+	fmt.Println("Ring Buffer Stress test passed")
+}
+
+func TestInputChannels(t *testing.T) {
+	assertEquals(panFilter([]int{2}), "pan=mono|c0=c2")
+	assertEquals(panFilter([]int{3, 0}), "pan=stereo|c0=c3|c1=c0")
+	assertEquals(panFilter([]int{0, 1, 3}), "pan=3c|c0=c0|c1=c1|c2=c3")
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg describes a 4 channel 48 kHz device, and records one second of silence.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *-loglevel*) echo \"$*\" > \"" + args + "\"; exec head -c 96000 /dev/zero ;; esac\n" +
+			"echo \"  Stream #0:0: Audio: pcm_s16le, 48000 Hz, 4.0, s16, 3072 kb/s\" >&2\nexit 1\n",
+	})()
+
+	mic, err := NewMicrophone(0, &Options{InputChannels: []int{2}, Filter: "volume=2"})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(mic.Channels(), 1)
+	assertEquals(len(mic.InputChannels()), 1)
+	if !mic.Read() {
+		panic("expected audio")
+	}
+	assertEquals(len(mic.Buffer()), 96000)
+	mic.Close()
+
+	data, err := os.ReadFile(args)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Contains(string(data), "-af pan=mono|c0=c2,volume=2 "), true)
+	assertEquals(strings.Contains(string(data), "-ac 1 "), true)
+
+	_, err = NewMicrophone(0, &Options{InputChannels: []int{4}})
+	assertEquals(err.Error(), "input channel 4 is out of range, device 0 has 4 channels")
+	_, err = NewMicrophone(0, &Options{InputChannels: []int{-1}})
+	assertEquals(err != nil, true)
+
+	mic, err = NewMicrophone(0, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(mic.Channels(), 4)
+	assertEquals(mic.InputChannels() == nil, true)
+
+	fmt.Println("Input Channels test passed")
+}
+
+func TestFingerprint(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// 30 seconds of a melody, long enough for a fingerprint with many items.
+	const samplerate = 11025
+	notes := []float64{262, 294, 330, 349, 392, 440, 494, 523}
+	data := make([]byte, samplerate*30*2)
+	for i := 0; i < len(data)/2; i++ {
+		note := notes[(i/(samplerate/4))*5%len(notes)]
+		sample := 0.5 * math.Sin(2*math.Pi*note*float64(i)/samplerate)
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(sample*32767)))
+	}
+	filename := filepath.Join(directory, "melody.wav")
+	writeTestWAV(filename, 1, 16, 1, samplerate, data)
+
+	fingerprint, duration, err := Fingerprint(filename, 0)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(duration, 30.0)
+	// Compressed fingerprints start with the algorithm and the number of items.
+	assertEquals(strings.HasPrefix(fingerprint, "AQAA"), true)
+	assertEquals(len(fingerprint) > 100, true)
+
+	again, _, err := Fingerprint(filename, 0)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(again, fingerprint)
+
+	_, duration, err = Fingerprint("test/beach.mp3", 0)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(math.Abs(duration-1.03) < 0.05, true)
+	_, _, err = Fingerprint("test/beach.mp3", 1)
+	assertEquals(err != nil, true)
+
+	fmt.Println("Fingerprint test passed")
+}
+
+func TestFingerprintMissingMuxer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg has no components.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 0\nexit 1\n",
+	})()
+
+	assertEquals(HasMuxer("chromaprint"), false)
+	fingerprint, _, err := Fingerprint("test/beach.mp3", 0)
+	assertEquals(fingerprint, "")
+	assertEquals(err.Error(), "your ffmpeg build lacks the chromaprint muxer")
+
+	fmt.Println("Fingerprint Missing Muxer test passed")
+}
+
+// Summary of astats in ffmpeg 4.2 for a stereo file with a clipped left channel.
+const astatsOutput42 = `Input #0, wav, from 'clipped.wav':
+  Duration: 00:00:01.00, bitrate: 1411 kb/s
+    Stream #0:0: Audio: pcm_s16le ([1][0][0][0] / 0x0001), 44100 Hz, stereo, s16, 1411 kb/s
+Stream mapping:
+  Stream #0:0 -> #0:0 (pcm_s16le (native) -> pcm_f64le (native))
+Press [q] to stop, [?] for help
+Output #0, null, to 'pipe:':
+    Stream #0:0: Audio: pcm_f64le, 44100 Hz, stereo, dbl, 5644 kb/s
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Channel: 1
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] DC offset: 0.000012
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min level: -1.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max level: 0.999969
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min difference: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max difference: 0.142456
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Mean difference: 0.041217
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS difference: 0.056201
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak level dB: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS level dB: -3.010300
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS peak dB: -2.998213
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS trough dB: -3.022154
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Crest factor: 1.414214
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Flat factor: 22.041580
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak count: 880
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Bit depth: 16/16
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Dynamic range: 90.308734
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Zero crossings: 880
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Zero crossings rate: 0.019955
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Channel: 2
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] DC offset: -0.000003
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min level: -0.500000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max level: 0.500000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min difference: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max difference: 0.071228
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Mean difference: 0.020608
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS difference: 0.028100
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak level dB: -6.020600
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS level dB: -9.030900
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS peak dB: -9.018813
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS trough dB: -9.042754
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Crest factor: 1.414214
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Flat factor: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak count: 440
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Bit depth: 16/16
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Dynamic range: 84.288134
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Zero crossings: 880
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Zero crossings rate: 0.019955
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Overall
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] DC offset: 0.000004
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min level: -1.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max level: 0.999969
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Min difference: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Max difference: 0.142456
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Mean difference: 0.030912
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS difference: 0.044430
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak level dB: 0.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS level dB: -5.070581
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS peak dB: -2.998213
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] RMS trough dB: -9.042754
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Flat factor: 11.020790
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Peak count: 660.000000
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Bit depth: 16/16
+[Parsed_astats_1 @ 0x55d0c1f3a8c0] Number of samples: 44100
+size=N/A time=00:00:01.00 bitrate=N/A speed= 312x
+video:0kB audio:689kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+`
+
+// Summary of astats in ffmpeg 7.0 for a mono file that starts with silence, with the fields
+// added since 4.2 and Windows line endings.
+const astatsOutput70 = "[aist#0:0/pcm_s16le @ 0x6000010e8000] Guessed Channel Layout: mono\r\n" +
+	"Input #0, wav, from 'quiet.wav':\r\n" +
+	"  Duration: 00:00:02.00, bitrate: 705 kb/s\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Channel: 1\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] DC offset: -0.000001\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Min level: -0.250000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Max level: 0.250000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Min difference: 0.000000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Max difference: 0.035614\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Mean difference: 0.005152\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] RMS difference: 0.010031\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Peak level dB: -12.041200\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] RMS level dB: -18.061800\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] RMS peak dB: -15.051500\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] RMS trough dB: -inf\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Crest factor: 2.000000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Flat factor: 0.000000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Peak count: 220\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Abs Peak count: 220\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Noise floor dB: -inf\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Noise floor count: 5513\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Entropy: 0.498216\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Bit depth: 14/16\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Dynamic range: 72.247068\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Zero crossings: 441\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Zero crossings rate: 0.005000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Number of NaNs: 0\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Number of Infs: 0\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Number of denormals: 0\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Overall\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] DC offset: -0.000001\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Min level: -0.250000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Max level: 0.250000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Peak level dB: -12.041200\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] RMS level dB: -18.061800\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Peak count: 220.000000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Abs Peak count: 220.000000\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Noise floor dB: -inf\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Entropy: 0.498216\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Bit depth: 14/16\r\n" +
+	"[Parsed_astats_1 @ 0x600003a3c0b0] Number of samples: 88200\r\n" +
+	"[out#0/null @ 0x600001ce4000] video:0KiB audio:1378KiB subtitle:0KiB other streams:0KiB global headers:0KiB muxing overhead: unknown\r\n"
+
+func TestAstatsParsing(t *testing.T) {
+	stats := parseAstats(astatsOutput42)
+	assertEquals(len(stats.Channels), 2)
+	left, right := stats.Channels[0], stats.Channels[1]
+	assertEquals(left.MinLevel, -1.0)
+	assertEquals(left.MaxLevel, 0.999969)
+	assertEquals(left.PeakLevel, 0.0)
+	assertEquals(left.RMSLevel, -3.0103)
+	assertEquals(left.PeakCount, int64(880))
+	assertEquals(left.ClippedSamples, int64(880))
+	assertEquals(left.ZeroCrossings, int64(880))
+	assertEquals(left.NoiseFloor, 0.0)
+	assertEquals(left.Fields["Bit depth"], "16/16")
+	_, ok := left.Fields["Entropy"]
+	assertEquals(ok, false)
+	assertEquals(right.DCOffset, -0.000003)
+	assertEquals(right.PeakLevel, -6.0206)
+	assertEquals(right.ClippedSamples, int64(0))
+	assertEquals(right.DynamicRange, 84.288134)
+	assertEquals(stats.Overall.PeakCount, int64(660))
+	assertEquals(stats.Overall.Samples, int64(44100))
+	assertEquals(stats.Overall.ZeroCrossings, int64(0))
+	assertEquals(stats.Overall.Fields["Mean difference"], "0.030912")
+
+	stats = parseAstats(astatsOutput70)
+	assertEquals(len(stats.Channels), 1)
+	mono := stats.Channels[0]
+	assertEquals(mono.MaxLevel, 0.25)
+	assertEquals(mono.PeakLevel, -12.0412)
+	assertEquals(math.IsInf(mono.RMSTrough, -1), true)
+	assertEquals(math.IsInf(mono.NoiseFloor, -1), true)
+	assertEquals(mono.ClippedSamples, int64(0))
+	assertEquals(mono.ZeroCrossingsRate, 0.005)
+	assertEquals(mono.Fields["Entropy"], "0.498216")
+	assertEquals(mono.Fields["Number of denormals"], "0")
+	assertEquals(stats.Overall.Samples, int64(88200))
+	assertEquals(stats.Overall.RMSPeak, 0.0)
+	_, ok = stats.Overall.Fields["RMS peak dB"]
+	assertEquals(ok, false)
+
+	// Samples at full scale count as clipped, using the absolute peak count when it is printed.
+	stats = parseAstats(strings.Replace(astatsOutput70, "Peak level dB: -12.041200", "Peak level dB: 0.000000", 1))
+	assertEquals(stats.Channels[0].ClippedSamples, int64(220))
+
+	stats = parseAstats("")
+	assertEquals(len(stats.Channels), 0)
+	assertEquals(stats.Overall.Fields == nil, true)
+
+	fmt.Println("Astats Parsing test passed")
+}
+
+func TestStats(t *testing.T) {
+	stats, err := Stats("test/beach.mp3", 0)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(stats.Channels), 2)
+	for _, channel := range stats.Channels {
+		assertEquals(channel.MinLevel >= -1 && channel.MaxLevel <= 1, true)
+		assertEquals(channel.PeakLevel <= 0, true)
+	}
+	assertEquals(stats.Overall.Samples > 0, true)
+
+	_, err = Stats("test/beach.mp3", 1)
+	assertEquals(err.Error(), "invalid stream index: 1, must be between 0 and 1")
+
+	fmt.Println("Stats test passed")
+}
+
+func TestWaveform(t *testing.T) {
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// One second of a 440 Hz sine, at half scale on the left and quarter scale on the right.
+	signal := func(t float64, channel int) float64 {
+		return Sine(440, 0.5/float64(channel+1))(t, channel)
+	}
+	generate := func(format string) Source {
+		generator, err := NewGenerator(signal, 1, &Options{SampleRate: 8000, Channels: 2, Format: format})
+		if err != nil {
+			panic(err)
+		}
+		return generator
+	}
+	files := map[string]string{}
+	for _, format := range []string{"s16", "f32"} {
+		source := generate(format)
+		data := []byte{}
+		for source.Read() {
+			data = append(data, source.Buffer()...)
+		}
+		files[format] = filepath.Join(directory, format+".wav")
+		tag, bps := uint16(1), 16
+		if format == "f32" {
+			tag, bps = 3, 32
+		}
+		writeTestWAV(files[format], tag, bps, 2, 8000, data)
+	}
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-3
+	}
+
+	// The same content gives the same waveform, whatever the format.
+	peaks, err := Waveform(files["s16"], nil, 20, WaveformOptions{})
+	if err != nil {
+		panic(err)
+	}
+	floats, err := Waveform(files["f32"], nil, 20, WaveformOptions{})
+	if err != nil {
+		panic(err)
+	}
+	streamed, err := ReadWaveform(generate("s16"), 20, WaveformOptions{})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(peaks), 20)
+	assertEquals(len(floats), 20)
+	assertEquals(len(streamed), 20)
+	for i := range peaks {
+		assertEquals(near(peaks[i], 0.375), true)
+		assertEquals(near(peaks[i], floats[i]), true)
+		assertEquals(near(peaks[i], streamed[i]), true)
+	}
+
+	// Levels of each channel, interleaved per bucket.
+	channels, err := Waveform(files["s16"], nil, 8, WaveformOptions{PerChannel: true})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(channels), 16)
+	for i := 0; i < len(channels); i += 2 {
+		assertEquals(near(channels[i], 0.5), true)
+		assertEquals(near(channels[i+1], 0.25), true)
+	}
+
+	rms, err := ReadWaveform(generate("f32"), 4, WaveformOptions{RMS: true})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(rms), 4)
+	for _, value := range rms {
+		assertEquals(near(value, 0.375/math.Sqrt2), true)
+	}
+
+	// Audio of unknown duration is reduced to the same buckets.
+	for _, settings := range []WaveformOptions{{}, {RMS: true, PerChannel: true}} {
+		known, err := Waveform(files["s16"], nil, 20, settings)
+		if err != nil {
+			panic(err)
+		}
+		unknown, err := reduceWaveform(generate("s16"), 0, 20, settings)
+		if err != nil {
+			panic(err)
+		}
+		assertEquals(len(unknown), len(known))
+		for i := range known {
+			assertEquals(near(unknown[i], known[i]), true)
+		}
+	}
+	// Buckets are combined as more audio is read than the buckets of the first frames can hold.
+	constant := func(t float64, channel int) float64 {
+		return 0.5
+	}
+	generator, err := NewGenerator(constant, 3, &Options{SampleRate: 8000, Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	halves, err := reduceWaveform(generator, 0, 6, WaveformOptions{RMS: true})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(halves), 6)
+	for _, value := range halves {
+		assertEquals(near(value, 0.5), true)
+	}
+	empty, err := NewGenerator(Silence(), 1, nil)
+	if err != nil {
+		panic(err)
+	}
+	empty.Close()
+	nothing, err := reduceWaveform(empty, 0, 6, WaveformOptions{})
+	assertEquals(err, nil)
+	assertEquals(nothing == nil, true)
+
+	// The last bucket holds the rest of the audio.
+	partial, err := ReadWaveform(generate("s16"), 3, WaveformOptions{})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(len(partial), 3)
+
+	_, err = Waveform(files["s16"], nil, 0, WaveformOptions{})
+	assertEquals(err.Error(), "invalid number of buckets: 0, must be positive")
+	_, err = ReadWaveform(generate("s16"), 0, WaveformOptions{})
+	assertEquals(err.Error(), "invalid resolution: 0 buckets per second, must be positive")
+
+	fmt.Println("Waveform test passed")
+}
+
+func TestSamplesFloat32(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, format := range []string{"u8", "s8", "u16le", "s16le", "s16be", "u24le", "s24le", "s24be", "u32be", "s32le", "f32le", "f32be", "f64le", "f64be"} {
+		codec := newSampleCodec(format)
+		buffer := make([]byte, 1000*codec.size)
+		for i := 0; i < len(buffer); i += codec.size {
+			codec.encode(buffer[i:], random.Float64()*2-1)
+		}
+		// Full scale values.
+		codec.encode(buffer, -1)
+		codec.encode(buffer[codec.size:], 1)
+
+		floats := decodeFloat32(nil, buffer, format)
+		assertEquals(len(floats), 1000)
+		for i, value := range floats {
+			assertEquals(value, float32(codec.decode(buffer[i*codec.size:])))
+		}
+		assertEquals(floats[0], float32(-1))
+		assertEquals(floats[1] > 0.99 && floats[1] <= 1, true)
+
+		// ConvertSamples gives the same samples.
+		converted, err := ConvertSamples(bytesToSamples(buffer, 1000, format), "f32")
+		if err != nil {
+			panic(err)
+		}
+		if sampleType(format) != "u24" && sampleType(format) != "s24" {
+			for i, value := range converted.([]float32) {
+				assertEquals(value, floats[i])
+			}
+		}
+	}
+
+	directory, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	filename := filepath.Join(directory, "u8.wav")
+	writeTestWAV(filename, 1, 8, 1, 8000, []byte{0, 64, 128, 192, 255})
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer audio.Close()
+	assertEquals(len(audio.SamplesFloat32()), 0)
+	assertEquals(audio.Read(), true)
+	floats := audio.SamplesFloat32()
+	assertEquals(len(floats), 5)
+	assertEquals(floats[0], float32(-1))
+	assertEquals(floats[1], float32(-0.5))
+	assertEquals(floats[2], float32(0))
+	assertEquals(floats[3], float32(0.5))
+	assertEquals(floats[4], float32(127)/128)
+
+	// The destination is reused if it is large enough.
+	dst := make([]float32, 0, 16)
+	into := audio.SamplesFloat32Into(dst)
+	assertEquals(&into[0], &dst[:1][0])
+
+	fmt.Println("Samples Float32 test passed")
+}
+
+func BenchmarkSamplesFloat32(b *testing.B) {
+	for _, format := range []string{"u8", "s16le", "s16be", "s24le", "s32le", "f32le", "f64le"} {
+		// 100 ms of 32 channel audio at 96 kHz.
+		buffer := make([]byte, 9600*32*newSampleCodec(format).size)
+		dst := make([]float32, 9600*32)
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(buffer)))
+			for i := 0; i < b.N; i++ {
+				decodeFloat32(dst, buffer, format)
+			}
+		})
+	}
+}
+
+// Opens an audio file, a writer and a transcoder, starts their processes and drops them without
+// closing them.
+func leakProcesses(filename, output string) {
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	if !audio.Read() {
+		panic("expected audio")
+	}
+	writer, err := NewAudioWriter(output, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write(make([]int16, 100)); err != nil {
+		panic(err)
+	}
+	pcm := TranscodeSpec{Format: "s16", SampleRate: 8000, Channels: 1}
+	transcoder, err := NewTranscoder(pcm, TranscodeSpec{Container: "ogg"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := transcoder.Write(make([]byte, 100)); err != nil {
+		panic(err)
+	}
+}
+
+func TestLeakDetection(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg decodes endless audio, or consumes all written audio. Capability queries fail.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg": fakeVersion +
+			"case \"$*\" in *\"-i - \"*) exec cat > /dev/null ;; esac\n[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"exec cat /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "endless.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	logger := &testLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	DetectLeaks(true)
+	defer DetectLeaks(false)
+
+	before := OpenProcesses()
+
+	// Closed objects keep no process running and are never reported.
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	audio.Close()
+	assertEquals(OpenProcesses(), before)
+
+	leakProcesses(filename, filepath.Join(dir, "output.wav"))
+	assertEquals(OpenProcesses(), before+3)
+
+	// The processes of the dropped objects are killed once they are garbage collected.
+	deadline := time.Now().Add(10 * time.Second)
+	for OpenProcesses() > before && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEquals(OpenProcesses(), before)
+
+	assertEquals(logger.contains("AudioWriter of "+filepath.Join(dir, "output.wav")+" was garbage collected"), true)
+	assertEquals(logger.contains("aio.leakProcesses"), true)
+	assertEquals(logger.contains("Audio of "+filename+" was garbage collected without being closed, killing ffmpeg"), true)
+	assertEquals(logger.contains("Transcoder was garbage collected"), true)
+
+	fmt.Println("Leak Detection test passed")
+}
+
+func TestEncodeProgress(t *testing.T) {
+	other := &ffmpegLog{}
+	parser := &progressParser{next: other}
+	var reports []EncodeProgress
+	parser.callback = func(progress EncodeProgress) {
+		reports = append(reports, progress)
+	}
+
+	// Reports are split across writes and interleaved with the log and the stats line.
+	output := "[libmp3lame @ 0x55d0] [warning] Queue input is backward in time\n" +
+		"bitrate=N/A\ntotal_size=N/A\nout_time_us=N/A\nout_time=N/A\nspeed=N/A\nprogress=continue\n" +
+		"size=     256kB time=00:00:16.00 bitrate= 131.1kbits/s speed=  32x\r" +
+		"stream_0_0_q=-1.0\nbitrate= 128.0kbits/s\ntotal_size=262188\nout_time_us=16345000\n" +
+		"out_time_ms=16345000\nout_time=00:00:16.345000\nspeed=32.1x\nprogress=end\n" +
+		"Conversion failed"
+	for i := 0; i < len(output); i += 7 {
+		end := i + 7
+		if end > len(output) {
+			end = len(output)
+		}
+		parser.Write([]byte(output[i:end]))
+	}
+
+	assertEquals(len(reports), 2)
+	assertEquals(reports[0].Done, false)
+	assertEquals(reports[0].Bitrate, 0.0)
+	assertEquals(reports[0].Fields["out_time"], "N/A")
+	assertEquals(reports[1].Done, true)
+	assertEquals(reports[1].OutTime, 16345*time.Millisecond)
+	assertEquals(reports[1].TotalSize, int64(262188))
+	assertEquals(reports[1].Bitrate, 128000.0)
+	assertEquals(reports[1].Speed, 32.1)
+	assertEquals(reports[1].Fields["stream_0_0_q"], "-1.0")
+	assertEquals(parser.progress().Done, true)
+
+	assertEquals(other.String(), "[libmp3lame @ 0x55d0] [warning] Queue input is backward in time\n"+
+		"size=     256kB time=00:00:16.00 bitrate= 131.1kbits/s speed=  32x\r")
+	parser.flush()
+	assertEquals(strings.HasSuffix(other.String(), "\rConversion failed"), true)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg reports its progress once all audio has been written.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"case \"$*\" in *\"-progress pipe:2\"*) ;; *) exec cat > /dev/null ;; esac\n" +
+			"printf 'out_time_us=500000\\nprogress=continue\\n' >&2\ncat > /dev/null\n" +
+			"printf 'out_time_us=1000000\\nspeed=40x\\nprogress=end\\n' >&2\n",
+	})()
+
+	writer, err := NewAudioWriter(filepath.Join(dir, "output.wav"), &Options{EncoderProgress: true})
+	if err != nil {
+		panic(err)
+	}
+	done := make(chan EncodeProgress, 2)
+	writer.OnProgress(func(progress EncodeProgress) {
+		done <- progress
+	})
+	assertEquals(writer.Progress().OutTime, time.Duration(0))
+
+	if err := writer.Write(make([]int16, 44100*2)); err != nil {
+		panic(err)
+	}
+	writer.Close()
+
+	assertEquals((<-done).OutTime, 500*time.Millisecond)
+	assertEquals((<-done).Done, true)
+	assertEquals(writer.Progress().OutTime, time.Second)
+	assertEquals(writer.Progress().Speed, 40.0)
+
+	plain, err := NewAudioWriter(filepath.Join(dir, "plain.wav"), nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := plain.Write(make([]int16, 100)); err != nil {
+		panic(err)
+	}
+	plain.Close()
+	assertEquals(plain.Progress().Done, false)
+
+	fmt.Println("Encode Progress test passed")
+}
+
+func TestMixer(t *testing.T) {
+	mixer, err := NewMixer(&Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(mixer.Format(), "s16")
+	assertEquals(mixer.BytesPerFrame(), 4)
+
+	// A stereo s16 sine at 1000 Hz is mixed with a mono f32 sine at 500 Hz at half its volume.
+	first, err := NewGenerator(Sine(1000, 0.5), 1, &Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	second, err := NewGenerator(Sine(500, 0.5), 1, &Options{SampleRate: 8000, Channels: 1, Format: "f32"})
+	if err != nil {
+		panic(err)
+	}
+	second.SetBuffer(make([]byte, 4*300))
+	if _, err := mixer.Attach(first, 1); err != nil {
+		panic(err)
+	}
+	index, err := mixer.Attach(second, 0.5)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(index, 1)
+	assertEquals(mixer.Tracks(), 2)
+
+	analyzer, err := NewAnalyzer(2, 8000, "s16", AnalyzerOptions{WindowSize: 256}, nil)
+	if err != nil {
+		panic(err)
+	}
+	mixer.SetBuffer(make([]byte, 4*1000))
+	frames, peak := 0, 0.0
+	var spectra []Spectrum
+	for mixer.Read() {
+		samples := mixer.Samples().([]int16)
+		frames += len(samples) / 2
+		for i, sample := range samples {
+			// Both channels are the same.
+			assertEquals(sample, samples[i-i%2])
+			peak = math.Max(peak, math.Abs(float64(sample)/32768))
+		}
+		result, err := analyzer.Process(samples)
+		if err != nil {
+			panic(err)
+		}
+		spectra = append(spectra, result...)
+	}
+	assertEquals(frames, 8000)
+	if peak > 0.75 || peak < 0.6 {
+		panic(fmt.Sprintf("expected a peak level between 0.6 and 0.75, got %v", peak))
+	}
+	for _, spectrum := range spectra {
+		if math.Abs(spectrum.Magnitudes[32]-0.5) > 0.002 || math.Abs(spectrum.Magnitudes[16]-0.25) > 0.002 {
+			panic(fmt.Sprintf("expected magnitudes of 0.5 and 0.25, got %v and %v", spectrum.Magnitudes[32], spectrum.Magnitudes[16]))
+		}
+	}
+	assertEquals(mixer.Underruns(), 0)
+	mixer.Close()
+	assertEquals(mixer.Read(), false)
+
+	// Sums saturate, and tracks written by hand underrun when they have no audio.
+	mixer, err = NewMixer(&Options{SampleRate: 8000, Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	loud, err := NewGenerator(Sine(1000, 1), 0.01, &Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := mixer.Attach(loud, 1); err != nil {
+		panic(err)
+	}
+	live, err := mixer.AddTrack(1)
+	if err != nil {
+		panic(err)
+	}
+	if err := mixer.WriteTrack(live, []float64{0.5, 0.5, -0.5, 0.25}); err != nil {
+		panic(err)
+	}
+	mixer.SetBuffer(make([]byte, 2*40))
+	assertEquals(mixer.Read(), true)
+	samples := mixer.Samples().([]int16)
+	assertEquals(samples[0], int16(16384))
+	assertEquals(samples[1], int16(32767))
+	assertEquals(samples[2], int16(16383))
+	assertEquals(samples[6], int16(-32768))
+	assertEquals(mixer.Underruns(), 1)
+
+	// Once all tracks have ended, the mix ends with the longest of them.
+	if err := mixer.WriteTrack(live, make([]int16, 60)); err != nil {
+		panic(err)
+	}
+	if err := mixer.CloseTrack(live); err != nil {
+		panic(err)
+	}
+	if err := mixer.WriteTrack(live, make([]int16, 1)); err == nil {
+		panic("expected error for writing to a closed track")
+	}
+	assertEquals(mixer.Read(), true)
+	assertEquals(len(mixer.Buffer()), 80)
+	assertEquals(mixer.Read(), true)
+	assertEquals(len(mixer.Buffer()), 2*20)
+	assertEquals(mixer.Read(), false)
+	assertEquals(mixer.Underruns(), 1)
+
+	if err := mixer.SetGain(0, 0.5); err != nil {
+		panic(err)
+	}
+	gain, _ := mixer.Gain(0)
+	assertEquals(gain, 0.5)
+	if err := mixer.SetGain(0, -1); err == nil {
+		panic("expected error for negative gain")
+	}
+	if err := mixer.SetGain(2, 1); err == nil {
+		panic("expected error for invalid track index")
+	}
+	if err := mixer.WriteTrack(0, make([]int16, 2)); err == nil {
+		panic("expected error for writing to an attached track")
+	}
+
+	// Reading waits for tracks written by hand, and is paced by the sample rate while they
+	// have no audio, instead of mixing silence as fast as it is read.
+	mixer, err = NewMixer(&Options{SampleRate: 8000, Channels: 1})
+	if err != nil {
+		panic(err)
+	}
+	live, err = mixer.AddTrack(1)
+	if err != nil {
+		panic(err)
+	}
+	mixer.SetBuffer(make([]byte, 2*800))
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(20 * time.Millisecond)
+			mixer.WriteTrack(live, make([]int16, 800))
+		}
+	}()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assertEquals(mixer.Read(), true)
+	}
+	assertEquals(mixer.Underruns(), 0)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		panic(fmt.Sprintf("expected reading to wait for the written audio, took %v", elapsed))
+	}
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		assertEquals(mixer.Read(), true)
+	}
+	assertEquals(mixer.Underruns(), 3)
+	// Each buffer holds 100 ms of audio, and the sixth buffer is due 600 ms after the first Read.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		panic(fmt.Sprintf("expected reading to be paced by the sample rate, took %v", elapsed))
+	}
+	done := make(chan bool)
+	go func() {
+		done <- mixer.Read()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	mixer.Close()
+	assertEquals(<-done, false)
+
+	stereo, err := NewMixer(&Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	defer stereo.Close()
+	if err := stereo.WriteTrack(0, make([]int16, 2)); err == nil {
+		panic("expected error for missing track")
+	}
+	if _, err := stereo.AddTrack(1); err != nil {
+		panic(err)
+	}
+	if err := stereo.WriteTrack(0, make([]int16, 3)); err == nil {
+		panic("expected error for incomplete frame")
+	}
+	other, _ := NewGenerator(Silence(), 1, &Options{SampleRate: 44100, Channels: 2})
+	if _, err := stereo.Attach(other, 1); err == nil {
+		panic("expected error for mismatched sample rate")
+	}
+	surround, _ := NewGenerator(Silence(), 1, &Options{SampleRate: 8000, Channels: 6})
+	if _, err := stereo.Attach(surround, 1); err == nil {
+		panic("expected error for mismatched channels")
+	}
+
+	fmt.Println("Mixer test passed")
+}
+
+func TestExtractAudio(t *testing.T) {
+	muxer, err := copyMuxer("audio.M4A", "aac")
+	assertEquals(muxer, "ipod")
+	assertEquals(err, nil)
+	muxer, _ = copyMuxer("audio", "aac")
+	assertEquals(muxer, "adts")
+	muxer, _ = copyMuxer("audio.out", "opus")
+	assertEquals(muxer, "ogg")
+	muxer, _ = copyMuxer("audio.bin", "pcm_s16be")
+	assertEquals(muxer, "matroska")
+	muxer, _ = copyMuxer("audio.mka", "truehd")
+	assertEquals(muxer, "matroska")
+	_, err = copyMuxer("audio.m4a", "vorbis")
+	assertEquals(err.Error(), "vorbis audio cannot be copied into a .m4a file without re-encoding, use .ogg instead")
+	_, err = copyMuxer("audio.wav", "dts")
+	assertEquals(err.Error(), "dts audio cannot be copied into a .wav file without re-encoding, use .mka instead")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg stores its arguments and creates the output, failing for outputs named "broken".
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeVersion +
+			"echo \"stream|index=0|codec_name=h264|codec_type=video\"\n" +
+			"echo \"stream|index=1|codec_name=aac|codec_type=audio|sample_rate=44100|channels=2\"\n",
+		"ffmpeg": fakeVersion +
+			"echo \"$@\" > \"" + filepath.Join(dir, "args") + "\"\n" +
+			"for output; do :; done\noutput=${output#file:}\necho data > \"$output\"\n" +
+			"case \"$output\" in *broken*) echo \"Could not write header\" >&2; exit 1 ;; esac\n",
+	})()
+
+	infile := filepath.Join(dir, "movie.mp4")
+	if err := os.WriteFile(infile, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	outfile := filepath.Join(dir, "audio.m4a")
+	if err := ExtractAudio(infile, outfile, 0); err != nil {
+		panic(err)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(strings.Contains(string(args), "-map 0:a:0 -c copy -f ipod file:"+outfile), true)
+	assertEquals(exists(outfile), true)
+
+	if err := ExtractAudio(infile, outfile, 1); err == nil {
+		panic("expected error for invalid stream index")
+	}
+	if err := ExtractAudio(infile, filepath.Join(dir, "audio.mp3"), 0); err == nil {
+		panic("expected error for a container that cannot hold the codec")
+	}
+	if err := ExtractAudio(infile, infile, 0); err == nil {
+		panic("expected error for extracting audio into the input file")
+	}
+
+	broken := filepath.Join(dir, "broken.aac")
+	err = ExtractAudio(infile, broken, 0)
+	if err == nil || !strings.Contains(err.Error(), "Could not write header") {
+		panic(fmt.Sprintf("expected error with the ffmpeg output, got %v", err))
+	}
+	assertEquals(exists(broken), false)
+
+	fmt.Println("Extract Audio test passed")
+}
+
+func TestProbeLimits(t *testing.T) {
+	limits := newProbeLimits(&Options{ProbeSize: 1 << 20, AnalyzeDuration: 2 * time.Second})
+	assertEquals(strings.Join(limits.args(), " "), "-probesize 1048576 -analyzeduration 2000000")
+	// Limits are raised to the lower bounds.
+	limits = newProbeLimits(&Options{ProbeSize: 100, AnalyzeDuration: time.Millisecond})
+	assertEquals(strings.Join(limits.args(), " "), "-probesize 32768 -analyzeduration 500000")
+	assertEquals(len(newProbeLimits(&Options{}).args()), 0)
+
+	if _, err := NewAudio("test/beach.mp3", &Options{ProbeSize: -1}); err == nil {
+		panic("expected error for negative probe size")
+	}
+	if _, err := NewAudio("test/beach.mp3", &Options{AnalyzeDuration: -time.Second}); err == nil {
+		panic("expected error for negative analyze duration")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake programs store their arguments. ffmpeg decodes endless audio.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeVersion + "echo \"$@\" > \"" + filepath.Join(dir, "ffprobe.args") + "\"\n" +
+			"echo \"stream|index=0|codec_name=aac|codec_type=audio|sample_rate=8000|channels=1\"\n",
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" > \"" + filepath.Join(dir, "ffmpeg.args") + "\"\nexec cat /dev/zero\n",
+	})()
+
+	filename := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+	audio, err := NewAudio(filename, &Options{ProbeSize: 1 << 20, AnalyzeDuration: time.Second})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	audio.Close()
+
+	args, _ := os.ReadFile(filepath.Join(dir, "ffprobe.args"))
+	assertEquals(strings.HasPrefix(string(args), "-probesize 1048576 -analyzeduration 1000000 -show_streams"), true)
+	args, _ = os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.HasPrefix(string(args), "-probesize 1048576 -analyzeduration 1000000 -i file:"+filename), true)
+
+	fmt.Println("Probe Limits test passed")
+}
+
+func BenchmarkProbeLimits(b *testing.B) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A large Matroska file with a high bitrate video stream, of which ffmpeg analyzes several
+	// megabytes by default before the audio is decoded.
+	filename := filepath.Join(dir, "large.mkv")
+	cmd := exec.Command(
+		"ffmpeg", "-loglevel", "error",
+		"-f", "lavfi", "-i", "testsrc2=size=1280x720:rate=30:duration=60",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=60",
+		"-c:v", "mpeg4", "-q:v", "2", "-c:a", "flac", filename,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		panic(fmt.Sprintf("%v: %s", err, output))
+	}
+
+	for name, options := range map[string]*Options{
+		"default": nil,
+		"limited": {ProbeSize: 64 << 10, AnalyzeDuration: time.Second},
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				audio, err := NewAudio(filename, options)
+				if err != nil {
+					panic(err)
+				}
+				if !audio.Read() {
+					panic("expected audio")
+				}
+				audio.Close()
+			}
+		})
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	assertEquals(len(ConvertBatch(nil, 4, nil)), 0)
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake ffmpeg decodes 100 ms of audio, and encodes by copying the audio into the output,
+	// failing for outputs named "fail". Both store their arguments.
+	args := filepath.Join(dir, "args")
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeVersion +
+			"echo \"stream|index=0|codec_name=mp3|codec_type=audio|sample_rate=8000|channels=2\"\n",
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" >> \"" + args + "\"\n" +
+			"case \"$*\" in *\"-i - \"*) ;; *) exec head -c 3200 /dev/zero ;; esac\n" +
+			"for output; do :; done\ncat > \"$output\"\ncase \"$output\" in *fail*) exit 1 ;; esac\n",
+	})()
+
+	jobs := []ConvertJob{}
+	for i := 0; i < 6; i++ {
+		input := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+		if err := os.WriteFile(input, []byte{}, 0644); err != nil {
+			panic(err)
+		}
+		output := filepath.Join(dir, fmt.Sprintf("%d.flac", i))
+		if i == 3 {
+			output = filepath.Join(dir, "fail.flac")
+		}
+		jobs = append(jobs, ConvertJob{Input: input, Output: output, Options: &Options{Channels: 1}})
+	}
+	jobs[4].Input = filepath.Join(dir, "missing.mp3")
+	jobs[5].Options.Threads = 3
+
+	var progress []int
+	errs := ConvertBatch(jobs, 2, func(done, total int, current string) {
+		assertEquals(total, 6)
+		assertEquals(strings.HasSuffix(current, ".mp3"), true)
+		progress = append(progress, done)
+	})
+	assertEquals(fmt.Sprint(progress), "[1 2 3 4 5 6]")
+
+	assertEquals(len(errs), 6)
+	for i, err := range errs {
+		switch i {
+		case 3:
+			if err == nil || !strings.Contains(err.Error(), "could not encode") {
+				panic(fmt.Sprintf("expected encoding error, got %v", err))
+			}
+			assertEquals(exists(jobs[i].Output), false)
+		case 4:
+			if err == nil || !strings.Contains(err.Error(), "does not exist") {
+				panic(fmt.Sprintf("expected error for missing input, got %v", err))
+			}
+		default:
+			assertEquals(err, nil)
+			data, err := os.ReadFile(jobs[i].Output)
+			if err != nil {
+				panic(err)
+			}
+			// All decoded audio is written to the output.
+			assertEquals(len(data), 3200)
+		}
+	}
+
+	// Jobs without threads share the CPUs between the workers, and the decoded audio is
+	// written without being filtered again.
+	data, err := os.ReadFile(args)
+	if err != nil {
+		panic(err)
+	}
+	threads := runtime.NumCPU() / 2
+	if threads < 1 {
+		threads = 1
+	}
+	assertEquals(strings.Contains(string(data), fmt.Sprintf("-threads %d -i file:%s", threads, jobs[0].Input)), true)
+	assertEquals(strings.Contains(string(data), "-threads 3 -i file:"+jobs[5].Input), true)
+	assertEquals(strings.Contains(string(data), "-threads 3 "+jobs[5].Output), true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range ConvertBatchContext(ctx, jobs, 2, nil) {
+		assertEquals(err, context.Canceled)
+	}
+
+	fmt.Println("Convert Batch test passed")
+}
+
+func TestRawAudio(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Three stereo frames of big endian 16-bit samples.
+	filename := filepath.Join(dir, "capture.pcm")
+	data := []byte{0x00, 0x01, 0xFF, 0xFF, 0x7F, 0xFF, 0x80, 0x00, 0x12, 0x34, 0x00, 0x00}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewRawAudio(filename, 8000, 2, "s16be", &Options{Format: "s16be"})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.SampleRate(), 8000)
+	assertEquals(audio.Channels(), 2)
+	assertEquals(audio.Codec(), "pcm_s16be")
+	assertEquals(audio.Duration(), 3.0/8000)
+	assertEquals(audio.Total(), len(data))
+	buffer, err := audio.ReadAllBuffer()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(string(buffer.Buffer()), string(data))
+
+	// The samples are converted to the requested format.
+	audio, err = NewRawAudio(filename, 8000, 2, "s16be", &Options{Format: "s16le"})
+	if err != nil {
+		panic(err)
+	}
+	buffer, err = audio.ReadAllBuffer()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(string(buffer.Buffer()), string([]byte{0x01, 0x00, 0xFF, 0xFF, 0xFF, 0x7F, 0x00, 0x80, 0x34, 0x12, 0x00, 0x00}))
+
+	// Files that end in the middle of a frame are reported.
+	if err := os.WriteFile(filename, data[:len(data)-1], 0644); err != nil {
+		panic(err)
+	}
+	if _, err := NewRawAudio(filename, 8000, 2, "s16be", nil); err == nil || !strings.Contains(err.Error(), "3 bytes are left over") {
+		panic(fmt.Sprintf("expected error for misaligned file, got %v", err))
+	}
+	if _, err := NewRawAudio(filename, 8000, 2, "s12", nil); err == nil {
+		panic("expected error for invalid format")
+	}
+	if _, err := NewRawAudio(filename, 0, 2, "s16", nil); err == nil {
+		panic("expected error for invalid sample rate")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// Resampled files are decoded by ffmpeg, which is told the layout of the samples. ffprobe is never run.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": "echo \"$@\" > \"" + filepath.Join(dir, "ffprobe.args") + "\"\nexit 1\n",
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" > \"" + filepath.Join(dir, "ffmpeg.args") + "\"\nexec head -c 6400 /dev/zero\n",
+	})()
+
+	if err := os.WriteFile(filename, make([]byte, 16000), 0644); err != nil {
+		panic(err)
+	}
+	audio, err = NewRawAudio(filename, 8000, 1, "s16le", &Options{SampleRate: 16000})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Duration(), 1.0)
+	assertEquals(audio.Total(), 32000)
+	assertEquals(audio.Read(), true)
+	audio.Close()
+
+	args, _ := os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.HasPrefix(string(args), "-f s16le -ar 8000 -ac 1 -i file:"+filename+" -f s16le -ar 16000 -ac 1"), true)
+	assertEquals(exists(filepath.Join(dir, "ffprobe.args")), false)
+
+	fmt.Println("Raw Audio test passed")
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samples := make([]int16, 44100*2)
+	random := rand.New(rand.NewSource(16))
+	for i := range samples {
+		samples[i] = int16(random.Intn(1<<16) - 1<<15)
+	}
+	samples[0], samples[1] = -1<<15, 1<<15-1
+	expected := samplesToBytes(samples)
+
+	filename := filepath.Join(dir, "round.raw")
+	writer, err := NewAudioWriter(filename, &Options{SampleRate: 44100, Channels: 2, Format: "s16"})
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write(samples); err != nil {
+		panic(err)
+	}
+	writer.Close()
+
+	audio, err := NewRawAudio(filename, 44100, 2, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Duration(), 1.0)
+	buffer, err := audio.ReadAllBuffer()
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(sha256.Sum256(buffer.Buffer()), sha256.Sum256(expected))
+
+	fmt.Println("Raw Round Trip test passed")
+}
+
+func TestSeek(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// One second of mono audio where every sample is its index.
+	filename := filepath.Join(dir, "ramp.raw")
+	ramp := make([]int16, 8000)
+	for i := range ramp {
+		ramp[i] = int16(i)
+	}
+	if err := os.WriteFile(filename, samplesToBytes(ramp), 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewRawAudio(filename, 8000, 1, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	audio.SetBuffer(make([]byte, 2000))
+
+	// Seeking before the first read.
+	if err := audio.Seek(0.5); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Position(), 0.5)
+	assertEquals(audio.Read(), true)
+	assertEquals(audio.Samples().([]int16)[0], int16(4000))
+	assertEquals(audio.Position(), 0.625)
+
+	// Seeking while reading.
+	if err := audio.Seek(0.25); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	assertEquals(audio.Samples().([]int16)[0], int16(2000))
+
+	// Seeking after the end of the audio restores the buffer.
+	if err := audio.Seek(0.9); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	assertEquals(len(audio.Buffer()), 1600)
+	assertEquals(audio.Read(), false)
+	if err := audio.Seek(0); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	assertEquals(len(audio.Buffer()), 2000)
+	assertEquals(audio.Samples().([]int16)[0], int16(0))
+
+	if err := audio.Seek(1.5); err == nil {
+		panic("expected error for seek past the end")
+	}
+	if err := audio.Seek(-1); err == nil {
+		panic("expected error for negative seek")
+	}
+	audio.Close()
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" > \"" + filepath.Join(dir, "ffmpeg.args") + "\"\nexec head -c 3200 /dev/zero\n",
+	})()
+
+	// Audio decoded by ffmpeg is seeked with the input, reversed audio is cut at the end.
+	audio, err = NewRawAudio(filename, 8000, 1, "s16", &Options{SampleRate: 16000})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	if err := audio.Seek(0.5); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	audio.Close()
+	assertEquals(audio.Position(), 0.6)
+	args, _ := os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.HasPrefix(string(args), "-ss 0.500000 -f s16"), true)
+
+	audio, err = NewRawAudio(filename, 8000, 1, "s16", &Options{SampleRate: 16000, Reverse: true})
+	if err != nil {
+		panic(err)
+	}
+	if err := audio.Seek(0.25); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	audio.Close()
+	args, _ = os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.HasPrefix(string(args), "-t 0.750000 -f s16"), true)
+
+	fmt.Println("Seek test passed")
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "capture.raw")
+	if err := os.WriteFile(filename, make([]byte, 16200), 0644); err != nil {
+		panic(err)
+	}
+	audio, err := NewRawAudio(filename, 8000, 1, "s16", nil)
+	if err != nil {
+		panic(err)
+	}
+	audio.SetBuffer(make([]byte, 2000))
+	for audio.Read() {
+	}
+
+	// Eight full buffers and a short one, which ends reading.
+	metrics := audio.Metrics()
+	assertEquals(metrics.Bytes, int64(16200))
+	assertEquals(metrics.Frames, int64(8100))
+	assertEquals(metrics.Operations, int64(9))
+	assertEquals(metrics.ShortBuffers, int64(1))
+	assertEquals(metrics.Errors, int64(0))
+	assertEquals(metrics.Blocked > 0, true)
+
+	audio.ResetMetrics()
+	assertEquals(audio.Metrics(), Metrics{})
+
+	// Metrics of values that were not created by their constructor are always zero.
+	assertEquals((&Microphone{}).Metrics(), Metrics{})
+	(&Player{}).ResetMetrics()
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "exec cat > /dev/null\n",
+	})()
+
+	writer, err := NewAudioWriter(filepath.Join(dir, "output.raw"), &Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write(make([]int16, 2000)); err != nil {
+		panic(err)
+	}
+	writer.Close()
+	metrics = writer.Metrics()
+	assertEquals(metrics.Bytes, int64(4000))
+	assertEquals(metrics.Frames, int64(1000))
+	assertEquals(metrics.Errors, int64(0))
+
+	// Writing fails once ffmpeg has exited, at the latest when the pipe is full.
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "exit 1\n",
+	})()
+
+	writer, err = NewAudioWriter(filepath.Join(dir, "output.raw"), &Options{SampleRate: 8000, Channels: 2})
+	if err != nil {
+		panic(err)
+	}
+	if err := writer.Write(make([]int16, 1<<20)); err == nil {
+		panic("expected error after ffmpeg exited")
+	}
+	writer.Close()
+	assertEquals(writer.Metrics().Errors, int64(1))
+
+	fmt.Println("Metrics test passed")
+}
+
+func TestSegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// One second of mono audio where every sample is its index.
+	filename := filepath.Join(dir, "ramp.raw")
+	ramp := make([]int16, 8000)
+	for i := range ramp {
+		ramp[i] = int16(i)
+	}
+	if err := os.WriteFile(filename, samplesToBytes(ramp), 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewRawAudio(filename, 8000, 1, "s16", &Options{StartTime: 0.25, Duration: 0.5})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Duration(), 0.5)
+	assertEquals(audio.Total(), 8000)
+	audio.SetBuffer(make([]byte, 3000))
+	samples := []int16{}
+	for audio.Read() {
+		samples = append(samples, audio.Samples().([]int16)...)
+	}
+	// The last buffer ends exactly at the end of the segment.
+	assertEquals(len(audio.Buffer()), 2000)
+	assertEquals(len(samples), 4000)
+	assertEquals(samples[0], int16(2000))
+	assertEquals(samples[len(samples)-1], int16(5999))
+
+	// Seeking is relative to the start of the segment.
+	if err := audio.Seek(0.1); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	assertEquals(audio.Samples().([]int16)[0], int16(2800))
+	if err := audio.Seek(0.6); err == nil {
+		panic("expected error for seek past the end of the segment")
+	}
+	audio.Close()
+
+	// Segments reaching past the end of the file are shortened.
+	audio, err = NewRawAudio(filename, 8000, 1, "s16", &Options{StartTime: 0.75, Duration: 1})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Duration(), 0.25)
+	audio.Close()
+
+	if _, err := NewRawAudio(filename, 8000, 1, "s16", &Options{StartTime: 1}); err == nil ||
+		!strings.Contains(err.Error(), "past the end") {
+		panic(fmt.Sprintf("expected error for start time past the end, got %v", err))
+	}
+	if _, err := NewRawAudio(filename, 8000, 1, "s16", &Options{Duration: -1}); err == nil {
+		panic("expected error for negative duration")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	defer fakePrograms(dir, map[string]string{
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" > \"" + filepath.Join(dir, "ffmpeg.args") + "\"\nexec head -c 3200 /dev/zero\n",
+	})()
+
+	// ffmpeg is given the segment from the seek position.
+	audio, err = NewRawAudio(filename, 8000, 1, "s16", &Options{SampleRate: 16000, StartTime: 0.25, Duration: 0.5})
+	if err != nil {
+		panic(err)
+	}
+	if err := audio.Seek(0.1); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	audio.Close()
+	args, _ := os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.HasPrefix(string(args), "-t 0.400000 -ss 0.350000 -f s16"), true)
+
+	fmt.Println("Segment test passed")
+}
+
+func TestAudioContext(t *testing.T) {
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// One second of silence in a WAV file, which is read without ffmpeg.
+	filename := filepath.Join(dir, "silence.wav")
+	header, err := wavHeader("s16le", 8000, 1)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filename, append(header, make([]byte, 16000)...), 0644); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	audio, err := NewAudioContext(ctx, filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	audio.SetBuffer(make([]byte, 1600))
+	assertEquals(audio.Read(), true)
+	cancel()
+	assertEquals(audio.Read(), false)
+	if _, err := audio.ReadFrame(); err != context.Canceled {
+		panic(fmt.Sprintf("expected context.Canceled, got %v", err))
+	}
+	audio.Close()
+
+	if _, err := NewAudioContext(ctx, filename, nil); err != context.Canceled {
+		panic(fmt.Sprintf("expected context.Canceled, got %v", err))
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// ffprobe hangs as on an unresponsive network share, ffmpeg decodes endless audio.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeVersion +
+			"[ -e \"" + filepath.Join(dir, "hang") + "\" ] && exec sleep 60\n" +
+			"echo \"stream|index=0|codec_name=aac|codec_type=audio|sample_rate=8000|channels=1\"\n",
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\nexec cat /dev/zero\n",
+	})()
+
+	filename = filepath.Join(dir, "stream.m4a")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	audio, err = NewAudioContext(ctx, filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), true)
+	cancel()
+	// The buffer being read when ffmpeg was killed may still be returned.
+	reads := 0
+	for audio.Read() {
+		reads++
+	}
+	assertEquals(reads <= 1, true)
+	if _, err := audio.ReadFrame(); err != context.Canceled {
+		panic(fmt.Sprintf("expected context.Canceled, got %v", err))
+	}
+	audio.Close()
+	audio.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "hang"), []byte{}, 0644); err != nil {
+		panic(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := NewAudioStreamsContext(ctx, filename, nil); err != context.DeadlineExceeded {
+		panic(fmt.Sprintf("expected context.DeadlineExceeded, got %v", err))
+	}
+	assertEquals(time.Since(started) < 10*time.Second, true)
+
+	fmt.Println("Audio Context test passed")
+}
+
+func TestAudioError(t *testing.T) {
+	// Only the latest output is kept.
+	log := &ffmpegLog{limit: 4}
+	log.Write([]byte("first\n"))
+	log.Write([]byte("last"))
+	assertEquals(log.String(), "last")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// ffmpeg decodes 200 ms of audio before it fails, unless the file "clean" exists.
+	defer fakePrograms(dir, map[string]string{
+		"ffprobe": fakeMonoProbe,
+		"ffmpeg": fakeVersion + "[ \"$1\" = \"-hide_banner\" ] && exit 1\n" +
+			"echo \"$@\" > \"" + filepath.Join(dir, "ffmpeg.args") + "\"\nhead -c 3200 /dev/zero\n" +
+			"[ -e \"" + filepath.Join(dir, "clean") + "\" ] && exit 0\n" +
+			"echo \"[mp3float @ 0x5581c6e3c2c0] Header missing\" >&2\n" +
+			"echo \"Error while decoding stream #0:0: Invalid data found when processing input\" >&2\nexit 1\n",
+	})()
+
+	filename := filepath.Join(dir, "talk.mp3")
+	if err := os.WriteFile(filename, []byte{}, 0644); err != nil {
+		panic(err)
+	}
+
+	audio, err := NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Error(), nil)
+	for audio.Read() {
+	}
+	err = audio.Error()
+	if err == nil {
+		panic("expected decode error")
+	}
+	for _, part := range []string{"could not decode " + filename + " at 200ms", "exit status 1", "Header missing", "Invalid data found"} {
+		if !strings.Contains(err.Error(), part) {
+			panic(fmt.Sprintf("expected %q in %q", part, err.Error()))
+		}
+	}
+	if _, err := audio.ReadFrame(); err != audio.Error() {
+		panic(fmt.Sprintf("expected the decode error, got %v", err))
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "ffmpeg.args"))
+	assertEquals(strings.Contains(string(args), "-loglevel error"), true)
+
+	// A clean end of the audio is not an error.
+	if err := os.WriteFile(filepath.Join(dir, "clean"), []byte{}, 0644); err != nil {
+		panic(err)
+	}
+	audio, err = NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	for audio.Read() {
+	}
+	assertEquals(audio.Error(), nil)
+
+	// An ffmpeg that cannot be started ends reading with an error.
+	audio, err = NewAudio(filename, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "ffmpeg"), 0644); err != nil {
+		panic(err)
+	}
+	assertEquals(audio.Read(), false)
+	if audio.Error() == nil {
+		panic("expected an error for ffmpeg that cannot be started")
+	}
+	if _, err := audio.ReadFrame(); err != audio.Error() {
+		panic(fmt.Sprintf("expected the start error, got %v", err))
+	}
+	// The audio has ended, so reading does not try to start ffmpeg again.
+	assertEquals(audio.Read(), false)
+	audio.Close()
+
+	fmt.Println("Audio Error test passed")
+}
+
+func TestPlayerMute(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dir, err := os.MkdirTemp("", "aio")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer fakePrograms(dir, map[string]string{
+		"ffplay": fakeVersion + "exec cat > /dev/null\n",
+	})()
+
+	// A Volume of 0 is the unset option and plays at full volume.
+	player, err := NewPlayer(1, 8000, "s16", &Options{Volume: 0})
+	if err != nil {
+		panic(err)
+	}
+	defer player.Close()
+	assertEquals(player.Volume(), 1.0)
+
+	// The player starts muted with SetVolume(0).
+	if err := player.SetVolume(0); err != nil {
+		panic(err)
+	}
+	assertEquals(player.Volume(), 0.0)
+	buffer, err := player.prepare([]int16{1000, -1000, 32767})
+	if err != nil {
+		panic(err)
+	}
+	assertEquals(bytes.Equal(buffer, make([]byte, 6)), true)
+
+	if _, err := NewPlayer(1, 8000, "s16", &Options{Volume: -1}); err == nil {
+		panic("expected error for negative volume")
+	}
+
+	fmt.Println("Player Mute test passed")
+}
@@ -0,0 +1,337 @@
+package aio
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Default number of samples in each window analyzed by an Analyzer.
+const defaultWindowSize = 2048
+
+// Magnitudes of the frequencies in one window of audio, as returned by Analyzer.Process.
+type Spectrum struct {
+	Channel    int           // Channel of the audio, or -1 for the mix of all channels.
+	PTS        time.Duration // Time of the first sample of the window, from the first sample processed.
+	Magnitudes []float64     // Magnitude of each frequency bin, from 0 Hz up to half the sample rate.
+}
+
+// Windows analyzed by an Analyzer and how their spectra are returned. A zero value means that
+// the default is used.
+type AnalyzerOptions struct {
+	WindowSize     int    // Number of samples in each window, a power of two. 2048 by default.
+	HopSize        int    // Number of samples between the starts of consecutive windows. Half the window by default.
+	WindowFunction string // Window function: "hann" (default), "hamming", "blackman" or "rectangular".
+	PerChannel     bool   // Analyze each channel separately instead of their mix.
+	Decibels       bool   // Return magnitudes in dBFS instead of linear values.
+}
+
+type Analyzer struct {
+	samplerate int         // Audio Sample Rate in Hz.
+	channels   int         // Number of audio channels.
+	format     string      // Format of audio samples.
+	size       int         // Number of samples in each window.
+	hop        int         // Number of samples between the starts of consecutive windows.
+	window     []float64   // Window function, scaled so that a full scale sine has a magnitude of 1.
+	decibels   bool        // Flag storing whether magnitudes are returned in dBFS.
+	perchannel bool        // Flag storing whether channels are analyzed separately instead of mixed.
+	pending    [][]float64 // Samples of the current window for each analyzed channel.
+	skip       int         // Number of samples to skip before the next window when the hop is larger than the window.
+	start      int         // Index of the first pending sample, counted from the first sample processed.
+	fft        *fft        // FFT of the window size.
+	mutex      sync.Mutex  // Mutex guarding the pending samples against concurrent calls to Process.
+}
+
+// Audio Sample Rate in Hz.
+func (analyzer *Analyzer) SampleRate() int {
+	return analyzer.samplerate
+}
+
+func (analyzer *Analyzer) Channels() int {
+	return analyzer.channels
+}
+
+func (analyzer *Analyzer) Format() string {
+	switch analyzer.format {
+	case "u8", "s8":
+		return analyzer.format
+	default:
+		return analyzer.format[:len(analyzer.format)-2]
+	}
+}
+
+// Number of samples in each window.
+func (analyzer *Analyzer) WindowSize() int {
+	return analyzer.size
+}
+
+// Number of samples between the starts of consecutive windows.
+func (analyzer *Analyzer) HopSize() int {
+	return analyzer.hop
+}
+
+// Number of frequency bins in each spectrum.
+func (analyzer *Analyzer) Bins() int {
+	return analyzer.size/2 + 1
+}
+
+// Center frequency of the given bin in Hz.
+func (analyzer *Analyzer) Frequency(bin int) float64 {
+	return float64(bin) * float64(analyzer.samplerate) / float64(analyzer.size)
+}
+
+// Creates a spectrum analyzer for audio with the given number of channels, sample rate and format.
+// The window size, hop size and window function are taken from the settings, and default to
+// 2048 samples, half the window size and "hann". Channels are mixed before they are analyzed,
+// unless settings.PerChannel is set.
+func NewAnalyzer(channels, samplerate int, format string, settings AnalyzerOptions, options *Options) (*Analyzer, error) {
+	options = withDefaults(options)
+
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := options.validate("NewAnalyzer"); err != nil {
+		return nil, err
+	}
+	size := settings.WindowSize
+	if size < 0 || size == 1 || size&(size-1) != 0 {
+		return nil, &OptionError{"settings.WindowSize", size, "must be a power of two larger than 1"}
+	}
+	if settings.HopSize < 0 {
+		return nil, &OptionError{"settings.HopSize", settings.HopSize, "must be non-negative"}
+	}
+	if !validWindowFunction(settings.WindowFunction) {
+		return nil, &OptionError{
+			"settings.WindowFunction", settings.WindowFunction, `must be "hann", "hamming", "blackman" or "rectangular"`,
+		}
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		size = defaultWindowSize
+	}
+	hop := settings.HopSize
+	if hop == 0 {
+		hop = size / 2
+	}
+
+	analyzer := &Analyzer{
+		samplerate: samplerate,
+		channels:   channels,
+		format:     format,
+		size:       size,
+		hop:        hop,
+		window:     windowFunction(settings.WindowFunction, size),
+		decibels:   settings.Decibels,
+		perchannel: settings.PerChannel,
+		fft:        newFFT(size),
+	}
+
+	analyzed := 1
+	if analyzer.perchannel {
+		analyzed = channels
+	}
+	analyzer.pending = make([][]float64, analyzed)
+	for i := range analyzer.pending {
+		analyzer.pending[i] = make([]float64, 0, size)
+	}
+
+	return analyzer, nil
+}
+
+// Adds the samples to the analyzer and returns the spectrum of every window completed by them,
+// ordered by time and channel. Byte slices hold samples in the format of the analyzer, while
+// other sample slices are read in the format matching their type. Samples that do not complete
+// a window are kept until the next call.
+func (analyzer *Analyzer) Process(samples interface{}) ([]Spectrum, error) {
+	buffer := samplesToBytes(samples)
+	if buffer == nil {
+		return nil, fmt.Errorf("invalid sample data type")
+	}
+	format := analyzer.format
+	if typed := sampleFormat(samples); typed != "" {
+		format = typed
+	}
+
+	codec := newSampleCodec(format)
+	frame := codec.size * analyzer.channels
+	if len(buffer)%frame != 0 {
+		return nil, fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", frame)
+	}
+
+	analyzer.mutex.Lock()
+	defer analyzer.mutex.Unlock()
+
+	var spectra []Spectrum
+	for i := 0; i < len(buffer); i += frame {
+		if analyzer.skip > 0 {
+			analyzer.skip--
+			analyzer.start++
+			continue
+		}
+
+		if analyzer.perchannel {
+			for channel := range analyzer.pending {
+				value := codec.decode(buffer[i+channel*codec.size:])
+				analyzer.pending[channel] = append(analyzer.pending[channel], value)
+			}
+		} else {
+			sum := 0.0
+			for channel := 0; channel < analyzer.channels; channel++ {
+				sum += codec.decode(buffer[i+channel*codec.size:])
+			}
+			analyzer.pending[0] = append(analyzer.pending[0], sum/float64(analyzer.channels))
+		}
+
+		if len(analyzer.pending[0]) == analyzer.size {
+			spectra = analyzer.complete(spectra)
+		}
+	}
+
+	return spectra, nil
+}
+
+// Appends the spectra of the full window to the given spectra and moves on to the next window.
+// Must be called with the mutex held.
+func (analyzer *Analyzer) complete(spectra []Spectrum) []Spectrum {
+	pts := time.Duration(float64(analyzer.start) / float64(analyzer.samplerate) * float64(time.Second))
+	for channel, pending := range analyzer.pending {
+		spectrum := Spectrum{Channel: -1, PTS: pts, Magnitudes: analyzer.magnitudes(pending)}
+		if analyzer.perchannel {
+			spectrum.Channel = channel
+		}
+		spectra = append(spectra, spectrum)
+
+		if analyzer.hop < analyzer.size {
+			analyzer.pending[channel] = pending[:copy(pending, pending[analyzer.hop:])]
+		} else {
+			analyzer.pending[channel] = pending[:0]
+		}
+	}
+
+	if analyzer.hop < analyzer.size {
+		analyzer.start += analyzer.hop
+	} else {
+		analyzer.start += analyzer.size
+		analyzer.skip = analyzer.hop - analyzer.size
+	}
+	return spectra
+}
+
+// Returns the magnitudes of the frequencies in the window of samples.
+func (analyzer *Analyzer) magnitudes(samples []float64) []float64 {
+	re := make([]float64, analyzer.size)
+	im := make([]float64, analyzer.size)
+	for i, value := range samples {
+		re[i] = value * analyzer.window[i]
+	}
+	analyzer.fft.transform(re, im)
+
+	magnitudes := make([]float64, analyzer.Bins())
+	for bin := range magnitudes {
+		magnitude := math.Hypot(re[bin], im[bin])
+		// Bins other than 0 Hz and half the sample rate also hold the energy of the negative frequencies.
+		if bin != 0 && bin != analyzer.size/2 {
+			magnitude *= 2
+		}
+		if analyzer.decibels {
+			magnitude = 20 * math.Log10(magnitude)
+		}
+		magnitudes[bin] = magnitude
+	}
+	return magnitudes
+}
+
+// Returns true if the Analyzer supports the window function with the given name.
+func validWindowFunction(name string) bool {
+	switch name {
+	case "", "hann", "hamming", "blackman", "rectangular":
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the window function with the given name, scaled by its sum so that the magnitude
+// of a sine is independent of the window function and size.
+func windowFunction(name string, size int) []float64 {
+	window := make([]float64, size)
+	sum := 0.0
+	for i := range window {
+		x := 2 * math.Pi * float64(i) / float64(size)
+		switch name {
+		case "hamming":
+			window[i] = 0.54 - 0.46*math.Cos(x)
+		case "blackman":
+			window[i] = 0.42 - 0.5*math.Cos(x) + 0.08*math.Cos(2*x)
+		case "rectangular":
+			window[i] = 1
+		default:
+			window[i] = 0.5 - 0.5*math.Cos(x)
+		}
+		sum += window[i]
+	}
+	for i := range window {
+		window[i] /= sum
+	}
+	return window
+}
+
+// Radix-2 fast Fourier transform of a fixed size.
+type fft struct {
+	size     int       // Number of samples, a power of two.
+	reversed []int     // Index of each sample after the bit reversal permutation.
+	cos, sin []float64 // Twiddle factors for the first half of the size.
+}
+
+func newFFT(size int) *fft {
+	transform := &fft{
+		size:     size,
+		reversed: make([]int, size),
+		cos:      make([]float64, size/2),
+		sin:      make([]float64, size/2),
+	}
+	shift := 64 - bits.TrailingZeros(uint(size))
+	for i := range transform.reversed {
+		transform.reversed[i] = int(bits.Reverse64(uint64(i)) >> shift)
+	}
+	for i := range transform.cos {
+		angle := -2 * math.Pi * float64(i) / float64(size)
+		transform.cos[i], transform.sin[i] = math.Cos(angle), math.Sin(angle)
+	}
+	return transform
+}
+
+// Transforms the complex values in place.
+func (transform *fft) transform(re, im []float64) {
+	for i, j := range transform.reversed {
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+
+	for length := 2; length <= transform.size; length *= 2 {
+		half := length / 2
+		step := transform.size / length
+		for start := 0; start < transform.size; start += length {
+			for k := 0; k < half; k++ {
+				c, s := transform.cos[k*step], transform.sin[k*step]
+				i, j := start+k, start+k+half
+				tr := re[j]*c - im[j]*s
+				ti := re[j]*s + im[j]*c
+				re[j], im[j] = re[i]-tr, im[i]-ti
+				re[i], im[i] = re[i]+tr, im[i]+ti
+			}
+		}
+	}
+}
@@ -1,14 +1,16 @@
 package aio
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
-	"os"
 	"os/exec"
-	"os/signal"
-	"regexp"
-	"syscall"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Audio struct {
@@ -19,14 +21,44 @@ type Audio struct {
 	bps        int               // Bits per sample.
 	stream     int               // Stream Index.
 	duration   float64           // Duration of audio in seconds.
+	starttime  float64           // Start time of the stream in seconds.
+	align      bool              // Flag storing whether the decoded audio is aligned to time zero of the file.
 	format     string            // Format of audio samples.
 	codec      string            // Codec used for video encoding.
 	ended      bool              // Flag storing whether Audio reading has ended.
 	hasstreams bool              // Flag storing whether file has additional data streams.
 	buffer     []byte            // Raw audio data.
 	metadata   map[string]string // Audio Metadata.
+	known      map[string]bool   // Metadata fields with a known value.
+	loglevel   string            // ffmpeg log level when logging is enabled.
+	nice       int               // Niceness of the ffmpeg process.
+	threads    int               // Number of threads used for decoding, 0 for the ffmpeg default.
+	reverse    bool              // Flag storing whether the audio is read from the end to the start.
+	filter     string            // ffmpeg audio filter graph applied while reading.
+	ignore     bool              // Flag storing whether decoding continues past damaged packets.
+	errors     *errorCounter     // Errors reported by ffmpeg while decoding, nil unless errors are ignored.
+	log        *ffmpegLog        // Latest output of the ffmpeg process, explaining why it failed.
+	trim       bool              // Flag storing whether silence is trimmed from the start and end.
+	threshold  float64           // Level in dBFS below which audio is trimmed as silence.
+	minsound   time.Duration     // Minimum duration of sound that ends trimmed silence.
+	trimmer    *silenceTrimmer   // Trims silence from the decoded audio, nil unless silence is trimmed.
+	limits     probeLimits       // Limits of how much of the input is read to find its streams.
+	wav        *wavFile          // Layout of the WAV file if it is read without ffmpeg, nil otherwise.
+	raw        []string          // ffmpeg options describing the samples of a file without a header, nil otherwise.
+	stdin      *stdinInput       // Input if the audio is read from stdin, nil otherwise.
+	position   int               // Number of frames read so far.
+	metrics    *tally            // Counters of the audio read, returned by Metrics.
+	ctx        context.Context   // Context that stops reading once it is done.
+	begin      float64           // Position in seconds in the file at which the audio starts.
+	limit      float64           // Maximum number of seconds read from begin, 0 to read to the end.
+	seek       float64           // Position in seconds reading starts from, set by Seek.
+	length     int               // Length of the buffer before it is shortened for the last audio.
+	err        error             // Error of the ffmpeg process, returned once all audio has been read.
+	mutex      sync.Mutex        // Mutex guarding the process and buffer against concurrent calls to Close.
 	pipe       io.ReadCloser     // Stdout pipe for ffmpeg process.
 	cmd        *exec.Cmd         // ffmpeg command.
+	handle     *processHandle    // Handle of the running ffmpeg process, nil until it is started.
+	stack      []byte            // Stack of the constructor call, captured if leak detection is enabled.
 }
 
 func (audio *Audio) FileName() string {
@@ -51,24 +83,118 @@ func (audio *Audio) BitsPerSample() int {
 	return audio.bps
 }
 
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (audio *Audio) BytesPerFrame() int {
+	return audio.bps / 8 * audio.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (audio *Audio) SamplesPerFrame() int {
+	return audio.channels
+}
+
+// Number of bytes in one second of audio.
+func (audio *Audio) BytesPerSecond() int {
+	return audio.samplerate * audio.BytesPerFrame()
+}
+
 // Returns the zero-indexed audio stream index.
 func (audio *Audio) Stream() int {
 	return audio.stream
 }
 
-// Returns the total number of audio samples in the file in bytes.
+// Returns the total number of audio samples in the file in bytes. With Options.TrimSilence,
+// this includes the silence that is trimmed.
 func (audio *Audio) Total() int {
-	frame := audio.channels * audio.bps / 8
-	second := audio.samplerate * frame
+	frame := audio.BytesPerFrame()
+	second := audio.BytesPerSecond()
 	total := int(math.Ceil(float64(second) * audio.duration))
 	return total + (frame-total%frame)%frame
 }
 
-// Audio Duration in seconds.
+// Audio Duration in seconds. With Options.TrimSilence, this includes the silence that is trimmed.
 func (audio *Audio) Duration() float64 {
 	return audio.duration
 }
 
+// Time of the first sample of the stream in seconds, from ffprobe. Streams in video containers
+// often start after, or shortly before, time zero of the file. 0 if the start time is unknown.
+func (audio *Audio) StartTime() float64 {
+	return audio.starttime
+}
+
+// Returns true if the decoded audio is padded or trimmed to start at time zero of the file.
+func (audio *Audio) AlignStart() bool {
+	return audio.align
+}
+
+// Returns the number of seconds of audio that have been read. For reversed audio, this counts
+// forward from the end of the file.
+func (audio *Audio) Position() float64 {
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+	return float64(audio.position) / float64(audio.samplerate)
+}
+
+// Returns true if the audio is read from the end to the start.
+func (audio *Audio) Reverse() bool {
+	return audio.reverse
+}
+
+// ffmpeg audio filter graph applied while reading.
+func (audio *Audio) Filter() string {
+	return audio.filter
+}
+
+// Returns true if decoding continues past damaged packets instead of stopping.
+func (audio *Audio) IgnoreErrors() bool {
+	return audio.ignore
+}
+
+// Returns true if silence is trimmed from the start and end of the audio.
+func (audio *Audio) TrimSilence() bool {
+	return audio.trim
+}
+
+// Returns the number of seconds of silence trimmed from the start of the audio, which is
+// known once the first buffer has been read.
+func (audio *Audio) TrimmedStart() float64 {
+	audio.mutex.Lock()
+	trimmer := audio.trimmer
+	audio.mutex.Unlock()
+	start, _ := trimmer.trimmed()
+	return float64(start) / float64(audio.samplerate)
+}
+
+// Returns the number of seconds of silence trimmed from the end of the audio, which is known
+// once all audio has been read.
+func (audio *Audio) TrimmedEnd() float64 {
+	audio.mutex.Lock()
+	trimmer := audio.trimmer
+	audio.mutex.Unlock()
+	_, end := trimmer.trimmed()
+	return float64(end) / float64(audio.samplerate)
+}
+
+// Returns the number of errors ffmpeg has reported while decoding so far, e.g. for damaged
+// packets it skipped or concealed. Errors are only counted with Options.IgnoreErrors.
+func (audio *Audio) DecodeErrors() int {
+	audio.mutex.Lock()
+	errors := audio.errors
+	audio.mutex.Unlock()
+	return errors.Count()
+}
+
+// Returns a snapshot of the audio read so far. Seeking does not reset the metrics.
+func (audio *Audio) Metrics() Metrics {
+	return audio.metrics.snapshot()
+}
+
+// Sets all metrics to zero.
+func (audio *Audio) ResetMetrics() {
+	audio.metrics.reset()
+}
+
 func (audio *Audio) Format() string {
 	switch audio.format {
 	case "u8", "s8":
@@ -88,6 +214,8 @@ func (audio *Audio) HasStreams() bool {
 }
 
 func (audio *Audio) Buffer() []byte {
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
 	return audio.buffer
 }
 
@@ -96,27 +224,63 @@ func (audio *Audio) MetaData() map[string]string {
 	return audio.metadata
 }
 
-// Casts the values in the byte buffer to those specified by the audio format.
+// Returns true if the value of the ffprobe metadata field (e.g. "sample_rate", "channels",
+// "bit_rate", "duration" or "codec_name") is known. Fields that ffprobe reports as "N/A" or
+// does not report at all are unknown, and their accessors return 0 or "".
+func (audio *Audio) Known(field string) bool {
+	return audio.known[field]
+}
+
+// Casts the values in the byte buffer to those specified by the audio format. The samples
+// share memory with the buffer, so they are overwritten by the next call to Read and must be
+// copied to be kept, and changing them changes the buffer. Samples in the opposite byte order
+// of the machine, or in a misaligned buffer set with SetBuffer, are a copy instead.
 func (audio *Audio) Samples() interface{} {
-	return bytesToSamples(audio.buffer, len(audio.buffer)/(audio.bps/8), audio.format)
+	buffer := audio.Buffer()
+	return bytesToSamples(buffer, len(buffer)/(audio.bps/8), audio.format)
+}
+
+// Returns the samples of the buffer as float32 values in the range [-1, 1], whatever the format.
+// Integer samples are scaled like ConvertSamples, so the smallest value of a signed format
+// maps to -1.
+func (audio *Audio) SamplesFloat32() []float32 {
+	return audio.SamplesFloat32Into(nil)
+}
+
+// Returns the samples of the buffer like SamplesFloat32, reusing the destination slice if it has
+// enough capacity, so that no memory is allocated when reading in a loop.
+func (audio *Audio) SamplesFloat32Into(dst []float32) []float32 {
+	return decodeFloat32(dst, audio.Buffer(), audio.format)
 }
 
 // Sets the buffer to the given byte array. The length of the buffer must be a multiple
 // of (bytes per sample * audio channels).
 func (audio *Audio) SetBuffer(buffer []byte) error {
-	if len(buffer)%(audio.bps/8*audio.channels) != 0 {
-		return fmt.Errorf("buffer size must be multiple of %d", audio.bps/8*audio.channels)
+	if len(buffer)%audio.BytesPerFrame() != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", audio.BytesPerFrame())
 	}
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
 	audio.buffer = buffer
+	audio.length = len(buffer)
 	return nil
 }
 
 func NewAudio(filename string, options *Options) (*Audio, error) {
-	if options == nil {
-		options = &Options{}
+	return NewAudioContext(context.Background(), filename, options)
+}
+
+// Same as NewAudio, but probing the file and decoding the audio stop once the context is done.
+// The ffmpeg process is killed, and Read returns false, while ReadFrame returns the error of
+// the context.
+func NewAudioContext(ctx context.Context, filename string, options *Options) (*Audio, error) {
+	options = withDefaults(options)
+
+	if err := options.validate("NewAudio"); err != nil {
+		return nil, err
 	}
 
-	streams, err := NewAudioStreams(filename, options)
+	streams, err := NewAudioStreamsContext(ctx, filename, options)
 	if streams == nil {
 		return nil, err
 	}
@@ -130,118 +294,308 @@ func NewAudio(filename string, options *Options) (*Audio, error) {
 
 // Read all audio streams from the given file.
 func NewAudioStreams(filename string, options *Options) ([]*Audio, error) {
-	if !exists(filename) {
-		return nil, fmt.Errorf("video file %s does not exist", filename)
-	}
-	// Check if ffmpeg and ffprobe are installed on the users machine.
-	if err := installed("ffmpeg"); err != nil {
-		return nil, err
+	return NewAudioStreamsContext(context.Background(), filename, options)
+}
+
+// Same as NewAudioStreams, but probing the file and decoding the audio of every stream stop
+// once the context is done.
+func NewAudioStreamsContext(ctx context.Context, filename string, options *Options) ([]*Audio, error) {
+	options = withDefaults(options)
+
+	if filename == "" {
+		return nil, &OptionError{"filename", `""`, "must not be empty"}
 	}
-	if err := installed("ffprobe"); err != nil {
+	if err := options.validate("NewAudioStreams"); err != nil {
 		return nil, err
 	}
 
-	audioData, err := ffprobe(filename, "a")
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	if len(audioData) == 0 {
-		return nil, fmt.Errorf("no audio data found in %s", filename)
+	// Audio piped to stdin is always probed and decoded with ffmpeg.
+	fromStdin := isStdin(filename)
+	if !fromStdin && !exists(filename) {
+		return nil, fmt.Errorf("video file %s does not exist", filename)
 	}
 
-	if options == nil {
-		options = &Options{}
+	// WAV files with PCM or floating point samples are read without ffmpeg,
+	// unless they have to be resampled, remixed, reversed or filtered.
+	var wav *wavFile
+	var err error
+	if !fromStdin {
+		if wav, err = parseWAV(filename); err != nil {
+			return nil, err
+		}
+	}
+	if wav != nil && (options.SampleRate != 0 && options.SampleRate != wav.samplerate ||
+		options.Channels != 0 && options.Channels != wav.channels || options.Reverse || options.Filter != "") {
+		wav = nil
 	}
 
-	var format string
-	if options.Format == "" {
-		format = createFormat("s16") // s16 default format.
+	var probe *ProbeResult
+	var input *stdinInput
+	limits := newProbeLimits(options)
+	if wav != nil {
+		probe = newProbeResult([]map[string]string{wav.metadata()}, map[string]string{})
 	} else {
-		format = createFormat(options.Format)
+		// Check if ffmpeg and ffprobe are installed on the users machine.
+		if err := installed("ffmpeg"); err != nil {
+			return nil, err
+		}
+		if options.Reverse {
+			if err := checkFilters("areverse"); err != nil {
+				return nil, err
+			}
+		}
+		if options.Filter != "" {
+			if err := checkFilters(options.Filter); err != nil {
+				return nil, err
+			}
+		}
+		// Without ffprobe, the information is read from the ffmpeg output instead.
+		run := ffprobe
+		if installed("ffprobe") != nil {
+			run = ffmpegProbe
+		}
+
+		if fromStdin {
+			var prefix []byte
+			if input, prefix, err = openStdin(); err != nil {
+				return nil, err
+			}
+			probe, err = run(ctx, filename, bytes.NewReader(prefix), limits)
+		} else {
+			probe, err = run(ctx, filename, nil, limits)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if err := checkFormat(format); err != nil {
-		return nil, err
+	// Audio streams are used for reading, any other stream such as video, subtitles,
+	// data or attachments only sets the hasstreams flag.
+	audioStreams := probe.AudioStreams()
+	hasstream := len(audioStreams) < len(probe.Streams)
+
+	if len(audioStreams) == 0 {
+		return nil, fmt.Errorf("no audio data found in %s", filename)
 	}
 
-	bps := int(parse(regexp.MustCompile(`\d{1,2}`).FindString(format))) // Bits per sample.
+	format := "s16" // s16 default format.
+	if options.Format != "" {
+		format = options.Format
+	}
+	if format, err = orderFormat(format, options.Endianness); err != nil {
+		return nil, err
+	}
 
-	// Loop over all stream types. v: Video, s: Subtitle, d: Data, t: Attachments
-	hasstream := false
-	for _, c := range "vsdt" {
-		data, err := ffprobe(filename, string(c))
+	stack := creationStack()
+	streams := make([]*Audio, len(audioStreams))
+	for i, stream := range audioStreams {
+		audio, err := newAudioStream(filename, i, stream.MetaData, format, options)
 		if err != nil {
 			return nil, err
 		}
-		if len(data) > 0 {
-			hasstream = true
-			break
-		}
+		audio.ctx = ctx
+		audio.hasstreams = hasstream
+		audio.limits = limits
+		audio.wav = wav
+		audio.stdin = input
+		audio.stack = stack
+		streams[i] = audio
 	}
 
-	streams := make([]*Audio, len(audioData))
-	for i, data := range audioData {
-		audio := &Audio{
-			filename:   filename,
-			format:     format,
-			bps:        bps,
-			stream:     i,
-			hasstreams: hasstream,
-			metadata:   data,
-		}
+	return streams, nil
+}
 
-		audio.addAudioData(data)
+// Creates the Audio struct reading the stream with the given index and ffprobe metadata,
+// decoding its samples to the given format.
+func newAudioStream(filename string, index int, data map[string]string, format string, options *Options) (*Audio, error) {
+	audio := &Audio{
+		filename:  filename,
+		format:    format,
+		bps:       newSampleCodec(format).size * 8,
+		stream:    index,
+		metadata:  data,
+		known:     make(map[string]bool),
+		loglevel:  options.LogLevel,
+		nice:      options.Nice,
+		threads:   options.Threads,
+		reverse:   options.Reverse,
+		filter:    options.Filter,
+		align:     options.AlignStart,
+		ignore:    options.IgnoreErrors,
+		trim:      options.TrimSilence,
+		threshold: options.SilenceThreshold,
+		minsound:  options.SilenceDuration,
+		metrics:   &tally{},
+		ctx:       context.Background(),
+	}
+	runtime.SetFinalizer(audio, (*Audio).leaked)
 
-		if options.SampleRate != 0 {
-			audio.samplerate = options.SampleRate
-		}
+	audio.addAudioData(data)
 
-		if options.Channels != 0 {
-			audio.channels = options.Channels
+	// Only the segment from Options.StartTime is read, for at most Options.Duration seconds.
+	if options.StartTime > 0 && audio.known["duration"] && options.StartTime >= audio.duration {
+		return nil, fmt.Errorf(
+			"start time %v is past the end of audio stream %d in %s, which is %v seconds long",
+			options.StartTime, index, filename, audio.duration,
+		)
+	}
+	audio.begin = options.StartTime
+	audio.limit = options.Duration
+	if audio.known["duration"] {
+		audio.duration -= options.StartTime
+		if options.Duration > 0 && options.Duration < audio.duration {
+			audio.duration = options.Duration
 		}
+	}
 
-		streams[i] = audio
+	if options.SampleRate != 0 {
+		audio.samplerate = options.SampleRate
+		audio.known["sample_rate"] = true
 	}
 
-	return streams, nil
+	if options.Channels != 0 {
+		audio.channels = options.Channels
+		audio.known["channels"] = true
+	}
+
+	// Audio cannot be read without a sample rate and number of channels.
+	if !audio.known["sample_rate"] || audio.samplerate <= 0 {
+		return nil, fmt.Errorf("sample rate of audio stream %d in %s is unknown", index, filename)
+	}
+	if !audio.known["channels"] || audio.channels <= 0 {
+		return nil, fmt.Errorf("number of channels of audio stream %d in %s is unknown", index, filename)
+	}
+	return audio, nil
 }
 
 // Adds audio data to the Audio struct from the ffprobe output.
+// Values that cannot be parsed, such as "N/A", are left at zero and marked as unknown.
 func (audio *Audio) addAudioData(data map[string]string) {
-	if samplerate, ok := data["sample_rate"]; ok {
-		audio.samplerate = int(parse(samplerate))
+	if samplerate, ok := parseValue(data["sample_rate"]); ok {
+		audio.samplerate = int(samplerate)
+		audio.known["sample_rate"] = true
+	}
+	if channels, ok := parseValue(data["channels"]); ok {
+		audio.channels = int(channels)
+		audio.known["channels"] = true
 	}
-	if channels, ok := data["channels"]; ok {
-		audio.channels = int(parse(channels))
+	if bitrate, ok := parseValue(data["bit_rate"]); ok {
+		audio.bitrate = int(bitrate)
+		audio.known["bit_rate"] = true
 	}
-	if bitrate, ok := data["bit_rate"]; ok {
-		audio.bitrate = int(parse(bitrate))
+	if duration, ok := parseValue(data["duration"]); ok {
+		audio.duration = duration
+		audio.known["duration"] = true
 	}
-	if duration, ok := data["duration"]; ok {
-		audio.duration = float64(parse(duration))
+	if start, ok := parseValue(data["start_time"]); ok {
+		audio.starttime = start
+		audio.known["start_time"] = true
 	}
-	if codec, ok := data["codec_name"]; ok {
+	if codec, ok := data["codec_name"]; ok && codec != "" && codec != "N/A" {
 		audio.codec = codec
+		audio.known["codec_name"] = true
 	}
 }
 
 // Once the user calls Read() for the first time on a Audio struct,
 // the ffmpeg command which is used to read the audio is started.
 func (audio *Audio) init() error {
-	// If user exits with Ctrl+C, stop ffmpeg process.
-	audio.cleanup()
+	if audio.buffer == nil {
+		audio.buffer = make([]byte, audio.BytesPerSecond())
+	}
+	audio.length = len(audio.buffer)
+
+	// WAV files are read directly, converting the samples to the requested format.
+	if audio.wav != nil {
+		start := int64(math.Round(audio.begin*float64(audio.samplerate))) + int64(audio.position)
+		count := int64(-1)
+		if audio.limit > 0 {
+			count = int64(math.Round(audio.limit*float64(audio.samplerate))) - int64(audio.position)
+		}
+		pipe, err := audio.wav.open(audio.filename, audio.format, start, count)
+		if err != nil {
+			return err
+		}
+		audio.pipe = audio.trimmed(pipe)
+		return nil
+	}
+
+	// Audio from stdin is passed on to ffmpeg, starting with the bytes read while probing.
+	filename := localInput(audio.filename)
+	var input io.Reader
+	if audio.stdin != nil {
+		reader, err := audio.stdin.claim()
+		if err != nil {
+			return err
+		}
+		filename, input = "pipe:0", reader
+	}
+
 	// ffmpeg command to pipe audio data to stdout.
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", audio.filename,
+	command := append(audio.limits.args(), audio.raw...)
+	command = append(
+		command,
+		"-i", filename,
 		"-f", audio.format,
 		"-ar", fmt.Sprintf("%d", audio.samplerate),
 		"-ac", fmt.Sprintf("%d", audio.channels),
 		"-map", fmt.Sprintf("0:a:%d", audio.stream),
-		"-loglevel", "quiet",
-		"-",
 	)
+	// The latest output is kept, so that the error of a failed decode can tell why it failed.
+	audio.log = &ffmpegLog{limit: maxErrorLog}
+	var stderr io.Writer = audio.log
+	if audio.ignore {
+		// Damaged packets are dropped or concealed. Every log line is prefixed with its level,
+		// so that the errors can be counted.
+		command = append([]string{"-err_detect", "ignore_err", "-fflags", "+discardcorrupt"}, command...)
+		command = append(command, "-loglevel", "repeat+level+"+logLevel(audio.loglevel, "warning"))
+		audio.errors = &errorCounter{}
+		stderr = io.MultiWriter(audio.errors, audio.log)
+	} else {
+		command = append(command, "-loglevel", logLevel(audio.loglevel, "error"))
+	}
+	if audio.threads > 0 {
+		command = append([]string{"-threads", fmt.Sprintf("%d", audio.threads)}, command...)
+	}
+	// Only the segment from the seek position is decoded. Reversed audio is decoded up to the
+	// position counted from the end, and then reversed.
+	start, length, limited := audio.begin, audio.limit, audio.limit > 0
+	if audio.reverse && audio.seek > 0 {
+		length, limited = audio.duration-audio.seek, true
+	} else if !audio.reverse {
+		start += audio.seek
+		length -= audio.seek
+	}
+	if start > 0 {
+		command = append([]string{"-ss", fmt.Sprintf("%f", start)}, command...)
+	}
+	if limited {
+		command = append([]string{"-t", fmt.Sprintf("%f", math.Max(length, 0))}, command...)
+	}
+	filters := []string{}
+	// Timestamps are kept, so that silence is added before a stream starting after time zero,
+	// and audio before time zero is dropped. After a seek, the audio starts at the seek position.
+	if audio.align {
+		command = append([]string{"-copyts"}, command...)
+		first := int(math.Round(start * float64(audio.samplerate)))
+		filters = append(filters, fmt.Sprintf("aresample=async=1:first_pts=%d", first))
+	}
+	if audio.filter != "" {
+		filters = append(filters, audio.filter)
+	}
+	// The areverse filter keeps the whole decoded stream in memory before writing any audio.
+	if audio.reverse {
+		filters = append(filters, "areverse")
+	}
+	if len(filters) > 0 {
+		command = append(command, "-af", strings.Join(filters, ","))
+	}
+	cmd := exec.CommandContext(audio.ctx, "ffmpeg", append(command, "-")...)
+	cmd.Stderr = logOutput(cmd, audio.loglevel, stderr)
 
 	audio.cmd = cmd
 
@@ -249,70 +603,284 @@ func (audio *Audio) init() error {
 	if err != nil {
 		return err
 	}
-	audio.pipe = pipe
+	audio.pipe = audio.trimmed(pipe)
+
+	// The input is copied on a separate goroutine rather than by the command, so that closing
+	// the audio does not wait for a read from stdin that may never return.
+	var stdin io.WriteCloser
+	if input != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
 
-	if err := cmd.Start(); err != nil {
+	if err := startNice(cmd, audio.nice); err != nil {
 		return err
 	}
+	output := audio.pipe
+	audio.handle = registerHandle(cmd, func() error {
+		output.Close()
+		err := cmd.Wait()
+		unregister(cmd)
+		return err
+	})
 
-	if audio.buffer == nil {
-		audio.buffer = make([]byte, audio.samplerate*audio.channels*audio.bps/8)
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, input)
+			stdin.Close()
+		}()
 	}
 
 	return nil
 }
 
-// Reads the next frame of audio and stores it in the buffer.
-// If the last audio frame has been read, returns false, otherwise true.
-func (audio *Audio) Read() bool {
+// Returns the pipe with silence trimmed from the start and end if Options.TrimSilence is set.
+func (audio *Audio) trimmed(pipe io.ReadCloser) io.ReadCloser {
+	if !audio.trim {
+		return pipe
+	}
+	threshold := audio.threshold
+	if threshold == 0 {
+		threshold = defaultSilenceThreshold
+	}
+	audio.trimmer = newSilenceTrimmer(pipe, audio.format, audio.channels, audio.samplerate, threshold, audio.minsound)
+	return audio.trimmer
+}
+
+// Starts the ffmpeg process if it has not been started and the audio has not been closed.
+// Returns the pipe and buffer to read into, or a nil pipe if the audio has been closed.
+func (audio *Audio) start() (io.ReadCloser, []byte, error) {
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+
 	if audio.ended {
-		return false
+		return nil, nil, nil
+	}
+	// Reading stops once the context is done, also for WAV files read without ffmpeg.
+	if err := audio.ctx.Err(); err != nil {
+		audio.close()
+		audio.err = err
+		return nil, nil, nil
 	}
 
-	// If cmd is nil, audio reading has not been initialized.
-	if audio.cmd == nil {
+	// If pipe is nil, audio reading has not been initialized.
+	if audio.pipe == nil {
+		// If the process could not be started, there is nothing to read and the audio ends with
+		// the error. The pipe may already be set, and must not be read by the next Read.
 		if err := audio.init(); err != nil {
-			return false
+			audio.close()
+			audio.pipe = nil
+			audio.cmd = nil
+			audio.trimmer = nil
+			audio.err = audio.failure(err)
+			return nil, nil, nil
 		}
 	}
 
-	n, err := io.ReadFull(audio.pipe, audio.buffer)
+	return audio.pipe, audio.buffer, nil
+}
+
+// Reads the next frame of audio and stores it in the buffer.
+// If the last audio frame has been read, returns false, otherwise true.
+// Safe to call while another goroutine calls Close, in which case Read returns false.
+func (audio *Audio) Read() bool {
+	buffer, _, err := audio.read(false)
+	return err == nil && len(buffer) > 0
+}
+
+// Reads the next frame of audio into a new buffer owned by the caller, along with the time
+// of its first sample from the start of the audio. Once all audio has been read, or the audio
+// has been closed, returns io.EOF. If ffmpeg failed to decode the audio, returns its error
+// after the last frame instead. Can be used together with Read, which shares the same position.
+func (audio *Audio) ReadFrame() (*Frame, error) {
+	buffer, pts, err := audio.read(true)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Data: buffer, Samples: len(buffer) / audio.BytesPerFrame(), PTS: pts}, nil
+}
+
+// Reads the next frame of audio into the audio buffer, or a new buffer of the same size if owned
+// is true. Returns the buffer shortened to the audio read and the timestamp of its first sample.
+func (audio *Audio) read(owned bool) ([]byte, time.Duration, error) {
+	pipe, buffer, err := audio.start()
+	if err != nil {
+		return nil, 0, err
+	}
+	if pipe == nil {
+		audio.mutex.Lock()
+		defer audio.mutex.Unlock()
+		return nil, 0, audio.end()
+	}
+	if owned {
+		buffer = make([]byte, len(buffer))
+	}
+
+	// The mutex is not held while reading, so that Close can unblock the read by closing the pipe.
+	started := time.Now()
+	n, err := io.ReadFull(pipe, buffer)
+
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+
+	// The process may have been stopped by CloseAll.
+	if audio.ended || audio.handle.closed() {
+		audio.ended = true
+		return nil, 0, io.EOF
+	}
+	audio.metrics.transfer(n, audio.BytesPerFrame(), started)
+
+	pts := time.Duration(float64(audio.position) / float64(audio.samplerate) * float64(time.Second))
+	audio.position += n / audio.BytesPerFrame()
 
 	if err != nil {
 		// When the user reaches the end of the audio stream, the buffer will have to be shortened
 		// such that the audio stream is accurately represented.
 		// The rest of this sliced array is not garbage collected.
-		audio.buffer = audio.buffer[:n]
-		audio.Close()
+		buffer = buffer[:n]
+		if !owned {
+			audio.buffer = buffer
+		}
+		if n > 0 {
+			audio.metrics.shortBuffer()
+		}
+		if audio.cmd != nil {
+			logEvent(audio.cmd, "reached the end of the audio")
+		}
+		// ffmpeg is killed once the context is done.
+		if err := audio.close(); audio.ctx.Err() != nil {
+			audio.err = audio.ctx.Err()
+		} else if err != nil {
+			audio.err = audio.failure(err)
+			audio.metrics.failed()
+		}
+		if n == 0 {
+			return nil, 0, audio.end()
+		}
 	}
 
-	return n > 0
+	return buffer, pts, nil
 }
 
-// Closes the pipe and stops the ffmpeg process.
+// Moves the position to the given number of seconds from the start of the audio, so that the
+// next Read returns the audio from there, or for reversed audio, from that many seconds before
+// the end. Restarts the ffmpeg process with the new position if reading has started, and may
+// also be called after all audio has been read. Returns an error if the position is past the
+// duration of the audio, or if the audio is read from stdin, which cannot be read again. Must
+// not be called while a Read is in progress.
+func (audio *Audio) Seek(seconds float64) error {
+	if seconds < 0 || math.IsNaN(seconds) {
+		return fmt.Errorf("invalid seek position: %v, must be non-negative", seconds)
+	}
+	if audio.stdin != nil {
+		return fmt.Errorf("cannot seek in audio read from stdin")
+	}
+	if seconds > audio.duration && (audio.known["duration"] || audio.reverse) {
+		return fmt.Errorf("seek position %v is past the duration of %v seconds", seconds, audio.duration)
+	}
+
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+
+	// The running process is stopped, and a new one is started by the next Read.
+	if audio.handle != nil {
+		audio.handle.close()
+	} else if audio.pipe != nil {
+		audio.pipe.Close()
+	}
+	// The finalizer is removed once the audio has ended, and may still be set otherwise.
+	runtime.SetFinalizer(audio, nil)
+	runtime.SetFinalizer(audio, (*Audio).leaked)
+	audio.pipe = nil
+	audio.cmd = nil
+	audio.handle = nil
+	audio.trimmer = nil
+	audio.errors = nil
+	audio.err = nil
+	audio.ended = false
+	if audio.length > 0 {
+		audio.buffer = audio.buffer[:audio.length]
+	}
+	audio.seek = seconds
+	audio.position = int(math.Round(seconds * float64(audio.samplerate)))
+	return nil
+}
+
+// Returns the error of the ffmpeg process with the position at which decoding stopped and the
+// last lines ffmpeg logged, e.g. "ffmpeg could not decode talk.mp3 at 32.5s: exit status 1:
+// Invalid data found when processing input". Must be called with the mutex held.
+func (audio *Audio) failure(err error) error {
+	at := time.Duration(float64(audio.position) / float64(audio.samplerate) * float64(time.Second))
+	message := fmt.Sprintf("ffmpeg could not decode %s at %v", audio.filename, at.Round(time.Millisecond))
+	// WAV files read without ffmpeg have no log.
+	if audio.log == nil {
+		return fmt.Errorf("%s: %w", message, err)
+	}
+	if output := strings.TrimSpace(audio.log.String()); output != "" {
+		lines := strings.Split(output, "\n")
+		if len(lines) > 3 {
+			lines = lines[len(lines)-3:]
+		}
+		return fmt.Errorf("%s: %w: %s", message, err, strings.Join(lines, "; "))
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// Returns the error that ended reading the audio once Read has returned false, e.g. because
+// ffmpeg failed to decode it or the context of the audio is done. Returns nil while reading,
+// after all audio has been read, and after Close, so that a failure can be told apart from the
+// end of the audio.
+func (audio *Audio) Error() error {
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+	return audio.err
+}
+
+// Returns the error that ended reading the audio, or io.EOF if all audio has been read.
+// Must be called with the mutex held.
+func (audio *Audio) end() error {
+	if audio.err != nil {
+		return audio.err
+	}
+	return io.EOF
+}
+
+// Closes the pipe and stops the ffmpeg process. Safe to call from any goroutine,
+// and unblocks any Read in progress.
 func (audio *Audio) Close() {
+	audio.mutex.Lock()
+	defer audio.mutex.Unlock()
+	audio.close()
+}
+
+// Closes the audio and returns the error of the ffmpeg process, if any.
+// Must be called with the mutex held.
+func (audio *Audio) close() error {
+	if audio.ended {
+		return nil
+	}
 	audio.ended = true
+	runtime.SetFinalizer(audio, nil)
+	if audio.handle != nil {
+		return audio.handle.close()
+	}
+	// WAV files read without ffmpeg only have a pipe.
 	if audio.pipe != nil {
 		audio.pipe.Close()
 	}
-	if audio.cmd != nil {
-		audio.cmd.Wait()
-	}
+	return nil
 }
 
-// Stops the "cmd" process running when the user presses Ctrl+C.
-// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
-func (audio *Audio) cleanup() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		if audio.pipe != nil {
-			audio.pipe.Close()
-		}
-		if audio.cmd != nil {
-			audio.cmd.Process.Kill()
-		}
-		os.Exit(1)
-	}()
+// Stops the ffmpeg process, or closes the WAV file, if the audio is garbage collected without
+// being closed.
+func (audio *Audio) leaked() {
+	if audio.ended {
+		return
+	}
+	if audio.handle != nil {
+		audio.handle.leaked("Audio of "+audio.filename, audio.stack)
+	} else if audio.pipe != nil {
+		audio.pipe.Close()
+	}
 }
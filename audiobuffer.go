@@ -0,0 +1,230 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Audio held in memory together with its sample rate, channels and format, e.g. a short clip
+// decoded with Audio.ReadAllBuffer. An AudioBuffer never changes once it has been created and
+// has no ffmpeg process, so it can be shared between goroutines.
+type AudioBuffer struct {
+	samplerate int    // Audio Sample Rate in Hz.
+	channels   int    // Number of audio channels.
+	format     string // Format of audio samples.
+	data       []byte // Raw audio data.
+}
+
+// Audio Sample Rate in Hz.
+func (buffer *AudioBuffer) SampleRate() int {
+	return buffer.samplerate
+}
+
+func (buffer *AudioBuffer) Channels() int {
+	return buffer.channels
+}
+
+func (buffer *AudioBuffer) Format() string {
+	switch buffer.format {
+	case "u8", "s8":
+		return buffer.format
+	default:
+		return buffer.format[:len(buffer.format)-2]
+	}
+}
+
+// Bits per sample.
+func (buffer *AudioBuffer) BitsPerSample() int {
+	return newSampleCodec(buffer.format).size * 8
+}
+
+// Number of bytes in one frame of audio.
+func (buffer *AudioBuffer) BytesPerFrame() int {
+	return newSampleCodec(buffer.format).size * buffer.channels
+}
+
+// Number of frames in the buffer.
+func (buffer *AudioBuffer) Frames() int {
+	return len(buffer.data) / buffer.BytesPerFrame()
+}
+
+// Duration of the audio in the buffer.
+func (buffer *AudioBuffer) Duration() time.Duration {
+	return time.Duration(float64(buffer.Frames()) / float64(buffer.samplerate) * float64(time.Second))
+}
+
+// Raw audio data of the buffer. The data must not be modified.
+func (buffer *AudioBuffer) Buffer() []byte {
+	return buffer.data
+}
+
+// Returns a copy of the samples, with the type matching the format of the buffer.
+func (buffer *AudioBuffer) Samples() interface{} {
+	data := make([]byte, len(buffer.data))
+	copy(data, buffer.data)
+	return bytesToSamples(data, len(data)/newSampleCodec(buffer.format).size, buffer.format)
+}
+
+// Creates an audio buffer holding a copy of the samples, which have the given number of channels,
+// sample rate and format. Byte slices hold samples in the given format, while other sample slices
+// must have the type matching the format, unless Options.ConvertSamples is set. 24-bit samples
+// may be given as []int32 or []uint32 like for AudioWriter.Write.
+func NewAudioBuffer(samples interface{}, channels, samplerate int, format string, options *Options) (*AudioBuffer, error) {
+	options = withDefaults(options)
+
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := options.validate("NewAudioBuffer"); err != nil {
+		return nil, err
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+
+	data := samplesToFormat(samples, format)
+	if data == nil {
+		return nil, fmt.Errorf("invalid sample data type")
+	}
+	if packed, ok, err := pack24(samples, format, options.ClampSamples); err != nil {
+		return nil, err
+	} else if ok {
+		data = packed
+	} else if typed := sampleFormat(samples); typed != "" && sampleType(typed) != sampleType(format) {
+		if !options.ConvertSamples {
+			return nil, fmt.Errorf("buffer format %s expects %T, got %T", format, makeSamples(format, 0), samples)
+		}
+		data = convertBuffer(samplesToBytes(samples), typed, format)
+	} else {
+		data = append([]byte{}, data...)
+	}
+
+	frame := newSampleCodec(format).size * channels
+	if len(data)%frame != 0 {
+		return nil, fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", frame)
+	}
+
+	return &AudioBuffer{samplerate: samplerate, channels: channels, format: format, data: data}, nil
+}
+
+// Returns the audio from the start time up to the end time, both rounded down to the nearest
+// frame. An end time past the end of the buffer is clamped to the end. The returned buffer
+// shares memory with this buffer.
+func (buffer *AudioBuffer) Slice(from, to time.Duration) (*AudioBuffer, error) {
+	if from < 0 {
+		return nil, fmt.Errorf("invalid start time: %v, must be non-negative", from)
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid end time: %v, must not be before the start time %v", to, from)
+	}
+
+	frames := buffer.Frames()
+	start := buffer.frameAt(from)
+	end := buffer.frameAt(to)
+	if start > frames {
+		return nil, fmt.Errorf("start time %v is past the end of the buffer at %v", from, buffer.Duration())
+	}
+	if end > frames {
+		end = frames
+	}
+
+	size := buffer.BytesPerFrame()
+	slice := *buffer
+	slice.data = buffer.data[start*size : end*size : end*size]
+	return &slice, nil
+}
+
+// Returns a new buffer with the audio of the other buffer added after the audio of this buffer.
+// Both buffers must have the same sample rate and channels. The other audio is converted to the
+// format of this buffer if the formats differ.
+func (buffer *AudioBuffer) Append(other *AudioBuffer) (*AudioBuffer, error) {
+	if err := buffer.match(other.samplerate, other.channels); err != nil {
+		return nil, err
+	}
+
+	data := other.data
+	if other.format != buffer.format {
+		data = convertBuffer(data, other.format, buffer.format)
+	}
+
+	result := *buffer
+	result.data = make([]byte, 0, len(buffer.data)+len(data))
+	result.data = append(append(result.data, buffer.data...), data...)
+	return &result, nil
+}
+
+// Writes the audio to the writer, which must have the sample rate and channels of the buffer.
+// The audio is converted to the format of the writer if the formats differ.
+func (buffer *AudioBuffer) WriteTo(writer *AudioWriter) error {
+	if err := buffer.match(writer.samplerate, writer.channels); err != nil {
+		return err
+	}
+	return writer.Write(buffer.convert(writer.format))
+}
+
+// Plays the audio with the player, which must have the sample rate and channels of the buffer,
+// blocking until it has been written to ffplay. The audio is converted to the format of the
+// player if the formats differ.
+func (buffer *AudioBuffer) Play(player *Player) error {
+	if err := buffer.match(player.samplerate, player.channels); err != nil {
+		return err
+	}
+	return player.Play(buffer.convert(player.format))
+}
+
+// Returns the index of the frame playing at the given time. Whole seconds and the rest are
+// counted separately, so that long durations do not overflow.
+func (buffer *AudioBuffer) frameAt(t time.Duration) int {
+	rate := time.Duration(buffer.samplerate)
+	return int(t/time.Second*rate + t%time.Second*rate/time.Second)
+}
+
+// Returns an error if the sample rate or channels differ from those of the buffer.
+func (buffer *AudioBuffer) match(samplerate, channels int) error {
+	if samplerate != buffer.samplerate {
+		return fmt.Errorf("sample rate %d Hz does not match the buffer sample rate %d Hz", samplerate, buffer.samplerate)
+	}
+	if channels != buffer.channels {
+		return fmt.Errorf("%d channels do not match the %d channels of the buffer", channels, buffer.channels)
+	}
+	return nil
+}
+
+// Returns the raw audio data in the given format.
+func (buffer *AudioBuffer) convert(format string) []byte {
+	if format == buffer.format {
+		return buffer.data
+	}
+	return convertBuffer(buffer.data, buffer.format, format)
+}
+
+// Reads all remaining audio into an AudioBuffer and closes the audio. Returns the error of
+// ffmpeg if it failed to decode the audio.
+func (audio *Audio) ReadAllBuffer() (*AudioBuffer, error) {
+	defer audio.Close()
+
+	var data []byte
+	for {
+		frame, err := audio.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, frame.Data...)
+	}
+
+	return &AudioBuffer{
+		samplerate: audio.samplerate,
+		channels:   audio.channels,
+		format:     audio.format,
+		data:       data,
+	}, nil
+}
@@ -5,20 +5,50 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type AudioWriter struct {
-	filename   string         // Output filename.
-	streamfile string         // Extra stream data filename.
-	samplerate int            // Audio Sample Rate in Hz.
-	channels   int            // Number of audio channels.
-	bitrate    int            // Bitrate for audio encoding.
-	format     string         // Format of audio samples.
-	codec      string         // Codec used for video encoding.
-	pipe       io.WriteCloser // Stdout pipe of ffmpeg process.
-	cmd        *exec.Cmd      // ffmpeg command.
+	filename   string            // Output filename.
+	streamfile string            // Extra stream data filename.
+	samplerate int               // Audio Sample Rate in Hz.
+	channels   int               // Number of audio channels.
+	bitrate    int               // Bitrate for audio encoding.
+	format     string            // Format of audio samples.
+	codec      string            // Codec used for video encoding.
+	outrate    int               // Sample rate of the encoded audio in Hz.
+	quality    string            // Variable bitrate quality of the encoder.
+	level      int               // Compression level of the encoder.
+	lowlatency bool              // Flag storing whether packets are flushed immediately.
+	maxdelay   time.Duration     // Maximum time the muxer holds encoded packets with LowLatency.
+	chapters   []Chapter         // Chapter markers to write to the output.
+	metafile   string            // Temporary ffmetadata file storing the chapters.
+	id3version int               // ID3v2 version for MP3 output.
+	id3v1      bool              // Flag storing whether an ID3v1 tag is written for MP3 output.
+	metadata   map[string]string // Global metadata tags for the output.
+	copymeta   bool              // Flag storing whether StreamFile metadata is copied.
+	outputs    []OutputSpec      // Additional outputs for the tee muxer.
+	log        *ffmpegLog        // ffmpeg stderr output used to find failed outputs.
+	realtime   bool              // Flag storing whether audio is consumed at playback speed.
+	loglevel   string            // ffmpeg log level when logging is enabled.
+	nice       int               // Niceness of the ffmpeg process.
+	threads    int               // Number of threads used for encoding, 0 for the ffmpeg default.
+	convert    bool              // Flag storing whether samples of another format are converted.
+	clamp      bool              // Flag storing whether 24-bit samples out of range saturate.
+	filter     string            // ffmpeg audio filter graph applied before encoding.
+	progress   *progressParser   // Parser of the encoder statistics, nil unless Options.EncoderProgress is set.
+	metrics    *tally            // Counters of the audio written, returned by Metrics.
+	closed     bool              // Flag storing whether the writer has been closed.
+	mutex      sync.Mutex        // Mutex guarding the process against concurrent calls to Close.
+	pipe       io.WriteCloser    // Stdout pipe of ffmpeg process.
+	cmd        *exec.Cmd         // ffmpeg command.
+	handle     *processHandle    // Handle of the running ffmpeg process, nil until it is started.
+	stack      []byte            // Stack of the constructor call, captured if leak detection is enabled.
 }
 
 func (writer *AudioWriter) FileName() string {
@@ -39,6 +69,25 @@ func (writer *AudioWriter) Channels() int {
 	return writer.channels
 }
 
+func (writer *AudioWriter) BitsPerSample() int {
+	return newSampleCodec(writer.format).size * 8
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (writer *AudioWriter) BytesPerFrame() int {
+	return newSampleCodec(writer.format).size * writer.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (writer *AudioWriter) SamplesPerFrame() int {
+	return writer.channels
+}
+
+// Number of bytes in one second of audio.
+func (writer *AudioWriter) BytesPerSecond() int {
+	return writer.samplerate * writer.BytesPerFrame()
+}
+
 // Audio Bitrate in bits/s.
 func (writer *AudioWriter) Bitrate() int {
 	return writer.bitrate
@@ -53,25 +102,180 @@ func (writer *AudioWriter) Format() string {
 	}
 }
 
+// Encoder of the audio, either Options.Codec or the encoder inferred from the file extension.
+// Empty if ffmpeg chooses the encoder.
 func (writer *AudioWriter) Codec() string {
 	return writer.codec
 }
 
+// Sample rate of the encoded audio in Hz. Differs from the sample rate of the written audio
+// if the encoder does not support it, e.g. 48000 Hz for Opus.
+func (writer *AudioWriter) EncodedSampleRate() int {
+	return writer.outrate
+}
+
+// Chapter markers written to the output file.
+func (writer *AudioWriter) Chapters() []Chapter {
+	return writer.chapters
+}
+
+// ID3v2 version used for MP3 output. 0 means the ffmpeg default is used.
+func (writer *AudioWriter) ID3Version() int {
+	return writer.id3version
+}
+
+// Returns true if an ID3v1 tag is appended to MP3 output.
+func (writer *AudioWriter) WriteID3v1() bool {
+	return writer.id3v1
+}
+
+// Global metadata tags written to the output file.
+func (writer *AudioWriter) MetaData() map[string]string {
+	return writer.metadata
+}
+
+// Returns true if metadata from the StreamFile is copied into the output.
+func (writer *AudioWriter) CopyStreamFileMetadata() bool {
+	return writer.copymeta
+}
+
+// Additional outputs written to alongside the output file.
+func (writer *AudioWriter) Outputs() []OutputSpec {
+	return writer.outputs
+}
+
+// Returns the targets of all outputs that have failed so far.
+// Only outputs with IgnoreFailure set can fail without stopping the writer.
+func (writer *AudioWriter) FailedOutputs() []string {
+	writer.mutex.Lock()
+	log := writer.log
+	writer.mutex.Unlock()
+
+	failed := []string{}
+	if log == nil {
+		return failed
+	}
+	for _, index := range failedOutputs(log.String()) {
+		// Output 0 is the output file, followed by the additional outputs.
+		if index == 0 {
+			failed = append(failed, writer.filename)
+		} else if index <= len(writer.outputs) {
+			failed = append(failed, writer.outputs[index-1].Target)
+		}
+	}
+	return failed
+}
+
+// Returns true if written audio is consumed at playback speed.
+func (writer *AudioWriter) RealTime() bool {
+	return writer.realtime
+}
+
+// Returns true if the writer flushes every encoded packet to the output immediately.
+func (writer *AudioWriter) LowLatency() bool {
+	return writer.lowlatency
+}
+
+// Maximum time the muxer holds encoded packets before writing them with LowLatency.
+func (writer *AudioWriter) MaxDelay() time.Duration {
+	return writer.maxdelay
+}
+
+// Variable bitrate quality of the encoder, e.g. "0" for the highest MP3 quality.
+func (writer *AudioWriter) Quality() string {
+	return writer.quality
+}
+
+// Compression level of the encoder, 0 for the default of the encoder.
+func (writer *AudioWriter) CompressionLevel() int {
+	return writer.level
+}
+
+// ffmpeg audio filter graph applied before encoding.
+func (writer *AudioWriter) Filter() string {
+	return writer.filter
+}
+
+// Returns a snapshot of the audio written so far.
+func (writer *AudioWriter) Metrics() Metrics {
+	return writer.metrics.snapshot()
+}
+
+// Sets all metrics to zero.
+func (writer *AudioWriter) ResetMetrics() {
+	writer.metrics.reset()
+}
+
+// Returns the last encoder statistics reported by ffmpeg, which reports them about twice per
+// second and once more when it has finished encoding. Returns the zero value until the first
+// report, and always unless Options.EncoderProgress is set.
+func (writer *AudioWriter) Progress() EncodeProgress {
+	return writer.progress.progress()
+}
+
+// Sets a callback invoked with the encoder statistics every time ffmpeg reports them, the last
+// time with Done set. The callback runs on the goroutine reading the output of ffmpeg and should
+// return quickly, since ffmpeg blocks until it returns. Has no effect unless
+// Options.EncoderProgress is set.
+func (writer *AudioWriter) OnProgress(callback func(progress EncodeProgress)) {
+	if writer.progress == nil {
+		return
+	}
+	writer.progress.mutex.Lock()
+	defer writer.progress.mutex.Unlock()
+	writer.progress.callback = callback
+}
+
 func NewAudioWriter(filename string, options *Options) (*AudioWriter, error) {
+	options = withDefaults(options)
+
+	if filename == "" {
+		return nil, &OptionError{"filename", `""`, "must not be empty"}
+	}
+	if err := options.validate("NewAudioWriter"); err != nil {
+		return nil, err
+	}
+
 	// Check if ffmpeg is installed on the users machine.
 	if err := installed("ffmpeg"); err != nil {
 		return nil, err
 	}
-
-	if options == nil {
-		options = &Options{}
+	if options.Filter != "" {
+		if err := checkFilters(options.Filter); err != nil {
+			return nil, err
+		}
 	}
 
 	writer := &AudioWriter{
 		filename:   filename,
 		streamfile: options.StreamFile,
 		bitrate:    options.Bitrate,
-		codec:      options.Codec,
+		lowlatency: options.LowLatency,
+		maxdelay:   options.MaxDelay,
+		id3version: options.ID3Version,
+		id3v1:      options.WriteID3v1,
+		metadata:   options.Metadata,
+		copymeta:   options.CopyStreamFileMetadata,
+		outputs:    options.Outputs,
+		realtime:   options.RealTime,
+		loglevel:   options.LogLevel,
+		nice:       options.Nice,
+		threads:    options.Threads,
+		convert:    options.ConvertSamples,
+		clamp:      options.ClampSamples,
+		filter:     options.Filter,
+		quality:    options.Quality,
+		level:      options.CompressionLevel,
+		metrics:    &tally{},
+		stack:      creationStack(),
+	}
+
+	if options.EncoderProgress {
+		writer.progress = &progressParser{}
+	}
+
+	if options.ID3Version != 0 && options.ID3Version != 3 && options.ID3Version != 4 {
+		return nil, fmt.Errorf("invalid ID3 version: %d, must be 3 or 4", options.ID3Version)
 	}
 
 	writer.samplerate = 44100 // 44100 Hz sampling rate by default.
@@ -84,14 +288,15 @@ func NewAudioWriter(filename string, options *Options) (*AudioWriter, error) {
 		writer.channels = options.Channels
 	}
 
-	if options.Format == "" {
-		writer.format = createFormat("s16") // s16 default format.
-	} else {
-		writer.format = createFormat(options.Format)
-		if err := checkFormat(writer.format); err != nil {
-			return nil, err
-		}
+	format := "s16" // s16 default format.
+	if options.Format != "" {
+		format = options.Format
+	}
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
 	}
+	writer.format = format
 
 	if options.StreamFile != "" {
 		if !exists(options.StreamFile) {
@@ -100,29 +305,104 @@ func NewAudioWriter(filename string, options *Options) (*AudioWriter, error) {
 		writer.streamfile = options.StreamFile
 	}
 
+	if options.CopyStreamFileMetadata && options.StreamFile == "" {
+		return nil, fmt.Errorf("copying stream file metadata requires a stream file")
+	}
+
+	if options.Codec != "" {
+		if err := checkEncoder(options.Codec); err != nil {
+			return nil, err
+		}
+	}
+
+	// Without a codec, the encoder and its sample rate are inferred from the file extension.
+	writer.codec = inferCodec(filename, options.Codec, writer.format)
+	writer.outrate = codecSampleRate(writer.codec, writer.samplerate)
+	if options.Codec == "" && writer.codec != "" {
+		if logger := currentLogger(); logger != nil {
+			logger.Printf("aio: encoding %s with %s at %d Hz", filename, writer.codec, writer.outrate)
+		}
+	}
+
+	if len(options.Outputs) > 0 {
+		// The tee muxer cannot guess an encoder from the output filename.
+		if writer.codec == "" {
+			return nil, fmt.Errorf("codec must be specified when writing to multiple outputs")
+		}
+		for _, output := range options.Outputs {
+			if output.Target == "" {
+				return nil, fmt.Errorf("output target must not be empty")
+			}
+		}
+	}
+
+	if len(options.Chapters) > 0 {
+		if err := checkChapterContainer(filename); err != nil {
+			return nil, err
+		}
+		if err := checkChapters(options.Chapters); err != nil {
+			return nil, err
+		}
+		writer.chapters = options.Chapters
+	}
+
+	runtime.SetFinalizer(writer, (*AudioWriter).leaked)
 	return writer, nil
 }
 
 // Once the user calls Write() for the first time on a AudioWriter struct,
 // the ffmpeg command which is used to write to the audio file is started.
 func (writer *AudioWriter) init() error {
-	// If user exits with Ctrl+C, stop ffmpeg process.
-	writer.cleanup()
 	// ffmpeg command to write to audio file. Takes in bytes from Stdin and encodes them.
+	// Errors are logged when writing to multiple outputs to find outputs that failed.
+	loglevel := "quiet"
+	if len(writer.outputs) > 0 {
+		loglevel = "error"
+	}
+
 	command := []string{
 		"-y", // overwrite output file if it exists.
-		"-loglevel", "quiet",
+		"-loglevel", logLevel(writer.loglevel, loglevel),
+	}
+
+	// Read the input at its native rate. Once the pipe buffer is full, Write blocks
+	// until ffmpeg has consumed enough audio to catch up with the wall clock.
+	if writer.realtime {
+		command = append(command, "-re")
+	}
+
+	command = append(
+		command,
 		"-f", writer.format,
 		"-ar", fmt.Sprintf("%d", writer.samplerate),
 		"-ac", fmt.Sprintf("%d", writer.channels),
 		"-i", "-", // The input comes from stdin.
-	}
+	)
+
+	// Index of the next input file after stdin.
+	input := 1
 
 	// Assumes "writer.file" is a container format.
+	if writer.streamfile != "" {
+		command = append(command, "-i", localInput(writer.streamfile))
+		input++
+	}
+
+	// Chapters are passed in as an ffmetadata file.
+	metadata := -1
+	if len(writer.chapters) > 0 {
+		metafile, err := writeChapters(writer.chapters)
+		if err != nil {
+			return err
+		}
+		writer.metafile = metafile
+		command = append(command, "-f", "ffmetadata", "-i", localInput(metafile))
+		metadata = input
+	}
+
 	if writer.streamfile != "" {
 		command = append(
 			command,
-			"-i", writer.streamfile,
 			"-map", "0:a:0",
 			"-map", "1:v?", // Add Video streams if present.
 			"-c:v", "copy",
@@ -134,51 +414,209 @@ func (writer *AudioWriter) init() error {
 			"-c:t", "copy",
 			"-shortest", // Cut longest streams to match audio duration.
 		)
+		if writer.copymeta {
+			command = append(
+				command,
+				"-map_metadata", "1", // Global metadata.
+				"-map_metadata:s:a", "1:s:a", // Audio stream metadata, e.g. language.
+				"-map_metadata:s:v", "1:s:v",
+				"-map_metadata:s:s", "1:s:s",
+			)
+		}
+	}
+
+	// The tee muxer does not pick streams automatically.
+	if writer.streamfile == "" && len(writer.outputs) > 0 {
+		command = append(command, "-map", "0:a")
+	}
+
+	if metadata != -1 {
+		// Global metadata is taken from the StreamFile if requested, otherwise from the chapters file.
+		if !writer.copymeta {
+			command = append(command, "-map_metadata", fmt.Sprintf("%d", metadata))
+		}
+		command = append(command, "-map_chapters", fmt.Sprintf("%d", metadata))
+	}
+
+	// User specified tags take precedence over any mapped metadata.
+	keys := make([]string, 0, len(writer.metadata))
+	for key := range writer.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		command = append(command, "-metadata", fmt.Sprintf("%s=%s", key, writer.metadata[key]))
+	}
+
+	if writer.filter != "" {
+		command = append(command, "-af", writer.filter)
 	}
 
 	if writer.codec != "" {
 		command = append(command, "-acodec", writer.codec)
 	}
 
+	if writer.outrate != writer.samplerate {
+		command = append(command, "-ar", fmt.Sprintf("%d", writer.outrate))
+	}
+
 	if writer.bitrate > 0 {
 		command = append(command, "-ab", fmt.Sprintf("%d", writer.bitrate))
 	}
 
-	command = append(command, writer.filename)
+	if writer.quality != "" {
+		command = append(command, "-q:a", writer.quality)
+	}
+
+	if writer.level > 0 {
+		command = append(command, "-compression_level", fmt.Sprintf("%d", writer.level))
+	}
+
+	if writer.threads > 0 {
+		command = append(command, "-threads", fmt.Sprintf("%d", writer.threads))
+	}
+
+	// ID3 options are only understood by the mp3 muxer.
+	if strings.ToLower(filepath.Ext(writer.filename)) == ".mp3" {
+		if writer.id3version != 0 {
+			command = append(command, "-id3v2_version", fmt.Sprintf("%d", writer.id3version))
+		}
+		if writer.id3v1 {
+			command = append(command, "-write_id3v1", "1")
+		}
+	}
+
+	// Write packets as soon as they are encoded rather than letting the muxer buffer them.
+	// This lowers the time until data reaches the output at the cost of more, smaller writes.
+	// The muxer holds packets for at most MaxDelay, e.g. to interleave them in MPEG-TS output.
+	if writer.lowlatency {
+		command = append(
+			command,
+			"-flush_packets", "1",
+			"-muxdelay", fmt.Sprintf("%g", writer.maxdelay.Seconds()),
+			"-muxpreload", "0",
+		)
+	}
+
+	// Encoder statistics are written to stderr, where they are parsed and filtered out of the log.
+	if writer.progress != nil {
+		command = append(command, "-progress", "pipe:2")
+	}
+
+	if len(writer.outputs) > 0 {
+		outputs := append([]OutputSpec{{Target: writer.filename}}, writer.outputs...)
+		command = append(command, "-f", "tee", teeTarget(outputs))
+	} else {
+		// ffmpeg does not guess a muxer for files without a header, which hold the samples as written.
+		if isRaw(writer.filename) {
+			command = append(command, "-f", writer.format)
+		}
+		command = append(command, writer.filename)
+	}
+
 	cmd := exec.Command("ffmpeg", command...)
 	writer.cmd = cmd
 
+	var stderr io.Writer
+	if len(writer.outputs) > 0 {
+		writer.log = &ffmpegLog{}
+		stderr = writer.log
+	}
+	cmd.Stderr = logOutput(cmd, writer.loglevel, stderr)
+	progress := writer.progress
+	if progress != nil {
+		progress.next = cmd.Stderr
+		cmd.Stderr = progress
+	}
+
 	pipe, err := cmd.StdinPipe()
 	if err != nil {
 		return err
 	}
 
 	writer.pipe = pipe
-	if err := cmd.Start(); err != nil {
+	if err := startNice(cmd, writer.nice); err != nil {
 		return err
 	}
+	metafile := writer.metafile
+	writer.handle = registerHandle(cmd, func() error {
+		pipe.Close()
+		err := cmd.Wait()
+		unregister(cmd)
+		if progress != nil {
+			progress.flush()
+		}
+		if metafile != "" {
+			os.Remove(metafile)
+		}
+		return err
+	})
 
 	return nil
 }
 
-// Writes the given samples to the audio file.
-func (writer *AudioWriter) Write(samples interface{}) error {
-	buffer := samplesToBytes(samples)
-	if buffer == nil {
-		return fmt.Errorf("invalid sample data type")
+// Returns the stdin pipe of the ffmpeg process, starting the process if it is not running yet.
+func (writer *AudioWriter) start() (io.WriteCloser, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	// The process may have been stopped by CloseAll.
+	if writer.closed || writer.handle.closed() {
+		return nil, fmt.Errorf("audio writer is closed")
 	}
 
 	// If cmd is nil, audio writing has not been set up.
 	if writer.cmd == nil {
 		if err := writer.init(); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	return writer.pipe, nil
+}
+
+// Writes all audio from the source, e.g. an Audio or Microphone, until it has been read
+// completely. The channels and sample rate of the source must match the writer, and samples are
+// converted to the format of the writer. Errors from reading the audio start with "reading audio"
+// and errors from writing it start with "writing audio". The source is closed once WriteFrom
+// returns, while the writer stays open.
+func (writer *AudioWriter) WriteFrom(source Source) error {
+	return pipe(source, writer, "writing")
+}
+
+// Writes the given samples to the audio file. Byte slices hold samples in the format of the
+// writer, while other sample slices must have the type matching the format, unless
+// Options.ConvertSamples is set. Writers using "s24" or "u24" also accept []int32 or []uint32
+// samples holding 24-bit values, which are packed into 3 bytes per sample. Returns an error if
+// the writer is closed, including when Close is called from another goroutine during the write.
+func (writer *AudioWriter) Write(samples interface{}) error {
+	buffer := samplesToFormat(samples, writer.format)
+	if buffer == nil {
+		return fmt.Errorf("invalid sample data type")
+	}
+	if packed, ok, err := pack24(samples, writer.format, writer.clamp); err != nil {
+		return err
+	} else if ok {
+		buffer = packed
+	} else if format := sampleFormat(samples); format != "" && sampleType(format) != sampleType(writer.format) {
+		if !writer.convert {
+			return fmt.Errorf("writer format %s expects %T, got %T", writer.format, makeSamples(writer.format, 0), samples)
+		}
+		buffer = convertBuffer(samplesToBytes(samples), format, writer.format)
+	}
+
+	pipe, err := writer.start()
+	if err != nil {
+		return err
+	}
+
 	total := 0
 	for total < len(buffer) {
-		n, err := writer.pipe.Write(buffer[total:])
+		started := time.Now()
+		n, err := pipe.Write(buffer[total:])
+		writer.metrics.transfer(n, writer.BytesPerFrame(), started)
 		if err != nil {
+			writer.metrics.failed()
 			return err
 		}
 		total += n
@@ -187,29 +625,27 @@ func (writer *AudioWriter) Write(samples interface{}) error {
 	return nil
 }
 
-// Closes the pipe and stops the ffmpeg process.
+// Closes the pipe and stops the ffmpeg process. Safe to call from any goroutine.
 func (writer *AudioWriter) Close() {
-	if writer.pipe != nil {
-		writer.pipe.Close()
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.closed {
+		return
 	}
-	if writer.cmd != nil {
-		writer.cmd.Wait()
+	writer.closed = true
+	runtime.SetFinalizer(writer, nil)
+	if writer.handle != nil {
+		writer.handle.close()
+	} else if writer.metafile != "" {
+		os.Remove(writer.metafile)
 	}
 }
 
-// Stops the "cmd" process running when the user presses Ctrl+C.
-// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
-func (writer *AudioWriter) cleanup() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		if writer.pipe != nil {
-			writer.pipe.Close()
-		}
-		if writer.cmd != nil {
-			writer.cmd.Process.Kill()
-		}
-		os.Exit(1)
-	}()
+// Stops the ffmpeg process if the writer is garbage collected without being closed. The output
+// file is left unfinished.
+func (writer *AudioWriter) leaked() {
+	if !writer.closed {
+		writer.handle.leaked("AudioWriter of "+writer.filename, writer.stack)
+	}
 }
@@ -0,0 +1,224 @@
+package aio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Sample String: "Input #0, matroska,webm, from 'movie.mkv':".
+	bannerInput = regexp.MustCompile(`^Input #\d+, (.+), from `)
+	// Sample String: "Duration: 00:03:25.12, start: 0.000000, bitrate: 912 kb/s".
+	bannerDuration = regexp.MustCompile(`^Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+	bannerBitrate  = regexp.MustCompile(`bitrate: (\d+) kb/s`)
+	bannerStart    = regexp.MustCompile(`start: (-?\d+(?:\.\d+)?)`)
+	// Sample String: "Stream #0:1[0x2](eng): Audio: aac (LC), 48000 Hz, 5.1, fltp (default)".
+	bannerStream = regexp.MustCompile(`^Stream #\d+:(\d+)(?:\[\w+\])?(?:\((\w+)\))?: (\w+): (.*)$`)
+	streamRate   = regexp.MustCompile(`^(\d+) Hz$`)
+	streamKbps   = regexp.MustCompile(`^(\d+) kb/s`)
+	streamCount  = regexp.MustCompile(`^(\d+) channels`)
+)
+
+// Reads the information about a media file from the ffmpeg banner, for machines without
+// ffprobe. If input is not nil, it is read instead of the file.
+func ffmpegProbe(ctx context.Context, filename string, input io.Reader, limits probeLimits) (*ProbeResult, error) {
+	name := localInput(filename)
+	if input != nil {
+		name = "pipe:0"
+	}
+
+	// The command fails since no output is given, after writing the information to Stderr.
+	command := append([]string{"-hide_banner"}, limits.args()...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(command, "-i", name)...)
+	cmd.Stdin = input
+	logCommand(cmd)
+
+	pipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	output, err := io.ReadAll(pipe)
+	cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := parseBanner(string(output))
+	if !ok {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return nil, fmt.Errorf("ffmpeg could not read %s: %s", filename, strings.TrimSpace(lines[len(lines)-1]))
+	}
+	return result, nil
+}
+
+// Parses the information about the first input printed by ffmpeg into the same form as the
+// ffprobe output. Returns false if no input was found. Fields that ffmpeg does not print, such
+// as the file size and the duration of each stream, are missing from the metadata.
+func parseBanner(output string) (*ProbeResult, bool) {
+	format := map[string]string{}
+	streams := []map[string]string{}
+
+	found := false
+	var tags map[string]string // Metadata the next tags belong to.
+	indent := 0                // Indentation of the "Metadata:" line the tags belong to.
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		depth := len(line) - len(strings.TrimLeft(line, " "))
+
+		if match := bannerInput.FindStringSubmatch(line); match != nil {
+			if found {
+				break // Only the first input is read.
+			}
+			found = true
+			format["format_name"] = match[1]
+			tags = nil
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		// Tags are indented below the "Metadata:" line of the input or stream.
+		if tags != nil && depth > indent {
+			if key, value, ok := cutTag(trimmed); ok {
+				tags["TAG:"+key] = value
+				continue
+			}
+		}
+		tags = nil
+
+		switch {
+		case trimmed == "Metadata:":
+			tags, indent = format, depth
+			if len(streams) > 0 {
+				tags = streams[len(streams)-1]
+			}
+		case bannerDuration.MatchString(trimmed):
+			match := bannerDuration.FindStringSubmatch(trimmed)
+			hours, _ := strconv.ParseFloat(match[1], 64)
+			minutes, _ := strconv.ParseFloat(match[2], 64)
+			seconds, _ := strconv.ParseFloat(match[3], 64)
+			format["duration"] = strconv.FormatFloat(hours*3600+minutes*60+seconds, 'f', -1, 64)
+			if bitrate := bannerBitrate.FindStringSubmatch(trimmed); bitrate != nil {
+				format["bit_rate"] = bitrate[1] + "000"
+			}
+			if start := bannerStart.FindStringSubmatch(trimmed); start != nil {
+				format["start_time"] = start[1]
+			}
+		case bannerStream.MatchString(trimmed):
+			streams = append(streams, parseStreamInfo(trimmed))
+		case !strings.HasPrefix(line, " "):
+			// Any unindented line ends the information about the input.
+			return newBannerResult(streams, format), true
+		}
+	}
+
+	if !found {
+		return nil, false
+	}
+	return newBannerResult(streams, format), true
+}
+
+// Creates the probe result from the information in the ffmpeg banner. ffmpeg only prints the
+// duration and start time of the file, which are used for every audio stream.
+func newBannerResult(streams []map[string]string, format map[string]string) *ProbeResult {
+	for _, stream := range streams {
+		if stream["codec_type"] != "audio" {
+			continue
+		}
+		for _, key := range []string{"duration", "start_time"} {
+			if format[key] != "" {
+				stream[key] = format[key]
+			}
+		}
+	}
+	result := newProbeResult(streams, format)
+	result.Fallback = true
+	return result
+}
+
+// Splits a tag line such as "title           : Surround" into the key and value.
+func cutTag(line string) (string, string, bool) {
+	index := strings.Index(line, ":")
+	if index <= 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:]), true
+}
+
+// Parses a stream line printed by ffmpeg, e.g. "Stream #0:0: Audio: pcm_s16le, 44100 Hz,
+// stereo, s16, 1411 kb/s", into the same keys as the ffprobe output for the stream. Fields
+// that are not printed are missing.
+func parseStreamInfo(line string) map[string]string {
+	stream := map[string]string{}
+	match := bannerStream.FindStringSubmatch(line)
+	if match == nil {
+		return stream
+	}
+
+	stream["index"] = match[1]
+	if match[2] != "" {
+		stream["TAG:language"] = match[2]
+	}
+	stream["codec_type"] = strings.ToLower(match[3])
+
+	// Parts are separated by commas, except for commas inside of parentheses.
+	parts := []string{}
+	depth, start := 0, 0
+	details := match[4]
+	for i, char := range details {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(details[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, strings.TrimSpace(details[start:]))
+
+	// The codec name comes first, followed by its profile or tag, e.g. "aac (LC)".
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		stream["codec_name"] = fields[0]
+	}
+	if stream["codec_type"] != "audio" {
+		return stream
+	}
+
+	for i, part := range parts[1:] {
+		// Flags such as "(default)" follow the last part.
+		if index := strings.Index(part, " ("); index != -1 && i == len(parts)-2 {
+			part = part[:index]
+		}
+		if rate := streamRate.FindStringSubmatch(part); rate != nil {
+			stream["sample_rate"] = rate[1]
+		} else if kbps := streamKbps.FindStringSubmatch(part); kbps != nil {
+			stream["bit_rate"] = kbps[1] + "000"
+		} else if count := streamCount.FindStringSubmatch(part); count != nil {
+			stream["channels"] = count[1]
+		} else if channels, ok := channelLayouts[part]; ok {
+			stream["channels"] = strconv.Itoa(channels)
+			stream["channel_layout"] = part
+		} else if _, ok := stream["channels"]; ok && stream["sample_fmt"] == "" {
+			// The sample format follows the channel layout, e.g. "fltp" or "s16".
+			stream["sample_fmt"] = part
+		}
+	}
+	return stream
+}
@@ -0,0 +1,133 @@
+package aio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Conversion of one file by ConvertBatch.
+type ConvertJob struct {
+	Input   string   // File to read the audio from.
+	Output  string   // File to write the converted audio to.
+	Options *Options // Options used to read the input and to write the output, may be nil.
+}
+
+// Converts the files of all jobs, running up to the given number of conversions at the same time,
+// or one per CPU if workers is 0 or less. Each conversion reads its input like NewAudio and writes
+// the audio to its output like NewAudioWriter, both with the options of the job. The filter of
+// the options is applied once, while reading. Jobs that do not set Options.Threads share the CPUs
+// between the workers, so that the ffmpeg processes do not oversubscribe the machine.
+//
+// The progress callback, which may be nil, is called after every finished job with the number of
+// finished jobs, the number of jobs and the input of the job that finished. Calls never overlap.
+// Returns the error of every job in the order of the jobs, nil for jobs that succeeded. Failed
+// conversions do not stop the other jobs, and their incomplete output is removed.
+func ConvertBatch(jobs []ConvertJob, workers int, onProgress func(done, total int, current string)) []error {
+	return ConvertBatchContext(context.Background(), jobs, workers, onProgress)
+}
+
+// Same as ConvertBatch, but stops all running conversions once the context is done. The errors
+// of the stopped jobs, and of the jobs that were not started, are the error of the context.
+func ConvertBatchContext(ctx context.Context, jobs []ConvertJob, workers int, onProgress func(done, total int, current string)) []error {
+	errs := make([]error, len(jobs))
+	if len(jobs) == 0 {
+		return errs
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	threads := runtime.NumCPU() / workers
+	if threads < 1 {
+		threads = 1
+	}
+
+	indices := make(chan int)
+	var mutex sync.Mutex // Serializes the progress callback.
+	done := 0
+
+	var wait sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range indices {
+				errs[index] = convertFile(ctx, jobs[index], threads)
+
+				mutex.Lock()
+				done++
+				if onProgress != nil {
+					onProgress(done, len(jobs), jobs[index].Input)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for index := range jobs {
+		if ctx.Err() != nil {
+			errs[index] = ctx.Err()
+			continue
+		}
+		select {
+		case indices <- index:
+		case <-ctx.Done():
+			errs[index] = ctx.Err()
+		}
+	}
+	close(indices)
+	wait.Wait()
+
+	return errs
+}
+
+// Converts the file of the job, using the given number of threads unless the options of the
+// job set them. Removes the output if the conversion fails.
+func convertFile(ctx context.Context, job ConvertJob, threads int) error {
+	options := withDefaults(job.Options)
+	if options.Threads == 0 {
+		options.Threads = threads
+	}
+
+	audio, err := NewAudioContext(ctx, job.Input, options)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+
+	// The writer takes the decoded audio as it is, which has already been filtered.
+	output := *options
+	output.SampleRate = audio.SampleRate()
+	output.Channels = audio.Channels()
+	output.Format = audio.Format()
+	output.Filter = ""
+	writer, err := NewAudioWriter(job.Output, &output)
+	if err != nil {
+		return err
+	}
+
+	stop := closeWhenDone(ctx, func() {
+		audio.Close()
+		writer.Close()
+	})
+	err = writer.WriteFrom(audio)
+	stop()
+	writer.Close()
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if err == nil && writer.handle != nil {
+		if err = writer.handle.close(); err != nil {
+			err = fmt.Errorf("ffmpeg could not encode %s: %w", job.Output, err)
+		}
+	}
+	if err != nil {
+		os.Remove(job.Output)
+	}
+	return err
+}
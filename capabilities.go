@@ -0,0 +1,212 @@
+package aio
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Version and components of an ffmpeg build.
+type ffmpegBuild struct {
+	version  string          // Version string, e.g. "6.1.1".
+	encoders map[string]bool // Names of the available encoders.
+	decoders map[string]bool // Names of the available decoders.
+	filters  map[string]bool // Names of the available filters.
+	muxers   map[string]bool // Names of the available output formats.
+}
+
+// ffmpeg builds that have been read, keyed by the path of the ffmpeg program.
+var builds = struct {
+	mutex sync.Mutex
+	read  map[string]*ffmpegBuild
+}{read: make(map[string]*ffmpegBuild)}
+
+// Returns the version and components of the installed ffmpeg. ffmpeg is only run once for
+// each path it is found at.
+func ffmpegCapabilities() (*ffmpegBuild, error) {
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	builds.mutex.Lock()
+	defer builds.mutex.Unlock()
+
+	if build, ok := builds.read[path]; ok {
+		return build, nil
+	}
+
+	outputs := make(map[string]string)
+	for _, flag := range []string{"-version", "-encoders", "-decoders", "-filters", "-muxers"} {
+		cmd := exec.Command(path, "-hide_banner", flag)
+		logCommand(cmd)
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("could not read ffmpeg %s: %w", flag, err)
+		}
+		outputs[flag] = string(output)
+	}
+
+	build := &ffmpegBuild{
+		version:  parseVersion(outputs["-version"]),
+		encoders: parseCodecs(outputs["-encoders"]),
+		decoders: parseCodecs(outputs["-decoders"]),
+		filters:  parseFilters(outputs["-filters"]),
+		muxers:   parseMuxers(outputs["-muxers"]),
+	}
+	builds.read[path] = build
+	return build, nil
+}
+
+// Parses the version from the output of "ffmpeg -version",
+// e.g. "6.1.1" from "ffmpeg version 6.1.1 Copyright (c) 2000-2023".
+func parseVersion(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[1] != "version" {
+		return ""
+	}
+	return fields[2]
+}
+
+// Parses the names from the output of "ffmpeg -encoders" or "ffmpeg -decoders".
+// The codecs are listed after a "------" line, with their capabilities before the name, e.g.
+// " A....D aac                  AAC (Advanced Audio Coding)".
+func parseCodecs(output string) map[string]bool {
+	codecs := make(map[string]bool)
+	listed := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 1 && strings.HasPrefix(fields[0], "---") {
+			listed = true
+		} else if listed && len(fields) >= 2 {
+			codecs[fields[1]] = true
+		}
+	}
+	return codecs
+}
+
+// Parses the names from the output of "ffmpeg -filters". Every filter is listed with its
+// inputs and outputs after the name, e.g. " ... acompressor       A->A       Audio compressor.".
+func parseFilters(output string) map[string]bool {
+	filters := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			filters[fields[1]] = true
+		}
+	}
+	return filters
+}
+
+// Parses the names from the output of "ffmpeg -muxers". The formats are listed after a "--"
+// line, with their capabilities before the name, e.g. "  E chromaprint     Chromaprint".
+// Formats with several names list them separated by commas.
+func parseMuxers(output string) map[string]bool {
+	muxers := make(map[string]bool)
+	listed := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 1 && strings.HasPrefix(fields[0], "--") {
+			listed = true
+		} else if listed && len(fields) >= 2 {
+			for _, name := range strings.Split(fields[1], ",") {
+				muxers[name] = true
+			}
+		}
+	}
+	return muxers
+}
+
+// Returns the version of the installed ffmpeg, e.g. "6.1.1".
+func FFmpegVersion() (string, error) {
+	build, err := ffmpegCapabilities()
+	if err != nil {
+		return "", err
+	}
+	return build.version, nil
+}
+
+// Returns true if the installed ffmpeg has the encoder with the given name, e.g. "libopus".
+// Returns false if ffmpeg is not installed.
+func HasEncoder(name string) bool {
+	build, err := ffmpegCapabilities()
+	return err == nil && build.encoders[name]
+}
+
+// Returns true if the installed ffmpeg has the decoder with the given name, e.g. "mp3".
+// Returns false if ffmpeg is not installed.
+func HasDecoder(name string) bool {
+	build, err := ffmpegCapabilities()
+	return err == nil && build.decoders[name]
+}
+
+// Returns true if the installed ffmpeg has the filter with the given name, e.g. "loudnorm".
+// Returns false if ffmpeg is not installed.
+func HasFilter(name string) bool {
+	build, err := ffmpegCapabilities()
+	return err == nil && build.filters[name]
+}
+
+// Returns true if the installed ffmpeg can write the output format with the given name,
+// e.g. "chromaprint". Returns false if ffmpeg is not installed.
+func HasMuxer(name string) bool {
+	build, err := ffmpegCapabilities()
+	return err == nil && build.muxers[name]
+}
+
+// Checks that the installed ffmpeg has the encoder. Passes if ffmpeg cannot be run, in which
+// case starting ffmpeg reports the problem.
+func checkEncoder(name string) error {
+	build, err := ffmpegCapabilities()
+	if err == nil && name != "copy" && !build.encoders[name] {
+		return fmt.Errorf("your ffmpeg build lacks the %s encoder", name)
+	}
+	return nil
+}
+
+// Checks that the installed ffmpeg can write the output format. Passes if ffmpeg cannot be run.
+func checkMuxer(name string) error {
+	build, err := ffmpegCapabilities()
+	if err == nil && !build.muxers[name] {
+		return fmt.Errorf("your ffmpeg build lacks the %s muxer", name)
+	}
+	return nil
+}
+
+// Checks that the installed ffmpeg has every filter used in the filter graph. Passes if ffmpeg
+// cannot be run, e.g. when only ffplay is installed.
+func checkFilters(graph string) error {
+	build, err := ffmpegCapabilities()
+	if err != nil {
+		return nil
+	}
+	for _, name := range filterNames(graph) {
+		if !build.filters[name] {
+			return fmt.Errorf("your ffmpeg build lacks the %s filter", name)
+		}
+	}
+	return nil
+}
+
+// Returns the names of the filters in the filter graph,
+// e.g. ["volume", "aecho"] for "[in]volume=0.5,aecho@echo=0.8:0.9:1000:0.3[out]".
+func filterNames(graph string) []string {
+	// Remove link labels such as "[in]".
+	graph = regexp.MustCompile(`\[[^\]]*\]`).ReplaceAllString(graph, "")
+	names := []string{}
+	for _, filter := range strings.FieldsFunc(graph, func(r rune) bool { return r == ',' || r == ';' }) {
+		name := strings.TrimSpace(strings.SplitN(filter, "=", 2)[0])
+		// Filters may be given an instance name after "@".
+		name = strings.SplitN(name, "@", 2)[0]
+		// Skips parts of quoted arguments containing commas.
+		if regexp.MustCompile(`^\w+$`).MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,79 @@
+package aio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Chapter struct {
+	Title string  // Chapter Title.
+	Start float64 // Start time of the chapter in seconds.
+	End   float64 // End time of the chapter in seconds.
+}
+
+// Checks that the given chapters are sorted by start time and do not overlap.
+func checkChapters(chapters []Chapter) error {
+	for i, chapter := range chapters {
+		if chapter.Start < 0 {
+			return fmt.Errorf("chapter %d has negative start time %f", i, chapter.Start)
+		}
+		if chapter.End <= chapter.Start {
+			return fmt.Errorf("chapter %d must end after it starts", i)
+		}
+		if i > 0 && chapter.Start < chapters[i-1].End {
+			return fmt.Errorf("chapter %d overlaps or comes before chapter %d", i, i-1)
+		}
+	}
+	return nil
+}
+
+// Returns an error if the container of the given file cannot store chapters.
+func checkChapterContainer(filename string) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".m4a", ".m4b", ".mp4", ".mov", ".mka", ".mkv":
+		return nil
+	default:
+		return fmt.Errorf("container of %s does not support chapters", filename)
+	}
+}
+
+// Escapes special characters in ffmetadata values.
+// https://ffmpeg.org/ffmpeg-formats.html#Metadata-1.
+func escapeMetadata(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		"=", `\=`,
+		";", `\;`,
+		"#", `\#`,
+		"\n", "\\\n",
+	)
+	return replacer.Replace(value)
+}
+
+// Writes the chapters to a temporary ffmetadata file and returns its path.
+func writeChapters(chapters []Chapter) (string, error) {
+	file, err := os.CreateTemp("", "aio-chapters-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	builder := strings.Builder{}
+	builder.WriteString(";FFMETADATA1\n")
+	for _, chapter := range chapters {
+		builder.WriteString("[CHAPTER]\n")
+		builder.WriteString("TIMEBASE=1/1000\n")
+		builder.WriteString(fmt.Sprintf("START=%d\n", int64(chapter.Start*1000)))
+		builder.WriteString(fmt.Sprintf("END=%d\n", int64(chapter.End*1000)))
+		builder.WriteString(fmt.Sprintf("title=%s\n", escapeMetadata(chapter.Title)))
+	}
+
+	if _, err := file.WriteString(builder.String()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
@@ -0,0 +1,290 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Windows read by a Chunker. Sizes are given in samples, or as durations that are rounded to
+// the nearest sample. A zero value means that the default is used.
+type ChunkerOptions struct {
+	WindowSize     int           // Number of samples in each window. 2048 by default.
+	HopSize        int           // Number of samples between the starts of consecutive windows. Half the window by default.
+	WindowDuration time.Duration // Duration of each window, used instead of WindowSize.
+	HopDuration    time.Duration // Time between the starts of consecutive windows, used instead of HopSize.
+	FinalWindow    string        // Last window if the audio ends before it is complete: "pad" (default), "truncate" or "drop".
+}
+
+// Splits the audio of a source into windows of a fixed number of samples that start every hop
+// samples, e.g. 25 ms windows with a 10 ms hop for speech models. Windows overlap if the hop is
+// smaller than the window, and the samples in between are skipped if it is larger. A Chunker is
+// itself a Source, reading one window at a time.
+type Chunker struct {
+	source  Source        // Source the audio is read from.
+	format  string        // Format of audio samples.
+	frame   int           // Number of bytes in one frame of audio.
+	size    int           // Number of samples in each window.
+	hop     int           // Number of samples between the starts of consecutive windows.
+	final   string        // Policy for the last window if it is incomplete.
+	window  []byte        // Current window, reused by every call to Read.
+	length  int           // Number of bytes of audio in the current window.
+	pts     time.Duration // Time of the first sample of the current window.
+	pending []byte        // Audio read from the source that has not been skipped yet.
+	head    int           // Number of bytes at the start of the pending audio that have been skipped.
+	skip    int           // Number of samples to skip before the next window when the hop is larger than the window.
+	start   int           // Index of the first pending sample, counted from the first sample read.
+	covered int           // Index of the sample after the last window, counted from the first sample read.
+	ended   bool          // Flag storing whether the source has no more audio.
+	err     error         // Error of the source, returned once all windows have been read.
+}
+
+// Audio Sample Rate in Hz.
+func (chunker *Chunker) SampleRate() int {
+	return chunker.source.SampleRate()
+}
+
+func (chunker *Chunker) Channels() int {
+	return chunker.source.Channels()
+}
+
+func (chunker *Chunker) BitsPerSample() int {
+	return chunker.source.BitsPerSample()
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (chunker *Chunker) BytesPerFrame() int {
+	return chunker.frame
+}
+
+func (chunker *Chunker) Format() string {
+	return chunker.source.Format()
+}
+
+// Number of samples in each window.
+func (chunker *Chunker) WindowSize() int {
+	return chunker.size
+}
+
+// Number of samples between the starts of consecutive windows.
+func (chunker *Chunker) HopSize() int {
+	return chunker.hop
+}
+
+// Policy for the last window if the audio ends before it is complete: "pad", "truncate" or "drop".
+func (chunker *Chunker) FinalWindow() string {
+	return chunker.final
+}
+
+// Audio of the current window. The buffer is reused by the next call to Read.
+func (chunker *Chunker) Buffer() []byte {
+	return chunker.window[:chunker.length]
+}
+
+// Samples of the current window, with the type matching the format. The samples share memory
+// with the buffer, so they are overwritten by the next call to Read.
+func (chunker *Chunker) Samples() interface{} {
+	buffer := chunker.Buffer()
+	return bytesToSamples(buffer, len(buffer)/newSampleCodec(chunker.format).size, chunker.format)
+}
+
+// Time of the first sample of the current window, counted from the first sample read.
+func (chunker *Chunker) PTS() time.Duration {
+	return chunker.pts
+}
+
+// Creates a chunker reading windows from the source. The window and hop sizes are taken from
+// settings.WindowSize and settings.HopSize in samples, or settings.WindowDuration and
+// settings.HopDuration rounded to the nearest sample, and default to 2048 samples and half the
+// window. settings.FinalWindow decides what happens to the last window if the audio ends before
+// it is complete: it is padded with silence ("pad", the default), shortened ("truncate") or
+// dropped ("drop").
+func NewChunker(source Source, settings ChunkerOptions) (*Chunker, error) {
+	if source == nil {
+		return nil, fmt.Errorf("source must not be nil")
+	}
+	if settings.WindowSize < 0 {
+		return nil, &OptionError{"settings.WindowSize", settings.WindowSize, "must be non-negative"}
+	}
+	if settings.HopSize < 0 {
+		return nil, &OptionError{"settings.HopSize", settings.HopSize, "must be non-negative"}
+	}
+	if settings.WindowDuration < 0 {
+		return nil, &OptionError{"settings.WindowDuration", settings.WindowDuration, "must be non-negative"}
+	}
+	if settings.HopDuration < 0 {
+		return nil, &OptionError{"settings.HopDuration", settings.HopDuration, "must be non-negative"}
+	}
+	if settings.WindowSize != 0 && settings.WindowDuration != 0 {
+		return nil, &OptionError{"settings.WindowDuration", settings.WindowDuration, "must not be set together with WindowSize"}
+	}
+	if settings.HopSize != 0 && settings.HopDuration != 0 {
+		return nil, &OptionError{"settings.HopDuration", settings.HopDuration, "must not be set together with HopSize"}
+	}
+	switch settings.FinalWindow {
+	case "", "pad", "truncate", "drop":
+	default:
+		return nil, &OptionError{"settings.FinalWindow", settings.FinalWindow, `must be "pad", "truncate" or "drop"`}
+	}
+
+	samplerate := source.SampleRate()
+	size := settings.WindowSize
+	if settings.WindowDuration != 0 {
+		size = durationSamples(settings.WindowDuration, samplerate)
+		if size < 1 {
+			return nil, &OptionError{"settings.WindowDuration", settings.WindowDuration, "must be at least one sample long"}
+		}
+	}
+	if size == 0 {
+		size = defaultWindowSize
+	}
+	hop := settings.HopSize
+	if settings.HopDuration != 0 {
+		hop = durationSamples(settings.HopDuration, samplerate)
+		if hop < 1 {
+			return nil, &OptionError{"settings.HopDuration", settings.HopDuration, "must be at least one sample long"}
+		}
+	}
+	if hop == 0 {
+		hop = size / 2
+		if hop == 0 {
+			hop = 1
+		}
+	}
+
+	final := settings.FinalWindow
+	if final == "" {
+		final = "pad"
+	}
+
+	format := byteFormat(source)
+	frame := newSampleCodec(format).size * source.Channels()
+	return &Chunker{
+		source: source,
+		format: format,
+		frame:  frame,
+		size:   size,
+		hop:    hop,
+		final:  final,
+		window: make([]byte, size*frame),
+	}, nil
+}
+
+// Returns the number of samples in the duration at the sample rate, rounded to the nearest sample.
+func durationSamples(duration time.Duration, samplerate int) int {
+	return int(math.Round(duration.Seconds() * float64(samplerate)))
+}
+
+// Reads the next window into the window buffer, which is reused by every call.
+// Returns false once all windows have been read.
+func (chunker *Chunker) Read() bool {
+	return chunker.next() == nil
+}
+
+// Reads the next window into a new buffer owned by the caller, along with the time of its first
+// sample, counted from the first sample read. Once all windows have been read, returns io.EOF,
+// or the error of the source if it failed.
+func (chunker *Chunker) ReadFrame() (*Frame, error) {
+	if err := chunker.next(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, chunker.length)
+	copy(data, chunker.window)
+	return &Frame{Data: data, Samples: len(data) / chunker.frame, PTS: chunker.pts}, nil
+}
+
+// Closes the source. Safe to call from any goroutine if the source allows it.
+func (chunker *Chunker) Close() {
+	chunker.source.Close()
+}
+
+// Reads the next window into the window buffer.
+func (chunker *Chunker) next() error {
+	window := chunker.size * chunker.frame
+	for len(chunker.pending)-chunker.head < window && !chunker.ended {
+		chunker.fill()
+	}
+
+	available := len(chunker.pending) - chunker.head
+	if available < window {
+		// The last window holds the rest of the audio, unless it has been part of earlier windows.
+		frames := available / chunker.frame
+		if frames == 0 || chunker.start+frames <= chunker.covered || chunker.final == "drop" {
+			chunker.length = 0
+			return chunker.end()
+		}
+		copy(chunker.window, chunker.pending[chunker.head:chunker.head+frames*chunker.frame])
+		chunker.length = frames * chunker.frame
+		if chunker.final == "pad" {
+			fillSilence(chunker.window[chunker.length:], chunker.format)
+			chunker.length = window
+		}
+	} else {
+		copy(chunker.window, chunker.pending[chunker.head:chunker.head+window])
+		chunker.length = window
+	}
+
+	samplerate := chunker.source.SampleRate()
+	chunker.pts = time.Duration(chunker.start) * time.Second / time.Duration(samplerate)
+	chunker.covered = chunker.start + chunker.size
+	chunker.advance(chunker.hop)
+	return nil
+}
+
+// Skips the given number of samples, or as many as have been read. The rest are skipped as
+// they are read.
+func (chunker *Chunker) advance(frames int) {
+	available := (len(chunker.pending) - chunker.head) / chunker.frame
+	if frames > available {
+		chunker.skip = frames - available
+		frames = available
+	}
+	chunker.head += frames * chunker.frame
+	chunker.start += frames
+}
+
+// Reads the next buffer from the source, skipping samples between windows.
+func (chunker *Chunker) fill() {
+	frame, err := chunker.source.ReadFrame()
+	if err != nil {
+		chunker.ended = true
+		if err != io.EOF {
+			chunker.err = err
+		}
+		return
+	}
+
+	// Skipped audio at the start of the pending audio is removed before the buffer grows.
+	if chunker.head > 0 {
+		chunker.pending = append(chunker.pending[:0], chunker.pending[chunker.head:]...)
+		chunker.head = 0
+	}
+	data := frame.Data[:len(frame.Data)/chunker.frame*chunker.frame]
+	if chunker.skip > 0 {
+		skipped := len(data) / chunker.frame
+		if skipped > chunker.skip {
+			skipped = chunker.skip
+		}
+		data = data[skipped*chunker.frame:]
+		chunker.skip -= skipped
+		chunker.start += skipped
+	}
+	chunker.pending = append(chunker.pending, data...)
+}
+
+// Returns the error of the source, or io.EOF if all windows have been read.
+func (chunker *Chunker) end() error {
+	if chunker.err != nil {
+		return chunker.err
+	}
+	return io.EOF
+}
+
+// Fills the buffer with silence in the given format, which is not zero for unsigned formats.
+func fillSilence(buffer []byte, format string) {
+	codec := newSampleCodec(format)
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		codec.encode(buffer[i:], 0)
+	}
+}
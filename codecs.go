@@ -0,0 +1,74 @@
+package aio
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Encoders used by AudioWriter for output files with these extensions if Options.Codec is empty.
+// "pcm" is replaced by the PCM encoder matching the format of the writer.
+var extensionCodecs = map[string]string{
+	".opus": "libopus",
+	".m4a":  "aac",
+	".ogg":  "libvorbis",
+	".flac": "flac",
+	".wav":  "pcm",
+}
+
+// Sample rates supported by encoders that cannot encode every sample rate. The last sample
+// rate is used for audio with any other sample rate.
+var codecSampleRates = map[string][]int{
+	"libopus": {8000, 12000, 16000, 24000, 48000},
+}
+
+// Returns the encoder for the output file, which is the given codec if it is not empty, or the
+// encoder inferred from the extension of the file. Returns an empty codec if ffmpeg should
+// choose the encoder, e.g. for unknown extensions or if the inferred encoder is not installed.
+func inferCodec(filename, codec, format string) string {
+	if codec != "" {
+		return codec
+	}
+	inferred, ok := extensionCodecs[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return ""
+	}
+	if inferred == "pcm" {
+		inferred = wavCodec(format)
+	}
+	if checkEncoder(inferred) != nil {
+		return ""
+	}
+	return inferred
+}
+
+// Returns the PCM encoder for WAV files closest to the sample format. WAV files store samples
+// in little endian byte order, and only support unsigned samples with 8 bits.
+func wavCodec(format string) string {
+	switch sampleType(format) {
+	case "u8", "s8":
+		return "pcm_u8"
+	case "u16", "s16":
+		return "pcm_s16le"
+	case "u24", "s24":
+		return "pcm_s24le"
+	case "u32", "s32":
+		return "pcm_s32le"
+	default:
+		return "pcm_" + sampleType(format) + "le"
+	}
+}
+
+// Returns the sample rate the encoder encodes audio with the given sample rate at, which
+// differs if the encoder does not support the sample rate.
+func codecSampleRate(codec string, samplerate int) int {
+	supported, ok := codecSampleRates[codec]
+	if !ok {
+		return samplerate
+	}
+	for _, rate := range supported {
+		if rate == samplerate {
+			return samplerate
+		}
+	}
+	return supported[len(supported)-1]
+}
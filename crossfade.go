@@ -0,0 +1,70 @@
+package aio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Joins two clips of interleaved samples, fading out the last overlapFrames frames of a while
+// fading in the first overlapFrames frames of b. The curve is either "linear", where the gains
+// of both clips add up to 1, or "equal-power", where the power of both clips adds up to 1, which
+// keeps the loudness of uncorrelated audio constant. Both clips must have the same type, and the
+// result has that type as well. Byte slices are treated as samples in the "u8" format, and mixed
+// samples of integer formats saturate. An overlap of 0 concatenates the clips.
+func Crossfade(a, b interface{}, overlapFrames, channels int, curve string) (interface{}, error) {
+	format := typedFormat(a)
+	if format == "" {
+		return nil, fmt.Errorf("invalid sample data type: %T", a)
+	}
+	if other := typedFormat(b); other != format {
+		return nil, fmt.Errorf("sample data types do not match: %T and %T", a, b)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid number of channels: %d, must be positive", channels)
+	}
+
+	var fadeOut, fadeIn func(t float64) float64
+	switch curve {
+	case "linear":
+		fadeOut = func(t float64) float64 { return 1 - t }
+		fadeIn = func(t float64) float64 { return t }
+	case "equal-power":
+		fadeOut = func(t float64) float64 { return math.Cos(t * math.Pi / 2) }
+		fadeIn = func(t float64) float64 { return math.Sin(t * math.Pi / 2) }
+	default:
+		return nil, fmt.Errorf("invalid crossfade curve: %q, must be \"linear\" or \"equal-power\"", curve)
+	}
+
+	first, second := samplesToBytes(a), samplesToBytes(b)
+	codec := newSampleCodec(format)
+	frame := codec.size * channels
+	if len(first)%frame != 0 || len(second)%frame != 0 {
+		return nil, fmt.Errorf("number of samples must be a multiple of the %d channels", channels)
+	}
+	if overlapFrames < 0 {
+		return nil, fmt.Errorf("invalid overlap: %d frames, must be non-negative", overlapFrames)
+	}
+	if overlapFrames > len(first)/frame || overlapFrames > len(second)/frame {
+		return nil, fmt.Errorf(
+			"overlap of %d frames is longer than the clips of %d and %d frames",
+			overlapFrames, len(first)/frame, len(second)/frame,
+		)
+	}
+
+	overlap := overlapFrames * frame
+	size := (len(first) + len(second) - overlap) / codec.size
+	result := makeSamples(format, size)
+	buffer := samplesToBytes(result)
+
+	start := len(first) - overlap
+	copy(buffer, first[:start])
+	for i := 0; i < overlap; i += codec.size {
+		// Gains are taken at the middle of each frame, so that both clips are faded symmetrically.
+		t := (float64(i/frame) + 0.5) / float64(overlapFrames)
+		value := codec.decode(first[start+i:])*fadeOut(t) + codec.decode(second[i:])*fadeIn(t)
+		codec.encode(buffer[start+i:], value)
+	}
+	copy(buffer[len(first):], second[overlap:])
+
+	return result, nil
+}
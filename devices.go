@@ -0,0 +1,104 @@
+package aio
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Change of the microphones connected to the machine, as sent by WatchDevices.
+type DeviceEvent struct {
+	Type  string // "added", "removed" or "error".
+	Name  string // Name of the device, as returned by ListMicrophones.
+	Index int    // Stream index of an added device, or the previous index of a removed device.
+	Err   error  // Error listing the devices for "error" events.
+}
+
+// Watches the microphones connected to the machine, listing them every interval. The current
+// microphones are sent as "added" events first, followed by an event for every microphone that
+// is added or removed later. Lists without changes send no events. If the devices cannot be
+// listed, an "error" event is sent and the watcher keeps polling. The channel is closed once
+// the context is done.
+func WatchDevices(ctx context.Context, interval time.Duration) (<-chan DeviceEvent, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %v, must be positive", interval)
+	}
+	if _, err := microphone(); err != nil {
+		return nil, err
+	}
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+
+	events := make(chan DeviceEvent, 16)
+	go func() {
+		defer close(events)
+
+		send := func(event DeviceEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		previous := []string{}
+		for {
+			devices, err := getMicrophones()
+			if err != nil {
+				if !send(DeviceEvent{Type: "error", Index: -1, Err: err}) {
+					return
+				}
+			} else {
+				for _, event := range deviceChanges(previous, devices) {
+					if !send(event) {
+						return
+					}
+				}
+				previous = devices
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Returns the events for the devices removed from and added to the list of devices.
+// Devices with the same name are matched in order.
+func deviceChanges(previous, current []string) []DeviceEvent {
+	count := map[string]int{}
+	for _, name := range current {
+		count[name]++
+	}
+	events := []DeviceEvent{}
+	for i, name := range previous {
+		if count[name] > 0 {
+			count[name]--
+		} else {
+			events = append(events, DeviceEvent{Type: "removed", Name: name, Index: i})
+		}
+	}
+
+	count = map[string]int{}
+	for _, name := range previous {
+		count[name]++
+	}
+	for i, name := range current {
+		if count[name] > 0 {
+			count[name]--
+		} else {
+			events = append(events, DeviceEvent{Type: "added", Name: name, Index: i})
+		}
+	}
+	return events
+}
@@ -0,0 +1,57 @@
+package aio
+
+import (
+	"math"
+	"time"
+)
+
+// ffmpeg filter used by Microphone with Options.DriftCompensation. The timestamps of device
+// inputs come from the system clock, so stretching the audio to match them keeps the recording
+// locked to real time. Up to 100 samples per second are inserted or dropped, enough for a
+// device clock that is off by 2000 ppm at 48 kHz.
+const driftFilter = "aresample=async=100:first_pts=0"
+
+// Drift of a Microphone clock relative to the wall clock, estimated from the audio read so far.
+// The estimate is only accurate if the audio is read as fast as it is captured, and improves
+// the longer the recording runs. The aresample filter used by Options.DriftCompensation does not
+// report the samples it inserts or drops, so they are estimated from the difference between the
+// frames read and the sample rate times Elapsed, which is the gap the filter closes. The frames
+// read are the compensated audio, not the number of frames the device captured.
+type Drift struct {
+	Frames     int64         // Number of frames read since the recording started.
+	Elapsed    time.Duration // Wall clock time from the start of the recording to the end of the last read.
+	Offset     time.Duration // Duration of the audio read minus Elapsed, positive if the device clock runs fast.
+	SampleRate float64       // Sample rate of the device measured against the wall clock, in Hz.
+	Inserted   int64         // Estimated number of frames inserted by Options.DriftCompensation to catch up with the wall clock.
+	Dropped    int64         // Estimated number of frames dropped by Options.DriftCompensation to fall back to the wall clock.
+}
+
+// Returns the drift of the microphone clock relative to the wall clock. With
+// Options.DriftCompensation, ffmpeg corrects the drift, so the offset should stay close to the
+// latency of the device, SampleRate measures the compensated audio rather than the device, and
+// Inserted or Dropped estimate the frames ffmpeg inserts or drops. Returns the zero Drift before
+// the first read.
+func (mic *Microphone) Drift() Drift {
+	mic.mutex.Lock()
+	defer mic.mutex.Unlock()
+
+	drift := Drift{Frames: mic.frames}
+	if mic.frames == 0 {
+		return drift
+	}
+	drift.Elapsed = mic.last.Sub(mic.started)
+	captured := time.Duration(mic.frames) * time.Second / time.Duration(mic.samplerate)
+	drift.Offset = captured - drift.Elapsed
+	if drift.Elapsed > 0 {
+		drift.SampleRate = float64(mic.frames) / drift.Elapsed.Seconds()
+	}
+	if mic.drift {
+		expected := int64(math.Round(drift.Elapsed.Seconds() * float64(mic.samplerate)))
+		if gap := expected - mic.frames; gap > 0 {
+			drift.Inserted = gap
+		} else {
+			drift.Dropped = -gap
+		}
+	}
+	return drift
+}
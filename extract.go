@@ -0,0 +1,154 @@
+package aio
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Muxers used by ExtractAudio for output files with these extensions.
+var extractMuxers = map[string]string{
+	".m4a":  "ipod",
+	".m4b":  "ipod",
+	".mp4":  "mp4",
+	".aac":  "adts",
+	".mp3":  "mp3",
+	".opus": "opus",
+	".ogg":  "ogg",
+	".oga":  "ogg",
+	".flac": "flac",
+	".wav":  "wav",
+	".ac3":  "ac3",
+	".webm": "webm",
+	".mka":  "matroska",
+	".mkv":  "matroska",
+}
+
+// Codecs that each muxer can hold without re-encoding. Matroska holds any audio codec.
+var copyCodecs = map[string][]string{
+	"ipod": {"aac", "alac"},
+	"mp4":  {"aac", "alac", "mp3", "ac3", "eac3", "opus", "flac"},
+	"adts": {"aac"},
+	"mp3":  {"mp3"},
+	"opus": {"opus"},
+	"ogg":  {"vorbis", "opus", "flac"},
+	"flac": {"flac"},
+	"wav":  {"pcm_u8", "pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_f64le", "pcm_alaw", "pcm_mulaw"},
+	"ac3":  {"ac3"},
+	"webm": {"opus", "vorbis"},
+}
+
+// Extensions suggested for the muxers chosen from a codec.
+var muxerExtensions = map[string]string{
+	"adts":     ".aac",
+	"mp3":      ".mp3",
+	"ogg":      ".ogg",
+	"flac":     ".flac",
+	"wav":      ".wav",
+	"matroska": ".mka",
+}
+
+// Returns true if audio encoded with the codec can be copied into the muxer.
+func canCopy(muxer, codec string) bool {
+	if muxer == "matroska" {
+		return true
+	}
+	for _, supported := range copyCodecs[muxer] {
+		if supported == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the muxer best suited to hold audio encoded with the codec, the same as used by
+// StreamHandler, or Matroska if that muxer cannot hold the codec.
+func codecMuxer(codec string) string {
+	if muxer, _ := streamContainer(codec); canCopy(muxer, codec) {
+		return muxer
+	}
+	return "matroska"
+}
+
+// Returns the muxer used to copy audio encoded with the codec into the output file. Known
+// extensions decide the muxer, and an error explains why the codec does not fit into it.
+// For other extensions, the muxer is chosen from the codec.
+func copyMuxer(filename, codec string) (string, error) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	muxer, ok := extractMuxers[extension]
+	if !ok {
+		return codecMuxer(codec), nil
+	}
+	if !canCopy(muxer, codec) {
+		return "", fmt.Errorf(
+			"%s audio cannot be copied into a %s file without re-encoding, use %s instead",
+			codec, extension, muxerExtensions[codecMuxer(codec)],
+		)
+	}
+	return muxer, nil
+}
+
+// Copies the audio stream with the given index out of the input file into the output file,
+// without decoding or re-encoding it, e.g. the AAC audio of an MP4 video into an M4A file.
+// The container is chosen from the extension of the output file, and must be able to hold the
+// codec of the stream, otherwise an error suggests an extension that can. For extensions that
+// do not name a container, the container is chosen from the codec: ADTS for AAC, Ogg for Opus
+// and Vorbis, and Matroska for codecs without a container of their own. If ffmpeg fails, the
+// output file is removed and the error includes the output of ffmpeg.
+func ExtractAudio(infile, outfile string, stream int) error {
+	if err := installed("ffmpeg"); err != nil {
+		return err
+	}
+	if outfile == "" {
+		return &OptionError{"outfile", `""`, "must not be empty"}
+	}
+
+	probe, err := ProbeAudio(infile)
+	if err != nil {
+		return err
+	}
+	streams := probe.AudioStreams()
+	if stream < 0 || stream >= len(streams) {
+		return fmt.Errorf("invalid stream index: %d, must be between 0 and %d", stream, len(streams))
+	}
+	codec := streams[stream].Codec
+	if codec == "" {
+		return fmt.Errorf("codec of audio stream %d of %s is unknown", stream, infile)
+	}
+
+	muxer, err := copyMuxer(outfile, codec)
+	if err != nil {
+		return err
+	}
+
+	// ffmpeg would truncate the input before reading it.
+	if input, err := filepath.Abs(infile); err == nil {
+		if output, err := filepath.Abs(outfile); err == nil && input == output {
+			return fmt.Errorf("cannot extract audio from %s into itself", infile)
+		}
+	}
+
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-y",
+		"-i", localInput(infile),
+		"-map", fmt.Sprintf("0:a:%d", stream),
+		"-c", "copy",
+		"-f", muxer,
+		localInput(outfile),
+	)
+	logCommand(cmd)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(outfile)
+		return fmt.Errorf("ffmpeg could not extract audio from %s: %w: %s", infile, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
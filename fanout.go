@@ -0,0 +1,142 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Sink wrapped by Dropping, see Fanout.
+type droppingSink struct {
+	Sink
+	queue int // Number of buffers that can be waiting to be written to the sink.
+}
+
+// Wraps the sink so that Fanout drops audio for it instead of waiting when it falls behind.
+// Up to queue buffers are kept for the sink, and any buffer read while the queue is full is
+// not written to it. Other functions write to the sink as usual.
+func Dropping(sink Sink, queue int) Sink {
+	if queue < 1 {
+		queue = 1
+	}
+	return &droppingSink{Sink: sink, queue: queue}
+}
+
+// A sink written to by Fanout, with the buffers waiting to be written to it.
+type fanoutBranch struct {
+	sink   Sink        // The sink, without the Dropping wrapper.
+	format string      // Format of the raw audio data of the sink.
+	drop   bool        // Flag storing whether buffers are dropped when the queue is full.
+	queue  chan []byte // Converted buffers waiting to be written to the sink.
+}
+
+// Copies the audio from the source to every sink in the background, converting the samples
+// to the format of each sink. Every sink gets its own copy of each buffer and is written to
+// from its own goroutine. A sink that falls behind makes Fanout wait for it, unless it is
+// wrapped with Dropping. Copying ends once the source ends, a sink fails or stop is called,
+// after which the source and all sinks are closed. Buffers read before the source ends are
+// still written to the sinks, while buffers not yet written when stop is called or a sink
+// fails are discarded. Errors are sent on the returned channel, which is closed once all
+// sinks have been closed. As with Pipe, the channels and sample rate of every sink must
+// match those of the source.
+func Fanout(source Source, sinks ...Sink) (stop func(), errs <-chan error) {
+	failures := make(chan error, len(sinks)+1)
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+
+	branches := make([]*fanoutBranch, len(sinks))
+	for i, sink := range sinks {
+		branch := &fanoutBranch{sink: sink, queue: make(chan []byte, 1)}
+		if dropping, ok := sink.(*droppingSink); ok {
+			branch.sink = dropping.Sink
+			branch.drop = true
+			branch.queue = make(chan []byte, dropping.queue)
+		}
+		branch.format = byteFormat(branch.sink)
+		branches[i] = branch
+	}
+
+	var wg sync.WaitGroup
+	for i, branch := range branches {
+		wg.Add(1)
+		go func(i int, branch *fanoutBranch) {
+			defer wg.Done()
+			defer branch.sink.Close()
+			for buffer := range branch.queue {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+				if err := branch.sink.Write(buffer); err != nil {
+					failures <- fmt.Errorf("writing audio to sink %d: %w", i, err)
+					stop()
+				}
+			}
+		}(i, branch)
+	}
+
+	go func() {
+		defer close(failures)
+		defer wg.Wait()
+		defer func() {
+			for _, branch := range branches {
+				close(branch.queue)
+			}
+		}()
+		defer source.Close()
+
+		for i, branch := range branches {
+			if err := checkSink(source, branch.sink); err != nil {
+				failures <- fmt.Errorf("sink %d: %w", i, err)
+				return
+			}
+		}
+
+		from := byteFormat(source)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			frame, err := source.ReadFrame()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				failures <- fmt.Errorf("reading audio: %w", err)
+				return
+			}
+
+			for _, branch := range branches {
+				var buffer []byte
+				if from != branch.format {
+					buffer = convertBuffer(frame.Data, from, branch.format)
+				} else {
+					buffer = make([]byte, len(frame.Data))
+					copy(buffer, frame.Data)
+				}
+
+				if branch.drop {
+					select {
+					case branch.queue <- buffer:
+					default:
+					}
+					continue
+				}
+				select {
+				case branch.queue <- buffer:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return stop, failures
+}
@@ -0,0 +1,424 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of bytes of filtered audio kept until it is read. Once this much output is
+// waiting, ffmpeg and Write wait for it to be read.
+const maxFilterOutput = 1 << 20
+
+type FilterGraph struct {
+	filter        string         // ffmpeg audio filter graph.
+	channels      int            // Number of channels of the input.
+	samplerate    int            // Sample rate of the input in Hz.
+	format        string         // Format of the input samples.
+	outchannels   int            // Number of channels of the output.
+	outsamplerate int            // Sample rate of the output in Hz.
+	outformat     string         // Format of the output samples.
+	buffer        []byte         // Raw audio data of the last Read.
+	output        []byte         // Filtered audio waiting to be read.
+	written       int            // Number of frames written to the filter graph.
+	produced      int            // Number of frames produced by the filter graph.
+	loglevel      string         // ffmpeg log level when logging is enabled.
+	nice          int            // Niceness of the ffmpeg process.
+	cmd           *exec.Cmd      // ffmpeg command.
+	pipe          io.WriteCloser // Stdin pipe for ffmpeg process.
+	log           *ffmpegLog     // Errors written by ffmpeg.
+	closed        bool           // Flag storing whether the input has been closed.
+	processing    int            // Number of calls to Process in progress, which collect all output themselves.
+	ended         bool           // Flag storing whether ffmpeg has exited and all output has been received.
+	err           error          // Error of the ffmpeg process once it has exited.
+	done          chan struct{}  // Closed once all output has been received.
+	mutex         sync.Mutex     // Mutex guarding the state against concurrent calls.
+	available     *sync.Cond     // Signals new output, read output, the end of the output and closing.
+}
+
+func (graph *FilterGraph) Filter() string {
+	return graph.filter
+}
+
+// Sample rate of the output in Hz.
+func (graph *FilterGraph) SampleRate() int {
+	return graph.outsamplerate
+}
+
+// Number of channels of the output.
+func (graph *FilterGraph) Channels() int {
+	return graph.outchannels
+}
+
+// Format of the output samples.
+func (graph *FilterGraph) Format() string {
+	switch graph.outformat {
+	case "u8", "s8":
+		return graph.outformat
+	default:
+		return graph.outformat[:len(graph.outformat)-2]
+	}
+}
+
+// Bits per output sample.
+func (graph *FilterGraph) BitsPerSample() int {
+	return newSampleCodec(graph.outformat).size * 8
+}
+
+// Number of bytes in one frame of output.
+func (graph *FilterGraph) BytesPerFrame() int {
+	return newSampleCodec(graph.outformat).size * graph.outchannels
+}
+
+// Number of bytes in one second of output.
+func (graph *FilterGraph) BytesPerSecond() int {
+	return graph.outsamplerate * graph.BytesPerFrame()
+}
+
+// Sample rate of the input in Hz.
+func (graph *FilterGraph) InputSampleRate() int {
+	return graph.samplerate
+}
+
+// Number of channels of the input.
+func (graph *FilterGraph) InputChannels() int {
+	return graph.channels
+}
+
+// Format of the input samples.
+func (graph *FilterGraph) InputFormat() string {
+	switch graph.format {
+	case "u8", "s8":
+		return graph.format
+	default:
+		return graph.format[:len(graph.format)-2]
+	}
+}
+
+// Raw audio data of the last Read.
+func (graph *FilterGraph) Buffer() []byte {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	return graph.buffer
+}
+
+// Casts the values in the byte buffer to those specified by the output format.
+func (graph *FilterGraph) Samples() interface{} {
+	buffer := graph.Buffer()
+	return bytesToSamples(buffer, len(buffer)/newSampleCodec(graph.outformat).size, graph.outformat)
+}
+
+// Sets the buffer filled by Read. The length of the buffer must be a multiple of the
+// output frame size.
+func (graph *FilterGraph) SetBuffer(buffer []byte) error {
+	if len(buffer)%graph.BytesPerFrame() != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", graph.BytesPerFrame())
+	}
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	graph.buffer = buffer
+	return nil
+}
+
+// Amount of audio that has been written to the filter graph but has not come out of it yet,
+// which includes the delay of filters such as loudnorm and the audio buffered by ffmpeg.
+func (graph *FilterGraph) Latency() time.Duration {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	in := float64(graph.written) / float64(graph.samplerate)
+	out := float64(graph.produced) / float64(graph.outsamplerate)
+	if in <= out {
+		return 0
+	}
+	return time.Duration((in - out) * float64(time.Second))
+}
+
+// Returns the error of the ffmpeg process once it has exited, e.g. for an invalid filter graph.
+func (graph *FilterGraph) Error() error {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	return graph.err
+}
+
+// Creates a filter graph processing audio with the given number of channels, sample rate and
+// format with the ffmpeg audio filter graph, e.g. "afftdn,loudnorm". The output has the
+// channels, sample rate and format of the options, or those of the input if they are not set.
+// A single ffmpeg process filters all audio, and is started on the first call to Write.
+func NewFilterGraph(channels, samplerate int, format, filter string, options *Options) (*FilterGraph, error) {
+	options = withDefaults(options)
+
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if filter == "" {
+		return nil, &OptionError{"filter", `""`, "must not be empty"}
+	}
+	if err := options.validate("NewFilterGraph"); err != nil {
+		return nil, err
+	}
+
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if err := checkFilters(filter); err != nil {
+		return nil, err
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &FilterGraph{
+		filter:        filter,
+		channels:      channels,
+		samplerate:    samplerate,
+		format:        format,
+		outchannels:   channels,
+		outsamplerate: samplerate,
+		outformat:     format,
+		loglevel:      options.LogLevel,
+		nice:          options.Nice,
+		done:          make(chan struct{}),
+	}
+	graph.available = sync.NewCond(&graph.mutex)
+
+	if options.Channels != 0 {
+		graph.outchannels = options.Channels
+	}
+	if options.SampleRate != 0 {
+		graph.outsamplerate = options.SampleRate
+	}
+	if options.Format != "" {
+		if graph.outformat, err = orderFormat(options.Format, options.Endianness); err != nil {
+			return nil, err
+		}
+	}
+
+	return graph, nil
+}
+
+// Once the user calls Write() for the first time on a FilterGraph struct,
+// the ffmpeg command which is used to filter the audio is started.
+func (graph *FilterGraph) init() error {
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", logLevel(graph.loglevel, "error"),
+		"-f", graph.format,
+		"-ar", fmt.Sprintf("%d", graph.samplerate),
+		"-ac", fmt.Sprintf("%d", graph.channels),
+		"-i", "-", // The input comes from stdin.
+		"-af", graph.filter,
+		"-f", graph.outformat,
+		"-ar", fmt.Sprintf("%d", graph.outsamplerate),
+		"-ac", fmt.Sprintf("%d", graph.outchannels),
+		"-flush_packets", "1",
+		"-",
+	)
+
+	graph.log = &ffmpegLog{limit: maxErrorLog}
+	cmd.Stderr = logOutput(cmd, graph.loglevel, graph.log)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := startNice(cmd, graph.nice); err != nil {
+		return err
+	}
+	graph.cmd = cmd
+	graph.pipe = stdin
+	register(cmd, graph.Close)
+
+	// The output is always read, so that ffmpeg never blocks on a full stdout pipe while
+	// Write waits for it to read more input.
+	go graph.receive(stdout)
+
+	return nil
+}
+
+// Collects the output of ffmpeg until it exits.
+func (graph *FilterGraph) receive(stdout io.Reader) {
+	chunk := make([]byte, 32*1024)
+	frame := graph.BytesPerFrame()
+	received := 0
+	for {
+		n, err := stdout.Read(chunk)
+		if n > 0 {
+			graph.mutex.Lock()
+			// Reading stops while the output is full, which makes ffmpeg wait as well. Once the
+			// input is closed, the rest of the audio is collected so that Close can return.
+			for len(graph.output) >= maxFilterOutput && graph.processing == 0 && !graph.closed {
+				graph.available.Wait()
+			}
+			graph.output = append(graph.output, chunk[:n]...)
+			received += n
+			graph.produced = received / frame
+			graph.available.Broadcast()
+			graph.mutex.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err := graph.cmd.Wait()
+	unregister(graph.cmd)
+
+	graph.mutex.Lock()
+	if err != nil {
+		if message := strings.TrimSpace(graph.log.String()); message != "" {
+			graph.err = fmt.Errorf("ffmpeg could not filter the audio: %s", message)
+		} else {
+			graph.err = fmt.Errorf("ffmpeg could not filter the audio: %w", err)
+		}
+	}
+	graph.ended = true
+	graph.available.Broadcast()
+	graph.mutex.Unlock()
+	close(graph.done)
+}
+
+// Returns the stdin pipe of the ffmpeg process, starting the process if it is not running yet.
+func (graph *FilterGraph) start() (io.WriteCloser, error) {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	if graph.closed {
+		return nil, fmt.Errorf("filter graph is closed")
+	}
+	if graph.cmd == nil {
+		if err := graph.init(); err != nil {
+			return nil, err
+		}
+	}
+	return graph.pipe, nil
+}
+
+// Writes the samples to the filter graph. Byte slices hold samples in the input format,
+// while other sample slices must match the input format. The output is collected in the
+// background, so Write only waits for it to be read once 1 MiB of output is waiting.
+func (graph *FilterGraph) Write(samples interface{}) error {
+	buffer := samplesToFormat(samples, graph.format)
+	if buffer == nil {
+		return fmt.Errorf("invalid sample data type")
+	}
+	frame := newSampleCodec(graph.format).size * graph.channels
+	if len(buffer)%frame != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", frame)
+	}
+
+	pipe, err := graph.start()
+	if err != nil {
+		return err
+	}
+
+	graph.mutex.Lock()
+	for len(graph.output) >= maxFilterOutput && graph.processing == 0 && !graph.ended && !graph.closed {
+		graph.available.Wait()
+	}
+	graph.mutex.Unlock()
+
+	if _, err := pipe.Write(buffer); err != nil {
+		// Wait for ffmpeg to exit, so that its error can be returned.
+		<-graph.done
+		if failure := graph.Error(); failure != nil {
+			return failure
+		}
+		return err
+	}
+
+	graph.mutex.Lock()
+	graph.written += len(buffer) / frame
+	graph.mutex.Unlock()
+	return nil
+}
+
+// Fills the buffer with the next filtered audio, waiting until enough audio has come out of
+// the filter graph. Once the graph has been closed, the last buffer may be shorter. Returns
+// false once all audio has been read.
+func (graph *FilterGraph) Read() bool {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+
+	if graph.buffer == nil {
+		graph.buffer = make([]byte, graph.BytesPerSecond())
+	}
+	for len(graph.output) < len(graph.buffer) && !graph.ended {
+		if graph.closed && graph.cmd == nil {
+			return false
+		}
+		graph.available.Wait()
+	}
+
+	n := len(graph.output)
+	if n > len(graph.buffer) {
+		n = len(graph.buffer)
+	}
+	n -= n % graph.BytesPerFrame()
+	if n == 0 {
+		return false
+	}
+
+	graph.buffer = graph.buffer[:n]
+	copy(graph.buffer, graph.output)
+	graph.output = graph.output[:copy(graph.output, graph.output[n:])]
+	// Wake up ffmpeg and Write if they wait for the output to be read.
+	graph.available.Broadcast()
+	return true
+}
+
+// Writes the samples to the filter graph and returns all filtered audio that has come out of
+// it so far, without waiting for more. The returned samples have the type matching the output
+// format, and hold nothing while the filter graph has not produced any audio yet.
+func (graph *FilterGraph) Process(samples interface{}) (interface{}, error) {
+	// The output is not limited while Process writes, since it only reads the output once
+	// all samples have been written.
+	graph.mutex.Lock()
+	graph.processing++
+	graph.mutex.Unlock()
+
+	err := graph.Write(samples)
+
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	graph.processing--
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(graph.output) - len(graph.output)%graph.BytesPerFrame()
+	buffer := make([]byte, n)
+	copy(buffer, graph.output)
+	graph.output = graph.output[:copy(graph.output, graph.output[n:])]
+	graph.available.Broadcast()
+	return bytesToSamples(buffer, n/newSampleCodec(graph.outformat).size, graph.outformat), nil
+}
+
+// Closes the input of the filter graph and waits for ffmpeg to filter all audio written to it.
+// Audio that has not been read yet can still be read with Read. Safe to call from any goroutine.
+func (graph *FilterGraph) Close() {
+	graph.mutex.Lock()
+	graph.closed = true
+	graph.available.Broadcast()
+	started := graph.cmd != nil
+	graph.mutex.Unlock()
+
+	if !started {
+		return
+	}
+	graph.pipe.Close()
+	<-graph.done
+}
@@ -0,0 +1,130 @@
+package aio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Builds an ffmpeg audio filter graph from typed filters, e.g.
+// aio.Filters().Volume(0.8).HighPass(80).ATempo(1.25).Loudnorm(-16).Build().
+// Parameters are validated when a filter is added, and the first invalid parameter is
+// returned by Build.
+type FilterChain struct {
+	filters []string // Filters of the chain in order.
+	err     error    // First invalid parameter of the chain.
+}
+
+// Creates an empty filter chain.
+func Filters() *FilterChain {
+	return &FilterChain{}
+}
+
+// Returns the filter graph for Options.Filter or NewFilterGraph, or the first invalid parameter.
+// An empty chain returns an empty filter graph.
+func (chain *FilterChain) Build() (string, error) {
+	if chain.err != nil {
+		return "", chain.err
+	}
+	return strings.Join(chain.filters, ","), nil
+}
+
+// Adds the filter with the given name and options, given as pairs of keys and values.
+// Values are escaped.
+func (chain *FilterChain) add(name string, options ...string) *FilterChain {
+	pairs := make([]string, 0, len(options)/2)
+	for i := 0; i+1 < len(options); i += 2 {
+		pairs = append(pairs, options[i]+"="+Escape(options[i+1]))
+	}
+	if len(pairs) > 0 {
+		name += "=" + strings.Join(pairs, ":")
+	}
+	chain.filters = append(chain.filters, name)
+	return chain
+}
+
+// Keeps the first invalid parameter of the chain.
+func (chain *FilterChain) fail(format string, args ...interface{}) *FilterChain {
+	if chain.err == nil {
+		chain.err = fmt.Errorf(format, args...)
+	}
+	return chain
+}
+
+// Multiplies the amplitude by the gain, e.g. 0.5 for half the amplitude.
+func (chain *FilterChain) Volume(gain float64) *FilterChain {
+	if !(gain >= 0) || math.IsInf(gain, 1) {
+		return chain.fail("invalid volume: %v, must be non-negative", gain)
+	}
+	return chain.add("volume", "volume", formatFloat(gain))
+}
+
+// Attenuates frequencies below the cutoff frequency in Hz.
+func (chain *FilterChain) HighPass(frequency float64) *FilterChain {
+	if !(frequency > 0 && frequency <= 999999) {
+		return chain.fail("invalid high-pass frequency: %v Hz, must be between 0 and 999999 Hz", frequency)
+	}
+	return chain.add("highpass", "f", formatFloat(frequency))
+}
+
+// Attenuates frequencies above the cutoff frequency in Hz.
+func (chain *FilterChain) LowPass(frequency float64) *FilterChain {
+	if !(frequency > 0 && frequency <= 999999) {
+		return chain.fail("invalid low-pass frequency: %v Hz, must be between 0 and 999999 Hz", frequency)
+	}
+	return chain.add("lowpass", "f", formatFloat(frequency))
+}
+
+// Changes the speed of the audio without changing its pitch, e.g. 1.25 to play it 25% faster.
+func (chain *FilterChain) ATempo(tempo float64) *FilterChain {
+	if !(tempo >= 0.5 && tempo <= 100) {
+		return chain.fail("invalid tempo: %v, must be between 0.5 and 100", tempo)
+	}
+	return chain.add("atempo", "tempo", formatFloat(tempo))
+}
+
+// Normalizes the loudness to the integrated loudness target in LUFS, e.g. -16 for podcasts.
+func (chain *FilterChain) Loudnorm(integrated float64) *FilterChain {
+	if !(integrated >= -70 && integrated <= -5) {
+		return chain.fail("invalid loudness target: %v LUFS, must be between -70 and -5 LUFS", integrated)
+	}
+	return chain.add("loudnorm", "I", formatFloat(integrated))
+}
+
+// Adds a filter the chain does not model, e.g. "aecho=0.8:0.9:1000:0.3". Values containing
+// special characters must be escaped with Escape.
+func (chain *FilterChain) Raw(filter string) *FilterChain {
+	if strings.TrimSpace(filter) == "" {
+		return chain.fail("invalid filter: %q, must not be empty", filter)
+	}
+	chain.filters = append(chain.filters, filter)
+	return chain
+}
+
+// Escapes an option value so that it can be used in a filter graph as it is, e.g. the text of
+// "drawtext=text=" + aio.Escape("10:30, live"). Values are escaped twice, once for the options of
+// the filter and once for the filter graph.
+// https://ffmpeg.org/ffmpeg-filters.html#Notes-on-filtergraph-escaping.
+func Escape(value string) string {
+	// Whitespace is escaped as well, since ffmpeg removes it from the start and end of values.
+	return escape(escape(value, ": \t\n\r"), "[],; \t\n\r")
+}
+
+// Escapes the given characters along with backslashes and quotes using ffmpeg's escaping rules.
+// https://ffmpeg.org/ffmpeg-utils.html#Quoting-and-escaping.
+func escape(value, special string) string {
+	builder := strings.Builder{}
+	for _, c := range value {
+		if c == '\\' || c == '\'' || strings.ContainsRune(special, c) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// Formats the number without an exponent, which is understood by all filter options.
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,65 @@
+package aio
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Number of seconds of audio used for fingerprints, the same as fpcalc uses by default, so that
+// fingerprints can be compared with those in the AcoustID database.
+const fingerprintLength = 120
+
+// Returns the Chromaprint fingerprint of the audio stream with the given index, along with the
+// duration of the stream in seconds, as needed for AcoustID lookups. The fingerprint is computed
+// from the first 120 seconds of audio by the chromaprint muxer of ffmpeg, and encoded in the
+// compressed base64 format used by fpcalc. Returns an error if the installed ffmpeg lacks the
+// chromaprint muxer.
+func Fingerprint(filename string, stream int) (string, float64, error) {
+	if err := installed("ffmpeg"); err != nil {
+		return "", 0, err
+	}
+	if err := checkMuxer("chromaprint"); err != nil {
+		return "", 0, err
+	}
+
+	probe, err := ProbeAudio(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	streams := probe.AudioStreams()
+	if stream < 0 || stream >= len(streams) {
+		return "", 0, fmt.Errorf("invalid stream index: %d, must be between 0 and %d", stream, len(streams))
+	}
+	duration := streams[stream].Duration
+	if duration == 0 {
+		duration = probe.Format.Duration
+	}
+
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", localInput(filename),
+		"-map", fmt.Sprintf("0:a:%d", stream),
+		"-t", fmt.Sprintf("%d", fingerprintLength),
+		"-f", "chromaprint",
+		"-fp_format", "base64",
+		"-",
+	)
+	logCommand(cmd)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("ffmpeg could not fingerprint %s: %w: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+
+	fingerprint := strings.TrimSpace(string(output))
+	if fingerprint == "" {
+		return "", 0, fmt.Errorf("ffmpeg returned no fingerprint for %s", filename)
+	}
+	return fingerprint, duration, nil
+}
@@ -0,0 +1,10 @@
+package aio
+
+import "time"
+
+// A buffer of audio owned by the caller, as returned by ReadFrame.
+type Frame struct {
+	Data    []byte        // Raw audio data.
+	Samples int           // Number of samples per channel in the data.
+	PTS     time.Duration // Presentation timestamp of the first sample.
+}
@@ -0,0 +1,238 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// Returns the value of a generated signal, usually in the range [-1, 1], at the given time
+// in seconds for the given zero-indexed channel.
+type Signal func(t float64, channel int) float64
+
+// Sine wave with the given frequency in Hz and amplitude, where 1 is full scale.
+func Sine(frequency, amplitude float64) Signal {
+	return func(t float64, channel int) float64 {
+		return amplitude * math.Sin(2*math.Pi*frequency*t)
+	}
+}
+
+// White noise with the given amplitude, where 1 is full scale. The noise is seeded with a
+// fixed value, so the same noise is generated every time.
+func WhiteNoise(amplitude float64) Signal {
+	random := rand.New(rand.NewSource(1))
+	return func(t float64, channel int) float64 {
+		return amplitude * (random.Float64()*2 - 1)
+	}
+}
+
+// Pink noise with the given amplitude, where 1 is full scale. The power of pink noise falls by
+// 3 dB per octave, so it sounds more balanced than white noise. The noise is seeded with a
+// fixed value, so the same noise is generated every time.
+func PinkNoise(amplitude float64) Signal {
+	random := rand.New(rand.NewSource(1))
+	// Filter state for each channel, see https://www.firstpr.com.au/dsp/pink-noise/.
+	state := [][7]float64{}
+	return func(t float64, channel int) float64 {
+		for len(state) <= channel {
+			state = append(state, [7]float64{})
+		}
+		b := &state[channel]
+		white := random.Float64()*2 - 1
+		b[0] = 0.99886*b[0] + white*0.0555179
+		b[1] = 0.99332*b[1] + white*0.0750759
+		b[2] = 0.96900*b[2] + white*0.1538520
+		b[3] = 0.86650*b[3] + white*0.3104856
+		b[4] = 0.55000*b[4] + white*0.5329522
+		b[5] = -0.7616*b[5] - white*0.0168980
+		pink := b[0] + b[1] + b[2] + b[3] + b[4] + b[5] + b[6] + white*0.5362
+		b[6] = white * 0.115926
+		// Scale the noise to roughly the range [-1, 1].
+		return amplitude * math.Max(-1, math.Min(1, pink*0.11))
+	}
+}
+
+// Silence on all channels.
+func Silence() Signal {
+	return func(t float64, channel int) float64 {
+		return 0
+	}
+}
+
+type Generator struct {
+	samplerate int     // Audio Sample Rate in Hz.
+	channels   int     // Number of audio channels.
+	format     string  // Format of audio samples.
+	bps        int     // Bits per sample.
+	duration   float64 // Duration of the generated audio in seconds, 0 if it never ends.
+	signal     Signal  // Function generating the samples.
+	frame      int     // Index of the next frame to generate.
+	ended      bool    // Flag storing whether all audio has been generated.
+	buffer     []byte  // Raw audio data.
+}
+
+// Audio Sample Rate in Hz.
+func (generator *Generator) SampleRate() int {
+	return generator.samplerate
+}
+
+func (generator *Generator) Channels() int {
+	return generator.channels
+}
+
+func (generator *Generator) BitsPerSample() int {
+	return generator.bps
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (generator *Generator) BytesPerFrame() int {
+	return generator.bps / 8 * generator.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (generator *Generator) SamplesPerFrame() int {
+	return generator.channels
+}
+
+// Number of bytes in one second of audio.
+func (generator *Generator) BytesPerSecond() int {
+	return generator.samplerate * generator.BytesPerFrame()
+}
+
+// Duration of the generated audio in seconds. 0 means audio is generated indefinitely.
+func (generator *Generator) Duration() float64 {
+	return generator.duration
+}
+
+func (generator *Generator) Format() string {
+	switch generator.format {
+	case "u8", "s8":
+		return generator.format
+	default:
+		return generator.format[:len(generator.format)-2]
+	}
+}
+
+func (generator *Generator) Buffer() []byte {
+	return generator.buffer
+}
+
+// Casts the values in the byte buffer to those specified by the audio format.
+func (generator *Generator) Samples() interface{} {
+	return bytesToSamples(generator.buffer, len(generator.buffer)/(generator.bps/8), generator.format)
+}
+
+// Sets the buffer to the given byte array. The length of the buffer must be a multiple
+// of (bytes per sample * audio channels).
+func (generator *Generator) SetBuffer(buffer []byte) error {
+	if len(buffer)%generator.BytesPerFrame() != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", generator.BytesPerFrame())
+	}
+	generator.buffer = buffer
+	return nil
+}
+
+// Creates a generator producing the signal for the given duration in seconds, or indefinitely
+// if the duration is 0. The sample rate (44100 Hz), channels (2) and format (s16) can be
+// changed with the options.
+func NewGenerator(signal Signal, duration float64, options *Options) (*Generator, error) {
+	options = withDefaults(options)
+
+	if err := options.validate("NewGenerator"); err != nil {
+		return nil, err
+	}
+
+	if signal == nil {
+		return nil, fmt.Errorf("signal must not be nil")
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("invalid duration: %v, must be non-negative", duration)
+	}
+
+	generator := &Generator{
+		samplerate: 44100,
+		channels:   2, // Stereo by default.
+		duration:   duration,
+		signal:     signal,
+	}
+
+	if options.SampleRate != 0 {
+		generator.samplerate = options.SampleRate
+	}
+	if options.Channels != 0 {
+		generator.channels = options.Channels
+	}
+
+	format := "s16" // s16 default format.
+	if options.Format != "" {
+		format = options.Format
+	}
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+	generator.format = format
+	generator.bps = int(parse(regexp.MustCompile(`\d{1,2}`).FindString(format))) // Bits per sample.
+
+	return generator, nil
+}
+
+// Generates the next frames of audio and stores them in the buffer. If the generator has a
+// duration and all audio has been generated, returns false, otherwise true.
+func (generator *Generator) Read() bool {
+	if generator.ended {
+		return false
+	}
+
+	if generator.buffer == nil {
+		generator.buffer = make([]byte, generator.BytesPerSecond())
+	}
+
+	frames := len(generator.buffer) / generator.BytesPerFrame()
+	if generator.duration > 0 {
+		total := int(math.Round(generator.duration * float64(generator.samplerate)))
+		if remaining := total - generator.frame; remaining < frames {
+			frames = remaining
+			// The last buffer is shortened like the buffer of Audio at the end of a file.
+			generator.buffer = generator.buffer[:frames*generator.BytesPerFrame()]
+		}
+	}
+	if frames <= 0 {
+		generator.ended = true
+		return false
+	}
+
+	codec := newSampleCodec(generator.format)
+	index := 0
+	for i := 0; i < frames; i++ {
+		t := float64(generator.frame+i) / float64(generator.samplerate)
+		for channel := 0; channel < generator.channels; channel++ {
+			codec.encode(generator.buffer[index:], generator.signal(t, channel))
+			index += codec.size
+		}
+	}
+	generator.frame += frames
+
+	return true
+}
+
+// Generates the next frames of audio into a new buffer owned by the caller, along with the
+// time of its first sample from the start of the signal. Returns io.EOF once the duration
+// has been generated or the generator has been closed.
+func (generator *Generator) ReadFrame() (*Frame, error) {
+	pts := time.Duration(float64(generator.frame) / float64(generator.samplerate) * float64(time.Second))
+	if !generator.Read() {
+		return nil, io.EOF
+	}
+	data := make([]byte, len(generator.buffer))
+	copy(data, generator.buffer)
+	return &Frame{Data: data, Samples: len(data) / generator.BytesPerFrame(), PTS: pts}, nil
+}
+
+// Stops generating audio. Read returns false afterwards.
+func (generator *Generator) Close() {
+	generator.ended = true
+}
@@ -0,0 +1,118 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+)
+
+// Audio that can be read buffer by buffer, implemented by Audio, Microphone, Generator, Chunker and Mixer.
+type Source interface {
+	SampleRate() int
+	Channels() int
+	BitsPerSample() int
+	BytesPerFrame() int
+	Format() string
+	Read() bool
+	ReadFrame() (*Frame, error)
+	Buffer() []byte
+	Samples() interface{}
+	Close()
+}
+
+// Destination that audio can be written to, implemented by AudioWriter, Player and RingBuffer.
+type Sink interface {
+	SampleRate() int
+	Channels() int
+	BitsPerSample() int
+	BytesPerFrame() int
+	Format() string
+	Write(samples interface{}) error
+	Close()
+}
+
+// Reads all audio from the source and writes it to the sink. The channels and sample rate of
+// both must match, while samples are converted to the format of the sink. Errors from reading
+// start with "reading audio" and errors from writing start with "writing audio". The source is
+// closed once Pipe returns, but the sink is left open, so more audio can be written to it.
+func Pipe(source Source, sink Sink) error {
+	return pipe(source, sink, "writing")
+}
+
+// Copies the audio from the source to the sink like Pipe. Errors from writing start with
+// the given verb, e.g. "playing".
+func pipe(source Source, sink Sink, verb string) error {
+	defer source.Close()
+
+	if err := checkSink(source, sink); err != nil {
+		return err
+	}
+
+	from, to := byteFormat(source), byteFormat(sink)
+	for {
+		frame, err := source.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading audio: %w", err)
+		}
+		buffer := frame.Data
+		if from != to {
+			buffer = convertBuffer(buffer, from, to)
+		}
+		if err := sink.Write(buffer); err != nil {
+			return fmt.Errorf("%s audio: %w", verb, err)
+		}
+	}
+}
+
+// Checks that the channels and sample rate of the sink match those of the source.
+func checkSink(source Source, sink Sink) error {
+	if source.Channels() != sink.Channels() {
+		return fmt.Errorf("source has %d channels, but the sink has %d", source.Channels(), sink.Channels())
+	}
+	if source.SampleRate() != sink.SampleRate() {
+		return fmt.Errorf(
+			"source has a sample rate of %d Hz, but the sink has %d Hz",
+			source.SampleRate(), sink.SampleRate(),
+		)
+	}
+	return nil
+}
+
+// Returns the format of the raw audio data of a source or sink, including the byte order.
+// Formats of types outside of this package are assumed to use the byte order of the machine.
+func byteFormat(audio interface{ Format() string }) string {
+	switch audio := audio.(type) {
+	case *Audio:
+		return audio.format
+	case *Microphone:
+		return audio.format
+	case *Generator:
+		return audio.format
+	case *AudioWriter:
+		return audio.format
+	case *Player:
+		return audio.format
+	case *RingBuffer:
+		return audio.format
+	case *Chunker:
+		return audio.format
+	case *Mixer:
+		return audio.format
+	default:
+		return createFormat(audio.Format())
+	}
+}
+
+// Checks that the types of this package implement the interfaces.
+var (
+	_ Source = (*Audio)(nil)
+	_ Source = (*Microphone)(nil)
+	_ Source = (*Generator)(nil)
+	_ Source = (*Chunker)(nil)
+	_ Source = (*Mixer)(nil)
+	_ Sink   = (*AudioWriter)(nil)
+	_ Sink   = (*Player)(nil)
+	_ Sink   = (*RingBuffer)(nil)
+)
@@ -0,0 +1,100 @@
+package aio
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Running ffmpeg, ffprobe and ffplay processes started by aio.
+var processes = struct {
+	mutex   sync.Mutex
+	running map[*exec.Cmd]func() // Processes that have been started and not closed yet, with the function closing them.
+	signals chan os.Signal       // Receives interrupts while interrupts are handled.
+}{running: make(map[*exec.Cmd]func())}
+
+// Sets whether aio handles Ctrl+C and SIGTERM by stopping all running ffmpeg processes and
+// exiting the program with status 1. This is off by default, so that programs using aio can
+// shut down gracefully on their own.
+func HandleInterrupts(enabled bool) {
+	processes.mutex.Lock()
+	defer processes.mutex.Unlock()
+
+	if enabled && processes.signals == nil {
+		processes.signals = make(chan os.Signal, 1)
+		signal.Notify(processes.signals, os.Interrupt, syscall.SIGTERM)
+		go interrupted(processes.signals)
+	} else if !enabled && processes.signals != nil {
+		signal.Stop(processes.signals)
+		close(processes.signals)
+		processes.signals = nil
+	}
+}
+
+// Stops all running processes and exits once an interrupt is received.
+func interrupted(signals chan os.Signal) {
+	if _, ok := <-signals; !ok {
+		return
+	}
+	processes.mutex.Lock()
+	for cmd := range processes.running {
+		cmd.Process.Kill()
+	}
+	processes.mutex.Unlock()
+	os.Exit(1)
+}
+
+// Adds a started process to the processes stopped on interrupts and by CloseAll. The close
+// function stops the process, which must then be unregistered. Objects whose processes are
+// stopped when they are garbage collected register through a processHandle instead, so that
+// the close function does not keep them reachable.
+func register(cmd *exec.Cmd, close func()) {
+	logCommand(cmd)
+	logEvent(cmd, "started")
+	processes.mutex.Lock()
+	defer processes.mutex.Unlock()
+	processes.running[cmd] = close
+}
+
+// Removes a process once it has been closed.
+func unregister(cmd *exec.Cmd) {
+	logEvent(cmd, "closed")
+	processes.mutex.Lock()
+	defer processes.mutex.Unlock()
+	delete(processes.running, cmd)
+}
+
+// Returns the number of ffmpeg and ffplay processes started by aio that are still running,
+// e.g. for monitoring. Every Audio, AudioWriter, Microphone and Player that has started
+// reading, writing or playing counts until it is closed.
+func OpenProcesses() int {
+	processes.mutex.Lock()
+	defer processes.mutex.Unlock()
+	return len(processes.running)
+}
+
+// Closes every Audio, AudioWriter, Microphone and Player with a running process, e.g. in a
+// shutdown hook. Written files are finalized as with Close, while players stop immediately,
+// discarding any queued audio. Later reads return no audio, and writes and playback fail as
+// if the objects had been closed. Returns once the processes have exited. Processes streaming
+// audio over HTTP are stopped as well.
+func CloseAll() {
+	processes.mutex.Lock()
+	closers := make([]func(), 0, len(processes.running))
+	for _, close := range processes.running {
+		closers = append(closers, close)
+	}
+	processes.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, close := range closers {
+		wg.Add(1)
+		go func(close func()) {
+			defer wg.Done()
+			close()
+		}(close)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,104 @@
+package aio
+
+import (
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// Debug flag set with DetectLeaks.
+var leaks = struct {
+	mutex   sync.Mutex
+	enabled bool
+}{}
+
+// Sets whether aio logs a warning with the stack of the constructor call whenever an Audio,
+// AudioWriter, Microphone, Player or Transcoder is garbage collected without being closed. Their processes
+// are stopped either way. Warnings go to the logger set with SetLogger, or to the standard
+// logger if there is none. Capturing the stacks slows down the constructors, so this is off by
+// default and meant for debugging.
+func DetectLeaks(enabled bool) {
+	leaks.mutex.Lock()
+	defer leaks.mutex.Unlock()
+	leaks.enabled = enabled
+}
+
+// Returns the stack of the calling constructor if leak detection is enabled, nil otherwise.
+func creationStack() []byte {
+	leaks.mutex.Lock()
+	defer leaks.mutex.Unlock()
+	if !leaks.enabled {
+		return nil
+	}
+	return debug.Stack()
+}
+
+// Process started by an Audio, AudioWriter, Microphone, Player or Transcoder, registered with
+// the running processes together with the function stopping it. The handle refers to the
+// process and its pipes but never to its owner, so that CloseAll can stop the process while an
+// owner that is no longer used can still be garbage collected, and its process stopped by a
+// finalizer.
+type processHandle struct {
+	cmd     *exec.Cmd    // Running command.
+	stop    func() error // Stops the process, e.g. by closing its pipe and waiting for it to exit.
+	mutex   sync.Mutex   // Mutex guarding the result of stopping the process.
+	stopped bool         // Flag storing whether the process has been stopped.
+	err     error        // Error returned by stop.
+}
+
+// Registers the started process and returns its handle. Stop is called at most once, either by
+// the owner or by CloseAll, and must unregister the process.
+func registerHandle(cmd *exec.Cmd, stop func() error) *processHandle {
+	handle := &processHandle{cmd: cmd, stop: stop}
+	register(cmd, func() { handle.close() })
+	return handle
+}
+
+// Stops the process if it has not been stopped yet and returns the error of stopping it.
+func (handle *processHandle) close() error {
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+	if !handle.stopped {
+		handle.stopped = true
+		handle.err = handle.stop()
+	}
+	return handle.err
+}
+
+// Returns true if the process has been stopped, e.g. by CloseAll. False for a nil handle.
+func (handle *processHandle) closed() bool {
+	if handle == nil {
+		return false
+	}
+	handle.mutex.Lock()
+	defer handle.mutex.Unlock()
+	return handle.stopped
+}
+
+// Kills the process of an owner that was garbage collected without being closed, and logs a
+// warning with the creation stack of the owner if it was captured. Runs on its own goroutine,
+// since finalizers must not block.
+func (handle *processHandle) leaked(owner string, stack []byte) {
+	if handle == nil {
+		return
+	}
+	go func() {
+		if handle.closed() {
+			return
+		}
+		if stack != nil {
+			warning := "aio: %s was garbage collected without being closed, killing %s (pid %d). Created at:\n%s"
+			args := []interface{}{owner, filepath.Base(handle.cmd.Path), handle.cmd.Process.Pid, stack}
+			if logger := currentLogger(); logger != nil {
+				logger.Printf(warning, args...)
+			} else {
+				log.Printf(warning, args...)
+			}
+		}
+		logEvent(handle.cmd, "killed, its owner was garbage collected without being closed")
+		handle.cmd.Process.Kill()
+		handle.close()
+	}()
+}
@@ -0,0 +1,122 @@
+package aio
+
+import (
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Receives debug messages from aio. *log.Logger implements this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Number of ffmpeg output lines buffered for the logger before lines are dropped.
+const logQueueSize = 1024
+
+var logging = struct {
+	mutex  sync.Mutex
+	logger Logger      // Logger receiving debug messages, nil if logging is disabled.
+	lines  chan string // ffmpeg output lines waiting to be logged.
+}{}
+
+// Sets the logger receiving debug messages, such as the commands run by aio and when processes
+// start and stop. Processes started with Options.LogLevel set also send their output to the
+// logger. A nil logger disables logging, which is the default.
+func SetLogger(logger Logger) {
+	logging.mutex.Lock()
+	defer logging.mutex.Unlock()
+
+	if logger != nil && logging.lines == nil {
+		logging.lines = make(chan string, logQueueSize)
+		go drainLog(logging.lines)
+	}
+	logging.logger = logger
+}
+
+// Returns the current logger, or nil if logging is disabled.
+func currentLogger() Logger {
+	logging.mutex.Lock()
+	defer logging.mutex.Unlock()
+	return logging.logger
+}
+
+// Passes queued ffmpeg output lines to the logger. Runs on its own goroutine so that slow
+// loggers never block the processes writing the output.
+func drainLog(lines chan string) {
+	for line := range lines {
+		if logger := currentLogger(); logger != nil {
+			logger.Printf("%s", line)
+		}
+	}
+}
+
+// Logs the command line of the command about to be run.
+func logCommand(cmd *exec.Cmd) {
+	if logger := currentLogger(); logger != nil {
+		logger.Printf("aio: running %s", strings.Join(cmd.Args, " "))
+	}
+}
+
+// Logs a lifecycle event of a process, such as "started" or "killed".
+func logEvent(cmd *exec.Cmd, event string) {
+	if logger := currentLogger(); logger != nil {
+		pid := 0
+		if cmd.Process != nil {
+			pid = cmd.Process.Pid
+		}
+		logger.Printf("aio: %s (pid %d) %s", filepath.Base(cmd.Path), pid, event)
+	}
+}
+
+// Returns the ffmpeg log level for a process. If a logger is set, this is the given level,
+// otherwise the default level of the process is used.
+func logLevel(level, fallback string) string {
+	if level != "" && currentLogger() != nil {
+		return level
+	}
+	return fallback
+}
+
+// Returns a writer sending the output of the process to the logger line by line, combined with
+// the given writer, if any. Returns the given writer if the process does not log its output.
+func logOutput(cmd *exec.Cmd, level string, writer io.Writer) io.Writer {
+	if level == "" || currentLogger() == nil {
+		return writer
+	}
+	logging.mutex.Lock()
+	output := &logWriter{prefix: filepath.Base(cmd.Path) + ": ", lines: logging.lines}
+	logging.mutex.Unlock()
+	if writer == nil {
+		return output
+	}
+	return io.MultiWriter(writer, output)
+}
+
+// Splits process output into lines and queues them for the logger.
+type logWriter struct {
+	prefix  string        // Prefix added to every line, e.g. the name of the program.
+	lines   chan<- string // Queue of lines waiting to be logged.
+	partial []byte        // Output after the last line break.
+}
+
+func (writer *logWriter) Write(data []byte) (int, error) {
+	writer.partial = append(writer.partial, data...)
+	for {
+		index := strings.IndexAny(string(writer.partial), "\r\n")
+		if index == -1 {
+			break
+		}
+		if line := strings.TrimSpace(string(writer.partial[:index])); line != "" {
+			// Lines are dropped if the logger cannot keep up with the process.
+			select {
+			case writer.lines <- writer.prefix + line:
+			default:
+			}
+		}
+		writer.partial = writer.partial[index+1:]
+	}
+	return len(data), nil
+}
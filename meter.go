@@ -0,0 +1,268 @@
+package aio
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Number of 100 ms blocks in the momentary and short-term loudness windows.
+const (
+	momentaryBlocks = 4
+	shortTermBlocks = 30
+)
+
+// Loudness of the absolute gate in LUFS. Gating blocks below it are ignored for the
+// integrated loudness.
+const absoluteGate = -70.0
+
+type Meter struct {
+	samplerate int        // Audio Sample Rate in Hz.
+	channels   int        // Number of audio channels.
+	format     string     // Format of audio samples.
+	weights    []float64  // Weight of each channel in the loudness sum.
+	shelf      []biquad   // High shelf stage of the K-weighting filter for each channel.
+	highpass   []biquad   // High pass stage of the K-weighting filter for each channel.
+	blocksize  int        // Number of frames in a 100 ms block.
+	energy     float64    // Weighted sum of the squared samples in the current block.
+	frames     int        // Number of frames in the current block.
+	blocks     []float64  // Mean energy of the last completed blocks, oldest first.
+	completed  int        // Number of completed blocks.
+	gated      []float64  // Mean energy of every 400 ms gating block above the absolute gate.
+	peak       float64    // Largest absolute sample value.
+	mutex      sync.Mutex // Mutex guarding the measurements against concurrent calls to Process.
+}
+
+// Audio Sample Rate in Hz.
+func (meter *Meter) SampleRate() int {
+	return meter.samplerate
+}
+
+func (meter *Meter) Channels() int {
+	return meter.channels
+}
+
+func (meter *Meter) Format() string {
+	switch meter.format {
+	case "u8", "s8":
+		return meter.format
+	default:
+		return meter.format[:len(meter.format)-2]
+	}
+}
+
+// Creates a loudness meter for audio with the given number of channels, sample rate and format.
+// Loudness is measured as described in ITU-R BS.1770. For 5 channel audio, the last two channels
+// are surround channels, and for 6 channel audio the fourth channel is the LFE channel, which
+// is not measured, followed by the two surround channels.
+func NewMeter(channels, samplerate int, format string, options *Options) (*Meter, error) {
+	options = withDefaults(options)
+
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := options.validate("NewMeter"); err != nil {
+		return nil, err
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+
+	meter := &Meter{
+		samplerate: samplerate,
+		channels:   channels,
+		format:     format,
+		weights:    make([]float64, channels),
+		shelf:      make([]biquad, channels),
+		highpass:   make([]biquad, channels),
+		blocksize:  (samplerate + 5) / 10,
+		blocks:     make([]float64, shortTermBlocks),
+	}
+
+	for i := range meter.weights {
+		meter.weights[i] = 1
+		meter.shelf[i] = shelfFilter(samplerate)
+		meter.highpass[i] = highpassFilter(samplerate)
+	}
+	switch channels {
+	case 5:
+		meter.weights[3], meter.weights[4] = 1.41, 1.41
+	case 6:
+		meter.weights[3], meter.weights[4], meter.weights[5] = 0, 1.41, 1.41
+	}
+
+	return meter, nil
+}
+
+// Adds the samples to the measurements. Byte slices hold samples in the format of the meter,
+// while other sample slices are read in the format matching their type.
+func (meter *Meter) Process(samples interface{}) error {
+	buffer := samplesToBytes(samples)
+	if buffer == nil {
+		return fmt.Errorf("invalid sample data type")
+	}
+	format := meter.format
+	if typed := sampleFormat(samples); typed != "" {
+		format = typed
+	}
+
+	codec := newSampleCodec(format)
+	frame := codec.size * meter.channels
+	if len(buffer)%frame != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", frame)
+	}
+
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+
+	for i := 0; i < len(buffer); i += frame {
+		for channel := 0; channel < meter.channels; channel++ {
+			value := codec.decode(buffer[i+channel*codec.size:])
+			meter.peak = math.Max(meter.peak, math.Abs(value))
+			weighted := meter.highpass[channel].process(meter.shelf[channel].process(value))
+			meter.energy += meter.weights[channel] * weighted * weighted
+		}
+		meter.frames++
+		if meter.frames == meter.blocksize {
+			meter.complete()
+		}
+	}
+
+	return nil
+}
+
+// Stores the energy of the current 100 ms block and adds the 400 ms gating block
+// ending with it. Must be called with the mutex held.
+func (meter *Meter) complete() {
+	copy(meter.blocks, meter.blocks[1:])
+	meter.blocks[len(meter.blocks)-1] = meter.energy / float64(meter.blocksize)
+	meter.energy = 0
+	meter.frames = 0
+	meter.completed++
+
+	if meter.completed >= momentaryBlocks {
+		if energy := meter.window(momentaryBlocks); loudness(energy) > absoluteGate {
+			meter.gated = append(meter.gated, energy)
+		}
+	}
+}
+
+// Returns the mean energy of the last blocks. Blocks before the start of the audio are silent.
+// Must be called with the mutex held.
+func (meter *Meter) window(blocks int) float64 {
+	sum := 0.0
+	for _, energy := range meter.blocks[len(meter.blocks)-blocks:] {
+		sum += energy
+	}
+	return sum / float64(blocks)
+}
+
+// Loudness of the last 400 ms of audio in LUFS. Updated every 100 ms of audio.
+func (meter *Meter) Momentary() float64 {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	return loudness(meter.window(momentaryBlocks))
+}
+
+// Loudness of the last 3 seconds of audio in LUFS. Updated every 100 ms of audio.
+func (meter *Meter) ShortTerm() float64 {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	return loudness(meter.window(shortTermBlocks))
+}
+
+// Gated loudness of all audio processed so far in LUFS. Returns negative infinity
+// until a 400 ms block louder than -70 LUFS has been processed.
+func (meter *Meter) Integrated() float64 {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+
+	if len(meter.gated) == 0 {
+		return math.Inf(-1)
+	}
+
+	// The relative gate is 10 LU below the loudness of the blocks above the absolute gate.
+	sum := 0.0
+	for _, energy := range meter.gated {
+		sum += energy
+	}
+	gate := sum / float64(len(meter.gated)) / 10
+
+	sum, count := 0.0, 0
+	for _, energy := range meter.gated {
+		if energy > gate {
+			sum += energy
+			count++
+		}
+	}
+	return loudness(sum / float64(count))
+}
+
+// Largest absolute sample value of all audio processed so far, where 1 is full scale.
+func (meter *Meter) Peak() float64 {
+	meter.mutex.Lock()
+	defer meter.mutex.Unlock()
+	return meter.peak
+}
+
+// Converts the mean energy of K-weighted samples to loudness in LUFS.
+func loudness(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+// Biquad filter keeping the state of one channel.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64 // Filter coefficients, normalized so that a0 is 1.
+	x1, x2, y1, y2     float64 // Last two inputs and outputs.
+}
+
+func (filter *biquad) process(x float64) float64 {
+	y := filter.b0*x + filter.b1*filter.x1 + filter.b2*filter.x2 - filter.a1*filter.y1 - filter.a2*filter.y2
+	filter.x2, filter.x1 = filter.x1, x
+	filter.y2, filter.y1 = filter.y1, y
+	return y
+}
+
+// Returns the high shelf stage of the K-weighting filter, modelling the acoustic effect of
+// the head. The coefficients of BS.1770 are given for 48 kHz, so the analog prototype is used
+// to compute them for any sample rate.
+func shelfFilter(samplerate int) biquad {
+	const (
+		frequency = 1681.974450955533
+		gain      = 3.999843853973347
+		q         = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * frequency / float64(samplerate))
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	return biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+}
+
+// Returns the high pass stage of the K-weighting filter.
+func highpassFilter(samplerate int) biquad {
+	const (
+		frequency = 38.13547087602444
+		q         = 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * frequency / float64(samplerate))
+	a0 := 1 + k/q + k*k
+	return biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+}
@@ -0,0 +1,92 @@
+package aio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot of the audio that has flowed through an Audio, Microphone, AudioWriter or Player, as
+// returned by their Metrics methods, e.g. to export to a monitoring system. Counters cover the
+// whole lifetime unless they are reset with ResetMetrics.
+type Metrics struct {
+	Bytes        int64         // Number of bytes of audio read from or written to ffmpeg.
+	Frames       int64         // Number of audio frames read from or written to ffmpeg.
+	Operations   int64         // Number of reads from or writes to the ffmpeg pipe.
+	Blocked      time.Duration // Total time spent waiting for reads and writes on the ffmpeg pipe.
+	ShortBuffers int64         // Number of reads that filled less than the buffer, e.g. the last one of a file.
+	Underruns    int64         // Number of times playback ran out of audio. Only counted by Player.
+	Errors       int64         // Number of reads or writes that failed.
+}
+
+// Counters behind Metrics, allocated separately from the types they count so that they are
+// aligned for atomic access. They are updated atomically, so that reading them never waits for
+// audio to flow. All methods do nothing for a nil counter.
+type tally struct {
+	bytes      int64
+	frames     int64
+	operations int64
+	blocked    int64 // Nanoseconds.
+	short      int64
+	underruns  int64
+	errors     int64
+}
+
+// Counts a read or write of n bytes that started at the given time.
+func (counters *tally) transfer(n, frame int, started time.Time) {
+	if counters == nil {
+		return
+	}
+	atomic.AddInt64(&counters.operations, 1)
+	atomic.AddInt64(&counters.bytes, int64(n))
+	atomic.AddInt64(&counters.frames, int64(n/frame))
+	atomic.AddInt64(&counters.blocked, int64(time.Since(started)))
+}
+
+// Counts a read that filled less than the buffer.
+func (counters *tally) shortBuffer() {
+	if counters != nil {
+		atomic.AddInt64(&counters.short, 1)
+	}
+}
+
+// Counts playback running out of audio.
+func (counters *tally) underrun() {
+	if counters != nil {
+		atomic.AddInt64(&counters.underruns, 1)
+	}
+}
+
+// Counts a read or write that failed.
+func (counters *tally) failed() {
+	if counters != nil {
+		atomic.AddInt64(&counters.errors, 1)
+	}
+}
+
+func (counters *tally) snapshot() Metrics {
+	if counters == nil {
+		return Metrics{}
+	}
+	return Metrics{
+		Bytes:        atomic.LoadInt64(&counters.bytes),
+		Frames:       atomic.LoadInt64(&counters.frames),
+		Operations:   atomic.LoadInt64(&counters.operations),
+		Blocked:      time.Duration(atomic.LoadInt64(&counters.blocked)),
+		ShortBuffers: atomic.LoadInt64(&counters.short),
+		Underruns:    atomic.LoadInt64(&counters.underruns),
+		Errors:       atomic.LoadInt64(&counters.errors),
+	}
+}
+
+// Sets all counters to zero. Counters updated during the reset may keep their update.
+func (counters *tally) reset() {
+	if counters == nil {
+		return
+	}
+	for _, counter := range []*int64{
+		&counters.bytes, &counters.frames, &counters.operations, &counters.blocked,
+		&counters.short, &counters.underruns, &counters.errors,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+}
@@ -1,27 +1,38 @@
 package aio
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"os"
 	"os/exec"
-	"os/signal"
 	"regexp"
 	"runtime"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 )
 
 type Microphone struct {
-	name       string        // Microphone device name.
-	samplerate int           // Audio Sample Rate in Hz.
-	channels   int           // Number of audio channels.
-	format     string        // Format of audio samples.
-	bps        int           // Bits per sample.
-	buffer     []byte        // Raw audio data.
-	pipe       io.ReadCloser // Stdout pipe for ffmpeg process streaming microphone audio.
-	cmd        *exec.Cmd     // ffmpeg command.
+	name       string         // Microphone device name.
+	samplerate int            // Audio Sample Rate in Hz.
+	channels   int            // Number of audio channels.
+	format     string         // Format of audio samples.
+	bps        int            // Bits per sample.
+	buffer     []byte         // Raw audio data.
+	closed     bool           // Flag storing whether the microphone has been closed.
+	mutex      sync.Mutex     // Mutex guarding the process and buffer against concurrent calls to Close.
+	pipe       io.ReadCloser  // Stdout pipe for ffmpeg process streaming microphone audio.
+	cmd        *exec.Cmd      // ffmpeg command.
+	loglevel   string         // ffmpeg log level when logging is enabled.
+	nice       int            // Niceness of the ffmpeg process.
+	filter     string         // ffmpeg audio filter graph applied to the recorded audio.
+	started    time.Time      // Time at which the ffmpeg process started recording.
+	drift      bool           // Flag storing whether ffmpeg compensates for clock drift.
+	frames     int64          // Number of frames read since the recording started.
+	last       time.Time      // Time at which the last read finished.
+	inputs     []int          // Channels of the device that are recorded, nil to record all channels.
+	metrics    *tally         // Counters of the audio read, returned by Metrics.
+	handle     *processHandle // Handle of the running ffmpeg process, nil until it is started.
+	stack      []byte         // Stack of the constructor call, captured if leak detection is enabled.
 }
 
 func (mic *Microphone) Name() string {
@@ -41,6 +52,31 @@ func (mic *Microphone) BitsPerSample() int {
 	return mic.bps
 }
 
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (mic *Microphone) BytesPerFrame() int {
+	return mic.bps / 8 * mic.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (mic *Microphone) SamplesPerFrame() int {
+	return mic.channels
+}
+
+// Number of bytes in one second of audio.
+func (mic *Microphone) BytesPerSecond() int {
+	return mic.samplerate * mic.BytesPerFrame()
+}
+
+// Returns a snapshot of the audio recorded so far.
+func (mic *Microphone) Metrics() Metrics {
+	return mic.metrics.snapshot()
+}
+
+// Sets all metrics to zero.
+func (mic *Microphone) ResetMetrics() {
+	mic.metrics.reset()
+}
+
 func (mic *Microphone) Format() string {
 	switch mic.format {
 	case "u8", "s8":
@@ -50,29 +86,84 @@ func (mic *Microphone) Format() string {
 	}
 }
 
+// ffmpeg audio filter graph applied to the recorded audio.
+func (mic *Microphone) Filter() string {
+	return mic.filter
+}
+
+// Channels of the device that are recorded, or nil if all channels are recorded.
+func (mic *Microphone) InputChannels() []int {
+	if len(mic.inputs) == 0 {
+		return nil
+	}
+	return append([]int{}, mic.inputs...)
+}
+
+// Returns true if samples are inserted or dropped to keep the recording locked to the wall clock.
+func (mic *Microphone) DriftCompensation() bool {
+	return mic.drift
+}
+
 func (mic *Microphone) Buffer() []byte {
+	mic.mutex.Lock()
+	defer mic.mutex.Unlock()
 	return mic.buffer
 }
 
 func (mic *Microphone) Samples() interface{} {
-	return bytesToSamples(mic.buffer, len(mic.buffer)/(mic.bps/8), mic.format)
+	buffer := mic.Buffer()
+	return bytesToSamples(buffer, len(buffer)/(mic.bps/8), mic.format)
+}
+
+// Returns the samples of the buffer as float32 values in the range [-1, 1], whatever the format.
+// Integer samples are scaled like ConvertSamples, so the smallest value of a signed format
+// maps to -1.
+func (mic *Microphone) SamplesFloat32() []float32 {
+	return mic.SamplesFloat32Into(nil)
+}
+
+// Returns the samples of the buffer like SamplesFloat32, reusing the destination slice if it has
+// enough capacity, so that no memory is allocated when recording in a loop.
+func (mic *Microphone) SamplesFloat32Into(dst []float32) []float32 {
+	return decodeFloat32(dst, mic.Buffer(), mic.format)
 }
 
 // Sets the buffer to the given byte array. The length of the buffer must be a multiple
 // of (bytes per sample * audio channels).
 func (mic *Microphone) SetBuffer(buffer []byte) error {
-	if len(buffer)%(mic.bps/8*mic.channels) != 0 {
-		return fmt.Errorf("buffer size must be multiple of %d", mic.bps/8*mic.channels)
+	if len(buffer)%mic.BytesPerFrame() != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", mic.BytesPerFrame())
 	}
+	mic.mutex.Lock()
+	defer mic.mutex.Unlock()
 	mic.buffer = buffer
 	return nil
 }
 
+// Returns the names of all microphones, in the order of the stream indices passed to NewMicrophone.
+func ListMicrophones() ([]string, error) {
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	return getMicrophones()
+}
+
 func NewMicrophone(stream int, options *Options) (*Microphone, error) {
+	options = withDefaults(options)
+
+	if err := options.validate("NewMicrophone"); err != nil {
+		return nil, err
+	}
+
 	// Check if ffmpeg is installed on the users machine.
 	if err := installed("ffmpeg"); err != nil {
 		return nil, err
 	}
+	if options.Filter != "" {
+		if err := checkFilters(options.Filter); err != nil {
+			return nil, err
+		}
+	}
 
 	var device string
 	switch runtime.GOOS {
@@ -95,59 +186,92 @@ func NewMicrophone(stream int, options *Options) (*Microphone, error) {
 		return nil, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
 
-	mic := &Microphone{name: device}
+	mic := &Microphone{
+		name:     device,
+		loglevel: options.LogLevel,
+		nice:     options.Nice,
+		filter:   options.Filter,
+		drift:    options.DriftCompensation,
+		inputs:   options.InputChannels,
+		metrics:  &tally{},
+		stack:    creationStack(),
+	}
 
 	if err := mic.getMicrophoneData(device); err != nil {
 		return nil, err
 	}
 
-	if options == nil {
-		options = &Options{}
+	format := "s16" // s16 default format.
+	if options.Format != "" {
+		format = options.Format
 	}
-
-	if options.Format == "" {
-		mic.format = createFormat("s16") // s16 default format.
-	} else {
-		mic.format = createFormat(options.Format)
-	}
-
-	if err := checkFormat(mic.format); err != nil {
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
 		return nil, err
 	}
+	mic.format = format
 
 	if options.SampleRate != 0 {
 		mic.samplerate = options.SampleRate
 	}
 
+	// The input channels are checked against the channels of the device before they are selected.
+	if len(mic.inputs) > 0 {
+		for _, channel := range mic.inputs {
+			if channel >= mic.channels {
+				return nil, fmt.Errorf("input channel %d is out of range, device %s has %d channels", channel, device, mic.channels)
+			}
+		}
+		mic.channels = len(mic.inputs)
+	}
+
 	if options.Channels != 0 {
 		mic.channels = options.Channels
 	}
 
+	if mic.samplerate <= 0 {
+		return nil, fmt.Errorf("sample rate of microphone %s is unknown", device)
+	}
+
 	mic.bps = int(parse(regexp.MustCompile(`\d{1,2}`).FindString(mic.format))) // Bits per sample.
+	runtime.SetFinalizer(mic, (*Microphone).leaked)
 
 	return mic, nil
 }
 
+// Returns the pan filter keeping the given input channels, in the given order.
+func panFilter(inputs []int) string {
+	layout := fmt.Sprintf("%dc", len(inputs))
+	switch len(inputs) {
+	case 1:
+		layout = "mono"
+	case 2:
+		layout = "stereo"
+	}
+	filter := "pan=" + layout
+	for i, channel := range inputs {
+		filter += fmt.Sprintf("|c%d=c%d", i, channel)
+	}
+	return filter
+}
+
 // Parses the microphone metadata from ffmpeg output.
 func (mic *Microphone) parseMicrophoneData(buffer string) {
 	// Sample String: "Stream #0:0: Audio: pcm_s16le, 44100 Hz, stereo, s16, 1411 kb/s".
-	index := strings.Index(buffer, "Stream #")
-	if index == -1 {
-		index++
-	}
-	buffer = buffer[index:]
-	// Sample rate.
-	regex := regexp.MustCompile(`\d+ Hz`)
-	match := regex.FindString(buffer)
-	if len(match) > 0 {
-		mic.samplerate = int(parse(match[:len(match)-len(" Hz")]))
+	stream := map[string]string{}
+	for _, line := range strings.Split(buffer, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "Stream #") {
+			stream = parseStreamInfo(line)
+			break
+		}
 	}
 
+	if samplerate, ok := parseValue(stream["sample_rate"]); ok {
+		mic.samplerate = int(samplerate)
+	}
 	mic.channels = 2 // stereo by default.
-	if strings.Contains(buffer, "stereo") {
-		mic.channels = 2
-	} else if strings.Contains(buffer, "mono") {
-		mic.channels = 1
+	if channels, ok := parseValue(stream["channels"]); ok {
+		mic.channels = int(channels)
 	}
 }
 
@@ -164,6 +288,7 @@ func (mic *Microphone) getMicrophoneData(device string) error {
 		"-f", micDeviceName,
 		"-i", device,
 	)
+	logCommand(cmd)
 	// The command will fail since we do not give a file to write to, therefore
 	// it will write the meta data to Stderr.
 	pipe, err := cmd.StderrPipe()
@@ -174,46 +299,56 @@ func (mic *Microphone) getMicrophoneData(device string) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
-	// Read ffmpeg output from Stdout.
-	builder := bytes.Buffer{}
-	buffer := make([]byte, 1024)
-	for {
-		n, err := pipe.Read(buffer)
-		builder.Write(buffer[:n])
-		if err == io.EOF {
-			break
-		}
-	}
+	// Read ffmpeg output from Stderr.
+	output, err := io.ReadAll(pipe)
 	// Wait for the command to finish.
 	cmd.Wait()
 
-	mic.parseMicrophoneData(builder.String())
+	if err != nil {
+		return err
+	}
+
+	mic.parseMicrophoneData(string(output))
 	return nil
 }
 
 // Once the user calls Read() for the first time on a Microphone struct,
 // the ffmpeg command which is used to read the microphone device is started.
 func (mic *Microphone) init() error {
-	// If user exits with Ctrl+C, stop ffmpeg process.
-	mic.cleanup()
-
 	micDeviceName, err := microphone()
 	if err != nil {
 		return err
 	}
 
 	// Use ffmpeg to pipe microphone to stdout.
-	cmd := exec.Command(
-		"ffmpeg",
+	command := []string{
 		"-hide_banner",
-		"-loglevel", "quiet",
+		"-loglevel", logLevel(mic.loglevel, "quiet"),
 		"-f", micDeviceName,
 		"-i", mic.name,
+	}
+	filters := []string{}
+	if len(mic.inputs) > 0 {
+		filters = append(filters, panFilter(mic.inputs))
+	}
+	if mic.drift {
+		filters = append(filters, driftFilter)
+	}
+	if mic.filter != "" {
+		filters = append(filters, mic.filter)
+	}
+	if len(filters) > 0 {
+		command = append(command, "-af", strings.Join(filters, ","))
+	}
+	command = append(
+		command,
 		"-f", mic.format,
 		"-ar", fmt.Sprintf("%d", mic.samplerate),
 		"-ac", fmt.Sprintf("%d", mic.channels),
 		"-",
 	)
+	cmd := exec.Command("ffmpeg", command...)
+	cmd.Stderr = logOutput(cmd, mic.loglevel, nil)
 
 	mic.cmd = cmd
 	pipe, err := cmd.StdoutPipe()
@@ -222,55 +357,122 @@ func (mic *Microphone) init() error {
 	}
 
 	mic.pipe = pipe
-	if err := cmd.Start(); err != nil {
+	if err := startNice(cmd, mic.nice); err != nil {
 		return err
 	}
+	mic.handle = registerHandle(cmd, func() error {
+		pipe.Close()
+		logEvent(cmd, "killed")
+		cmd.Process.Kill()
+		err := cmd.Wait()
+		unregister(cmd)
+		return err
+	})
+	mic.started = time.Now()
 
 	if mic.buffer == nil {
-		mic.buffer = make([]byte, mic.samplerate*mic.channels*mic.bps/8)
+		mic.buffer = make([]byte, mic.BytesPerSecond())
 	}
 
 	return nil
 }
 
 // Reads the next frame from of audio and stores it in the buffer.
-// If the last frame has been read, returns false, otherwise true.
+// If the microphone has been closed, returns false, otherwise true.
+// Safe to call while another goroutine calls Close, in which case Read returns false.
 func (mic *Microphone) Read() bool {
+	_, _, err := mic.read(false)
+	return err == nil
+}
+
+// Reads the next frame of audio into a new buffer owned by the caller, along with the time at
+// which its first sample was captured, measured from the start of the recording. Returns io.EOF
+// once the microphone has been closed or has stopped recording.
+func (mic *Microphone) ReadFrame() (*Frame, error) {
+	buffer, pts, err := mic.read(true)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Data: buffer, Samples: len(buffer) / mic.BytesPerFrame(), PTS: pts}, nil
+}
+
+// Reads the next frame of audio into the microphone buffer, or a new buffer of the same size if
+// owned is true. Returns the buffer and the capture time of its first sample.
+func (mic *Microphone) read(owned bool) ([]byte, time.Duration, error) {
+	mic.mutex.Lock()
+	if mic.closed {
+		mic.mutex.Unlock()
+		return nil, 0, io.EOF
+	}
 	// If cmd is nil, microphone reading has not been initialized.
 	if mic.cmd == nil {
 		if err := mic.init(); err != nil {
-			return false
+			mic.mutex.Unlock()
+			return nil, 0, err
 		}
 	}
+	pipe, buffer, started := mic.pipe, mic.buffer, mic.started
+	mic.mutex.Unlock()
+
+	if owned {
+		buffer = make([]byte, len(buffer))
+	}
+
+	// The mutex is not held while reading, so that Close can unblock the read by closing the pipe.
+	reading := time.Now()
+	n, err := io.ReadFull(pipe, buffer)
+
+	// The last sample of the buffer has just been captured.
+	finished := time.Now()
+	length := time.Duration(len(buffer)) * time.Second / time.Duration(mic.BytesPerSecond())
+	pts := finished.Sub(started) - length
+	if pts < 0 {
+		pts = 0
+	}
 
-	io.ReadFull(mic.pipe, mic.buffer)
+	mic.mutex.Lock()
+	defer mic.mutex.Unlock()
 
-	return true
+	// The process may have been stopped by CloseAll.
+	if mic.closed || mic.handle.closed() {
+		mic.closed = true
+		return nil, 0, io.EOF
+	}
+	mic.metrics.transfer(n, mic.BytesPerFrame(), reading)
+	if err == io.ErrUnexpectedEOF {
+		mic.metrics.shortBuffer()
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		if err != io.EOF {
+			mic.metrics.failed()
+		}
+		return nil, 0, err
+	}
+	mic.frames += int64(len(buffer) / mic.BytesPerFrame())
+	mic.last = finished
+	return buffer, pts, nil
 }
 
-// Closes the pipe and stops the ffmpeg process.
+// Closes the pipe and stops the ffmpeg process. Safe to call from any goroutine,
+// and unblocks any Read in progress.
 func (mic *Microphone) Close() {
-	if mic.pipe != nil {
-		mic.pipe.Close()
+	mic.mutex.Lock()
+	defer mic.mutex.Unlock()
+
+	if mic.closed {
+		return
 	}
-	if mic.cmd != nil {
-		mic.cmd.Process.Kill()
+	mic.closed = true
+	runtime.SetFinalizer(mic, nil)
+	if mic.handle != nil {
+		mic.handle.close()
 	}
 }
 
-// Stops the "cmd" process running when the user presses Ctrl+C.
-// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
-func (mic *Microphone) cleanup() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		if mic.pipe != nil {
-			mic.pipe.Close()
-		}
-		if mic.cmd != nil {
-			mic.cmd.Process.Kill()
-		}
-		os.Exit(1)
-	}()
+// Stops the ffmpeg process if the microphone is garbage collected without being closed.
+func (mic *Microphone) leaked() {
+	if !mic.closed {
+		mic.handle.leaked("Microphone "+mic.name, mic.stack)
+	}
 }
@@ -0,0 +1,475 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Audio mixed by a Mixer, either read from an attached source or written with WriteTrack.
+type mixerTrack struct {
+	source   Source    // Attached source, nil for a track fed with WriteTrack.
+	format   string    // Format of the raw audio data of the source.
+	channels int       // Number of audio channels of the source.
+	gain     float64   // Gain applied to the samples of the track.
+	pending  []float64 // Samples waiting to be mixed, with the channels of the mixer.
+	ended    bool      // Flag storing whether the track has no audio after the pending samples.
+}
+
+type Mixer struct {
+	samplerate int           // Audio Sample Rate in Hz.
+	channels   int           // Number of audio channels.
+	format     string        // Format of audio samples.
+	bps        int           // Bits per sample.
+	tracks     []*mixerTrack // Tracks mixed into the output.
+	underruns  int           // Number of times a track fed with WriteTrack ran out of audio.
+	frame      int           // Index of the next frame to mix.
+	started    time.Time     // Time the first buffer was mixed, from which mixed frames are due.
+	closed     bool          // Flag storing whether the mixer has been closed.
+	buffer     []byte        // Raw audio data.
+	mutex      sync.Mutex    // Mutex guarding the tracks against calls from other goroutines.
+	written    *sync.Cond    // Signaled when audio is written, a track is closed or a deadline passes.
+}
+
+// Audio Sample Rate in Hz.
+func (mixer *Mixer) SampleRate() int {
+	return mixer.samplerate
+}
+
+func (mixer *Mixer) Channels() int {
+	return mixer.channels
+}
+
+func (mixer *Mixer) BitsPerSample() int {
+	return mixer.bps
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (mixer *Mixer) BytesPerFrame() int {
+	return mixer.bps / 8 * mixer.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (mixer *Mixer) SamplesPerFrame() int {
+	return mixer.channels
+}
+
+// Number of bytes in one second of audio.
+func (mixer *Mixer) BytesPerSecond() int {
+	return mixer.samplerate * mixer.BytesPerFrame()
+}
+
+func (mixer *Mixer) Format() string {
+	switch mixer.format {
+	case "u8", "s8":
+		return mixer.format
+	default:
+		return mixer.format[:len(mixer.format)-2]
+	}
+}
+
+func (mixer *Mixer) Buffer() []byte {
+	return mixer.buffer
+}
+
+// Casts the values in the byte buffer to those specified by the audio format.
+func (mixer *Mixer) Samples() interface{} {
+	return bytesToSamples(mixer.buffer, len(mixer.buffer)/(mixer.bps/8), mixer.format)
+}
+
+// Sets the buffer to the given byte array. The length of the buffer must be a multiple
+// of (bytes per sample * audio channels). Smaller buffers lower the latency of live tracks.
+func (mixer *Mixer) SetBuffer(buffer []byte) error {
+	if len(buffer)%mixer.BytesPerFrame() != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", mixer.BytesPerFrame())
+	}
+	mixer.buffer = buffer
+	return nil
+}
+
+// Number of tracks added to the mixer, including closed tracks.
+func (mixer *Mixer) Tracks() int {
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+	return len(mixer.tracks)
+}
+
+// Number of times a track fed with WriteTrack had not been given enough audio by the time the
+// mixed buffer was due, in which case the missing audio was mixed as silence.
+func (mixer *Mixer) Underruns() int {
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+	return mixer.underruns
+}
+
+// Creates a mixer that sums the audio of several tracks in Go, e.g. a live Microphone and music
+// read from a file. The sample rate (44100 Hz), channels (2) and format (s16) of the mixed audio
+// can be changed with the options. Tracks are added with Attach and AddTrack, and the mixed
+// audio is read like any other Source.
+func NewMixer(options *Options) (*Mixer, error) {
+	options = withDefaults(options)
+
+	if err := options.validate("NewMixer"); err != nil {
+		return nil, err
+	}
+
+	mixer := &Mixer{
+		samplerate: 44100,
+		channels:   2, // Stereo by default.
+	}
+	mixer.written = sync.NewCond(&mixer.mutex)
+
+	if options.SampleRate != 0 {
+		mixer.samplerate = options.SampleRate
+	}
+	if options.Channels != 0 {
+		mixer.channels = options.Channels
+	}
+
+	format := "s16" // s16 default format.
+	if options.Format != "" {
+		format = options.Format
+	}
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+	mixer.format = format
+	mixer.bps = int(parse(regexp.MustCompile(`\d{1,2}`).FindString(format))) // Bits per sample.
+
+	return mixer, nil
+}
+
+// Adds the source as a track with the given gain, where 1 is the original volume, and returns
+// the index of the track. The source must have the sample rate of the mixer, and either the
+// same channels, one channel, which is copied to all channels, or any channels if the mixer
+// has one, in which case they are averaged. Samples are converted from the format of the
+// source. The source is read as the mixed audio is read, and closed with the mixer.
+func (mixer *Mixer) Attach(source Source, gain float64) (int, error) {
+	if source.SampleRate() != mixer.samplerate {
+		return 0, fmt.Errorf(
+			"source has a sample rate of %d Hz, but the mixer has %d Hz",
+			source.SampleRate(), mixer.samplerate,
+		)
+	}
+	if channels := source.Channels(); channels != mixer.channels && channels != 1 && mixer.channels != 1 {
+		return 0, fmt.Errorf("source has %d channels, which cannot be mixed into %d channels", channels, mixer.channels)
+	}
+	return mixer.add(&mixerTrack{source: source, format: byteFormat(source), channels: source.Channels(), gain: gain})
+}
+
+// Adds a track fed with WriteTrack with the given gain, where 1 is the original volume, and
+// returns the index of the track. Reading the mixed audio waits for the track to be given a
+// buffer of audio, but no longer than until the buffer is due in real time, counted from the
+// first Read at the sample rate of the mixer. Audio missing by then is mixed as silence and
+// counted as an underrun. The track keeps the mixer from ending until it is closed with
+// CloseTrack.
+func (mixer *Mixer) AddTrack(gain float64) (int, error) {
+	return mixer.add(&mixerTrack{format: mixer.format, channels: mixer.channels, gain: gain})
+}
+
+func (mixer *Mixer) add(track *mixerTrack) (int, error) {
+	if err := checkMixerGain(track.gain); err != nil {
+		return 0, err
+	}
+
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	if mixer.closed {
+		return 0, fmt.Errorf("mixer is closed")
+	}
+	mixer.tracks = append(mixer.tracks, track)
+	return len(mixer.tracks) - 1, nil
+}
+
+// Returns an error if the gain of a track is negative.
+func checkMixerGain(gain float64) error {
+	if !(gain >= 0) {
+		return fmt.Errorf("invalid gain: %v, must be non-negative", gain)
+	}
+	return nil
+}
+
+// Returns the track with the given index. Must be called with the mutex held.
+func (mixer *Mixer) track(index int) (*mixerTrack, error) {
+	if index < 0 || index >= len(mixer.tracks) {
+		return nil, fmt.Errorf("invalid track index: %d, must be between 0 and %d", index, len(mixer.tracks))
+	}
+	return mixer.tracks[index], nil
+}
+
+// Adds the samples to the end of the track with the given index, which must have been added
+// with AddTrack. Byte slices hold samples in the format of the mixer, while other sample slices
+// are converted from their type. Mixers using "s24" or "u24" also accept []int32 or []uint32
+// samples holding 24-bit values. The samples must hold whole frames with the channels of the
+// mixer. Safe to call from any goroutine, e.g. while another goroutine reads the mixed audio.
+func (mixer *Mixer) WriteTrack(index int, samples interface{}) error {
+	buffer := samplesToBytes(samples)
+	if buffer == nil {
+		return fmt.Errorf("invalid sample data type")
+	}
+	format := mixer.format
+	if packed, ok, err := pack24(samples, mixer.format, false); err != nil {
+		return err
+	} else if ok {
+		buffer = packed
+	} else if typed := sampleFormat(samples); typed != "" {
+		format = typed
+	}
+	codec := newSampleCodec(format)
+	if len(buffer)%(codec.size*mixer.channels) != 0 {
+		return fmt.Errorf("number of samples must be a multiple of the %d channels", mixer.channels)
+	}
+
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	track, err := mixer.track(index)
+	if err != nil {
+		return err
+	}
+	if track.source != nil {
+		return fmt.Errorf("track %d is read from its source", index)
+	}
+	if track.ended {
+		return fmt.Errorf("track %d is closed", index)
+	}
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		track.pending = append(track.pending, codec.decode(buffer[i:]))
+	}
+	mixer.written.Broadcast()
+	return nil
+}
+
+// Gain of the track with the given index, where 1 is the original volume.
+func (mixer *Mixer) Gain(index int) (float64, error) {
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	track, err := mixer.track(index)
+	if err != nil {
+		return 0, err
+	}
+	return track.gain, nil
+}
+
+// Sets the gain of the track with the given index, taking effect with the next mixed buffer.
+func (mixer *Mixer) SetGain(index int, gain float64) error {
+	if err := checkMixerGain(gain); err != nil {
+		return err
+	}
+
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	track, err := mixer.track(index)
+	if err != nil {
+		return err
+	}
+	track.gain = gain
+	return nil
+}
+
+// Ends the track with the given index once its pending audio has been mixed. The source of an
+// attached track is closed, and no more samples can be written to that track.
+func (mixer *Mixer) CloseTrack(index int) error {
+	mixer.mutex.Lock()
+	track, err := mixer.track(index)
+	if err == nil {
+		track.ended = true
+		mixer.written.Broadcast()
+	}
+	mixer.mutex.Unlock()
+
+	if err == nil && track.source != nil {
+		track.source.Close()
+	}
+	return err
+}
+
+// Reads the attached source until the track has the given number of frames pending or the
+// source has ended. The mutex is not held while reading, since reading a live source blocks.
+func (mixer *Mixer) fill(track *mixerTrack, frames int) {
+	codec := newSampleCodec(track.format)
+	for {
+		mixer.mutex.Lock()
+		done := track.ended || len(track.pending) >= frames*mixer.channels
+		mixer.mutex.Unlock()
+		if done {
+			return
+		}
+
+		if !track.source.Read() {
+			mixer.mutex.Lock()
+			track.ended = true
+			mixer.mutex.Unlock()
+			return
+		}
+
+		buffer := track.source.Buffer()
+		samples := make([]float64, 0, len(buffer)/codec.size/track.channels*mixer.channels)
+		for i := 0; i+codec.size*track.channels <= len(buffer); i += codec.size * track.channels {
+			switch {
+			case track.channels == mixer.channels:
+				for channel := 0; channel < track.channels; channel++ {
+					samples = append(samples, codec.decode(buffer[i+channel*codec.size:]))
+				}
+			case track.channels == 1:
+				// Mono sources are copied to all channels.
+				value := codec.decode(buffer[i:])
+				for channel := 0; channel < mixer.channels; channel++ {
+					samples = append(samples, value)
+				}
+			default:
+				// All channels are averaged for a mono mixer.
+				sum := 0.0
+				for channel := 0; channel < track.channels; channel++ {
+					sum += codec.decode(buffer[i+channel*codec.size:])
+				}
+				samples = append(samples, sum/float64(track.channels))
+			}
+		}
+
+		mixer.mutex.Lock()
+		track.pending = append(track.pending, samples...)
+		mixer.mutex.Unlock()
+	}
+}
+
+// Returns true if a track fed with WriteTrack is open and has less than the given number of
+// frames pending. Must be called with the mutex held.
+func (mixer *Mixer) starving(tracks []*mixerTrack, frames int) bool {
+	for _, track := range tracks {
+		if track.source == nil && !track.ended && len(track.pending) < frames*mixer.channels {
+			return true
+		}
+	}
+	return false
+}
+
+// Mixes the next frames of all tracks into the buffer, reading the attached sources as needed
+// and waiting for tracks fed with WriteTrack until the buffer is due. Samples are summed after applying the gain of each track, and the sums saturate for integer
+// formats. Returns false once the mixer has been closed, or once all tracks have ended and all
+// of their audio has been mixed. The last buffer is shortened to the remaining audio.
+func (mixer *Mixer) Read() bool {
+	mixer.mutex.Lock()
+	if mixer.closed {
+		mixer.mutex.Unlock()
+		return false
+	}
+	if mixer.buffer == nil {
+		mixer.buffer = make([]byte, mixer.BytesPerSecond())
+	}
+	frames := len(mixer.buffer) / mixer.BytesPerFrame()
+	tracks := append([]*mixerTrack{}, mixer.tracks...)
+	mixer.mutex.Unlock()
+
+	for _, track := range tracks {
+		if track.source != nil {
+			mixer.fill(track, frames)
+		}
+	}
+
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	// The buffer is due once its audio would have been played in real time, so that readers
+	// such as an AudioWriter are paced by the sample rate while tracks are written by hand.
+	if mixer.started.IsZero() {
+		mixer.started = time.Now()
+	}
+	due := mixer.started.Add(time.Duration(float64(mixer.frame+frames) / float64(mixer.samplerate) * float64(time.Second)))
+	for !mixer.closed && mixer.starving(tracks, frames) {
+		wait := time.Until(due)
+		if wait <= 0 {
+			break
+		}
+		timer := time.AfterFunc(wait, func() {
+			mixer.mutex.Lock()
+			mixer.written.Broadcast()
+			mixer.mutex.Unlock()
+		})
+		mixer.written.Wait()
+		timer.Stop()
+	}
+
+	if mixer.closed {
+		return false
+	}
+
+	// Once all tracks have ended, the audio ends with the longest track.
+	live, longest := false, 0
+	for _, track := range tracks {
+		if !track.ended {
+			live = true
+		}
+		if len(track.pending) > longest {
+			longest = len(track.pending)
+		}
+	}
+	if !live {
+		if longest == 0 {
+			return false
+		}
+		if longest/mixer.channels < frames {
+			frames = longest / mixer.channels
+			mixer.buffer = mixer.buffer[:frames*mixer.BytesPerFrame()]
+		}
+	}
+
+	sums := make([]float64, frames*mixer.channels)
+	for _, track := range tracks {
+		n := len(track.pending)
+		if n > len(sums) {
+			n = len(sums)
+		} else if n < len(sums) && !track.ended {
+			mixer.underruns++
+		}
+		for i, sample := range track.pending[:n] {
+			sums[i] += sample * track.gain
+		}
+		track.pending = append(track.pending[:0], track.pending[n:]...)
+	}
+
+	codec := newSampleCodec(mixer.format)
+	for i, sum := range sums {
+		codec.encode(mixer.buffer[i*codec.size:], sum)
+	}
+	mixer.frame += frames
+
+	return true
+}
+
+// Mixes the next frames of audio into a new buffer owned by the caller, along with the time of
+// its first sample from the start of the mix. Returns io.EOF once Read returns false.
+func (mixer *Mixer) ReadFrame() (*Frame, error) {
+	pts := time.Duration(float64(mixer.frame) / float64(mixer.samplerate) * float64(time.Second))
+	if !mixer.Read() {
+		return nil, io.EOF
+	}
+	data := make([]byte, len(mixer.buffer))
+	copy(data, mixer.buffer)
+	return &Frame{Data: data, Samples: len(data) / mixer.BytesPerFrame(), PTS: pts}, nil
+}
+
+// Stops mixing and closes the attached sources. Read returns false afterwards.
+func (mixer *Mixer) Close() {
+	mixer.mutex.Lock()
+	if mixer.closed {
+		mixer.mutex.Unlock()
+		return
+	}
+	mixer.closed = true
+	mixer.written.Broadcast()
+	tracks := mixer.tracks
+	mixer.mutex.Unlock()
+
+	for _, track := range tracks {
+		if track.source != nil {
+			track.source.Close()
+		}
+	}
+}
@@ -1,11 +1,244 @@
 package aio
 
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
 type Options struct {
-	Stream     int    // Audio Stream Index to use.
-	SampleRate int    // Sample rate in Hz.
-	Channels   int    // Number of channels.
-	Bitrate    int    // Bitrate in bits/s.
-	Format     string // Format of audio.
-	Codec      string // Audio Codec.
-	StreamFile string // File path for extra stream data.
+	Stream                 int               // Audio Stream Index to use.
+	SampleRate             int               // Sample rate in Hz.
+	Channels               int               // Number of channels.
+	Bitrate                int               // Bitrate in bits/s.
+	Format                 string            // Format of audio.
+	Codec                  string            // Audio Codec.
+	Quality                string            // Variable bitrate quality of the encoder, e.g. "0" for the highest MP3 quality. Used instead of Bitrate.
+	CompressionLevel       int               // Compression level of the encoder, e.g. 0 to 12 for FLAC.
+	StreamFile             string            // File path for extra stream data.
+	LowLatency             bool              // Flush encoded packets immediately instead of buffering them.
+	MaxDelay               time.Duration     // Maximum time the muxer holds encoded packets with LowLatency, 0 to write them as soon as they are encoded.
+	Chapters               []Chapter         // Chapter markers to write to the output.
+	ID3Version             int               // ID3v2 version (3 or 4) for MP3 output.
+	WriteID3v1             bool              // Write an ID3v1 tag at the end of MP3 output.
+	Metadata               map[string]string // Global metadata tags to write to the output.
+	CopyStreamFileMetadata bool              // Copy metadata from the StreamFile into the output.
+	Outputs                []OutputSpec      // Additional outputs written to alongside the output file.
+	RealTime               bool              // Consume written audio at playback speed.
+	EncoderProgress        bool              // Parse the encoder statistics reported by ffmpeg, returned by AudioWriter.Progress.
+	Volume                 int               // Initial playback volume from 1 to 100. 0 plays at 100, start muted with Player.SetVolume(0).
+	QueueSize              int               // Maximum number of audio frames queued by Player.PlayAsync.
+	Device                 string            // Audio output device name for playback.
+	StrictSamples          bool              // Return an error instead of converting samples that do not match the format.
+	ChannelLayout          string            // Channel layout for playback, e.g. "5.1".
+	Downmix                bool              // Downmix audio with more than two channels to stereo for playback.
+	Filter                 string            // ffmpeg audio filter graph applied by Audio, AudioWriter, Microphone and Player, e.g. built with Filters().
+	ProgressInterval       time.Duration     // Minimum time between calls to the Player.OnProgress callback.
+	Display                int               // Show an ffplay window with the waveform (1) or spectrum (2) during playback.
+	WindowTitle            string            // Title of the ffplay window shown with Display.
+	Lead                   time.Duration     // Maximum amount of audio the Player writes ahead of what has been played, 0 to write unpaced.
+	Latency                time.Duration     // Duration of the output device buffer for playback.
+	LogLevel               string            // ffmpeg log level, e.g. "warning" or "info", for output sent to the logger set with SetLogger.
+	Endianness             string            // Byte order of formats without a "le" or "be" suffix: "le", "be" or "native" (default).
+	ConvertSamples         bool              // Convert samples that do not match the format of an AudioWriter instead of returning an error.
+	ClampSamples           bool              // Clamp 24-bit samples outside of the 24-bit range instead of returning an error.
+	Nice                   int               // Niceness of the ffmpeg processes, from -20 (highest priority) to 19 (lowest priority).
+	InputChannels          []int             // Channels of the Microphone device to record, starting at 0, e.g. []int{2} for its third input.
+	Reverse                bool              // Read the audio from the end to the start.
+	AlignStart             bool              // Pad or trim the start of the decoded audio so that it begins at time zero of the file.
+	DriftCompensation      bool              // Insert or drop Microphone samples to keep the recording locked to the wall clock.
+	IgnoreErrors           bool              // Keep decoding past damaged packets and count them with Audio.DecodeErrors.
+	TrimSilence            bool              // Trim silence from the start and end of the audio while reading.
+	SilenceThreshold       float64           // Level in dBFS below which TrimSilence treats audio as silence, -50 by default.
+	SilenceDuration        time.Duration     // Minimum duration of sound that ends trimmed silence. Shorter sounds at the start or end, e.g. clicks, are trimmed too.
+	ProbeSize              int64             // Maximum number of bytes of the input read to find its streams, at least 32 KiB. 0 for the ffmpeg default of 5 MB.
+	AnalyzeDuration        time.Duration     // Maximum duration of the input analyzed to find its streams, at least 500 ms. 0 for the ffmpeg default of 5 seconds.
+	Threads                int               // Number of threads ffmpeg uses to decode for Audio and to encode for AudioWriter. 0 lets ffmpeg choose.
+	StartTime              float64           // Position in seconds in the file at which Audio starts reading.
+	Duration               float64           // Number of seconds of audio read from StartTime. 0 reads to the end.
+}
+
+// Options used for fields that are not set in the options given to a constructor.
+var defaults = struct {
+	mutex   sync.RWMutex
+	options Options
+}{}
+
+// Sets the options used by all constructors for fields that are not set in their own options,
+// or for all fields if they are given nil options. A field is not set if it has its zero value:
+// 0 for numbers and durations, "" for strings such as Format and Codec, false for booleans and
+// nil for slices and maps. As a result, a boolean set to true in the defaults cannot be turned
+// off by the options of a constructor. Safe to call from any goroutine.
+func SetDefaultOptions(options Options) {
+	defaults.mutex.Lock()
+	defer defaults.mutex.Unlock()
+	defaults.options = options.clone()
+}
+
+// Returns a copy of the options set with SetDefaultOptions.
+func DefaultOptions() Options {
+	defaults.mutex.RLock()
+	defer defaults.mutex.RUnlock()
+	return defaults.options.clone()
+}
+
+// Returns new options with the fields that are set in the given options, which may be nil,
+// and the default options for all other fields. Neither the options nor the defaults are changed.
+func withDefaults(options *Options) *Options {
+	merged := DefaultOptions()
+	if options == nil {
+		return &merged
+	}
+
+	given := reflect.ValueOf(options.clone())
+	result := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < given.NumField(); i++ {
+		if field := given.Field(i); !field.IsZero() {
+			result.Field(i).Set(field)
+		}
+	}
+	return &merged
+}
+
+// Returns a copy of the options that shares no slices or maps with them.
+func (options Options) clone() Options {
+	if options.Chapters != nil {
+		options.Chapters = append([]Chapter{}, options.Chapters...)
+	}
+	if options.Outputs != nil {
+		options.Outputs = append([]OutputSpec{}, options.Outputs...)
+	}
+	if options.InputChannels != nil {
+		options.InputChannels = append([]int{}, options.InputChannels...)
+	}
+	if options.Metadata != nil {
+		metadata := make(map[string]string, len(options.Metadata))
+		for key, value := range options.Metadata {
+			metadata[key] = value
+		}
+		options.Metadata = metadata
+	}
+	return options
+}
+
+// Limits of the sample rate and number of channels accepted by the constructors.
+const (
+	maxSampleRate = 768000
+	maxChannels   = 64
+)
+
+// Error returned by the constructors for an option or argument with an invalid value.
+type OptionError struct {
+	Field  string      // Name of the option or argument, e.g. "SampleRate" or "filename".
+	Value  interface{} // The invalid value.
+	Reason string      // Why the value is invalid, e.g. "must be between 1 and 64".
+}
+
+func (err *OptionError) Error() string {
+	return fmt.Sprintf("invalid %s: %v, %s", err.Field, err.Value, err.Reason)
+}
+
+// Checks the options used by the given constructor, e.g. "NewPlayer", before anything is
+// started. Zero values mean that the default is used and are always valid.
+func (options *Options) validate(context string) error {
+	if options.SampleRate != 0 {
+		if err := checkSampleRate("SampleRate", options.SampleRate); err != nil {
+			return err
+		}
+	}
+	if options.Channels != 0 {
+		if err := checkChannels("Channels", options.Channels); err != nil {
+			return err
+		}
+	}
+
+	if options.Stream < 0 {
+		return &OptionError{"Stream", options.Stream, "must be non-negative"}
+	}
+	if options.Bitrate < 0 {
+		return &OptionError{"Bitrate", options.Bitrate, "must be non-negative"}
+	}
+	if options.CompressionLevel < 0 {
+		return &OptionError{"CompressionLevel", options.CompressionLevel, "must be non-negative"}
+	}
+	if options.Threads < 0 {
+		return &OptionError{"Threads", options.Threads, "must be non-negative"}
+	}
+	if options.Nice < minNice || options.Nice > maxNice {
+		return &OptionError{"Nice", options.Nice, fmt.Sprintf("must be between %d and %d", minNice, maxNice)}
+	}
+	if options.Nice != 0 && !niceSupported {
+		return &OptionError{"Nice", options.Nice, fmt.Sprintf("process priorities are not supported on %s", runtime.GOOS)}
+	}
+
+	switch context {
+	case "NewPlayer":
+		if options.Volume < 0 || options.Volume > 100 {
+			return &OptionError{"Volume", options.Volume, "must be between 0 and 100"}
+		}
+		if options.Display < 0 || options.Display > 2 {
+			return &OptionError{"Display", options.Display, "must be 0, 1 or 2"}
+		}
+		if options.QueueSize < 0 {
+			return &OptionError{"QueueSize", options.QueueSize, "must be non-negative"}
+		}
+		if options.Lead < 0 {
+			return &OptionError{"Lead", options.Lead, "must be non-negative"}
+		}
+		if options.Latency < 0 {
+			return &OptionError{"Latency", options.Latency, "must be non-negative"}
+		}
+		if options.ProgressInterval < 0 {
+			return &OptionError{"ProgressInterval", options.ProgressInterval, "must be non-negative"}
+		}
+	case "NewAudio", "NewAudioStreams", "NewRawAudio":
+		if options.ProbeSize < 0 {
+			return &OptionError{"ProbeSize", options.ProbeSize, "must be non-negative"}
+		}
+		if options.AnalyzeDuration < 0 {
+			return &OptionError{"AnalyzeDuration", options.AnalyzeDuration, "must be non-negative"}
+		}
+		if !(options.StartTime >= 0) {
+			return &OptionError{"StartTime", options.StartTime, "must be non-negative"}
+		}
+		if !(options.Duration >= 0) {
+			return &OptionError{"Duration", options.Duration, "must be non-negative"}
+		}
+		if !(options.SilenceThreshold <= 0) {
+			return &OptionError{"SilenceThreshold", options.SilenceThreshold, "must be negative"}
+		}
+		if options.SilenceDuration < 0 {
+			return &OptionError{"SilenceDuration", options.SilenceDuration, "must be non-negative"}
+		}
+	case "NewAudioWriter":
+		if options.MaxDelay < 0 {
+			return &OptionError{"MaxDelay", options.MaxDelay, "must be non-negative"}
+		}
+	case "NewMicrophone":
+		for _, channel := range options.InputChannels {
+			if channel < 0 {
+				return &OptionError{"InputChannels", options.InputChannels, "must be non-negative"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks that the sample rate is between 1 Hz and the largest supported sample rate.
+func checkSampleRate(field string, samplerate int) error {
+	if samplerate < 1 || samplerate > maxSampleRate {
+		return &OptionError{field, samplerate, fmt.Sprintf("must be between 1 and %d Hz", maxSampleRate)}
+	}
+	return nil
+}
+
+// Checks that the number of channels is between 1 and the largest supported number of channels.
+func checkChannels(field string, channels int) error {
+	if channels < 1 || channels > maxChannels {
+		return &OptionError{field, channels, fmt.Sprintf("must be between 1 and %d", maxChannels)}
+	}
+	return nil
 }
@@ -0,0 +1,56 @@
+package aio
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type OutputSpec struct {
+	Target        string // Output file path or URL.
+	Container     string // Container format, e.g. "mpegts". Guessed from the target if empty.
+	IgnoreFailure bool   // Keep writing to other outputs if this output fails.
+}
+
+// Creates the output string for the ffmpeg tee muxer, e.g. "[onfail=abort]a.mp3|[f=mpegts:onfail=ignore]udp://...".
+// https://ffmpeg.org/ffmpeg-formats.html#tee-1.
+func teeTarget(outputs []OutputSpec) string {
+	slaves := make([]string, len(outputs))
+	for i, output := range outputs {
+		options := []string{}
+		if output.Container != "" {
+			options = append(options, "f="+escape(output.Container, ":]"))
+		}
+		// The "onfail" option is always given so the target is never mistaken for an option list.
+		if output.IgnoreFailure {
+			options = append(options, "onfail=ignore")
+		} else {
+			options = append(options, "onfail=abort")
+		}
+		slave := fmt.Sprintf("[%s]%s", strings.Join(options, ":"), output.Target)
+		slaves[i] = escape(slave, "|")
+	}
+	return strings.Join(slaves, "|")
+}
+
+// Returns the indices of all failed tee outputs from the ffmpeg log.
+func failedOutputs(log string) []int {
+	indices := []int{}
+	regex := regexp.MustCompile(`Slave muxer #(\d+) failed`)
+	for _, match := range regex.FindAllStringSubmatch(log, -1) {
+		index := int(parse(match[1]))
+		if !containsInt(indices, index) {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+func containsInt(list []int, item int) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
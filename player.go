@@ -1,20 +1,86 @@
 package aio
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
-	"os/signal"
-	"syscall"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Player struct {
-	samplerate int            // Audio Sample Rate in Hz.
-	channels   int            // Number of audio channels.
-	format     string         // Format of audio samples.
-	pipe       io.WriteCloser // Stdin pipe for ffplay process.
-	cmd        *exec.Cmd      // ffplay command.
+	samplerate int           // Audio Sample Rate in Hz.
+	channels   int           // Number of audio channels.
+	format     string        // Format of audio samples.
+	bps        int           // Bits per sample.
+	wire       string        // Format of the samples written to the playback process.
+	clamp      bool          // Flag storing whether 24-bit samples outside of the 24-bit range saturate.
+	volume     int           // Initial ffplay volume from 0 to 100.
+	gain       float64       // Gain applied to played samples.
+	balance    float64       // Stereo balance from -1 (left) to 1 (right).
+	lead       time.Duration // Maximum amount of audio written ahead of what has been played, 0 if unpaced.
+	latency    time.Duration // Requested output device buffer duration, 0 for the default.
+	offset     time.Duration // Sync offset by which audio is delayed.
+	adjust     int           // Frames of silence to insert (positive) or audio to drop (negative).
+	skew       int           // Bytes of silence inserted minus bytes of audio dropped.
+	fadefrom   float64       // Gain at the start of the current fade.
+	fadeto     float64       // Gain at the end of the current fade.
+	fadeframes int           // Length of the current fade in frames, 0 if there is no fade.
+	fadedone   int           // Number of frames of the current fade that have been written.
+	paused     bool          // Flag storing whether playback is paused.
+	closed     bool          // Flag storing whether the player has been closed.
+	written    int           // Number of bytes written to ffplay.
+	start      time.Time     // Time at which the written audio started playing.
+	mutex      sync.Mutex    // Mutex guarding the playback state.
+	resume     *sync.Cond    // Wakes up Play calls waiting for playback to resume.
+	queue      [][]byte      // Buffers queued by PlayAsync.
+	queued     int           // Number of bytes in the queue.
+	queuesize  int           // Maximum number of bytes in the queue.
+	working    bool          // Flag storing whether the queue is being played.
+	dequeued   *sync.Cond    // Signals that buffers have been removed from the queue.
+	err        error         // First error encountered while playing queued buffers.
+	device     string        // Audio output device name.
+	backend    string        // Program used for playback, either "ffplay" or "ffmpeg".
+	strict     bool          // Flag storing whether mismatched sample types are rejected.
+	loglevel   string        // ffmpeg log level when logging is enabled.
+	nice       int           // Niceness of the playback process.
+	endianness string        // Byte order of formats without a "le" or "be" suffix.
+	layout     string        // Channel layout of the audio, e.g. "5.1".
+	downmix    bool          // Flag storing whether audio is downmixed to stereo.
+	filter     string        // ffmpeg audio filter graph applied during playback.
+	display    int           // ffplay visualization mode, 0 if no window is shown.
+	title      string        // Title of the ffplay window.
+	looping    bool          // Flag storing whether PlayLoop is running.
+	underrun   bool          // Flag storing whether playback ran out of audio since the last write.
+	idle       bool          // Flag storing whether playback was paused since the last write.
+	underruns  int           // Number of times playback ran out of audio.
+	metrics    *tally        // Counters of the audio written to the playback process, returned by Metrics.
+	onunderrun func()        // Callback invoked when playback runs out of audio.
+	onprogress func(int64)   // Callback invoked with the number of samples played.
+	interval   time.Duration // Minimum time between calls to the progress callback.
+	notified   time.Time     // Time at which the progress callback was last invoked.
+	notifying  bool          // Flag storing whether the progress callback is running.
+	generation int           // Incremented by Stop to cancel writes in progress.
+	stopped    chan struct{} // Closed and replaced by Stop to cancel PlayChan.
+	starting   sync.Mutex    // Mutex ensuring only one playback process is started.
+	process    *playback     // Running playback process.
+	stack      []byte        // Stack of the constructor call, captured if leak detection is enabled.
+}
+
+// A running ffplay or ffmpeg playback process.
+type playback struct {
+	log    *ffmpegLog     // Stderr output of the process.
+	exited chan struct{}  // Closed once the process has exited.
+	err    error          // Error returned by the process. Set before exited is closed.
+	pipe   io.WriteCloser // Stdin pipe for the process.
+	cmd    *exec.Cmd      // ffplay or ffmpeg command.
+	handle *processHandle // Handle used by CloseAll to stop the process.
 }
 
 func (player *Player) SampleRate() int {
@@ -25,6 +91,41 @@ func (player *Player) Channels() int {
 	return player.channels
 }
 
+func (player *Player) BitsPerSample() int {
+	return player.bps
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (player *Player) BytesPerFrame() int {
+	return player.bps / 8 * player.channels
+}
+
+// Number of samples in one audio frame, which is the number of channels.
+func (player *Player) SamplesPerFrame() int {
+	return player.channels
+}
+
+// Number of bytes in one second of audio.
+func (player *Player) BytesPerSecond() int {
+	return player.samplerate * player.BytesPerFrame()
+}
+
+// Number of bytes in one frame of the audio written to the playback process.
+func (player *Player) wireFrame() int {
+	return newSampleCodec(player.wire).size * player.channels
+}
+
+// Returns the format written to the playback process for the given format. Packed 24-bit samples
+// cannot be read by ffplay on several builds, so they are expanded to 32 bits.
+func wireFormat(format string) string {
+	switch sampleType(format) {
+	case "s24", "u24":
+		return "s32" + format[3:]
+	default:
+		return format
+	}
+}
+
 func (player *Player) Format() string {
 	switch player.format {
 	case "u8", "s8":
@@ -34,14 +135,288 @@ func (player *Player) Format() string {
 	}
 }
 
-func NewPlayer(channels, samplerate int, format string) (*Player, error) {
-	// Check if ffplay is installed on the users machine.
-	if err := installed("ffplay"); err != nil {
+// Playback volume, where 1 is the original volume of the samples. This combines
+// the initial Volume option with the gain set by SetVolume.
+func (player *Player) Volume() float64 {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return float64(player.volume) / 100 * player.gain
+}
+
+// Sets the gain applied to all samples played after this call, on top of the initial
+// Volume option. Integer samples saturate when a gain larger than 1 pushes them out of range.
+func (player *Player) SetVolume(volume float64) error {
+	if volume < 0 {
+		return fmt.Errorf("volume must be non-negative")
+	}
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.gain = volume
+	return nil
+}
+
+// Stereo balance from -1 (full left) to 1 (full right), where 0 is centered.
+func (player *Player) Balance() float64 {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.balance
+}
+
+// Sets the stereo balance of all samples played after this call, from -1 (full left) to
+// 1 (full right). The other channel is attenuated, on top of the volume. Only stereo audio
+// can be balanced.
+func (player *Player) SetBalance(balance float64) error {
+	if balance < -1 || balance > 1 {
+		return fmt.Errorf("balance must be between -1 and 1")
+	}
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	if player.channels != 2 {
+		return fmt.Errorf("balance requires 2 channels, player has %d", player.channels)
+	}
+	player.balance = balance
+	return nil
+}
+
+// Maximum number of audio frames queued by PlayAsync.
+func (player *Player) QueueSize() int {
+	return player.queuesize / player.wireFrame()
+}
+
+// Returns the first error encountered while playing buffers queued by PlayAsync, or
+// an error containing the output of the playback process if it has failed.
+func (player *Player) Error() error {
+	player.mutex.Lock()
+	err := player.err
+	process := player.process
+	player.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if process != nil {
+		return process.check()
+	}
+	return nil
+}
+
+// Number of bytes written to the playback process since playback started. Audio in a 24-bit
+// format is written with 4 bytes per sample.
+func (player *Player) BytesWritten() int {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.written
+}
+
+// Returns the number of seconds of audio that have been played. This is estimated from the
+// number of bytes written and the time since playback started, and may run ahead of the
+// audio heard by the lead plus the output device latency, or by the audio buffered in the pipe
+// to the playback process if writes are not paced. While paused, the position stops increasing
+// once the audio queued before the pause has been played.
+func (player *Player) Position() float64 {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	second := float64(player.samplerate * player.wireFrame())
+	return float64(player.written-player.skew)/second - player.ahead().Seconds()
+}
+
+// Returns the duration of audio that has been given to the player but not played yet,
+// including audio in the PlayAsync queue.
+func (player *Player) BufferedDuration() time.Duration {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	second := float64(player.samplerate * player.wireFrame())
+	queued := time.Duration(float64(player.queued) / second * float64(time.Second))
+	return player.ahead() + queued
+}
+
+// Maximum amount of audio written to the playback process ahead of what has been played.
+// This is 0 if writes are not paced.
+func (player *Player) Lead() time.Duration {
+	return player.lead
+}
+
+// Duration of the output device buffer requested from the audio system. This is 0 if
+// the default is used, which is always the case on systems other than Linux.
+func (player *Player) Latency() time.Duration {
+	return player.latency
+}
+
+// Returns a snapshot of the audio written to the playback process so far. Bytes count the audio
+// as it is sent to ffplay, e.g. with 4 bytes per 24-bit sample.
+func (player *Player) Metrics() Metrics {
+	return player.metrics.snapshot()
+}
+
+// Sets all metrics to zero.
+func (player *Player) ResetMetrics() {
+	player.metrics.reset()
+}
+
+// Number of times playback ran out of audio because samples were not played fast enough.
+func (player *Player) Underruns() int {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.underruns
+}
+
+// Sets a callback invoked on its own goroutine whenever playback runs out of audio because
+// samples were not played fast enough. Underruns are detected when the next samples are played.
+func (player *Player) OnUnderrun(callback func()) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.onunderrun = callback
+}
+
+// Sets a callback invoked on its own goroutine with the number of samples per channel played
+// since playback started, at most once per Options.ProgressInterval while audio is being played.
+// If the callback is still running when the next call is due, that call is skipped.
+func (player *Player) OnProgress(callback func(playedSamples int64)) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.onprogress = callback
+}
+
+// Program used for playback. This is "ffplay", or "ffmpeg" if ffplay is not installed
+// or an output device was selected.
+func (player *Player) Backend() string {
+	return player.backend
+}
+
+// Channel layout of the audio. Empty if the layout is guessed from the number of channels.
+func (player *Player) ChannelLayout() string {
+	return player.layout
+}
+
+// Returns true if audio with more than two channels is downmixed to stereo.
+func (player *Player) Downmix() bool {
+	return player.downmix
+}
+
+// ffmpeg audio filter graph applied during playback.
+func (player *Player) Filter() string {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.filter
+}
+
+// ffplay visualization mode: 0 if no window is shown, 1 for the waveform and 2 for the spectrum.
+func (player *Player) Display() int {
+	return player.display
+}
+
+// Audio output device name. Empty if the system default device is used.
+func (player *Player) Device() string {
+	return player.device
+}
+
+// Returns true if PlayLoop is running.
+func (player *Player) Looping() bool {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.looping
+}
+
+// Returns true if playback is paused.
+func (player *Player) Paused() bool {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.paused
+}
+
+// Returns the names of all audio output devices that can be passed to NewPlayer via Options.Device.
+// Supported on Linux (PulseAudio) and MacOS.
+func ListPlaybackDevices() ([]string, error) {
+	if err := installed("ffmpeg"); err != nil {
 		return nil, err
 	}
+	return getSpeakers()
+}
 
-	format = createFormat(format)
-	if err := checkFormat(format); err != nil {
+// Plays the audio file and blocks until it has finished playing. The options are used
+// to read the file (e.g. to select the audio stream) and to configure the Player.
+func PlayFile(filename string, options *Options) error {
+	return PlayFileContext(context.Background(), filename, options)
+}
+
+// Same as PlayFile, but stops playback once the context is done.
+func PlayFileContext(ctx context.Context, filename string, options *Options) error {
+	audio, err := NewAudioContext(ctx, filename, options)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+
+	player, err := NewPlayer(audio.Channels(), audio.SampleRate(), audio.Format(), options)
+	if err != nil {
+		return err
+	}
+
+	// Read the audio in chunks of 100 ms so cancellation takes effect quickly.
+	frame := audio.Channels() * audio.BitsPerSample() / 8
+	audio.SetBuffer(make([]byte, audio.SampleRate()/10*frame))
+
+	for audio.Read() {
+		select {
+		case <-ctx.Done():
+			player.Stop()
+			return ctx.Err()
+		default:
+		}
+		if err := player.Play(audio.Buffer()); err != nil {
+			player.Stop()
+			return err
+		}
+	}
+
+	return player.Wait()
+}
+
+// Creates a player for audio with the given number of channels, sample rate and format. The
+// options are optional, so that players created without them keep working.
+func NewPlayer(channels, samplerate int, format string, options ...*Options) (*Player, error) {
+	if len(options) > 1 {
+		return nil, &OptionError{"options", len(options), "must be at most one"}
+	}
+	var given *Options
+	if len(options) == 1 {
+		given = options[0]
+	}
+	return newPlayer(channels, samplerate, format, withDefaults(given))
+}
+
+func newPlayer(channels, samplerate int, format string, options *Options) (*Player, error) {
+
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := options.validate("NewPlayer"); err != nil {
+		return nil, err
+	}
+
+	// Audio is played with ffplay if it is installed on the users machine. Otherwise, or if an
+	// output device is given, ffmpeg writes the audio to the platform audio output.
+	backend := "ffplay"
+	ffplayErr := installed("ffplay")
+	if ffplayErr != nil || options.Device != "" {
+		if _, err := speaker(); err != nil {
+			// Without an output device, ffplay not being installed is the more useful error.
+			if options.Device == "" {
+				return nil, ffplayErr
+			}
+			return nil, err
+		}
+		if err := installed("ffmpeg"); err != nil {
+			return nil, err
+		}
+		backend = "ffmpeg"
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
 		return nil, err
 	}
 
@@ -49,58 +424,667 @@ func NewPlayer(channels, samplerate int, format string) (*Player, error) {
 		samplerate: samplerate,
 		channels:   channels,
 		format:     format,
+		bps:        int(parse(regexp.MustCompile(`\d{1,2}`).FindString(format))), // Bits per sample.
+		wire:       wireFormat(format),
+		clamp:      options.ClampSamples,
+		gain:       1,
+		device:     options.Device,
+		backend:    backend,
+		strict:     options.StrictSamples,
+		loglevel:   options.LogLevel,
+		nice:       options.Nice,
+		endianness: options.Endianness,
+		layout:     options.ChannelLayout,
+		downmix:    options.Downmix,
+		filter:     options.Filter,
+		display:    options.Display,
+		title:      options.WindowTitle,
+		metrics:    &tally{},
+		stack:      creationStack(),
+	}
+
+	if options.Filter != "" {
+		if err := checkFilters(options.Filter); err != nil {
+			return nil, err
+		}
 	}
 
+	if options.Display != 0 {
+		if backend != "ffplay" {
+			return nil, fmt.Errorf("showing a window requires ffplay and the default output device")
+		}
+		if !display() {
+			return nil, fmt.Errorf("no display available to show the ffplay window")
+		}
+	}
+
+	if options.ChannelLayout != "" {
+		if err := checkLayout(options.ChannelLayout, channels); err != nil {
+			return nil, err
+		}
+	}
+
+	// Since 0 is the zero value of the option, it cannot mute the player. Muting is done
+	// with SetVolume(0) before the first Play instead.
+	player.volume = 100 // Full volume by default.
+	if options.Volume != 0 {
+		player.volume = options.Volume
+	}
+	player.resume = sync.NewCond(&player.mutex)
+	player.dequeued = sync.NewCond(&player.mutex)
+	player.stopped = make(chan struct{})
+
+	// Pacing is opt-in, so that by default Play writes as fast as the playback process
+	// accepts audio.
+	player.lead = options.Lead
+
+	// The output buffer can only be configured for PulseAudio.
+	if runtime.GOOS == "linux" && options.Latency > 0 {
+		player.latency = options.Latency
+		if player.latency < time.Millisecond {
+			player.latency = time.Millisecond
+		}
+	}
+
+	player.interval = 100 * time.Millisecond
+	if options.ProgressInterval != 0 {
+		player.interval = options.ProgressInterval
+	}
+
+	// Queue up to one second of audio by default.
+	player.queuesize = player.samplerate * player.wireFrame()
+	if options.QueueSize != 0 {
+		player.queuesize = options.QueueSize * player.wireFrame()
+	}
+
+	runtime.SetFinalizer(player, (*Player).leaked)
 	return player, nil
 }
 
+// Starts the playback process if it is not running yet.
+func (player *Player) open() error {
+	player.starting.Lock()
+	defer player.starting.Unlock()
+
+	player.mutex.Lock()
+	running := player.process != nil
+	closed := player.closed
+	player.mutex.Unlock()
+
+	if closed {
+		return fmt.Errorf("player is closed")
+	}
+	if running {
+		return nil
+	}
+	return player.init()
+}
+
 func (player *Player) init() error {
-	// If user exits with Ctrl+C, stop ffplay process.
-	player.cleanup()
-	// ffplay command to plat an audio stream. Takes in bytes from Stdin.
-	cmd := exec.Command(
-		"ffplay",
-		"-f", player.format,
+	var cmd *exec.Cmd
+	if player.backend == "ffplay" {
+		// ffplay command to play an audio stream. Takes in bytes from Stdin.
+		command := append(
+			player.input(),
+			"-autoexit",
+			"-volume", fmt.Sprintf("%d", player.volume),
+			"-loglevel", logLevel(player.loglevel, "error"),
+		)
+		if player.display == 0 {
+			command = append(command, "-nodisp")
+		} else {
+			command = append(command, "-showmode", fmt.Sprintf("%d", player.display))
+			if player.title != "" {
+				command = append(command, "-window_title", player.title)
+			}
+		}
+		if filters := player.filters(); len(filters) > 0 {
+			command = append(command, "-af", strings.Join(filters, ","))
+		}
+		cmd = exec.Command("ffplay", command...)
+		// ffplay plays audio through SDL, which uses the PulseAudio latency setting on Linux.
+		if player.latency > 0 {
+			cmd.Env = append(os.Environ(), fmt.Sprintf("PULSE_LATENCY_MSEC=%d", player.latency.Milliseconds()))
+		}
+	} else {
+		command, err := player.sinkCommand()
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("ffmpeg", command...)
+	}
+
+	process := &playback{
+		log:    &ffmpegLog{},
+		exited: make(chan struct{}),
+		cmd:    cmd,
+	}
+	cmd.Stderr = logOutput(cmd, player.loglevel, process.log)
+
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	process.pipe = pipe
+
+	if err := startNice(cmd, player.nice); err != nil {
+		return err
+	}
+
+	// The process is unregistered once it has exited.
+	process.handle = registerHandle(cmd, func() error {
+		logEvent(cmd, "killed")
+		cmd.Process.Kill()
+		<-process.exited
+		return nil
+	})
+
+	go func() {
+		process.err = cmd.Wait()
+		unregister(cmd)
+		close(process.exited)
+	}()
+
+	// Give the process a moment to open the output device, so that invalid devices
+	// are reported here rather than on a later write.
+	select {
+	case <-process.exited:
+		return process.failure()
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	player.mutex.Lock()
+	player.process = process
+	player.mutex.Unlock()
+
+	return nil
+}
+
+// Returns an error if the process has exited or failed to open the audio device.
+func (process *playback) check() error {
+	select {
+	case <-process.exited:
+		return process.failure()
+	default:
+	}
+
+	// ffplay keeps running without sound if the audio device cannot be opened.
+	if strings.Contains(process.log.String(), "audio open failed") {
+		process.cmd.Process.Kill()
+		<-process.exited
+		return process.failure()
+	}
+
+	return nil
+}
+
+// Creates an error describing why playback failed from the output of the process.
+// Must only be called once the process has exited.
+func (process *playback) failure() error {
+	if message := strings.TrimSpace(process.log.String()); message != "" {
+		return fmt.Errorf("audio playback failed: %s", message)
+	}
+	if process.err != nil {
+		return fmt.Errorf("audio playback failed: %v", process.err)
+	}
+	return fmt.Errorf("audio playback failed: playback process exited")
+}
+
+// Returns the arguments describing the raw audio input read from stdin.
+func (player *Player) input() []string {
+	command := []string{
+		"-f", player.wire,
 		"-ac", fmt.Sprintf("%d", player.channels),
+	}
+	if player.layout != "" {
+		command = append(command, "-ch_layout", player.layout)
+	}
+	return append(
+		command,
 		"-ar", fmt.Sprintf("%d", player.samplerate),
 		"-i", "-",
-		"-nodisp",
-		"-autoexit",
-		"-loglevel", "quiet",
 	)
+}
 
-	player.cmd = cmd
+// Returns the audio filters applied during playback.
+func (player *Player) filters() []string {
+	filters := []string{}
+	if player.downmix && player.channels > 2 {
+		filters = append(filters, "aformat=channel_layouts=stereo")
+	}
+	if player.filter != "" {
+		filters = append(filters, player.filter)
+	}
+	return filters
+}
 
-	pipe, err := cmd.StdinPipe()
+// Returns the ffmpeg arguments used to play audio on the selected output device,
+// or the default output device if none was selected.
+func (player *Player) sinkCommand() ([]string, error) {
+	sink, err := speaker()
+	if err != nil {
+		return nil, err
+	}
+
+	command := append([]string{"-hide_banner", "-loglevel", logLevel(player.loglevel, "error")}, player.input()...)
+
+	// ffmpeg has no volume option, so the initial volume is applied with a filter.
+	filters := player.filters()
+	if player.volume != 100 {
+		filters = append(filters, fmt.Sprintf("volume=%f", float64(player.volume)/100))
+	}
+	if len(filters) > 0 {
+		command = append(command, "-af", strings.Join(filters, ","))
+	}
+
+	command = append(command, "-f", sink)
+
+	switch sink {
+	case "pulse":
+		if player.latency > 0 {
+			command = append(command, "-buffer_duration", fmt.Sprintf("%d", player.latency.Milliseconds()))
+		}
+		if player.device != "" {
+			command = append(command, "-device", player.device)
+		}
+		command = append(command, "aio")
+	case "audiotoolbox":
+		if player.device == "" {
+			command = append(command, "-")
+			break
+		}
+		// audiotoolbox selects devices by index.
+		devices, err := getSpeakers()
+		if err != nil {
+			return nil, err
+		}
+		index := -1
+		for i, device := range devices {
+			if device == player.device {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("could not find audio output device: %s", player.device)
+		}
+		command = append(command, "-audio_device_index", fmt.Sprintf("%d", index), "-")
+	}
+
+	return command, nil
+}
+
+// Converts the samples to bytes in the format written to the playback process and applies the
+// gain set by SetVolume. Players using "s24" or "u24" also accept []int32 or []uint32 samples
+// holding 24-bit values, like AudioWriter. The returned buffer may share memory with the samples.
+func (player *Player) prepare(samples interface{}) ([]byte, error) {
+	if player.Looping() {
+		return nil, fmt.Errorf("player is looping, stop the loop before playing other audio")
+	}
+
+	buffer := samplesToBytes(samples)
+	if buffer == nil {
+		return nil, fmt.Errorf("invalid sample data type")
+	}
+
+	from := player.format
+	if packed, ok, err := pack24(samples, player.format, player.clamp); err != nil {
+		return nil, err
+	} else if ok {
+		buffer = packed
+	} else if format := sampleFormat(samples); format != "" && format != player.format {
+		// Samples only differing in byte order are swapped even in strict mode.
+		if player.strict && sampleType(format) != sampleType(player.format) {
+			return nil, fmt.Errorf("samples of type %T do not match the player format %s", samples, player.Format())
+		}
+		from = format
+	}
+	if from != player.wire {
+		buffer = convertBuffer(buffer, from, player.wire)
+	}
+
+	if err := player.open(); err != nil {
+		return nil, err
+	}
+
+	player.mutex.Lock()
+	gain := player.gain
+	balance := player.balance
+	player.mutex.Unlock()
+
+	if balance != 0 {
+		// Only the quieter side is attenuated, so centered audio keeps its volume.
+		left, right := gain*math.Min(1, 1-balance), gain*math.Min(1, 1+balance)
+		buffer = applyChannelGains(buffer, player.wire, []float64{left, right})
+	} else if gain != 1 {
+		buffer = applyGain(buffer, player.wire, gain)
+	}
+
+	return buffer, nil
+}
+
+// Plays the samples, blocking until they have been written to ffplay.
+// Any buffers queued by PlayAsync are played first.
+func (player *Player) Play(samples interface{}) error {
+	buffer, err := player.prepare(samples)
 	if err != nil {
 		return err
 	}
-	player.pipe = pipe
 
-	if err := cmd.Start(); err != nil {
+	player.drain()
+
+	return player.write(buffer)
+}
+
+// Plays the samples like Play, so that the Player can be used as a Sink.
+func (player *Player) Write(samples interface{}) error {
+	return player.Play(samples)
+}
+
+// Adds the samples to the playback queue and returns immediately. If the queue is full,
+// PlayAsync blocks until there is enough space. Errors from playing earlier buffers are
+// returned by the next call to PlayAsync and by Error.
+func (player *Player) PlayAsync(samples interface{}) error {
+	buffer, err := player.prepare(samples)
+	if err != nil {
 		return err
 	}
 
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if player.err != nil {
+		return player.err
+	}
+
+	// The samples may be reused by the caller once PlayAsync returns.
+	queued := make([]byte, len(buffer))
+	copy(queued, buffer)
+
+	// Buffers larger than the queue are only added once the queue is empty.
+	for player.queued > 0 && player.queued+len(queued) > player.queuesize {
+		player.dequeued.Wait()
+	}
+
+	player.queue = append(player.queue, queued)
+	player.queued += len(queued)
+
+	if !player.working {
+		player.working = true
+		go player.work()
+	}
+
 	return nil
 }
 
-func (player *Player) Play(samples interface{}) error {
-	buffer := samplesToBytes(samples)
-	if buffer == nil {
-		return fmt.Errorf("invalid sample data type")
+// Plays all queued buffers. Runs on its own goroutine until the queue is empty.
+func (player *Player) work() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	for len(player.queue) > 0 {
+		buffer := player.queue[0]
+		generation := player.generation
+
+		player.mutex.Unlock()
+		err := player.write(buffer)
+		player.mutex.Lock()
+
+		// Stop clears the queue and any errors caused by stopping playback.
+		if generation == player.generation {
+			player.queue = player.queue[1:]
+			player.queued -= len(buffer)
+			if err != nil && player.err == nil {
+				player.err = err
+			}
+		}
+		player.dequeued.Broadcast()
 	}
 
-	// If cmd is nil, audio player has not been initialized.
-	if player.cmd == nil {
-		if err := player.init(); err != nil {
-			return err
+	player.working = false
+	player.dequeued.Broadcast()
+}
+
+// Blocks until all queued buffers have been written to ffplay.
+func (player *Player) drain() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	for player.working {
+		player.dequeued.Wait()
+	}
+}
+
+// Plays the samples on repeat without gaps until the stop channel is closed. The loop stops
+// within 100 ms of closing the channel. Calls to Play and PlayAsync fail while the loop is running.
+// The loop must be stopped before closing the player.
+func (player *Player) PlayLoop(samples interface{}, stop <-chan struct{}) error {
+	buffer, err := player.prepare(samples)
+	if err != nil {
+		return err
+	}
+
+	player.drain()
+
+	player.mutex.Lock()
+	if player.looping {
+		player.mutex.Unlock()
+		return fmt.Errorf("player is already looping")
+	}
+	player.looping = true
+	player.mutex.Unlock()
+
+	defer func() {
+		player.mutex.Lock()
+		player.looping = false
+		player.mutex.Unlock()
+	}()
+
+	if len(buffer) == 0 {
+		<-stop
+		return nil
+	}
+
+	// Write the buffer in chunks of 100 ms to check the stop channel regularly.
+	frame := player.wireFrame()
+	chunk := player.samplerate / 10 * frame
+
+	for {
+		for i := 0; i < len(buffer); i += chunk {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+
+			end := i + chunk
+			if end > len(buffer) {
+				end = len(buffer)
+			}
+			if err := player.write(buffer[i:end]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Plays the audio from the source, e.g. an Audio, until it has been read completely, blocking
+// until all of it has been written to the playback process. The channels and sample rate of the
+// source must match the player, and samples are converted to the format of the player. Errors
+// from reading the audio start with "reading audio" and errors from playing it start with
+// "playing audio". The source is closed once PlayAudio returns.
+func (player *Player) PlayAudio(source Source) error {
+	return pipe(source, player, "playing")
+}
+
+// Plays raw audio data received from the channel until it is closed, blocking while the
+// playback process is full. Returns when the channel is closed, an error occurs or Stop is called.
+func (player *Player) PlayChan(ch <-chan []byte) error {
+	stopped := player.stopChannel()
+	for {
+		select {
+		case buffer, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := player.Play(buffer); err != nil {
+				return err
+			}
+		case <-stopped:
+			return fmt.Errorf("playback stopped")
+		}
+	}
+}
+
+// Same as PlayChan, but receives sample slices which are converted and validated as in Play.
+func (player *Player) PlaySamplesChan(ch <-chan interface{}) error {
+	stopped := player.stopChannel()
+	for {
+		select {
+		case samples, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := player.Play(samples); err != nil {
+				return err
+			}
+		case <-stopped:
+			return fmt.Errorf("playback stopped")
+		}
+	}
+}
+
+// Returns the channel closed by the next call to Stop.
+func (player *Player) stopChannel() <-chan struct{} {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.stopped
+}
+
+// Adapts a Player to io.WriteCloser.
+type playerWriter struct {
+	player  *Player
+	partial []byte // Bytes of an incomplete frame from the last write.
+}
+
+// Returns a writer that plays raw audio data in the format of the player. Data is only played in
+// whole frames; the bytes of an incomplete frame are kept until the next write completes it and
+// are discarded on Close. Closing the writer plays all remaining audio and waits for it to finish.
+func (player *Player) Writer() io.WriteCloser {
+	return &playerWriter{player: player}
+}
+
+func (writer *playerWriter) Write(data []byte) (int, error) {
+	player := writer.player
+	frame := player.BytesPerFrame()
+
+	buffer := data
+	if len(writer.partial) > 0 {
+		buffer = append(writer.partial, data...)
+	}
+
+	whole := len(buffer) - len(buffer)%frame
+	if whole > 0 {
+		if err := player.Play(buffer[:whole]); err != nil {
+			return 0, err
 		}
 	}
 
+	writer.partial = append([]byte{}, buffer[whole:]...)
+	return len(data), nil
+}
+
+func (writer *playerWriter) Close() error {
+	writer.partial = nil
+	writer.player.Resume()
+	return writer.player.Wait()
+}
+
+// Writes the buffer to ffplay. If a lead is set, writes are paced so that no more than the
+// lead of audio is queued ahead of playback, which allows Pause to take effect quickly.
+// Otherwise the buffer is written at once and the pipe to ffplay applies backpressure.
+func (player *Player) write(buffer []byte) error {
+	frame := player.wireFrame()
+	second := player.samplerate * frame
+
+	player.mutex.Lock()
+	generation := player.generation
+	buffer = player.sync(buffer)
+	player.mutex.Unlock()
+
 	total := 0
 	for total < len(buffer) {
-		n, err := player.pipe.Write(buffer[total:])
+		player.mutex.Lock()
+		for player.paused && generation == player.generation {
+			player.resume.Wait()
+		}
+		if generation != player.generation {
+			player.mutex.Unlock()
+			return fmt.Errorf("playback stopped")
+		}
+		process := player.process
+		ahead := player.ahead()
+		// Running out of audio while paused is expected and not an underrun.
+		if player.underrun && !player.idle {
+			player.underruns++
+			player.metrics.underrun()
+			if player.onunderrun != nil {
+				go player.onunderrun()
+			}
+		}
+		player.underrun = false
+		player.idle = false
+		player.progress(ahead)
+		player.mutex.Unlock()
+
+		if process == nil {
+			return fmt.Errorf("player is closed")
+		}
+
+		size := len(buffer) - total
+		if player.lead > 0 {
+			if ahead >= player.lead {
+				time.Sleep(ahead - player.lead/2)
+				continue
+			}
+
+			// Write as many whole frames as fit into the remaining lead.
+			size = int(float64(second) * (player.lead - ahead).Seconds())
+			size -= size % frame
+			if size < frame {
+				size = frame
+			}
+		}
+		end := total + size
+		if end > len(buffer) {
+			end = len(buffer)
+		}
+
+		if err := process.check(); err != nil {
+			return err
+		}
+
+		chunk, faded := player.fade(buffer[total:end])
+		if faded {
+			return fmt.Errorf("playback stopped")
+		}
+
+		started := time.Now()
+		n, err := process.pipe.Write(chunk)
+		player.metrics.transfer(n, frame, started)
+		player.mutex.Lock()
+		stopped := generation != player.generation
+		if !stopped {
+			player.written += n
+		}
+		player.mutex.Unlock()
+		if stopped {
+			return fmt.Errorf("playback stopped")
+		}
 		if err != nil {
+			player.metrics.failed()
+			// Writing fails if the process has exited, in which case its output explains why.
+			if err := process.check(); err != nil {
+				return err
+			}
 			return err
 		}
 		total += n
@@ -109,29 +1093,361 @@ func (player *Player) Play(samples interface{}) error {
 	return nil
 }
 
-// Closes the pipe and stops the ffplay process.
-func (player *Player) Close() {
-	if player.pipe != nil {
-		player.pipe.Close()
+// Invokes the progress callback if the progress interval has passed since it was last invoked.
+// Must be called with the mutex held.
+func (player *Player) progress(ahead time.Duration) {
+	if player.onprogress == nil || player.notifying || time.Since(player.notified) < player.interval {
+		return
+	}
+
+	frame := player.wireFrame()
+	played := int64((player.written-player.skew)/frame) - int64(ahead.Seconds()*float64(player.samplerate))
+
+	player.notified = time.Now()
+	player.notifying = true
+	callback := player.onprogress
+	go func() {
+		callback(played)
+		player.mutex.Lock()
+		player.notifying = false
+		player.mutex.Unlock()
+	}()
+}
+
+// Applies the pending sync offset adjustment to the buffer about to be written, inserting
+// silence in front of it or dropping audio from its start. Must be called with the mutex held.
+func (player *Player) sync(buffer []byte) []byte {
+	frame := player.wireFrame()
+	if player.adjust > 0 {
+		silence := make([]byte, player.adjust*frame)
+		if codec := newSampleCodec(player.wire); codec.kind == 'u' {
+			for i := 0; i < len(silence); i += codec.size {
+				codec.encode(silence[i:], 0)
+			}
+		}
+		player.skew += len(silence)
+		player.adjust = 0
+		return append(silence, buffer...)
 	}
-	if player.cmd != nil {
-		player.cmd.Wait()
+	if player.adjust < 0 {
+		drop := -player.adjust * frame
+		if drop > len(buffer) {
+			drop = len(buffer)
+		}
+		player.skew -= drop
+		player.adjust += drop / frame
+		return buffer[drop:]
 	}
+	return buffer
 }
 
-// Stops the "cmd" process running when the user presses Ctrl+C.
-// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
-func (player *Player) cleanup() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		if player.pipe != nil {
-			player.pipe.Close()
+// Returns the gain of the current fade at the next frame written. Must be called with the mutex held.
+func (player *Player) fadeGain() float64 {
+	if player.fadeframes == 0 {
+		return 1
+	}
+	if player.fadedone >= player.fadeframes {
+		return player.fadeto
+	}
+	return player.fadefrom + (player.fadeto-player.fadefrom)*float64(player.fadedone)/float64(player.fadeframes)
+}
+
+// Starts a fade from the current gain to the given gain over the duration.
+func (player *Player) startFade(to float64, duration time.Duration) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	from := player.fadeGain()
+	if player.fadeframes == 0 && to == 1 {
+		from = 0
+	}
+
+	// Fades shorter than one frame take effect on the next frame.
+	frames := int(duration.Seconds() * float64(player.samplerate))
+	if frames < 1 {
+		frames = 1
+	}
+
+	player.fadefrom = from
+	player.fadeto = to
+	player.fadeframes = frames
+	player.fadedone = 0
+}
+
+// Applies the current fade to the buffer about to be written. Returns true if the audio
+// has faded out, in which case nothing should be written.
+func (player *Player) fade(buffer []byte) ([]byte, bool) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if player.fadeframes == 0 {
+		return buffer, false
+	}
+	if player.fadeto == 0 && player.fadedone >= player.fadeframes {
+		return nil, true
+	}
+
+	from, to := player.fadefrom, player.fadeto
+	done, frames := player.fadedone, player.fadeframes
+	result := applyFrameGains(buffer, player.wire, player.channels, func(frame int) float64 {
+		if done+frame >= frames {
+			return to
 		}
-		if player.cmd != nil {
-			player.cmd.Process.Kill()
+		return from + (to-from)*float64(done+frame)/float64(frames)
+	})
+
+	player.fadedone += len(buffer) / player.wireFrame()
+	// A completed fade in no longer changes the audio.
+	if player.fadedone >= player.fadeframes && player.fadeto == 1 {
+		player.fadeframes = 0
+	}
+
+	return result, false
+}
+
+// Returns the duration of audio that has been written to ffplay but not played yet.
+// Must be called with the mutex held.
+func (player *Player) ahead() time.Duration {
+	now := time.Now()
+	second := player.samplerate * player.wireFrame()
+	written := time.Duration(float64(player.written) / float64(second) * float64(time.Second))
+	// If more time has passed than there is audio, everything written has been played and
+	// ffplay is waiting for more audio, so the playback clock is moved forward.
+	if now.Sub(player.start) > written {
+		player.start = now.Add(-written)
+		if player.written > 0 {
+			player.underrun = true
 		}
-		os.Exit(1)
-	}()
+	}
+	return written - now.Sub(player.start)
+}
+
+// Sync offset by which audio is delayed relative to the samples given to the player.
+func (player *Player) SyncOffset() time.Duration {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.offset
+}
+
+// Sets the sync offset by which audio is delayed, e.g. to line up audio with a video display
+// that has its own latency. Increasing the offset inserts silence before the next samples
+// played, and decreasing it drops that much audio from the start of the next samples played.
+// Each change is applied once. Position accounts for the inserted and dropped audio.
+func (player *Player) SetSyncOffset(offset time.Duration) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	change := offset - player.offset
+	player.adjust += int(change.Seconds() * float64(player.samplerate))
+	player.offset = offset
+}
+
+// Fades in the audio written next over the given duration, starting from silence. If a fade
+// is in progress, the new fade starts from its current gain instead.
+func (player *Player) FadeIn(duration time.Duration) {
+	player.startFade(1, duration)
+}
+
+// Fades out the audio written next over the given duration, then stops playback as with Stop
+// once the faded audio has been played. Audio already queued in ffplay (at most the lead if
+// paced) plays at full volume before the fade begins. Blocks until playback has stopped, or
+// returns early if another fade replaces this one. If no audio is being played, playback
+// stops immediately.
+func (player *Player) FadeOut(duration time.Duration) {
+	player.startFade(0, duration)
+
+	for {
+		player.mutex.Lock()
+		replaced := player.fadeto != 0 || player.fadeframes == 0
+		ahead := player.ahead()
+		player.mutex.Unlock()
+
+		if replaced {
+			return
+		}
+		// Once all written audio has been played, either the fade is complete or nothing is playing.
+		if ahead == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	player.Stop()
+}
+
+// Pauses playback. Audio already queued in ffplay still plays out. This is bounded by the lead
+// only if Options.Lead is set, otherwise all audio written so far is played before pausing.
+// Calls to Play while paused block until Resume or Close is called.
+func (player *Player) Pause() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.paused = true
+	player.idle = true
+}
+
+// Resumes paused playback.
+func (player *Player) Resume() {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.paused = false
+	player.resume.Broadcast()
+}
+
+// Signals the end of the audio and blocks until ffplay has played all queued audio and exited.
+// The next call to Play starts a new ffplay process.
+func (player *Player) Wait() error {
+	player.drain()
+
+	player.mutex.Lock()
+	process := player.process
+	player.mutex.Unlock()
+
+	if process == nil {
+		return nil
+	}
+
+	process.pipe.Close()
+	<-process.exited
+	player.reset(process)
+
+	if process.err != nil {
+		return process.failure()
+	}
+	return nil
+}
+
+// Clears the playback state once the given process has exited.
+func (player *Player) reset(process *playback) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	// A player whose process was stopped by CloseAll stays closed.
+	if process.handle.closed() {
+		player.closed = true
+	}
+	// The process may have already been replaced by a concurrent call to Wait or Stop.
+	if player.process == process {
+		player.process = nil
+		player.written = 0
+		player.skew = 0
+	}
+}
+
+// Plays all remaining audio, then changes the channels, sample rate and format of the player.
+// The next call to Play starts a new playback process with the new configuration, and samples
+// are converted or validated against the new format.
+func (player *Player) Reconfigure(channels, samplerate int, format string) error {
+	if err := checkChannels("channels", channels); err != nil {
+		return err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return err
+	}
+	format, err := orderFormat(format, player.endianness)
+	if err != nil {
+		return err
+	}
+
+	if player.Looping() {
+		return fmt.Errorf("player is looping, stop the loop before reconfiguring")
+	}
+
+	frames := player.QueueSize()
+	if err := player.Wait(); err != nil {
+		return err
+	}
+
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	// The channel layout only applies to the old number of channels.
+	if player.layout != "" && checkLayout(player.layout, channels) != nil {
+		player.layout = ""
+	}
+	if channels != 2 {
+		player.balance = 0
+	}
+
+	player.adjust = player.adjust * samplerate / player.samplerate
+	player.channels = channels
+	player.samplerate = samplerate
+	player.format = format
+	player.bps = int(parse(regexp.MustCompile(`\d{1,2}`).FindString(format)))
+	player.wire = wireFormat(format)
+	player.queuesize = frames * player.wireFrame()
+
+	return nil
+}
+
+// Plays all remaining audio, then changes the audio filter graph applied during playback.
+// The next call to Play starts a new playback process with the new filter. An empty
+// filter removes the filter.
+func (player *Player) SetFilter(filter string) error {
+	if player.Looping() {
+		return fmt.Errorf("player is looping, stop the loop before changing the filter")
+	}
+
+	if err := checkFilters(filter); err != nil {
+		return err
+	}
+
+	if err := player.Wait(); err != nil {
+		return err
+	}
+
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.filter = filter
+
+	return nil
+}
+
+// Stops playback immediately, discarding all queued audio. Calls to Play in progress return an
+// error. The next call to Play starts a new playback process. Safe to call from any goroutine.
+func (player *Player) Stop() {
+	player.mutex.Lock()
+	player.generation++
+	close(player.stopped)
+	player.stopped = make(chan struct{})
+	player.queue = nil
+	player.queued = 0
+	player.fadeframes = 0
+	player.fadedone = 0
+	player.paused = false
+	player.err = nil
+	player.resume.Broadcast()
+	player.dequeued.Broadcast()
+	process := player.process
+	player.mutex.Unlock()
+
+	if process == nil {
+		return
+	}
+
+	logEvent(process.cmd, "killed")
+	process.cmd.Process.Kill()
+	<-process.exited
+	player.reset(process)
+}
+
+// Flushes all queued audio and waits for it to finish playing before stopping the ffplay process.
+// Safe to call while other goroutines are playing audio. Calls to Play in progress may return
+// an error, and all later calls to Play fail.
+func (player *Player) Close() {
+	// Holding the starting mutex ensures no process is started after the player is closed.
+	player.starting.Lock()
+	player.mutex.Lock()
+	player.closed = true
+	player.mutex.Unlock()
+	player.starting.Unlock()
+
+	// Unblock any Play calls waiting on a paused player.
+	player.Resume()
+	player.Wait()
+	runtime.SetFinalizer(player, nil)
+}
+
+// Kills the playback process if the player is garbage collected without being closed.
+func (player *Player) leaked() {
+	if !player.closed && player.process != nil {
+		player.process.handle.leaked("Player", player.stack)
+	}
 }
@@ -0,0 +1,106 @@
+package aio
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Returns the options for recording speech, e.g. voice notes or calls: mono 16 kHz audio
+// encoded with Opus at 24 kbps. The output should be an Ogg (".ogg" or ".opus") or WebM file.
+// Returns an error if the installed ffmpeg lacks the libopus encoder.
+func PresetVoice() (*Options, error) {
+	options := &Options{
+		SampleRate: 16000,
+		Channels:   1,
+		Format:     "s16",
+		Codec:      "libopus",
+		Bitrate:    24000,
+	}
+	return checkPreset(options)
+}
+
+// Returns the options for podcasts, normalized to -16 LUFS: stereo 44.1 kHz audio encoded at
+// 128 kbps with MP3 for ".mp3" files, or AAC for ".m4a", ".mp4" and ".aac" files. Returns an
+// error for other extensions, or if the installed ffmpeg lacks the encoder or the loudnorm filter.
+func PresetPodcast(filename string) (*Options, error) {
+	codec, err := presetCodec(filename)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := Filters().Loudnorm(-16).Build()
+	if err != nil {
+		return nil, err
+	}
+	options := &Options{
+		SampleRate: 44100,
+		Channels:   2,
+		Format:     "s16",
+		Codec:      codec,
+		Bitrate:    128000,
+		Filter:     filter,
+	}
+	return checkPreset(options)
+}
+
+// Returns the options for music in high quality: stereo 44.1 kHz audio encoded with variable
+// bitrate MP3 at the highest quality (V0) for ".mp3" files, or AAC at 256 kbps for ".m4a", ".mp4"
+// and ".aac" files. Returns an error for other extensions, or if the installed ffmpeg lacks
+// the encoder.
+func PresetMusicHigh(filename string) (*Options, error) {
+	codec, err := presetCodec(filename)
+	if err != nil {
+		return nil, err
+	}
+	options := &Options{
+		SampleRate: 44100,
+		Channels:   2,
+		Format:     "s16",
+		Codec:      codec,
+	}
+	if codec == "libmp3lame" {
+		options.Quality = "0"
+	} else {
+		options.Bitrate = 256000
+	}
+	return checkPreset(options)
+}
+
+// Returns the options for archiving audio without loss: stereo 44.1 kHz audio encoded with FLAC
+// at the highest standard compression level of 8. The output should be a ".flac" file.
+// Returns an error if the installed ffmpeg lacks the flac encoder.
+func PresetArchival() (*Options, error) {
+	options := &Options{
+		SampleRate:       44100,
+		Channels:         2,
+		Format:           "s16",
+		Codec:            "flac",
+		CompressionLevel: 8,
+	}
+	return checkPreset(options)
+}
+
+// Returns the encoder for MP3 or AAC output, depending on the extension of the filename.
+func presetCodec(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mp3":
+		return "libmp3lame", nil
+	case ".m4a", ".mp4", ".aac":
+		return "aac", nil
+	default:
+		return "", fmt.Errorf("unsupported extension for %s, must be .mp3, .m4a, .mp4 or .aac", filename)
+	}
+}
+
+// Returns the options of the preset if the installed ffmpeg has its encoder and filters.
+func checkPreset(options *Options) (*Options, error) {
+	if err := checkEncoder(options.Codec); err != nil {
+		return nil, err
+	}
+	if options.Filter != "" {
+		if err := checkFilters(options.Filter); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
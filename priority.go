@@ -0,0 +1,33 @@
+package aio
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// Range of niceness values accepted by Options.Nice.
+const (
+	minNice = -20
+	maxNice = 19
+)
+
+// Starts the command with the given niceness, from -20 (highest priority) to 19 (lowest
+// priority). A niceness of 0 leaves the priority unchanged.
+func startNice(cmd *exec.Cmd, nice int) error {
+	if nice == 0 {
+		return cmd.Start()
+	}
+
+	prepareNice(cmd, nice)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := applyNice(cmd, nice); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("could not set the priority of %s: %w", filepath.Base(cmd.Path), err)
+	}
+	logEvent(cmd, fmt.Sprintf("running with nice %d", nice))
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package aio
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Process priorities are not supported on this platform.
+const niceSupported = false
+
+func prepareNice(cmd *exec.Cmd, nice int) {}
+
+func applyNice(cmd *exec.Cmd, nice int) error {
+	return fmt.Errorf("process priorities are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package aio
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Process priorities are supported on this platform.
+const niceSupported = true
+
+// Processes are started with the default priority, which is changed once they run.
+func prepareNice(cmd *exec.Cmd, nice int) {}
+
+// Sets the niceness of the running process. Negative values usually require privileges.
+func applyNice(cmd *exec.Cmd, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, nice)
+}
@@ -0,0 +1,44 @@
+package aio
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Process priorities are supported on this platform.
+const niceSupported = true
+
+// Windows process priority classes, see
+// https://learn.microsoft.com/en-us/windows/win32/procthread/scheduling-priorities.
+const (
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+)
+
+// Starts the process in the priority class closest to the niceness. Values from 10 use the idle
+// class, values from 1 the below normal class, values up to -1 the above normal class and values
+// up to -10 the high class.
+func prepareNice(cmd *exec.Cmd, nice int) {
+	var class uint32
+	switch {
+	case nice >= 10:
+		class = idlePriorityClass
+	case nice > 0:
+		class = belowNormalPriorityClass
+	case nice <= -10:
+		class = highPriorityClass
+	default:
+		class = aboveNormalPriorityClass
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= class
+}
+
+// The priority class is set when the process is created.
+func applyNice(cmd *exec.Cmd, nice int) error {
+	return nil
+}
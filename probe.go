@@ -0,0 +1,175 @@
+package aio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Smallest probe size and analyze duration used, so that the streams of a file and their
+// parameters are still found when the limits are set very low.
+const (
+	minProbeSize       = 32 << 10
+	minAnalyzeDuration = 500 * time.Millisecond
+)
+
+// Limits of how much of the input ffprobe and ffmpeg read to find its streams. Zero values
+// leave the ffmpeg defaults (5 MB and 5 seconds) in place.
+type probeLimits struct {
+	size     int64         // Maximum number of bytes read, Options.ProbeSize.
+	duration time.Duration // Maximum duration of the input analyzed, Options.AnalyzeDuration.
+}
+
+// Returns the limits set in the options, raised to the lower bounds.
+func newProbeLimits(options *Options) probeLimits {
+	limits := probeLimits{size: options.ProbeSize, duration: options.AnalyzeDuration}
+	if limits.size != 0 && limits.size < minProbeSize {
+		limits.size = minProbeSize
+	}
+	if limits.duration != 0 && limits.duration < minAnalyzeDuration {
+		limits.duration = minAnalyzeDuration
+	}
+	return limits
+}
+
+// Returns the ffprobe and ffmpeg input options setting the limits, to be given before the input.
+func (limits probeLimits) args() []string {
+	args := []string{}
+	if limits.size != 0 {
+		args = append(args, "-probesize", fmt.Sprintf("%d", limits.size))
+	}
+	if limits.duration != 0 {
+		args = append(args, "-analyzeduration", fmt.Sprintf("%d", limits.duration.Microseconds()))
+	}
+	return args
+}
+
+// Information about a media file from ffprobe.
+type ProbeResult struct {
+	Format   ProbeFormat   // Information about the container.
+	Streams  []ProbeStream // Information about every stream in the file, in file order.
+	Fallback bool          // Read from the ffmpeg output since ffprobe is not installed, so some fields are missing.
+}
+
+// Information about the container of a media file.
+type ProbeFormat struct {
+	Name     string            // Container format, e.g. "mp3" or "mov,mp4,m4a,3gp,3g2,mj2".
+	Duration float64           // Duration in seconds, 0 if unknown.
+	Bitrate  int               // Bitrate in bits/s, 0 if unknown.
+	Size     int64             // File size in bytes, 0 if unknown.
+	Tags     map[string]string // Global metadata tags, e.g. "title", with the keys as stored in the file.
+	MetaData map[string]string // Raw ffprobe output for the format.
+}
+
+// Information about a single stream of a media file.
+type ProbeStream struct {
+	Index         int               // Index of the stream among all streams in the file.
+	Type          string            // Stream type, e.g. "audio", "video" or "subtitle".
+	Codec         string            // Codec name, e.g. "mp3".
+	SampleRate    int               // Audio Sample Rate in Hz, 0 if unknown or not audio.
+	Channels      int               // Number of audio channels, 0 if unknown or not audio.
+	ChannelLayout string            // Audio channel layout, e.g. "stereo".
+	Bitrate       int               // Bitrate in bits/s, 0 if unknown.
+	Duration      float64           // Duration in seconds, 0 if unknown.
+	Tags          map[string]string // Stream metadata tags, e.g. "language".
+	MetaData      map[string]string // Raw ffprobe output for the stream.
+}
+
+// Returns the audio streams of the file, in file order.
+func (result *ProbeResult) AudioStreams() []ProbeStream {
+	streams := []ProbeStream{}
+	for _, stream := range result.Streams {
+		if stream.Type == "audio" {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+// Reads the container and stream information of a media file, such as the codec, duration,
+// sample rate, channels and tags, with a single ffprobe run. Unlike NewAudio, no ffmpeg
+// process is started and the file may have no audio streams.
+func ProbeAudio(filename string) (*ProbeResult, error) {
+	if !exists(filename) {
+		return nil, fmt.Errorf("file %s does not exist", filename)
+	}
+	if err := installed("ffprobe"); err != nil {
+		if installed("ffmpeg") != nil {
+			return nil, err
+		}
+		return ffmpegProbe(context.Background(), filename, nil, probeLimits{})
+	}
+	return ffprobe(context.Background(), filename, nil, probeLimits{})
+}
+
+// Creates the probe result from the parsed ffprobe output.
+func newProbeResult(streams []map[string]string, format map[string]string) *ProbeResult {
+	result := &ProbeResult{
+		Format: ProbeFormat{
+			Name:     known(format["format_name"]),
+			Tags:     probeTags(format),
+			MetaData: format,
+		},
+		Streams: make([]ProbeStream, len(streams)),
+	}
+	if duration, ok := parseValue(format["duration"]); ok {
+		result.Format.Duration = duration
+	}
+	if bitrate, ok := parseValue(format["bit_rate"]); ok {
+		result.Format.Bitrate = int(bitrate)
+	}
+	if size, ok := parseValue(format["size"]); ok {
+		result.Format.Size = int64(size)
+	}
+	for i, stream := range streams {
+		result.Streams[i] = newProbeStream(stream)
+	}
+	return result
+}
+
+// Creates the stream information from the ffprobe output for the stream.
+func newProbeStream(data map[string]string) ProbeStream {
+	stream := ProbeStream{
+		Type:          known(data["codec_type"]),
+		Codec:         known(data["codec_name"]),
+		ChannelLayout: known(data["channel_layout"]),
+		Tags:          probeTags(data),
+		MetaData:      data,
+	}
+	if index, ok := parseValue(data["index"]); ok {
+		stream.Index = int(index)
+	}
+	if samplerate, ok := parseValue(data["sample_rate"]); ok {
+		stream.SampleRate = int(samplerate)
+	}
+	if channels, ok := parseValue(data["channels"]); ok {
+		stream.Channels = int(channels)
+	}
+	if bitrate, ok := parseValue(data["bit_rate"]); ok {
+		stream.Bitrate = int(bitrate)
+	}
+	if duration, ok := parseValue(data["duration"]); ok {
+		stream.Duration = duration
+	}
+	return stream
+}
+
+// Returns the metadata tags from the ffprobe output, which are prefixed with "TAG:".
+func probeTags(data map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for key, value := range data {
+		if strings.HasPrefix(key, "TAG:") {
+			tags[key[len("TAG:"):]] = value
+		}
+	}
+	return tags
+}
+
+// Returns the ffprobe value, or an empty string if ffprobe reports it as unknown.
+func known(value string) string {
+	if value == "N/A" {
+		return ""
+	}
+	return value
+}
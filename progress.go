@@ -0,0 +1,137 @@
+package aio
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Matches the key=value lines written by ffmpeg with -progress, e.g. "bitrate= 128.0kbits/s".
+// Values never contain spaces, which keeps the lines apart from the stats line ffmpeg logs.
+var progressLine = regexp.MustCompile(`^([a-z0-9_]+)=\s*(\S*)$`)
+
+// Encoder statistics reported by ffmpeg while an AudioWriter encodes, as returned by
+// AudioWriter.Progress. Statistics that ffmpeg reports as unknown ("N/A") are left at zero,
+// and every value of the last report is also kept in Fields as it was reported, keyed by its
+// name, e.g. "out_time".
+type EncodeProgress struct {
+	OutTime   time.Duration     // Duration of the audio encoded so far.
+	TotalSize int64             // Number of bytes written to the output so far.
+	Bitrate   float64           // Average bitrate of the output so far in bits/s.
+	Speed     float64           // Encoding speed relative to playback speed, e.g. 20 for 20x.
+	Done      bool              // Flag storing whether ffmpeg has finished encoding.
+	Fields    map[string]string // All values of the last report, keyed by name.
+}
+
+// Parses the progress reports in the stderr output of ffmpeg. ffmpeg writes a block of
+// key=value lines for every report, ending with "progress=continue", or "progress=end" for the
+// last one. All other output is passed on unchanged, so that it can still be logged or checked
+// for errors.
+type progressParser struct {
+	mutex    sync.Mutex
+	next     io.Writer            // Receives all output that is not part of a report, may be nil.
+	partial  []byte               // Output after the last line break.
+	block    map[string]string    // Values of the report being read.
+	latest   EncodeProgress       // Last complete report.
+	callback func(EncodeProgress) // Callback invoked with every complete report.
+}
+
+func (parser *progressParser) Write(data []byte) (int, error) {
+	parser.mutex.Lock()
+	parser.partial = append(parser.partial, data...)
+	var other []byte
+	var reports []EncodeProgress
+	for {
+		index := bytes.IndexAny(parser.partial, "\r\n")
+		if index == -1 {
+			break
+		}
+		line := parser.partial[:index+1]
+		parser.partial = parser.partial[index+1:]
+		if report, ok := parser.parse(string(line[:index])); !ok {
+			other = append(other, line...)
+		} else if report != nil {
+			reports = append(reports, *report)
+		}
+	}
+	callback := parser.callback
+	parser.mutex.Unlock()
+
+	if callback != nil {
+		for _, report := range reports {
+			callback(report)
+		}
+	}
+	if len(other) > 0 && parser.next != nil {
+		parser.next.Write(other)
+	}
+	return len(data), nil
+}
+
+// Adds the line to the report being read. Returns false if the line is not part of a report,
+// and the complete report if the line ends it. Must be called with the mutex held.
+func (parser *progressParser) parse(line string) (*EncodeProgress, bool) {
+	match := progressLine.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+	if parser.block == nil {
+		parser.block = map[string]string{}
+	}
+	parser.block[match[1]] = match[2]
+	if match[1] != "progress" {
+		return nil, true
+	}
+
+	report := newEncodeProgress(parser.block)
+	parser.block = nil
+	parser.latest = report
+	return &report, true
+}
+
+// Passes on output after the last line break once ffmpeg has exited.
+func (parser *progressParser) flush() {
+	parser.mutex.Lock()
+	partial := parser.partial
+	parser.partial = nil
+	parser.mutex.Unlock()
+	if len(partial) > 0 && parser.next != nil {
+		parser.next.Write(partial)
+	}
+}
+
+// Returns the last complete report, the zero value for a nil parser.
+func (parser *progressParser) progress() EncodeProgress {
+	if parser == nil {
+		return EncodeProgress{}
+	}
+	parser.mutex.Lock()
+	defer parser.mutex.Unlock()
+	return parser.latest
+}
+
+// Builds the report from the values of a block.
+func newEncodeProgress(fields map[string]string) EncodeProgress {
+	report := EncodeProgress{Fields: fields, Done: fields["progress"] == "end"}
+
+	// out_time_ms is in microseconds as well in all ffmpeg versions.
+	for _, key := range []string{"out_time_us", "out_time_ms"} {
+		if value, ok := parseValue(fields[key]); ok {
+			report.OutTime = time.Duration(value) * time.Microsecond
+			break
+		}
+	}
+	if value, ok := parseValue(fields["total_size"]); ok {
+		report.TotalSize = int64(value)
+	}
+	if value, ok := parseValue(strings.TrimSuffix(fields["bitrate"], "kbits/s")); ok {
+		report.Bitrate = value * 1000
+	}
+	if value, ok := parseValue(strings.TrimSuffix(fields["speed"], "x")); ok {
+		report.Speed = value
+	}
+	return report
+}
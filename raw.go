@@ -0,0 +1,116 @@
+package aio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Extensions of files holding samples without a header, which AudioWriter writes in its format.
+var rawExtensions = map[string]bool{
+	".raw": true,
+	".pcm": true,
+}
+
+// Returns true if the file holds samples without a header, judging by its extension.
+func isRaw(filename string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// Reads a file holding samples without a header, e.g. a .pcm or .raw capture, which ffprobe
+// cannot recognize. The samples in the file have the given sample rate, number of channels and
+// format, where formats without a byte order, e.g. "s16", are in the byte order of the machine.
+// The options work as for NewAudio, so the samples are decoded to Options.Format, and resampled
+// or remixed with Options.SampleRate and Options.Channels. The duration is calculated from the
+// size of the file, which must be a multiple of the frame size.
+//
+// The file is read without ffmpeg unless it has to be resampled, remixed, reversed or filtered.
+func NewRawAudio(filename string, samplerate, channels int, format string, options *Options) (*Audio, error) {
+	options = withDefaults(options)
+
+	if filename == "" {
+		return nil, &OptionError{"filename", `""`, "must not be empty"}
+	}
+	if err := options.validate("NewRawAudio"); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	input, err := NormalizeFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	frame := int64(newSampleCodec(input).size * channels)
+	if info.Size()%frame != 0 {
+		return nil, fmt.Errorf(
+			"size of %s is %d bytes, which is not a multiple of the frame size of %d bytes, %d bytes are left over",
+			filename, info.Size(), frame, info.Size()%frame,
+		)
+	}
+
+	// The samples are laid out like the audio data of a WAV file without a header.
+	raw := &wavFile{
+		format:     input,
+		codec:      "pcm_" + input,
+		samplerate: samplerate,
+		channels:   channels,
+		bps:        newSampleCodec(input).size * 8,
+		size:       info.Size(),
+	}
+	native := !(options.SampleRate != 0 && options.SampleRate != samplerate ||
+		options.Channels != 0 && options.Channels != channels || options.Reverse || options.Filter != "")
+
+	if !native {
+		if err := installed("ffmpeg"); err != nil {
+			return nil, err
+		}
+		if options.Reverse {
+			if err := checkFilters("areverse"); err != nil {
+				return nil, err
+			}
+		}
+		if options.Filter != "" {
+			if err := checkFilters(options.Filter); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	output := "s16" // s16 default format.
+	if options.Format != "" {
+		output = options.Format
+	}
+	if output, err = orderFormat(output, options.Endianness); err != nil {
+		return nil, err
+	}
+
+	// The duration in the metadata is rounded to microseconds.
+	data := raw.metadata()
+	data["duration"] = strconv.FormatFloat(float64(info.Size()/frame)/float64(samplerate), 'f', -1, 64)
+	audio, err := newAudioStream(filename, 0, data, output, options)
+	if err != nil {
+		return nil, err
+	}
+	audio.stack = creationStack()
+	if native {
+		audio.wav = raw
+	} else {
+		audio.raw = []string{
+			"-f", input,
+			"-ar", fmt.Sprintf("%d", samplerate),
+			"-ac", fmt.Sprintf("%d", channels),
+		}
+	}
+	return audio, nil
+}
@@ -0,0 +1,87 @@
+package aio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Number of zero crossings of the sinc function on each side of the resampling filter.
+const resampleZeros = 16
+
+// Resamples the interleaved samples from one sample rate to another, e.g. from 44100 Hz to
+// 48000 Hz. The result has the same type as the samples. Byte slices are treated as samples in
+// the "u8" format. Returns the samples themselves if both sample rates are the same.
+//
+// Resampling uses a windowed sinc filter and runs entirely in Go, so it works on samples that
+// did not come from a file. Every call resamples the buffer on its own, treating the audio before
+// and after it as silence, so buffers should be resampled in one piece rather than in chunks.
+// For long audio or the highest quality, use the SampleRate option of NewAudio instead.
+func Resample(samples interface{}, channels, fromRate, toRate int) (interface{}, error) {
+	format := typedFormat(samples)
+	if format == "" {
+		return nil, fmt.Errorf("invalid sample data type: %T", samples)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid number of channels: %d, must be positive", channels)
+	}
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rates: %d Hz and %d Hz, must be positive", fromRate, toRate)
+	}
+	if fromRate == toRate {
+		return samples, nil
+	}
+
+	converted, err := ConvertSamples(samples, "f64")
+	if err != nil {
+		return nil, err
+	}
+	input := converted.([]float64)
+	if len(input)%channels != 0 {
+		return nil, fmt.Errorf("number of samples must be a multiple of the %d channels", channels)
+	}
+
+	frames := len(input) / channels
+	size := int(int64(frames) * int64(toRate) / int64(fromRate))
+	output := make([]float64, size*channels)
+
+	// When downsampling, the cutoff is lowered to the new Nyquist frequency to avoid aliasing.
+	cutoff := math.Min(1, float64(toRate)/float64(fromRate))
+	width := resampleZeros / cutoff
+	step := float64(fromRate) / float64(toRate)
+
+	for frame := 0; frame < size; frame++ {
+		position := float64(frame) * step
+		first := int(math.Ceil(position - width))
+		last := int(math.Floor(position + width))
+		if first < 0 {
+			first = 0
+		}
+		if last >= frames {
+			last = frames - 1
+		}
+		for i := first; i <= last; i++ {
+			weight := cutoff * sinc(cutoff*(position-float64(i))) * blackman((position-float64(i))/width)
+			for channel := 0; channel < channels; channel++ {
+				output[frame*channels+channel] += input[i*channels+channel] * weight
+			}
+		}
+	}
+
+	return ConvertSamples(output, format)
+}
+
+// Normalized sinc function, sin(pi x) / (pi x).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// Blackman window for x in [-1, 1], which is 1 at 0 and 0 at both ends.
+func blackman(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
@@ -0,0 +1,230 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// How a RingBuffer handles writes that do not fit. The zero value rejects them.
+type RingBufferOptions struct {
+	Overwrite bool // Overwrite the oldest audio if the buffer is full instead of returning an error.
+}
+
+// Fixed size queue of audio frames connecting a goroutine producing audio, e.g. one recording
+// a Microphone, to a goroutine consuming it. Reading never allocates, and neither does writing
+// byte slices, which hold samples in the format of the buffer. Writing other sample slices
+// allocates, since their type is checked and they may be converted, byte swapped or packed into
+// 24 bits. The buffer implements Sink, so a Source can be piped into it with Pipe. If the buffer is full, writes either overwrite the oldest audio or
+// return an error, as chosen when the buffer is created.
+type RingBuffer struct {
+	samplerate int        // Audio Sample Rate in Hz.
+	channels   int        // Number of audio channels.
+	format     string     // Format of audio samples.
+	frame      int        // Number of bytes in one frame of audio.
+	overwrite  bool       // Flag storing whether writes to a full buffer overwrite the oldest audio.
+	convert    bool       // Flag storing whether samples of another format are converted.
+	clamp      bool       // Flag storing whether 24-bit samples out of range saturate.
+	data       []byte     // Audio in the buffer, starting at the read position and wrapping around.
+	start      int        // Byte offset of the oldest audio in the buffer.
+	length     int        // Number of bytes of audio in the buffer.
+	dropped    int        // Number of frames overwritten or rejected because the buffer was full.
+	closed     bool       // Flag storing whether the buffer has been closed.
+	mutex      sync.Mutex // Mutex guarding the audio in the buffer.
+	written    *sync.Cond // Signals new audio and closing to waiting readers.
+}
+
+// Audio Sample Rate in Hz.
+func (ring *RingBuffer) SampleRate() int {
+	return ring.samplerate
+}
+
+func (ring *RingBuffer) Channels() int {
+	return ring.channels
+}
+
+func (ring *RingBuffer) BitsPerSample() int {
+	return newSampleCodec(ring.format).size * 8
+}
+
+// Number of bytes in one audio frame, i.e. one sample for every channel.
+func (ring *RingBuffer) BytesPerFrame() int {
+	return ring.frame
+}
+
+func (ring *RingBuffer) Format() string {
+	switch ring.format {
+	case "u8", "s8":
+		return ring.format
+	default:
+		return ring.format[:len(ring.format)-2]
+	}
+}
+
+// Returns true if writes to a full buffer overwrite the oldest audio instead of failing.
+func (ring *RingBuffer) Overwrite() bool {
+	return ring.overwrite
+}
+
+// Number of frames the buffer can hold.
+func (ring *RingBuffer) Size() int {
+	return len(ring.data) / ring.frame
+}
+
+// Number of frames that can be read without blocking.
+func (ring *RingBuffer) Available() int {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	return ring.length / ring.frame
+}
+
+// Number of frames that can be written without overwriting audio or failing.
+func (ring *RingBuffer) Free() int {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	return (len(ring.data) - ring.length) / ring.frame
+}
+
+// Number of frames that were lost because the buffer was full, either overwritten before they
+// were read or rejected by Write.
+func (ring *RingBuffer) Dropped() int {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	return ring.dropped
+}
+
+// Creates a ring buffer holding the given number of frames of audio with the given channels,
+// sample rate and format. If settings.Overwrite is set, writes to a full buffer overwrite the
+// oldest audio, otherwise they return an error.
+func NewRingBuffer(frames, channels, samplerate int, format string, settings RingBufferOptions, options *Options) (*RingBuffer, error) {
+	options = withDefaults(options)
+
+	if frames < 1 {
+		return nil, fmt.Errorf("invalid ring buffer size: %d frames, must be positive", frames)
+	}
+	if err := checkChannels("channels", channels); err != nil {
+		return nil, err
+	}
+	if err := checkSampleRate("samplerate", samplerate); err != nil {
+		return nil, err
+	}
+	if err := options.validate("NewRingBuffer"); err != nil {
+		return nil, err
+	}
+
+	format, err := orderFormat(format, options.Endianness)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := newSampleCodec(format).size * channels
+	ring := &RingBuffer{
+		samplerate: samplerate,
+		channels:   channels,
+		format:     format,
+		frame:      frame,
+		overwrite:  settings.Overwrite,
+		convert:    options.ConvertSamples,
+		clamp:      options.ClampSamples,
+		data:       make([]byte, frames*frame),
+	}
+	ring.written = sync.NewCond(&ring.mutex)
+	return ring, nil
+}
+
+// Adds the samples to the end of the buffer. Byte slices hold samples in the format of the
+// buffer, while other sample slices must have the type matching the format, unless
+// Options.ConvertSamples is set. If there is not enough room for all samples, the oldest audio
+// is overwritten if the buffer was created to overwrite, otherwise none of the samples are
+// written and an error is returned. Returns an error if the buffer is closed.
+func (ring *RingBuffer) Write(samples interface{}) error {
+	buffer := samplesToFormat(samples, ring.format)
+	if buffer == nil {
+		return fmt.Errorf("invalid sample data type")
+	}
+	if packed, ok, err := pack24(samples, ring.format, ring.clamp); err != nil {
+		return err
+	} else if ok {
+		buffer = packed
+	} else if format := sampleFormat(samples); format != "" && sampleType(format) != sampleType(ring.format) {
+		if !ring.convert {
+			return fmt.Errorf("ring buffer format %s expects %T, got %T", ring.format, makeSamples(ring.format, 0), samples)
+		}
+		buffer = convertBuffer(samplesToBytes(samples), format, ring.format)
+	}
+	if len(buffer)%ring.frame != 0 {
+		return fmt.Errorf("buffer size must be a multiple of the frame size of %d bytes", ring.frame)
+	}
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	if ring.closed {
+		return fmt.Errorf("ring buffer is closed")
+	}
+
+	free := len(ring.data) - ring.length
+	if len(buffer) > free {
+		if !ring.overwrite {
+			ring.dropped += len(buffer) / ring.frame
+			return fmt.Errorf("ring buffer is full: %d frames do not fit in %d free frames", len(buffer)/ring.frame, free/ring.frame)
+		}
+		// Only the newest audio is kept if there is more than the buffer can hold.
+		if len(buffer) > len(ring.data) {
+			ring.dropped += (len(buffer) - len(ring.data)) / ring.frame
+			buffer = buffer[len(buffer)-len(ring.data):]
+		}
+		if overwritten := len(buffer) - free; overwritten > 0 {
+			ring.dropped += overwritten / ring.frame
+			ring.start = (ring.start + overwritten) % len(ring.data)
+			ring.length -= overwritten
+		}
+	}
+
+	end := (ring.start + ring.length) % len(ring.data)
+	n := copy(ring.data[end:], buffer)
+	copy(ring.data, buffer[n:])
+	ring.length += len(buffer)
+
+	ring.written.Broadcast()
+	return nil
+}
+
+// Reads the oldest audio in the buffer into the given buffer, up to as many whole frames as it
+// can hold. Blocks until at least one frame is available. Once the ring buffer has been closed,
+// the rest of the audio is read, followed by io.EOF.
+func (ring *RingBuffer) Read(buffer []byte) (int, error) {
+	size := len(buffer) / ring.frame * ring.frame
+	if size == 0 {
+		return 0, fmt.Errorf("buffer must hold at least one frame of %d bytes", ring.frame)
+	}
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	for ring.length == 0 && !ring.closed {
+		ring.written.Wait()
+	}
+	if ring.length == 0 {
+		return 0, io.EOF
+	}
+
+	if size > ring.length {
+		size = ring.length
+	}
+	n := copy(buffer[:size], ring.data[ring.start:])
+	copy(buffer[n:size], ring.data)
+	ring.start = (ring.start + size) % len(ring.data)
+	ring.length -= size
+
+	return size, nil
+}
+
+// Closes the buffer, so that writes fail and reads return io.EOF once the rest of the audio
+// has been read. Safe to call from any goroutine, and unblocks any Read in progress.
+func (ring *RingBuffer) Close() {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.closed = true
+	ring.written.Broadcast()
+}
@@ -0,0 +1,430 @@
+package aio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Describes how individual samples of an audio format are stored in a byte buffer.
+type sampleCodec struct {
+	size  int              // Bytes per sample.
+	kind  byte             // 'u' for unsigned, 's' for signed integers and 'f' for floating point.
+	order binary.ByteOrder // Byte order of multi-byte samples.
+}
+
+// Matches the bits per sample in an audio format. Compiled once, since codecs are created for
+// every converted buffer.
+var sampleBits = regexp.MustCompile(`\d{1,2}`)
+
+func newSampleCodec(format string) sampleCodec {
+	bits := int(parse(sampleBits.FindString(format)))
+	codec := sampleCodec{size: bits / 8, kind: format[0], order: binary.LittleEndian}
+	if strings.HasSuffix(format, "be") {
+		codec.order = binary.BigEndian
+	}
+	return codec
+}
+
+// Reads the raw bits of the sample at the start of the buffer.
+func (codec sampleCodec) bits(buffer []byte) uint64 {
+	switch codec.size {
+	case 1:
+		return uint64(buffer[0])
+	case 2:
+		return uint64(codec.order.Uint16(buffer))
+	case 3:
+		if codec.order == binary.LittleEndian {
+			return uint64(buffer[0]) | uint64(buffer[1])<<8 | uint64(buffer[2])<<16
+		}
+		return uint64(buffer[2]) | uint64(buffer[1])<<8 | uint64(buffer[0])<<16
+	case 4:
+		return uint64(codec.order.Uint32(buffer))
+	default:
+		return codec.order.Uint64(buffer)
+	}
+}
+
+// Writes the raw bits of a sample to the start of the buffer.
+func (codec sampleCodec) putBits(buffer []byte, bits uint64) {
+	switch codec.size {
+	case 1:
+		buffer[0] = byte(bits)
+	case 2:
+		codec.order.PutUint16(buffer, uint16(bits))
+	case 3:
+		if codec.order == binary.LittleEndian {
+			buffer[0], buffer[1], buffer[2] = byte(bits), byte(bits>>8), byte(bits>>16)
+		} else {
+			buffer[0], buffer[1], buffer[2] = byte(bits>>16), byte(bits>>8), byte(bits)
+		}
+	case 4:
+		codec.order.PutUint32(buffer, uint32(bits))
+	default:
+		codec.order.PutUint64(buffer, bits)
+	}
+}
+
+// Returns the sample at the start of the buffer scaled to the range [-1, 1].
+func (codec sampleCodec) decode(buffer []byte) float64 {
+	bits := codec.bits(buffer)
+	half := float64(uint64(1) << (codec.size*8 - 1))
+	switch codec.kind {
+	case 'f':
+		if codec.size == 4 {
+			return float64(math.Float32frombits(uint32(bits)))
+		}
+		return math.Float64frombits(bits)
+	case 'u':
+		return (float64(bits) - half) / half
+	default:
+		// Sign extend the sample to 64 bits.
+		shift := 64 - codec.size*8
+		return float64(int64(bits<<shift)>>shift) / half
+	}
+}
+
+// Writes the value in the range [-1, 1] to the start of the buffer.
+// Values outside of this range saturate for integer formats.
+func (codec sampleCodec) encode(buffer []byte, value float64) {
+	if codec.kind == 'f' {
+		if codec.size == 4 {
+			codec.putBits(buffer, uint64(math.Float32bits(float32(value))))
+		} else {
+			codec.putBits(buffer, math.Float64bits(value))
+		}
+		return
+	}
+
+	half := float64(uint64(1) << (codec.size*8 - 1))
+	sample := math.Round(value * half)
+	if sample > half-1 {
+		sample = half - 1
+	} else if sample < -half {
+		sample = -half
+	}
+
+	if codec.kind == 'u' {
+		codec.putBits(buffer, uint64(sample+half))
+	} else {
+		codec.putBits(buffer, uint64(int64(sample)))
+	}
+}
+
+// Returns a copy of the buffer with every sample multiplied by the given gain.
+func applyGain(buffer []byte, format string, gain float64) []byte {
+	return applyChannelGains(buffer, format, []float64{gain})
+}
+
+// Returns a copy of the interleaved buffer with the samples of each channel multiplied
+// by the gain for that channel.
+func applyChannelGains(buffer []byte, format string, gains []float64) []byte {
+	codec := newSampleCodec(format)
+	result := make([]byte, len(buffer))
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		gain := gains[i/codec.size%len(gains)]
+		codec.encode(result[i:], codec.decode(buffer[i:])*gain)
+	}
+	return result
+}
+
+// Returns a copy of the interleaved buffer with the samples of each frame multiplied by the
+// gain returned for the index of that frame.
+func applyFrameGains(buffer []byte, format string, channels int, gain func(frame int) float64) []byte {
+	codec := newSampleCodec(format)
+	result := make([]byte, len(buffer))
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		frame := i / codec.size / channels
+		codec.encode(result[i:], codec.decode(buffer[i:])*gain(frame))
+	}
+	return result
+}
+
+// Converts a linear sample value, where 1 is full scale, to decibels relative to full scale.
+// Returns negative infinity for 0.
+func ToDBFS(value float64) float64 {
+	return 20 * math.Log10(math.Abs(value))
+}
+
+// Converts decibels relative to full scale to a linear sample value, where 1 is full scale.
+func FromDBFS(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// Multiplies the samples by the gain in decibels, modifying them in place. Samples of integer
+// formats saturate, and unsigned samples are scaled around the middle of their range. Byte
+// slices are treated as samples in the "u8" format.
+func ApplyGain(samples interface{}, db float64) error {
+	format := typedFormat(samples)
+	if format == "" {
+		return fmt.Errorf("invalid sample data type: %T", samples)
+	}
+	gain := FromDBFS(db)
+	buffer := samplesToBytes(samples)
+	codec := newSampleCodec(format)
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		codec.encode(buffer[i:], codec.decode(buffer[i:])*gain)
+	}
+	return nil
+}
+
+// Returns the largest absolute value of the samples, where 1 is full scale.
+// Byte slices are treated as samples in the "u8" format.
+func Peak(samples interface{}) (float64, error) {
+	format := typedFormat(samples)
+	if format == "" {
+		return 0, fmt.Errorf("invalid sample data type: %T", samples)
+	}
+	buffer := samplesToBytes(samples)
+	codec := newSampleCodec(format)
+	peak := 0.0
+	for i := 0; i+codec.size <= len(buffer); i += codec.size {
+		peak = math.Max(peak, math.Abs(codec.decode(buffer[i:])))
+	}
+	return peak, nil
+}
+
+// Scales the samples in place so that their peak is at the given level in decibels relative to
+// full scale, e.g. -1. Silent samples are left unchanged.
+func NormalizePeak(samples interface{}, targetDBFS float64) error {
+	peak, err := Peak(samples)
+	if err != nil || peak == 0 {
+		return err
+	}
+	return ApplyGain(samples, targetDBFS-ToDBFS(peak))
+}
+
+// Returns the audio format matching the element type of the sample slice.
+// Byte slices are treated as raw audio data and have no format.
+func sampleFormat(samples interface{}) string {
+	switch samples.(type) {
+	case []int8:
+		return "s8"
+	case []uint16:
+		return createFormat("u16")
+	case []int16:
+		return createFormat("s16")
+	case []uint32:
+		return createFormat("u32")
+	case []int32:
+		return createFormat("s32")
+	case []float32:
+		return createFormat("f32")
+	case []float64:
+		return createFormat("f64")
+	default:
+		return ""
+	}
+}
+
+// Returns the audio format matching the element type of the sample slice like sampleFormat,
+// but treats byte slices as samples in the "u8" format.
+func typedFormat(samples interface{}) string {
+	if _, ok := samples.([]uint8); ok {
+		return "u8"
+	}
+	return sampleFormat(samples)
+}
+
+// Converts the raw audio data from one format to another, scaling the samples to the new range.
+func convertBuffer(buffer []byte, from, to string) []byte {
+	source := newSampleCodec(from)
+	target := newSampleCodec(to)
+	result := make([]byte, len(buffer)/source.size*target.size)
+	convertInto(result, buffer, from, to)
+	return result
+}
+
+// Converts the raw audio data from one format to another, writing the result to the start of
+// the destination buffer, which must be large enough to hold all converted samples.
+func convertInto(result, buffer []byte, from, to string) {
+	source := newSampleCodec(from)
+	target := newSampleCodec(to)
+	samples := len(buffer) / source.size
+	for i := 0; i < samples; i++ {
+		target.encode(result[i*target.size:], source.decode(buffer[i*source.size:]))
+	}
+}
+
+// Converts the raw audio data to float32 samples in the range [-1, 1], scaled like
+// ConvertSamples, reusing the destination slice if it has enough capacity. The most common
+// formats are converted without going through float64.
+func decodeFloat32(dst []float32, buffer []byte, format string) []float32 {
+	codec := newSampleCodec(format)
+	size := len(buffer) / codec.size
+	if cap(dst) < size {
+		dst = make([]float32, size)
+	}
+	dst = dst[:size]
+
+	// The byte order is checked outside of the loops, so that it is not called through an interface.
+	little := codec.order == binary.LittleEndian
+	switch {
+	case codec.kind == 'f' && codec.size == 4 && little:
+		for i := range dst {
+			dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(buffer[i*4:]))
+		}
+	case codec.kind == 'f' && codec.size == 4:
+		for i := range dst {
+			dst[i] = math.Float32frombits(binary.BigEndian.Uint32(buffer[i*4:]))
+		}
+	case codec.kind == 's' && codec.size == 2 && little:
+		for i := range dst {
+			dst[i] = float32(int16(binary.LittleEndian.Uint16(buffer[i*2:]))) / (1 << 15)
+		}
+	case codec.kind == 's' && codec.size == 2:
+		for i := range dst {
+			dst[i] = float32(int16(binary.BigEndian.Uint16(buffer[i*2:]))) / (1 << 15)
+		}
+	case codec.kind == 's' && codec.size == 3 && little:
+		for i := range dst {
+			sample := buffer[i*3 : i*3+3]
+			dst[i] = float32(int32(uint32(sample[0])<<8|uint32(sample[1])<<16|uint32(sample[2])<<24)>>8) / (1 << 23)
+		}
+	case codec.kind == 's' && codec.size == 4 && little:
+		for i := range dst {
+			dst[i] = float32(float64(int32(binary.LittleEndian.Uint32(buffer[i*4:]))) / (1 << 31))
+		}
+	case codec.kind == 'u' && codec.size == 1:
+		for i := range dst {
+			dst[i] = float32(int(buffer[i])-(1<<7)) / (1 << 7)
+		}
+	default:
+		for i := range dst {
+			dst[i] = float32(codec.decode(buffer[i*codec.size:]))
+		}
+	}
+	return dst
+}
+
+// Returns a slice of the given number of samples with the element type matching the audio format.
+// 24-bit formats have no matching type and are returned as a byte slice holding 3 bytes per sample.
+func makeSamples(format string, size int) interface{} {
+	switch sampleType(format) {
+	case "u8":
+		return make([]uint8, size)
+	case "s8":
+		return make([]int8, size)
+	case "u16":
+		return make([]uint16, size)
+	case "s16":
+		return make([]int16, size)
+	case "u24", "s24":
+		return make([]byte, size*3)
+	case "u32":
+		return make([]uint32, size)
+	case "s32":
+		return make([]int32, size)
+	case "f32":
+		return make([]float32, size)
+	default:
+		return make([]float64, size)
+	}
+}
+
+// Packs 24-bit samples held in a 32-bit slice into 3 bytes per sample in the byte order of the
+// 24-bit format: []int32 for "s24" in the range [-8388608, 8388607], and []uint32 for "u24" in
+// the range [0, 16777215]. Values outside of the range saturate if clamp is set, otherwise an
+// error is returned. Returns false if the samples are not 24-bit samples for the format.
+func pack24(samples interface{}, format string, clamp bool) ([]byte, bool, error) {
+	// Only 32-bit slices hold 24-bit samples, so the format is not parsed for other samples.
+	switch samples.(type) {
+	case []int32, []uint32:
+	default:
+		return nil, false, nil
+	}
+	kind := sampleType(format)
+	codec := newSampleCodec(format)
+	switch data := samples.(type) {
+	case []int32:
+		if kind != "s24" {
+			return nil, false, nil
+		}
+		buffer := make([]byte, len(data)*3)
+		for i, sample := range data {
+			if sample < -1<<23 || sample > 1<<23-1 {
+				if !clamp {
+					return nil, true, fmt.Errorf("sample %d at index %d does not fit in 24 bits", sample, i)
+				}
+				if sample < 0 {
+					sample = -1 << 23
+				} else {
+					sample = 1<<23 - 1
+				}
+			}
+			codec.putBits(buffer[i*3:], uint64(sample))
+		}
+		return buffer, true, nil
+	case []uint32:
+		if kind != "u24" {
+			return nil, false, nil
+		}
+		buffer := make([]byte, len(data)*3)
+		for i, sample := range data {
+			if sample > 1<<24-1 {
+				if !clamp {
+					return nil, true, fmt.Errorf("sample %d at index %d does not fit in 24 bits", sample, i)
+				}
+				sample = 1<<24 - 1
+			}
+			codec.putBits(buffer[i*3:], uint64(sample))
+		}
+		return buffer, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// Converts the samples to the given audio format, e.g. "s16", scaling them to the range of the new
+// format. Integer samples are scaled by a power of two, so that the smallest value of a signed
+// format maps to -1 and back, and the offset of unsigned formats is removed. Samples outside of
+// the range of an integer format saturate. The result is a slice of the type matching the format,
+// e.g. []int16 for "s16". For the 24-bit formats "s24" and "u24", the samples are packed into a
+// byte slice with 3 bytes per sample, in the byte order of the format. Byte slices are treated as
+// samples in the "u8" format.
+func ConvertSamples(src interface{}, dstFormat string) (interface{}, error) {
+	return ConvertSamplesInto(nil, src, dstFormat)
+}
+
+// Converts the samples like ConvertSamples, reusing the destination slice if it has the type
+// returned for the format and enough capacity. Otherwise a new slice is allocated.
+func ConvertSamplesInto(dst, src interface{}, dstFormat string) (interface{}, error) {
+	from := typedFormat(src)
+	if from == "" {
+		return nil, fmt.Errorf("invalid sample data type: %T", src)
+	}
+
+	to, err := NormalizeFormat(dstFormat)
+	if err != nil {
+		return nil, err
+	}
+	buffer := samplesToBytes(src)
+	size := len(buffer) / newSampleCodec(from).size
+
+	// Typed samples are always stored in the byte order of the machine.
+	length := size
+	if kind := sampleType(to); kind == "u24" || kind == "s24" {
+		length = size * 3
+	} else {
+		to = createFormat(kind)
+	}
+
+	result := reflect.ValueOf(dst)
+	if dst == nil || result.Type() != reflect.TypeOf(makeSamples(to, 0)) || result.Cap() < length {
+		result = reflect.ValueOf(makeSamples(to, size))
+	}
+	result = result.Slice(0, length)
+
+	if from == to {
+		reflect.Copy(result, reflect.ValueOf(src))
+	} else if floats, ok := result.Interface().([]float32); ok {
+		decodeFloat32(floats, buffer, from)
+	} else {
+		convertInto(samplesToBytes(result.Interface()), buffer, from, to)
+	}
+
+	return result.Interface(), nil
+}
@@ -0,0 +1,142 @@
+package aio
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Level in dBFS below which Options.TrimSilence treats audio as silence by default.
+const defaultSilenceThreshold = -50
+
+// Trims silence from the start and end of raw audio read from a pipe. Silence after the last
+// sound is held back until more sound follows, and dropped once the pipe has ended. Sounds
+// shorter than the minimum number of frames, e.g. clicks, are trimmed along with the silence.
+type silenceTrimmer struct {
+	pipe      io.ReadCloser // Pipe the audio is read from.
+	codec     sampleCodec   // Layout of the samples.
+	frame     int           // Number of bytes in one frame of audio.
+	threshold float64       // Linear level below which samples are silent.
+	minimum   int           // Number of frames of sound needed to end silence.
+	chunk     []byte        // Buffer for reading from the pipe.
+	pending   []byte        // Incomplete frame at the end of the last read.
+	held      []byte        // Audio after the last sound, waiting for more sound.
+	run       int           // Number of consecutive frames of sound at the end of the held audio.
+	ready     []byte        // Audio ready to be returned by Read.
+	offset    int           // Number of bytes of the ready audio that have been returned.
+	started   bool          // Flag storing whether sound has been found.
+	ended     bool          // Flag storing whether the pipe has ended.
+	mutex     sync.Mutex    // Mutex guarding the trimmed frame counts.
+	start     int           // Number of frames trimmed from the start.
+	end       int           // Number of frames trimmed from the end, once the pipe has ended.
+}
+
+// Creates a trimmer for audio with the given format and channels. Audio below the threshold in
+// dBFS is silent, and sound must last for at least the given duration to end silence.
+func newSilenceTrimmer(pipe io.ReadCloser, format string, channels, samplerate int, threshold float64, duration time.Duration) *silenceTrimmer {
+	codec := newSampleCodec(format)
+	minimum := int(duration * time.Duration(samplerate) / time.Second)
+	if minimum < 1 {
+		minimum = 1
+	}
+	return &silenceTrimmer{
+		pipe:      pipe,
+		codec:     codec,
+		frame:     codec.size * channels,
+		threshold: FromDBFS(threshold),
+		minimum:   minimum,
+		chunk:     make([]byte, 32*1024),
+	}
+}
+
+func (trimmer *silenceTrimmer) Read(data []byte) (int, error) {
+	for trimmer.offset == len(trimmer.ready) {
+		if trimmer.ended {
+			return 0, io.EOF
+		}
+		trimmer.ready, trimmer.offset = trimmer.ready[:0], 0
+
+		n, err := trimmer.pipe.Read(trimmer.chunk)
+		trimmer.process(trimmer.chunk[:n])
+		if err == io.EOF {
+			trimmer.finish()
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(data, trimmer.ready[trimmer.offset:])
+	trimmer.offset += n
+	return n, nil
+}
+
+func (trimmer *silenceTrimmer) Close() error {
+	return trimmer.pipe.Close()
+}
+
+// Sorts the frames of the audio into sound and silence, moving audio that is followed by
+// enough sound to the ready audio.
+func (trimmer *silenceTrimmer) process(data []byte) {
+	trimmer.pending = append(trimmer.pending, data...)
+	frames := len(trimmer.pending) / trimmer.frame
+	for i := 0; i < frames; i++ {
+		frame := trimmer.pending[i*trimmer.frame : (i+1)*trimmer.frame]
+		if trimmer.silent(frame) {
+			if !trimmer.started {
+				// Short sounds before the first sound are trimmed with the silence.
+				trimmer.count(&trimmer.start, len(trimmer.held)/trimmer.frame+1)
+				trimmer.held = trimmer.held[:0]
+			} else {
+				trimmer.held = append(trimmer.held, frame...)
+			}
+			trimmer.run = 0
+			continue
+		}
+		trimmer.held = append(trimmer.held, frame...)
+		trimmer.run++
+		if trimmer.run >= trimmer.minimum {
+			trimmer.started = true
+			trimmer.ready = append(trimmer.ready, trimmer.held...)
+			trimmer.held = trimmer.held[:0]
+		}
+	}
+	trimmer.pending = append(trimmer.pending[:0], trimmer.pending[frames*trimmer.frame:]...)
+}
+
+// Drops the held audio once the pipe has ended, since no more sound follows it.
+func (trimmer *silenceTrimmer) finish() {
+	trimmer.ended = true
+	if trimmer.started {
+		trimmer.count(&trimmer.end, len(trimmer.held)/trimmer.frame)
+	} else {
+		trimmer.count(&trimmer.start, len(trimmer.held)/trimmer.frame)
+	}
+	trimmer.held = nil
+}
+
+// Returns true if all samples of the frame are below the threshold.
+func (trimmer *silenceTrimmer) silent(frame []byte) bool {
+	for i := 0; i < len(frame); i += trimmer.codec.size {
+		if math.Abs(trimmer.codec.decode(frame[i:])) >= trimmer.threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds the number of frames to one of the trimmed frame counts.
+func (trimmer *silenceTrimmer) count(counter *int, frames int) {
+	trimmer.mutex.Lock()
+	defer trimmer.mutex.Unlock()
+	*counter += frames
+}
+
+// Returns the number of frames trimmed from the start and from the end so far.
+func (trimmer *silenceTrimmer) trimmed() (int, int) {
+	if trimmer == nil {
+		return 0, 0
+	}
+	trimmer.mutex.Lock()
+	defer trimmer.mutex.Unlock()
+	return trimmer.start, trimmer.end
+}
@@ -0,0 +1,188 @@
+package aio
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Matches the summary lines printed by the astats filter once all audio has been analyzed.
+var astatsLine = regexp.MustCompile(`^\[Parsed_astats_\d+ @ [^\]]+\] (?:\[info\] )?(.+?)\s*$`)
+
+// Peak level in dBFS from which samples count as clipped, allowing for the largest positive
+// value of integer formats being one step below full scale.
+const clippingLevel = -0.001
+
+// Statistics of one channel, or of all channels together, as measured by the astats filter.
+// Levels are given relative to full scale, with samples between -1 and 1. Fields that the
+// installed ffmpeg does not print are left at zero, and every field printed is also kept in
+// Fields as it was printed, keyed by its name, e.g. "Bit depth".
+type ChannelStats struct {
+	DCOffset          float64           // Mean sample value.
+	MinLevel          float64           // Lowest sample value.
+	MaxLevel          float64           // Highest sample value.
+	PeakLevel         float64           // Highest absolute sample value in dBFS.
+	RMSLevel          float64           // RMS level of the whole audio in dBFS.
+	RMSPeak           float64           // Highest RMS level of a short window in dBFS.
+	RMSTrough         float64           // Lowest RMS level of a short window in dBFS.
+	CrestFactor       float64           // Ratio of the peak level to the RMS level.
+	FlatFactor        float64           // Flatness of the signal at its peak levels.
+	DynamicRange      float64           // Ratio between the largest and smallest nonzero sample in dB.
+	NoiseFloor        float64           // Minimum local RMS level in dBFS, printed by newer ffmpeg versions.
+	PeakCount         int64             // Number of samples at the lowest or highest level.
+	ClippedSamples    int64             // Number of samples at full scale, estimated from the peak counts.
+	ZeroCrossings     int64             // Number of times the signal changes sign.
+	ZeroCrossingsRate float64           // Zero crossings per sample.
+	Samples           int64             // Number of samples analyzed.
+	Fields            map[string]string // All values printed by astats, keyed by name.
+}
+
+// Statistics of the whole audio stream of a file, as returned by Stats.
+type AudioStats struct {
+	Channels []ChannelStats // Statistics of each channel.
+	Overall  ChannelStats   // Statistics of all channels together.
+}
+
+// Analyzes the whole audio stream with the given index with the astats filter of ffmpeg and
+// returns the statistics of each channel and of all channels together, without decoding the
+// audio in Go. The audio is converted to floating point samples first, so that levels do not
+// depend on the format of the file.
+func Stats(filename string, stream int) (*AudioStats, error) {
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if err := checkFilters("aformat,astats"); err != nil {
+		return nil, err
+	}
+
+	probe, err := ProbeAudio(filename)
+	if err != nil {
+		return nil, err
+	}
+	streams := probe.AudioStreams()
+	if stream < 0 || stream >= len(streams) {
+		return nil, fmt.Errorf("invalid stream index: %d, must be between 0 and %d", stream, len(streams))
+	}
+
+	cmd := exec.Command(
+		"ffmpeg",
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "info",
+		"-i", localInput(filename),
+		"-map", fmt.Sprintf("0:a:%d", stream),
+		"-af", "aformat=sample_fmts=dbl,astats",
+		"-f", "null",
+		"-",
+	)
+	logCommand(cmd)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg could not analyze %s: %w: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+
+	stats := parseAstats(stderr.String())
+	if len(stats.Channels) == 0 {
+		return nil, fmt.Errorf("ffmpeg returned no statistics for %s", filename)
+	}
+	return stats, nil
+}
+
+// Parses the summary printed by the astats filter into per channel and overall statistics.
+// Other lines of the ffmpeg output are ignored, as are fields that are unknown to this version.
+func parseAstats(output string) *AudioStats {
+	stats := &AudioStats{}
+	var section *ChannelStats
+	for _, line := range strings.Split(output, "\n") {
+		match := astatsLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		text := match[1]
+		if text == "Overall" {
+			section = &stats.Overall
+			continue
+		}
+		index := strings.Index(text, ":")
+		if index == -1 {
+			continue
+		}
+		name, value := strings.TrimSpace(text[:index]), strings.TrimSpace(text[index+1:])
+		if name == "Channel" {
+			stats.Channels = append(stats.Channels, ChannelStats{})
+			section = &stats.Channels[len(stats.Channels)-1]
+			continue
+		}
+		if section == nil {
+			continue
+		}
+		section.set(name, value)
+	}
+
+	for i := range stats.Channels {
+		stats.Channels[i].clipping()
+	}
+	stats.Overall.clipping()
+	return stats
+}
+
+// Stores the value of the astats field with the given name.
+func (stats *ChannelStats) set(name, value string) {
+	if stats.Fields == nil {
+		stats.Fields = map[string]string{}
+	}
+	stats.Fields[name] = value
+
+	number, ok := parseValue(value)
+	if !ok {
+		return
+	}
+	switch name {
+	case "DC offset":
+		stats.DCOffset = number
+	case "Min level":
+		stats.MinLevel = number
+	case "Max level":
+		stats.MaxLevel = number
+	case "Peak level dB":
+		stats.PeakLevel = number
+	case "RMS level dB":
+		stats.RMSLevel = number
+	case "RMS peak dB":
+		stats.RMSPeak = number
+	case "RMS trough dB":
+		stats.RMSTrough = number
+	case "Crest factor":
+		stats.CrestFactor = number
+	case "Flat factor":
+		stats.FlatFactor = number
+	case "Dynamic range":
+		stats.DynamicRange = number
+	case "Noise floor dB":
+		stats.NoiseFloor = number
+	case "Peak count":
+		stats.PeakCount = int64(number)
+	case "Zero crossings":
+		stats.ZeroCrossings = int64(number)
+	case "Zero crossings rate":
+		stats.ZeroCrossingsRate = number
+	case "Number of samples":
+		stats.Samples = int64(number)
+	}
+}
+
+// Estimates the number of clipped samples from the peak counts. Newer ffmpeg versions count the
+// samples at the highest absolute level, older ones only those at the lowest or highest level.
+func (stats *ChannelStats) clipping() {
+	if _, ok := stats.Fields["Peak level dB"]; !ok || stats.PeakLevel < clippingLevel {
+		return
+	}
+	stats.ClippedSamples = stats.PeakCount
+	if count, ok := parseValue(stats.Fields["Abs Peak count"]); ok {
+		stats.ClippedSamples = int64(count)
+	}
+}
@@ -0,0 +1,62 @@
+package aio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Largest number of bytes read from stdin to probe the audio, which is the default probe
+// size of ffprobe.
+const stdinProbeSize = 5000000
+
+// Tracks whether stdin has been read, since it can only be consumed once.
+var stdin = struct {
+	mutex sync.Mutex
+	read  bool
+}{}
+
+// Returns true if the filename refers to stdin, i.e. "-", "pipe:" or "pipe:0".
+func isStdin(filename string) bool {
+	return filename == "-" || filename == "pipe:" || filename == "pipe:0"
+}
+
+// Audio read from stdin, shared by all audio streams found in it.
+type stdinInput struct {
+	mutex   sync.Mutex
+	reader  io.Reader // The probed prefix of stdin followed by the rest of stdin.
+	claimed bool      // Flag storing whether a process has been given the input.
+}
+
+// Reads the prefix of stdin used to probe the audio. The prefix is kept, so that the input
+// returned can be decoded from the start. Returns an error if stdin has been read before.
+func openStdin() (*stdinInput, []byte, error) {
+	stdin.mutex.Lock()
+	defer stdin.mutex.Unlock()
+
+	if stdin.read {
+		return nil, nil, fmt.Errorf("stdin has already been read, it can only be read once")
+	}
+	stdin.read = true
+
+	prefix, err := io.ReadAll(io.LimitReader(os.Stdin, stdinProbeSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &stdinInput{reader: io.MultiReader(bytes.NewReader(prefix), os.Stdin)}, prefix, nil
+}
+
+// Returns the reader of the input for the process decoding it. Only one audio stream
+// can be decoded from stdin, so any later calls return an error.
+func (input *stdinInput) claim() (io.Reader, error) {
+	input.mutex.Lock()
+	defer input.mutex.Unlock()
+
+	if input.claimed {
+		return nil, fmt.Errorf("stdin is already being read by another audio stream")
+	}
+	input.claimed = true
+	return input.reader, nil
+}
@@ -0,0 +1,210 @@
+package aio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// Serves the audio of a file over HTTP, see StreamHandler.
+type streamHandler struct {
+	filename string  // Audio file to stream.
+	options  Options // Options used to decode or encode the audio.
+}
+
+// Returns an http.Handler streaming the audio of the given file to every client. Without a codec
+// in the options, the audio is decoded to the format in the options and sent as a WAV file.
+// With a codec, the audio is encoded by ffmpeg and sent in a container suited to the codec.
+// Audio is sent as it is produced, and ffmpeg is stopped once the client disconnects.
+func StreamHandler(filename string, options *Options) http.Handler {
+	options = withDefaults(options)
+	return &streamHandler{filename: filename, options: *options}
+}
+
+func (handler *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !exists(handler.filename) {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := handler.options.validate("StreamHandler")
+	if err == nil {
+		if handler.options.Codec == "" {
+			err = handler.serveWAV(w, r)
+		} else {
+			err = handler.serveEncoded(w, r)
+		}
+	}
+
+	// Errors are only returned before any audio has been sent, so the status can still be set.
+	if err != nil {
+		if logger := currentLogger(); logger != nil {
+			logger.Printf("aio: could not stream %s: %v", handler.filename, err)
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// Sends the decoded audio as a WAV file.
+func (handler *streamHandler) serveWAV(w http.ResponseWriter, r *http.Request) error {
+	audio, err := NewAudio(handler.filename, &handler.options)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+
+	header, err := wavHeader(audio.format, audio.samplerate, audio.channels)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	// Send a tenth of a second of audio at a time.
+	frame := audio.BytesPerFrame()
+	size := audio.BytesPerSecond() / 10 / frame * frame
+	if size == 0 {
+		size = frame
+	}
+	audio.SetBuffer(make([]byte, size))
+
+	stop := closeWhenDone(r.Context(), audio.Close)
+	defer stop()
+
+	if err := flushWrite(w, header); err != nil {
+		return nil
+	}
+	for audio.Read() {
+		if err := flushWrite(w, audio.Buffer()); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Sends the audio encoded with the codec from the options.
+func (handler *streamHandler) serveEncoded(w http.ResponseWriter, r *http.Request) error {
+	options := handler.options
+	if err := installed("ffmpeg"); err != nil {
+		return err
+	}
+	if err := checkEncoder(options.Codec); err != nil {
+		return err
+	}
+
+	container, contentType := streamContainer(options.Codec)
+	w.Header().Set("Content-Type", contentType)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	command := []string{
+		"-hide_banner",
+		"-loglevel", logLevel(options.LogLevel, "quiet"),
+		"-i", localInput(handler.filename),
+		"-map", fmt.Sprintf("0:a:%d", options.Stream),
+	}
+	if options.SampleRate != 0 {
+		command = append(command, "-ar", fmt.Sprintf("%d", options.SampleRate))
+	}
+	if options.Channels != 0 {
+		command = append(command, "-ac", fmt.Sprintf("%d", options.Channels))
+	}
+	command = append(command, "-acodec", options.Codec)
+	if options.Bitrate > 0 {
+		command = append(command, "-ab", fmt.Sprintf("%d", options.Bitrate))
+	}
+	command = append(command, "-f", container, "-")
+
+	// The process is killed once the request context is done, i.e. the client has disconnected.
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", command...)
+	cmd.Stderr = logOutput(cmd, options.LogLevel, nil)
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := startNice(cmd, options.Nice); err != nil {
+		return err
+	}
+	register(cmd, func() {
+		logEvent(cmd, "killed")
+		cmd.Process.Kill()
+	})
+
+	written := false
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := pipe.Read(buffer)
+		if n > 0 {
+			if err := flushWrite(w, buffer[:n]); err != nil {
+				logEvent(cmd, "killed")
+				cmd.Process.Kill()
+				break
+			}
+			written = true
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err = cmd.Wait()
+	unregister(cmd)
+	if !written && err != nil && r.Context().Err() == nil {
+		return fmt.Errorf("ffmpeg could not encode the audio: %w", err)
+	}
+	return nil
+}
+
+// Returns the ffmpeg container format and HTTP content type used to stream audio
+// encoded with the given codec.
+func streamContainer(codec string) (string, string) {
+	switch {
+	case codec == "mp3" || codec == "libmp3lame" || codec == "libshine":
+		return "mp3", "audio/mpeg"
+	case codec == "aac" || codec == "libfdk_aac":
+		return "adts", "audio/aac"
+	case codec == "opus" || codec == "libopus" || codec == "vorbis" || codec == "libvorbis":
+		return "ogg", "audio/ogg"
+	case codec == "flac":
+		return "flac", "audio/flac"
+	case strings.HasPrefix(codec, "pcm_"):
+		return "wav", "audio/wav"
+	default:
+		return "matroska", "audio/x-matroska"
+	}
+}
+
+// Writes the data to the client and sends it immediately instead of buffering it.
+func flushWrite(w http.ResponseWriter, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Calls stop once the context is done, unless the returned function has been called before.
+func closeWhenDone(ctx context.Context, stop func()) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
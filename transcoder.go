@@ -0,0 +1,385 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Maximum number of bytes of transcoded audio kept until it is read. Once this much output is
+// waiting, ffmpeg and Write wait for it to be read.
+const maxTranscodeOutput = 1 << 20
+
+// Format of the audio written to or read from a Transcoder. Audio with a Format is raw PCM,
+// any other audio is encoded.
+type TranscodeSpec struct {
+	Format     string // Sample format of raw PCM, e.g. "s16". Empty for encoded audio.
+	Container  string // Container format of encoded audio, e.g. "ogg". Detected by ffmpeg for the input if empty.
+	Codec      string // Audio codec of the encoded output, e.g. "libopus". Chosen by ffmpeg if empty.
+	Bitrate    int    // Bitrate of the encoded output in bits/s.
+	SampleRate int    // Sample rate in Hz. Required for raw PCM input, kept from the input if 0 for the output.
+	Channels   int    // Number of channels. Required for raw PCM input, kept from the input if 0 for the output.
+}
+
+type Transcoder struct {
+	input    TranscodeSpec // Format of the written audio.
+	output   TranscodeSpec // Format of the read audio.
+	loglevel string        // ffmpeg log level when logging is enabled.
+	nice     int           // Niceness of the ffmpeg process.
+	state    *transcoding  // ffmpeg process and its output.
+	stack    []byte        // Stack of the constructor call, captured if leak detection is enabled.
+}
+
+// The ffmpeg process of a Transcoder and the output it has produced. It never refers to the
+// Transcoder, so that the output can be collected in the background while a Transcoder that
+// is no longer used can still be garbage collected, and its process stopped by a finalizer.
+type transcoding struct {
+	cmd      *exec.Cmd      // ffmpeg command.
+	pipe     io.WriteCloser // Stdin pipe for ffmpeg process.
+	handle   *processHandle // Handle used by CloseAll to stop the process.
+	log      *ffmpegLog     // Errors written by ffmpeg.
+	pending  []byte         // Transcoded audio waiting to be read.
+	ended    bool           // Flag storing whether ffmpeg has exited and all output has been received.
+	closed   bool           // Flag storing whether the transcoder has been closed.
+	err      error          // Error of the ffmpeg process once it has exited.
+	done     chan struct{}  // Closed once all output has been received.
+	mutex    sync.Mutex     // Mutex guarding the state against concurrent calls.
+	received *sync.Cond     // Signals new output, read output and the end of the output.
+}
+
+// Format of the written audio, with the byte order of raw PCM added to its format.
+func (transcoder *Transcoder) Input() TranscodeSpec {
+	return transcoder.input
+}
+
+// Format of the read audio, with the byte order of raw PCM added to its format.
+func (transcoder *Transcoder) Output() TranscodeSpec {
+	return transcoder.output
+}
+
+// Creates a transcoder converting audio written to it in the input format to audio read from
+// it in the output format, e.g. Ogg Opus received over the network to raw PCM, or raw PCM to
+// MP3. A single ffmpeg process transcodes all audio, and is started on the first call to
+// Write or Read.
+func NewTranscoder(input, output TranscodeSpec, options *Options) (*Transcoder, error) {
+	options = withDefaults(options)
+
+	if err := options.validate("NewTranscoder"); err != nil {
+		return nil, err
+	}
+	if input.Format != "" {
+		if err := checkSampleRate("input.SampleRate", input.SampleRate); err != nil {
+			return nil, err
+		}
+		if err := checkChannels("input.Channels", input.Channels); err != nil {
+			return nil, err
+		}
+	}
+	if output.SampleRate != 0 {
+		if err := checkSampleRate("output.SampleRate", output.SampleRate); err != nil {
+			return nil, err
+		}
+	}
+	if output.Channels != 0 {
+		if err := checkChannels("output.Channels", output.Channels); err != nil {
+			return nil, err
+		}
+	}
+	if output.Format == "" && output.Container == "" {
+		return nil, &OptionError{"output.Container", `""`, "must be set for encoded audio"}
+	}
+	if output.Bitrate < 0 {
+		return nil, &OptionError{"output.Bitrate", output.Bitrate, "must be non-negative"}
+	}
+
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if output.Format == "" && output.Codec != "" {
+		if err := checkEncoder(output.Codec); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if input.Format != "" {
+		if input.Format, err = orderFormat(input.Format, options.Endianness); err != nil {
+			return nil, err
+		}
+	}
+	if output.Format != "" {
+		if output.Format, err = orderFormat(output.Format, options.Endianness); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &transcoding{done: make(chan struct{})}
+	state.received = sync.NewCond(&state.mutex)
+	transcoder := &Transcoder{
+		input:    input,
+		output:   output,
+		loglevel: options.LogLevel,
+		nice:     options.Nice,
+		state:    state,
+		stack:    creationStack(),
+	}
+	runtime.SetFinalizer(transcoder, (*Transcoder).leaked)
+
+	return transcoder, nil
+}
+
+// Returns the ffmpeg arguments describing the input read from stdin.
+func (transcoder *Transcoder) inputArguments() []string {
+	input := transcoder.input
+	if input.Format != "" {
+		return []string{
+			"-f", input.Format,
+			"-ar", fmt.Sprintf("%d", input.SampleRate),
+			"-ac", fmt.Sprintf("%d", input.Channels),
+			"-i", "-",
+		}
+	}
+	if input.Container != "" {
+		return []string{"-f", input.Container, "-i", "-"}
+	}
+	return []string{"-i", "-"}
+}
+
+// Returns the ffmpeg arguments describing the output written to stdout.
+func (transcoder *Transcoder) outputArguments() []string {
+	output := transcoder.output
+	command := []string{"-vn"}
+	if output.SampleRate != 0 {
+		command = append(command, "-ar", fmt.Sprintf("%d", output.SampleRate))
+	}
+	if output.Channels != 0 {
+		command = append(command, "-ac", fmt.Sprintf("%d", output.Channels))
+	}
+	if output.Format != "" {
+		return append(command, "-f", output.Format, "-flush_packets", "1", "-")
+	}
+	if output.Codec != "" {
+		command = append(command, "-acodec", output.Codec)
+	}
+	if output.Bitrate > 0 {
+		command = append(command, "-ab", fmt.Sprintf("%d", output.Bitrate))
+	}
+	return append(command, "-f", output.Container, "-flush_packets", "1", "-")
+}
+
+// Once the user calls Write() or Read() for the first time on a Transcoder struct,
+// the ffmpeg command which is used to transcode the audio is started.
+func (transcoder *Transcoder) init() error {
+	command := []string{"-hide_banner", "-loglevel", logLevel(transcoder.loglevel, "error")}
+	command = append(command, transcoder.inputArguments()...)
+	command = append(command, transcoder.outputArguments()...)
+	cmd := exec.Command("ffmpeg", command...)
+
+	// Stderr is drained in the background, keeping the latest errors of ffmpeg.
+	state := transcoder.state
+	state.log = &ffmpegLog{limit: maxErrorLog}
+	cmd.Stderr = logOutput(cmd, transcoder.loglevel, state.log)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := startNice(cmd, transcoder.nice); err != nil {
+		return err
+	}
+	state.cmd = cmd
+	state.pipe = stdin
+	// The process is unregistered once it has exited.
+	state.handle = registerHandle(cmd, func() error {
+		state.mutex.Lock()
+		state.discard()
+		state.mutex.Unlock()
+		stdin.Close()
+		logEvent(cmd, "killed")
+		cmd.Process.Kill()
+		<-state.done
+		return nil
+	})
+
+	// The output is always read, so that ffmpeg never blocks on a full stdout pipe while
+	// Write waits for it to read more input.
+	go state.receive(stdout)
+
+	return nil
+}
+
+// Collects the output of ffmpeg until it exits.
+func (state *transcoding) receive(stdout io.Reader) {
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := stdout.Read(chunk)
+		if n > 0 {
+			state.mutex.Lock()
+			// Reading stops while the output is full, which makes ffmpeg wait as well.
+			for len(state.pending) >= maxTranscodeOutput && !state.closed {
+				state.received.Wait()
+			}
+			if !state.closed {
+				state.pending = append(state.pending, chunk[:n]...)
+			}
+			state.received.Broadcast()
+			state.mutex.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err := state.cmd.Wait()
+	unregister(state.cmd)
+
+	state.mutex.Lock()
+	if err != nil && !state.closed {
+		if message := strings.TrimSpace(state.log.String()); message != "" {
+			state.err = fmt.Errorf("ffmpeg could not transcode the audio: %s", message)
+		} else {
+			state.err = fmt.Errorf("ffmpeg could not transcode the audio: %w", err)
+		}
+	}
+	state.ended = true
+	state.received.Broadcast()
+	state.mutex.Unlock()
+	close(state.done)
+}
+
+// Marks the transcoder as closed and drops the output that has not been read, waking up all
+// waiting calls. Must be called with the mutex held.
+func (state *transcoding) discard() {
+	state.closed = true
+	state.pending = nil
+	state.received.Broadcast()
+}
+
+// Starts the ffmpeg process if it is not running yet. Must be called with the mutex held.
+func (transcoder *Transcoder) start() error {
+	if transcoder.state.closed {
+		return fmt.Errorf("transcoder is closed")
+	}
+	if transcoder.state.cmd == nil {
+		return transcoder.init()
+	}
+	return nil
+}
+
+// Writes audio in the input format to the transcoder. The output is collected in the
+// background, so Write only waits for it to be read once 1 MiB of output is waiting.
+// Returns the error of ffmpeg if it has failed.
+func (transcoder *Transcoder) Write(data []byte) (int, error) {
+	state := transcoder.state
+	state.mutex.Lock()
+	err := transcoder.start()
+	for err == nil && len(state.pending) >= maxTranscodeOutput && !state.ended && !state.closed {
+		state.received.Wait()
+	}
+	if err == nil && state.closed {
+		err = fmt.Errorf("transcoder is closed")
+	}
+	pipe := state.pipe
+	state.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := pipe.Write(data)
+	if err != nil {
+		// Wait for ffmpeg to exit, so that its error can be returned.
+		<-state.done
+		if failure := transcoder.Error(); failure != nil {
+			return n, failure
+		}
+		return n, fmt.Errorf("input of the transcoder is closed")
+	}
+	return n, nil
+}
+
+// Reads audio in the output format from the transcoder, waiting until ffmpeg has produced
+// some. Returns io.EOF once all audio has been read after CloseWrite, or the error of ffmpeg
+// if it has failed.
+func (transcoder *Transcoder) Read(data []byte) (int, error) {
+	state := transcoder.state
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if err := transcoder.start(); err != nil {
+		return 0, err
+	}
+	for len(state.pending) == 0 && !state.ended && !state.closed {
+		state.received.Wait()
+	}
+
+	if state.closed {
+		return 0, fmt.Errorf("transcoder is closed")
+	}
+	if len(state.pending) == 0 {
+		if state.err != nil {
+			return 0, state.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(data, state.pending)
+	state.pending = state.pending[:copy(state.pending, state.pending[n:])]
+	// Wake up ffmpeg and Write if they wait for the output to be read.
+	state.received.Broadcast()
+	return n, nil
+}
+
+// Signals the end of the input, so that ffmpeg writes the rest of the output and exits.
+// The output can be read until Read returns io.EOF.
+func (transcoder *Transcoder) CloseWrite() error {
+	state := transcoder.state
+	state.mutex.Lock()
+	err := transcoder.start()
+	pipe := state.pipe
+	state.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return pipe.Close()
+}
+
+// Returns the error of the ffmpeg process once it has exited, e.g. for an unsupported codec.
+func (transcoder *Transcoder) Error() error {
+	transcoder.state.mutex.Lock()
+	defer transcoder.state.mutex.Unlock()
+	return transcoder.state.err
+}
+
+// Stops the ffmpeg process and discards any output that has not been read. Use CloseWrite
+// to finish transcoding instead. Safe to call from any goroutine, and returns once the process
+// has exited.
+func (transcoder *Transcoder) Close() error {
+	state := transcoder.state
+	state.mutex.Lock()
+	state.discard()
+	handle := state.handle
+	state.mutex.Unlock()
+
+	runtime.SetFinalizer(transcoder, nil)
+	if handle != nil {
+		handle.close()
+	}
+	return nil
+}
+
+// Stops the ffmpeg process if the transcoder is garbage collected without being closed.
+func (transcoder *Transcoder) leaked() {
+	state := transcoder.state
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if !state.closed {
+		state.handle.leaked("Transcoder", transcoder.stack)
+	}
+}
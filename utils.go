@@ -2,16 +2,18 @@ package aio
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"unsafe"
 )
 
@@ -28,29 +30,79 @@ func exists(filename string) bool {
 	return false
 }
 
-// Checks if the given program is installed.
+// Returns the ffmpeg input for a local file. Without the "file:" prefix, ffmpeg reads names
+// such as "10:30.mp3" or "concat:a.mp3" as protocols. The file protocol opens the rest of the
+// input as a path, without decoding "%" escapes, so no other characters need to be escaped.
+// https://ffmpeg.org/ffmpeg-protocols.html#file.
+func localInput(filename string) string {
+	return "file:" + filename
+}
+
+// Paths of programs that have been checked by installed.
+var installations = struct {
+	mutex   sync.Mutex
+	checked map[string]bool
+}{checked: make(map[string]bool)}
+
+// Checks if the given program is installed. The program is only run once for each path it
+// is found at, but it is looked up in the PATH on every call so that a removed program is
+// reported as not installed.
 func installed(program string) error {
-	cmd := exec.Command(program, "-version")
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return fmt.Errorf("%s is not installed", program)
+	}
+
+	installations.mutex.Lock()
+	defer installations.mutex.Unlock()
+
+	if installations.checked[path] {
+		return nil
+	}
+
+	cmd := exec.Command(path, "-version")
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("%s is not installed", program)
 	}
 
+	installations.checked[path] = true
 	return nil
 }
 
-// Runs ffprobe on the given file and returns a map of the metadata.
-func ffprobe(filename, stype string) ([]map[string]string, error) {
-	// "stype" is stream stype. "v" for video, "a" for audio.
+// Checks that ffmpeg and ffprobe are installed. ffplay is optional, since the Player
+// falls back to ffmpeg if it is not installed.
+func CheckDependencies() error {
+	for _, program := range []string{"ffmpeg", "ffprobe"} {
+		if err := installed(program); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs ffprobe on the given file and returns the information about its format and streams.
+// If input is not nil, it is probed instead of the file. ffprobe is killed once the context
+// is done, in which case the error of the context is returned.
+func ffprobe(ctx context.Context, filename string, input io.Reader, limits probeLimits) (*ProbeResult, error) {
+	if input != nil {
+		filename = "pipe:0"
+	} else {
+		filename = localInput(filename)
+	}
+
 	// Extract media metadata information with ffprobe.
-	cmd := exec.Command(
-		"ffprobe",
+	command := append(
+		limits.args(),
 		"-show_streams",
-		"-select_streams", stype,
+		"-show_format",
 		"-print_format", "compact",
 		"-loglevel", "quiet",
 		filename,
 	)
+	cmd := exec.CommandContext(ctx, "ffprobe", command...)
+	cmd.Stdin = input
+	logCommand(cmd)
 
 	pipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -62,49 +114,170 @@ func ffprobe(filename, stype string) ([]map[string]string, error) {
 	}
 
 	// Read ffprobe output from Stdout.
-	builder := bytes.Buffer{}
-	buffer := make([]byte, 1024)
-	for {
-		n, err := pipe.Read(buffer)
-		builder.Write(buffer[:n])
-		if err == io.EOF {
-			break
-		}
+	output, err := io.ReadAll(pipe)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
 	}
 
 	// Wait for ffprobe command to complete.
 	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 
-	// Parse ffprobe output to fill in audio data.
-	datalist := make([]map[string]string, 0)
-	metadata := builder.String()
-	for _, stream := range strings.Split(metadata, "\n") {
-		if len(strings.TrimSpace(stream)) > 0 {
-			data := make(map[string]string)
-			for _, line := range strings.Split(stream, "|") {
-				if strings.Contains(line, "=") {
-					keyValue := strings.Split(line, "=")
-					if _, ok := data[keyValue[0]]; !ok {
-						data[keyValue[0]] = keyValue[1]
-					}
+	streams, format := parseSections(string(output))
+	return newProbeResult(streams, format), nil
+}
+
+// Parses the compact ffprobe output into a map of metadata for each stream.
+func parseFFprobe(metadata string) []map[string]string {
+	streams, _ := parseSections(metadata)
+	return streams
+}
+
+// Parses the compact ffprobe output into a map of metadata for each stream and for the format.
+// Every stream record starts with "stream|" and the format record with "format|". Lines of
+// nested sections, such as side data, belong to the record above.
+func parseSections(metadata string) ([]map[string]string, map[string]string) {
+	streams := make([]map[string]string, 0)
+	format := make(map[string]string)
+	var data map[string]string
+	for _, line := range strings.Split(metadata, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "format|") {
+			data = format
+		} else if strings.HasPrefix(line, "stream|") || data == nil {
+			data = make(map[string]string)
+			streams = append(streams, data)
+		}
+		for _, field := range splitCompact(line) {
+			if strings.Contains(field, "=") {
+				keyValue := strings.SplitN(field, "=", 2)
+				if _, ok := data[keyValue[0]]; !ok {
+					data[keyValue[0]] = keyValue[1]
 				}
 			}
-			datalist = append(datalist, data)
 		}
 	}
 
-	return datalist, nil
+	return streams, format
+}
+
+// Splits a line of compact ffprobe output into its fields. ffprobe escapes "|", backslashes
+// and control characters in values with a backslash, which are unescaped in the returned fields.
+func splitCompact(line string) []string {
+	fields := []string{}
+	field := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			case 'b':
+				field.WriteByte('\b')
+			case 'f':
+				field.WriteByte('\f')
+			default:
+				field.WriteByte(line[i])
+			}
+		case line[i] == '|':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
 }
 
 // Parses the given data into a float64.
 func parse(data string) float64 {
+	n, _ := parseValue(data)
+	return n
+}
+
+// Parses the given data into a float64. Returns false if the value is unknown, e.g. "N/A".
+func parseValue(data string) (float64, bool) {
 	n, err := strconv.ParseFloat(data, 64)
 	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Number of bytes of ffmpeg output kept to explain why a process failed.
+const maxErrorLog = 4096
+
+// Collects the stderr output of an ffmpeg process. Safe to read while the process is running.
+type ffmpegLog struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+	limit  int // Maximum number of bytes kept, dropping the oldest output. 0 keeps all output.
+}
+
+func (log *ffmpegLog) Write(data []byte) (int, error) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	n, err := log.buffer.Write(data)
+	if log.limit > 0 && log.buffer.Len() > log.limit {
+		log.buffer.Next(log.buffer.Len() - log.limit)
+	}
+	return n, err
+}
+
+func (log *ffmpegLog) String() string {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return log.buffer.String()
+}
+
+// Matches errors logged by a demuxer or decoder with the "level" log flag, e.g.
+// "[mp3float @ 0x5581c6e3c2c0] [error] Header missing".
+var decodeError = regexp.MustCompile(`^\[[^\]]+ @ [^\]]+\] \[(error|fatal)\]`)
+
+// Counts the errors in ffmpeg output written with the "level" log flag. Only the number of
+// errors is kept, so that long decodes with many errors do not grow memory.
+type errorCounter struct {
+	mutex   sync.Mutex
+	count   int    // Number of errors counted so far.
+	partial []byte // Output after the last line break.
+}
+
+func (counter *errorCounter) Write(data []byte) (int, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.partial = append(counter.partial, data...)
+	for {
+		index := bytes.IndexAny(counter.partial, "\r\n")
+		if index == -1 {
+			break
+		}
+		if decodeError.Match(counter.partial[:index]) {
+			counter.count++
+		}
+		counter.partial = counter.partial[index+1:]
+	}
+	return len(data), nil
+}
+
+// Returns the number of errors counted so far, 0 for a nil counter.
+func (counter *errorCounter) Count() int {
+	if counter == nil {
 		return 0
 	}
-	return n
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.count
 }
 
 // Returns the microphone device name used for the -f option with ffmpeg.
@@ -121,6 +294,151 @@ func microphone() (string, error) {
 	}
 }
 
+// Returns the audio output device name used for the -f option with ffmpeg.
+func speaker() (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return "pulse", nil
+	case "darwin":
+		return "audiotoolbox", nil
+	default:
+		return "", fmt.Errorf("audio output devices are not supported on %s", runtime.GOOS)
+	}
+}
+
+// Returns true if a window can be shown. Windows and MacOS always have a display,
+// other systems need an X11 or Wayland display.
+func display() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	default:
+		return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+	}
+}
+
+// Parses the output of "ffmpeg -sinks pulse" or "ffmpeg -sources pulse" to get the names of all
+// audio output or input devices.
+func parseSinks(buffer string) []string {
+	devices := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(buffer, "\r\n", "\n"), "\n") {
+		// Sample line: "* alsa_output.pci-0000_00_1f.3.analog-stereo [Built-in Audio Analog Stereo]"
+		// The default device is marked with "*".
+		line = strings.TrimPrefix(strings.TrimSpace(line), "* ")
+		index := strings.Index(line, " [")
+		if index > 0 && !strings.Contains(line[:index], " ") {
+			devices = append(devices, line[:index])
+		}
+	}
+	return devices
+}
+
+// Parses the output of the audiotoolbox "-list_devices" option to get the names of all audio output devices.
+func parseAudioToolboxDevices(buffer string) []string {
+	devices := []string{}
+	// Sample line: "[AudioToolbox @ 0x7f9b5c] [0]         MacBook Pro Speakers, BuiltInSpeakerDevice"
+	regex := regexp.MustCompile(`\[(\d+)\]\s+([^,]+),`)
+	for _, match := range regex.FindAllStringSubmatch(buffer, -1) {
+		devices = append(devices, strings.TrimSpace(match[2]))
+	}
+	return devices
+}
+
+// Returns the names of all audio output devices.
+func getSpeakers() ([]string, error) {
+	sink, err := speaker()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if sink == "pulse" {
+		cmd = exec.Command("ffmpeg", "-hide_banner", "-sinks", "pulse")
+	} else {
+		cmd = exec.Command(
+			"ffmpeg",
+			"-hide_banner",
+			"-f", "lavfi",
+			"-i", "anullsrc",
+			"-t", "0",
+			"-f", sink,
+			"-list_devices", "true",
+			"-",
+		)
+	}
+
+	logCommand(cmd)
+
+	// Device lists are written to Stdout for "-sinks" and to Stderr for "-list_devices".
+	log := &ffmpegLog{}
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.Run()
+
+	if sink == "pulse" {
+		return parseSinks(log.String()), nil
+	}
+	return parseAudioToolboxDevices(log.String()), nil
+}
+
+// Parses the output of the avfoundation "-list_devices" option to get the names of all audio input devices.
+func parseAVFoundationDevices(buffer string) []string {
+	index := strings.Index(strings.ToLower(buffer), "avfoundation audio devices")
+	if index == -1 {
+		return []string{}
+	}
+
+	devices := []string{}
+	// Sample line: "[AVFoundation indev @ 0x7f8e4ac04d40] [0] MacBook Pro Microphone"
+	regex := regexp.MustCompile(`\] \[(\d+)\] (.+)$`)
+	for _, line := range strings.Split(strings.ReplaceAll(buffer[index:], "\r\n", "\n"), "\n") {
+		if match := regex.FindStringSubmatch(line); match != nil {
+			devices = append(devices, strings.TrimSpace(match[2]))
+		}
+	}
+	return devices
+}
+
+// Returns the names of all microphones, in the order of the stream indices given to NewMicrophone.
+func getMicrophones() ([]string, error) {
+	source, err := microphone()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	switch source {
+	case "dshow":
+		return getDevicesWindows()
+	case "pulse":
+		cmd = exec.Command("ffmpeg", "-hide_banner", "-sources", "pulse")
+	default:
+		cmd = exec.Command("ffmpeg", "-hide_banner", "-f", source, "-list_devices", "true", "-i", "")
+	}
+
+	logCommand(cmd)
+
+	// Device lists are written to Stdout for "-sources" and to Stderr for "-list_devices".
+	log := &ffmpegLog{}
+	cmd.Stdout = log
+	cmd.Stderr = log
+	err = cmd.Run()
+
+	if source == "pulse" {
+		// Listing the sources only fails if PulseAudio cannot be reached.
+		if err != nil {
+			lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+			return nil, fmt.Errorf("could not list microphones: %s", strings.TrimSpace(lines[len(lines)-1]))
+		}
+		return parseSinks(log.String()), nil
+	}
+	// ffmpeg always exits with an error after listing avfoundation devices.
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, err
+	}
+	return parseAVFoundationDevices(log.String()), nil
+}
+
 // For webcam streaming on windows, ffmpeg requires a device name.
 // All device names are parsed and returned by this function.
 func parseDevices(buffer string) []string {
@@ -186,6 +504,7 @@ func getDevicesWindows() ([]string, error) {
 		"-f", "dshow",
 		"-i", "dummy",
 	)
+	logCommand(cmd)
 
 	pipe, err := cmd.StderrPipe()
 	if err != nil {
@@ -197,30 +516,110 @@ func getDevicesWindows() ([]string, error) {
 		return nil, err
 	}
 
-	// Read list devices from Stdout.
-	builder := bytes.Buffer{}
-	buffer := make([]byte, 1024)
-	for {
-		n, err := pipe.Read(buffer)
-		builder.Write(buffer[:n])
-		if err == io.EOF {
-			break
-		}
-	}
+	// Read list devices from Stderr.
+	output, err := io.ReadAll(pipe)
 
 	// Wait for the command to finish.
 	cmd.Wait()
 
-	devices := parseDevices(builder.String())
+	if err != nil {
+		return nil, err
+	}
+
+	devices := parseDevices(string(output))
 	return devices, nil
 }
 
+// Number of channels in each of the standard ffmpeg channel layouts.
+// https://ffmpeg.org/ffmpeg-utils.html#Channel-Layout.
+var channelLayouts = map[string]int{
+	"mono":           1,
+	"stereo":         2,
+	"downmix":        2,
+	"2.1":            3,
+	"3.0":            3,
+	"3.0(back)":      3,
+	"4.0":            4,
+	"quad":           4,
+	"quad(side)":     4,
+	"3.1":            4,
+	"5.0":            5,
+	"5.0(side)":      5,
+	"4.1":            5,
+	"5.1":            6,
+	"5.1(side)":      6,
+	"6.0":            6,
+	"6.0(front)":     6,
+	"hexagonal":      6,
+	"6.1":            7,
+	"6.1(back)":      7,
+	"6.1(front)":     7,
+	"7.0":            7,
+	"7.0(front)":     7,
+	"7.1":            8,
+	"7.1(wide)":      8,
+	"7.1(wide-side)": 8,
+	"octagonal":      8,
+	"hexadecagonal":  16,
+}
+
+// Checks that the channel layout is known and has the given number of channels.
+func checkLayout(layout string, channels int) error {
+	count, ok := channelLayouts[layout]
+	if !ok {
+		return fmt.Errorf("unknown channel layout: %s", layout)
+	}
+	if count != channels {
+		return fmt.Errorf("channel layout %s has %d channels, not %d", layout, count, channels)
+	}
+	return nil
+}
+
+// Supported audio sample formats without a byte order suffix.
+var formats = []string{"u8", "s8", "u16", "s16", "u24", "s24", "u32", "s32", "f32", "f64"}
+
+// Returns the names of all supported audio sample formats, e.g. "s16". Formats with more than
+// 8 bits per sample are stored in the byte order of the machine unless "le" (little endian)
+// or "be" (big endian) is appended to the name, e.g. "s16be".
+func ValidFormats() []string {
+	return append([]string{}, formats...)
+}
+
+// Expands the audio format to include the byte order of the machine if it has more than 8 bits
+// per sample and no "le" or "be" suffix, e.g. "s16" becomes "s16le" on little endian machines.
+// Returns an error if the format is not supported.
+func NormalizeFormat(format string) (string, error) {
+	normalized := format
+	if !strings.HasSuffix(format, "le") && !strings.HasSuffix(format, "be") {
+		normalized = createFormat(format)
+	}
+	if checkFormat(normalized) != nil {
+		return "", fmt.Errorf("audio format %s is not supported, must be one of %s", format, strings.Join(formats, ", "))
+	}
+	return normalized, nil
+}
+
+// Expands the audio format like NormalizeFormat, using the given byte order ("le", "be" or
+// "native") for formats without a "le" or "be" suffix.
+func orderFormat(format, endianness string) (string, error) {
+	switch endianness {
+	case "", "native":
+		return NormalizeFormat(format)
+	case "le", "be":
+	default:
+		return "", fmt.Errorf("invalid endianness: %s, must be one of le, be, native", endianness)
+	}
+	if format != "u8" && format != "s8" && !strings.HasSuffix(format, "le") && !strings.HasSuffix(format, "be") {
+		format += endianness
+	}
+	return NormalizeFormat(format)
+}
+
 // Check audio format string.
 func checkFormat(format string) error {
 	match := regexp.MustCompile(`^(([us]8)|([us]((16)|(24)|(32))[bl]e)|(f((32)|(64))[bl]e))$`)
 	if len(match.FindString(format)) == 0 {
-		formats := "u8, s8, u16, s16, u24, s24, u32, s32, f32, or f64"
-		return fmt.Errorf("audio format %s is not supported, must be one of %s", format[:len(format)-2], formats)
+		return fmt.Errorf("audio format %s is not supported, must be one of %s", format, strings.Join(formats, ", "))
 	}
 	return nil
 }
@@ -230,12 +629,13 @@ func createFormat(format string) string {
 	case "u8", "s8":
 		return format
 	default:
-		return fmt.Sprintf("%s%s", format, endianness())
+		return fmt.Sprintf("%s%s", format, NativeEndianness())
 	}
 }
 
-// Little Endian -> "le", Big Endian -> "be".
-func endianness() string {
+// Returns the byte order of the machine, "le" for little endian or "be" for big endian.
+// Audio formats without a "le" or "be" suffix use this byte order by default.
+func NativeEndianness() string {
 	x := 1
 	littleEndian := *(*byte)(unsafe.Pointer(&x)) == 1
 	if littleEndian {
@@ -245,99 +645,134 @@ func endianness() string {
 	}
 }
 
-// Alias the byte buffer as a certain type specified by the format string.
+// Returns true if samples of the audio format are stored in the byte order of the machine.
+func nativeOrder(format string) bool {
+	if strings.HasSuffix(format, "le") || strings.HasSuffix(format, "be") {
+		return strings.HasSuffix(format, NativeEndianness())
+	}
+	return true
+}
+
+// Returns the audio format without its byte order suffix, e.g. "s16" for "s16be".
+func sampleType(format string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(format, "le"), "be")
+}
+
+// Alias the byte buffer as a certain type specified by the format string, so that changes to the
+// samples change the buffer and vice versa. Samples stored in the opposite byte order of the
+// machine, or in a buffer that is not aligned to the size of a sample, are copied instead.
 func bytesToSamples(buffer []byte, size int, format string) interface{} {
+	// 8 and 24 bit samples are never copied, since they have no alignment and byte order.
+	if kind := sampleType(format); kind != "u8" && kind != "s8" && kind != "u24" && kind != "s24" {
+		if bytes := newSampleCodec(format).size; !nativeOrder(format) || !aligned(buffer, bytes) {
+			return decodeSamples(buffer[:size*bytes], format)
+		}
+	}
+	// An empty buffer has no first element, in which case the samples are a nil slice.
+	var pointer unsafe.Pointer
+	if len(buffer) > 0 {
+		pointer = unsafe.Pointer(&buffer[0])
+	}
 	switch format {
 	case "f32be", "f32le":
-		var data []float32
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*float32)(pointer), size)
 	case "f64be", "f64le":
-		var data []float64
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*float64)(pointer), size)
 	case "s16be", "s16le":
-		var data []int16
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*int16)(pointer), size)
 	case "s32be", "s32le":
-		var data []int32
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*int32)(pointer), size)
 	case "s8":
-		var data []int8
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*int8)(pointer), size)
 	case "u16be", "u16le":
-		var data []uint16
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*uint16)(pointer), size)
 	case "u32be", "u32le":
-		var data []uint32
-		pointer := (*reflect.SliceHeader)(unsafe.Pointer(&data))
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&buffer)).Data
-		pointer.Cap = size
-		pointer.Len = size
-		return data
+		return unsafe.Slice((*uint32)(pointer), size)
 	default:
 		return buffer
 	}
 }
 
-func samplesToBytes(data interface{}) []byte {
-	var buffer []byte
-	pointer := (*reflect.SliceHeader)(unsafe.Pointer(&buffer))
+// Returns true if the start of the buffer is aligned to the given number of bytes.
+func aligned(buffer []byte, size int) bool {
+	return len(buffer) == 0 || uintptr(unsafe.Pointer(&buffer[0]))%uintptr(size) == 0
+}
 
+// Copies the raw audio data into a new slice of the type specified by the format string,
+// reading the samples in the byte order of the format.
+func decodeSamples(buffer []byte, format string) interface{} {
+	codec := newSampleCodec(format)
+	data := makeSamples(format, len(buffer)/codec.size)
+	binary.Read(bytes.NewReader(buffer), codec.order, data)
+	return data
+}
+
+// Returns the samples as bytes in the given audio format. Samples are byte swapped if the format
+// only differs from the type of the samples in byte order, otherwise the bytes share memory
+// with the samples.
+func samplesToFormat(data interface{}, format string) []byte {
+	buffer := samplesToBytes(data)
+	if native := sampleFormat(data); native != "" && native != format && !nativeOrder(format) {
+		if sampleType(native) == sampleType(format) {
+			swapped := bytes.NewBuffer(make([]byte, 0, len(buffer)))
+			binary.Write(swapped, newSampleCodec(format).order, data)
+			buffer = swapped.Bytes()
+		}
+	}
+	return buffer
+}
+
+func samplesToBytes(data interface{}) []byte {
+	var pointer unsafe.Pointer
 	var size int
 	switch data := data.(type) {
 	case []uint8:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data)
 	case []int8:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data)
 	case []uint16:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 2
 	case []int16:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 2
 	case []uint32:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 4
 	case []int32:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 4
 	case []float32:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 4
 	case []float64:
-		pointer.Data = (*reflect.SliceHeader)(unsafe.Pointer(&data)).Data
+		if len(data) > 0 {
+			pointer = unsafe.Pointer(&data[0])
+		}
 		size = len(data) * 8
 	default:
 		return nil
 	}
 
-	pointer.Cap = size
-	pointer.Len = size
-
-	return buffer
+	// Empty samples of a known type are not nil, since nil marks an unknown type.
+	if size == 0 {
+		return []byte{}
+	}
+	return unsafe.Slice((*byte)(pointer), size)
 }
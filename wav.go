@@ -0,0 +1,231 @@
+package aio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Layout of the audio data in a WAV file that can be read without ffmpeg.
+type wavFile struct {
+	format     string // Format of the samples in the file, e.g. "s16le".
+	codec      string // Name of the ffmpeg codec for the samples, e.g. "pcm_s16le".
+	samplerate int    // Audio Sample Rate in Hz.
+	channels   int    // Number of audio channels.
+	bps        int    // Bits per sample.
+	offset     int64  // Position of the audio data in the file.
+	size       int64  // Size of the audio data in bytes.
+}
+
+// WAV format tags of the sample encodings supported by the native reader.
+const (
+	wavPCM        = 0x0001
+	wavFloat      = 0x0003
+	wavExtensible = 0xFFFE
+)
+
+// Parses the header of a WAV file with PCM or floating point samples. Returns nil if the
+// file is not such a WAV file, e.g. because it is compressed, and should be read with ffmpeg.
+func parseWAV(filename string) (*wavFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, nil
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, nil
+	}
+
+	wav := &wavFile{}
+	found := false
+	offset := int64(12)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, chunk); err != nil {
+			return nil, nil
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, nil
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(file, data); err != nil {
+				return nil, nil
+			}
+			tag := binary.LittleEndian.Uint16(data[0:2])
+			// The sample encoding of extensible WAV files is stored in the sub format.
+			if tag == wavExtensible && size >= 26 {
+				tag = binary.LittleEndian.Uint16(data[24:26])
+			}
+			wav.channels = int(binary.LittleEndian.Uint16(data[2:4]))
+			wav.samplerate = int(binary.LittleEndian.Uint32(data[4:8]))
+			wav.bps = int(binary.LittleEndian.Uint16(data[14:16]))
+			wav.format = wavFormat(tag, wav.bps)
+			if wav.format == "" || wav.channels <= 0 || wav.samplerate <= 0 {
+				return nil, nil
+			}
+			wav.codec = "pcm_" + wav.format
+			found = true
+		case "data":
+			if !found {
+				return nil, nil
+			}
+			wav.offset = offset
+			wav.size = size
+			// Some writers store a placeholder size when streaming, so the data is read to the end of the file.
+			if info, err := file.Stat(); err == nil && (size == 0xFFFFFFFF || offset+size > info.Size()) {
+				wav.size = info.Size() - offset
+			}
+			wav.size -= wav.size % int64(wav.channels*wav.bps/8)
+			return wav, nil
+		default:
+			if _, err := file.Seek(size, io.SeekCurrent); err != nil {
+				return nil, nil
+			}
+		}
+		// Chunks are padded to an even number of bytes.
+		if size%2 == 1 {
+			if _, err := file.Seek(1, io.SeekCurrent); err != nil {
+				return nil, nil
+			}
+			size++
+		}
+		offset += size
+	}
+}
+
+// Returns the audio format of samples with the given WAV format tag and bits per sample,
+// or an empty string if they are not supported.
+func wavFormat(tag uint16, bps int) string {
+	switch {
+	case tag == wavPCM && bps == 8:
+		return "u8"
+	case tag == wavPCM && (bps == 16 || bps == 24 || bps == 32):
+		return fmt.Sprintf("s%dle", bps)
+	case tag == wavFloat && (bps == 32 || bps == 64):
+		return fmt.Sprintf("f%dle", bps)
+	default:
+		return ""
+	}
+}
+
+// Returns the header of a WAV file holding samples in the given format. The sizes in the header
+// are set to their largest value, as used for streams whose length is not known in advance.
+func wavHeader(format string, samplerate, channels int) ([]byte, error) {
+	var tag uint16
+	switch format {
+	case "u8", "s16le", "s24le", "s32le":
+		tag = wavPCM
+	case "f32le", "f64le":
+		tag = wavFloat
+	default:
+		return nil, fmt.Errorf("samples in %s format cannot be stored in a WAV file", format)
+	}
+	bps := newSampleCodec(format).size * 8
+
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 0xFFFFFFFF)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], tag)
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(samplerate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(samplerate*channels*bps/8))
+	binary.LittleEndian.PutUint16(header[32:], uint16(channels*bps/8))
+	binary.LittleEndian.PutUint16(header[34:], uint16(bps))
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], 0xFFFFFFFF)
+	return header, nil
+}
+
+// Returns the ffprobe style metadata of the audio in the WAV file.
+func (wav *wavFile) metadata() map[string]string {
+	second := wav.samplerate * wav.channels * wav.bps / 8
+	return map[string]string{
+		"index":           "0",
+		"codec_name":      wav.codec,
+		"codec_type":      "audio",
+		"sample_rate":     fmt.Sprintf("%d", wav.samplerate),
+		"channels":        fmt.Sprintf("%d", wav.channels),
+		"bits_per_sample": fmt.Sprintf("%d", wav.bps),
+		"bit_rate":        fmt.Sprintf("%d", second*8),
+		"duration":        fmt.Sprintf("%f", float64(wav.size)/float64(second)),
+		"start_time":      "0.000000",
+	}
+}
+
+// Opens the audio data of the WAV file, converting the samples to the given format. Reads the
+// given number of frames starting at the given frame, or all frames after it if count is negative.
+func (wav *wavFile) open(filename, format string, start, count int64) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	frame := int64(wav.channels * wav.bps / 8)
+	skip := start * frame
+	if skip > wav.size {
+		skip = wav.size
+	}
+	size := wav.size - skip
+	if count >= 0 && count*frame < size {
+		size = count * frame
+	}
+	if _, err := file.Seek(wav.offset+skip, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &convertReader{
+		reader: io.LimitReader(file, size),
+		closer: file,
+		from:   wav.format,
+		to:     format,
+	}, nil
+}
+
+// Reads raw audio data from a reader, converting the samples from one format to another.
+type convertReader struct {
+	reader  io.Reader // Reader of samples in the source format.
+	closer  io.Closer // Closes the source of the samples.
+	from    string    // Format of the source samples.
+	to      string    // Format of the returned samples.
+	pending []byte    // Converted samples that have not been returned yet.
+}
+
+func (reader *convertReader) Read(data []byte) (int, error) {
+	if reader.from == reader.to {
+		return reader.reader.Read(data)
+	}
+	if len(reader.pending) == 0 {
+		source := newSampleCodec(reader.from).size
+		target := newSampleCodec(reader.to).size
+		buffer := make([]byte, (len(data)/target+1)*source)
+		n, err := io.ReadFull(reader.reader, buffer)
+		n -= n % source
+		if n == 0 {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		reader.pending = convertBuffer(buffer[:n], reader.from, reader.to)
+	}
+	n := copy(data, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+func (reader *convertReader) Close() error {
+	return reader.closer.Close()
+}
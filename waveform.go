@@ -0,0 +1,231 @@
+package aio
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// How the audio of each bucket is reduced by Waveform and ReadWaveform. The zero value reduces
+// the mix of all channels to its peak level.
+type WaveformOptions struct {
+	RMS        bool // Reduce each bucket to its RMS level instead of its peak.
+	PerChannel bool // Return the levels of each channel separately instead of their mix.
+}
+
+// Reduces audio to one value per bucket and channel, for drawing waveforms. Only the running
+// peak or sum of squares of each bucket is kept, so the audio is never held in memory.
+type envelope struct {
+	codec    sampleCodec // Layout of the samples.
+	channels int         // Number of audio channels.
+	outputs  int         // Number of values per bucket, 1 for the mix of all channels.
+	rms      bool        // Flag storing whether buckets are reduced to their RMS level instead of their peak.
+	values   []float64   // Peak or sum of squares of each bucket and output, interleaved by bucket.
+	counts   []int       // Number of frames in each bucket.
+}
+
+func newEnvelope(format string, channels int, settings WaveformOptions) *envelope {
+	outputs := 1
+	if settings.PerChannel {
+		outputs = channels
+	}
+	return &envelope{
+		codec:    newSampleCodec(format),
+		channels: channels,
+		outputs:  outputs,
+		rms:      settings.RMS,
+	}
+}
+
+// Adds empty buckets until there are at least the given number of buckets.
+func (envelope *envelope) grow(buckets int) {
+	for len(envelope.counts) < buckets {
+		envelope.counts = append(envelope.counts, 0)
+		for i := 0; i < envelope.outputs; i++ {
+			envelope.values = append(envelope.values, 0)
+		}
+	}
+}
+
+// Adds a frame of audio to the bucket with the given index, adding buckets up to it as needed.
+func (envelope *envelope) add(frame []byte, bucket int) {
+	envelope.grow(bucket + 1)
+	envelope.counts[bucket]++
+
+	values := envelope.values[bucket*envelope.outputs : (bucket+1)*envelope.outputs]
+	if envelope.outputs == 1 {
+		sum := 0.0
+		for channel := 0; channel < envelope.channels; channel++ {
+			sum += envelope.codec.decode(frame[channel*envelope.codec.size:])
+		}
+		envelope.reduce(values, 0, sum/float64(envelope.channels))
+		return
+	}
+	for channel := range values {
+		envelope.reduce(values, channel, envelope.codec.decode(frame[channel*envelope.codec.size:]))
+	}
+}
+
+// Adds the sample value to the peak or sum of squares of the given output.
+func (envelope *envelope) reduce(values []float64, output int, value float64) {
+	if envelope.rms {
+		values[output] += value * value
+	} else if math.Abs(value) > values[output] {
+		values[output] = math.Abs(value)
+	}
+}
+
+// Returns an envelope with the given number of buckets, where the audio of each bucket of this
+// envelope is part of the bucket returned for its index.
+func (envelope *envelope) regroup(buckets int, bucket func(index int) int) *envelope {
+	result := *envelope
+	result.values, result.counts = nil, nil
+	result.grow(buckets)
+	for i, count := range envelope.counts {
+		to := bucket(i)
+		result.counts[to] += count
+		for output := 0; output < envelope.outputs; output++ {
+			value := envelope.values[i*envelope.outputs+output]
+			if index := to*envelope.outputs + output; envelope.rms {
+				result.values[index] += value
+			} else if value > result.values[index] {
+				result.values[index] = value
+			}
+		}
+	}
+	return &result
+}
+
+// Returns the value of every bucket, with the values of each channel interleaved per bucket.
+func (envelope *envelope) result() []float64 {
+	if envelope.rms {
+		for i := range envelope.values {
+			if count := envelope.counts[i/envelope.outputs]; count > 0 {
+				envelope.values[i] = math.Sqrt(envelope.values[i] / float64(count))
+			}
+		}
+	}
+	return envelope.values
+}
+
+// Reads all frames from the source and adds each to the bucket returned for its index.
+func (envelope *envelope) read(source Source, bucket func(index int) int) error {
+	frame := source.BytesPerFrame()
+	index := 0
+	for {
+		data, err := source.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading audio: %w", err)
+		}
+		for i := 0; i+frame <= len(data.Data); i += frame {
+			envelope.add(data.Data[i:i+frame], bucket(index))
+			index++
+		}
+	}
+}
+
+// Returns the waveform of the audio file as the given number of buckets of equal duration, each
+// reduced to its peak level between 0 and 1, or to its RMS level with settings.RMS. The channels
+// are mixed, unless settings.PerChannel is set, in which case the values of all channels are
+// returned for each bucket in turn. The audio is decoded as it is reduced, so files of any
+// length can be drawn, and the stream and filter used are taken from the options like NewAudio.
+// Files whose duration is unknown, e.g. streams ffprobe cannot measure, are drawn as well.
+func Waveform(filename string, options *Options, buckets int, settings WaveformOptions) ([]float64, error) {
+	options = withDefaults(options)
+
+	if buckets < 1 {
+		return nil, fmt.Errorf("invalid number of buckets: %d, must be positive", buckets)
+	}
+	if err := options.validate("Waveform"); err != nil {
+		return nil, err
+	}
+
+	audio, err := NewAudio(filename, options)
+	if err != nil {
+		return nil, err
+	}
+	defer audio.Close()
+
+	values, err := reduceWaveform(audio, audio.Total()/audio.BytesPerFrame(), buckets, settings)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("%s has no audio to draw", filename)
+	}
+	return values, nil
+}
+
+// Reduces the audio of the source to the given number of buckets, assuming it has the given
+// number of frames, or an unknown number if it is 0. Returns nil if the number of frames is
+// unknown and the source has no audio.
+func reduceWaveform(source Source, frames, buckets int, settings WaveformOptions) ([]float64, error) {
+	envelope := newEnvelope(byteFormat(source), source.Channels(), settings)
+	if frames > 0 {
+		// All buckets are returned, even if the stream is shorter than its estimated duration,
+		// and audio past the estimated duration is part of the last bucket.
+		envelope.grow(buckets)
+		err := envelope.read(source, func(index int) int {
+			bucket := int(int64(index) * int64(buckets) / int64(frames))
+			if bucket >= buckets {
+				bucket = buckets - 1
+			}
+			return bucket
+		})
+		if err != nil {
+			return nil, err
+		}
+		return envelope.result(), nil
+	}
+
+	// Without the duration, the audio is reduced to buckets of a number of frames that doubles
+	// whenever there are too many of them, which are combined into the requested buckets once
+	// the audio has ended. Keeping at least 64 times as many buckets as requested places the
+	// bounds of the requested buckets within 1/64 of a bucket of their exact times.
+	limit := 128 * buckets
+	width := 1
+	err := envelope.read(source, func(index int) int {
+		frames = index + 1
+		if index/width >= limit {
+			// The envelope is replaced in place, since it is the one being read into.
+			*envelope = *envelope.regroup(limit/2, func(bucket int) int { return bucket / 2 })
+			width *= 2
+		}
+		return index / width
+	})
+	if err != nil {
+		return nil, err
+	}
+	if frames == 0 {
+		return nil, nil
+	}
+	envelope = envelope.regroup(buckets, func(bucket int) int {
+		return int(int64(bucket) * int64(width) * int64(buckets) / int64(frames))
+	})
+	return envelope.result(), nil
+}
+
+// Reads the source until it ends and returns its waveform with the given number of buckets per
+// second of audio, e.g. the pixels per second of a waveform display, reduced like Waveform.
+// The last bucket holds the rest of the audio and may be shorter. The source is closed once
+// ReadWaveform returns.
+func ReadWaveform(source Source, resolution float64, settings WaveformOptions) ([]float64, error) {
+	defer source.Close()
+
+	if !(resolution > 0) {
+		return nil, fmt.Errorf("invalid resolution: %v buckets per second, must be positive", resolution)
+	}
+
+	envelope := newEnvelope(byteFormat(source), source.Channels(), settings)
+	samplerate := float64(source.SampleRate())
+	err := envelope.read(source, func(index int) int {
+		return int(float64(index) * resolution / samplerate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return envelope.result(), nil
+}